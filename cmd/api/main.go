@@ -1,8 +1,16 @@
 package main
 
 import (
+	"censys/pkg/client"
+	"censys/pkg/gateway"
+	"censys/pkg/nearcache"
+	"censys/pkg/openapi"
+	"censys/pkg/ratelimit"
 	"censys/pkg/transport"
+	"censys/pkg/util"
 	pb "censys/proto/gen/proto"
+	"context"
+	"expvar"
 	"fmt"
 	"github.com/joho/godotenv"
 	"google.golang.org/grpc"
@@ -12,13 +20,132 @@ import (
 	"os"
 )
 
-// NewServer creates a new http server
-func NewServer(server transport.Server) http.Handler {
+// NewServer creates a new http server. The versioned API is validated
+// against spec, routes are rate limited by limiter unless it is nil, and
+// every request is given an id. The RPCs exposed by gw are served under
+// /rpc unless it is nil.
+func NewServer(server transport.Server, spec *openapi.Document, limiter *ratelimit.Limiter, gw http.Handler) http.Handler {
 	router := http.NewServeMux()
-	router.HandleFunc("GET /store", server.HandleGet)
-	router.HandleFunc("POST /store", server.HandleSet)
-	router.HandleFunc("DELETE /store/{key}", server.HandleDelete)
-	return router
+	handle := func(method string, path string, handler http.HandlerFunc) {
+		if limiter != nil {
+			handler = limiter.Wrap(method+" "+path, handler)
+		}
+		// Every keyspace route is also served within a namespace
+		router.HandleFunc(method+" "+path, handler)
+		router.HandleFunc(method+" /ns/{namespace}"+path, handler)
+	}
+
+	// The versioned API is validated against its OpenAPI document
+	v1 := func(method string, path string, handler http.HandlerFunc) {
+		for _, prefix := range []string{"/v1", "/v1/namespaces/{namespace}"} {
+			validated := spec.Validate(method, prefix+path, handler)
+			if limiter != nil {
+				validated = limiter.Wrap(method+" /v1"+path, validated)
+			}
+			router.HandleFunc(method+" "+prefix+path, validated)
+		}
+	}
+
+	router.Handle("GET /v1/openapi.json", spec)
+	router.Handle("GET /debug/vars", expvar.Handler())
+	v1("GET", "/keys", server.HandleKeyList)
+	v1("GET", "/keys/{key}", server.HandleKeyGet)
+	v1("PUT", "/keys/{key}", server.HandleKeyPut)
+	v1("DELETE", "/keys/{key}", server.HandleKeyDelete)
+
+	// The unversioned key-value routes are deprecated in favor of /v1/keys
+	handle("GET", "/store", transport.Deprecated(server.HandleGet))
+	handle("POST", "/store", transport.Deprecated(server.HandleSet))
+	handle("GET", "/store/{key}", transport.Deprecated(server.HandleGet))
+	handle("PUT", "/store/{key}", transport.Deprecated(server.HandleSetPath))
+	handle("PATCH", "/store/{key}", transport.Deprecated(server.HandlePatch))
+	handle("DELETE", "/store/{key}", transport.Deprecated(server.HandleDelete))
+
+	handle("POST", "/hashes/{key}", server.HandleHashSet)
+	handle("GET", "/hashes/{key}", server.HandleHashGetAll)
+	handle("GET", "/hashes/{key}/{field}", server.HandleHashGet)
+	handle("DELETE", "/hashes/{key}/{field}", server.HandleHashDelete)
+
+	handle("POST", "/lists/{key}", server.HandleListPush)
+	handle("POST", "/lists/{key}/pop", server.HandleListPop)
+	handle("GET", "/lists/{key}", server.HandleListRange)
+
+	handle("POST", "/sets/{key}", server.HandleSetAdd)
+	handle("GET", "/sets/{key}", server.HandleSetMembers)
+	handle("GET", "/sets", server.HandleSetIntersect)
+	handle("DELETE", "/sets/{key}/{member}", server.HandleSetRemove)
+
+	handle("POST", "/zsets/{key}", server.HandleSortedSetAdd)
+	handle("GET", "/zsets/{key}", server.HandleSortedSetRange)
+	handle("DELETE", "/zsets/{key}/{member}", server.HandleSortedSetRemove)
+	handle("POST", "/zsets/{key}/{member}/increment", server.HandleSortedSetIncrement)
+	handle("GET", "/zsets/{key}/{member}/rank", server.HandleSortedSetRank)
+
+	handle("POST", "/channels/{channel}", server.HandlePublish)
+	handle("GET", "/channels", server.HandleSubscribe)
+
+	handle("POST", "/queues/{name}", server.HandleEnqueue)
+	handle("POST", "/queues/{name}/dequeue", server.HandleDequeue)
+	handle("PUT", "/queues/{name}/config", server.HandleConfigureQueue)
+	handle("POST", "/queues/{name}/messages/{id}/ack", server.HandleAck)
+	handle("POST", "/queues/{name}/messages/{id}/nack", server.HandleNack)
+
+	handle("GET", "/elections/{election}", server.HandleLeader)
+	handle("GET", "/elections/{election}/observe", server.HandleObserve)
+
+	if gw != nil {
+		var rpc http.HandlerFunc = http.StripPrefix("/rpc", gw).ServeHTTP
+		if limiter != nil {
+			rpc = limiter.Wrap("/rpc/", rpc)
+		}
+		router.Handle("/rpc/", rpc)
+	}
+	return util.RequestID(client.ForwardClientIP(router))
+}
+
+// gatewayMethods are the RPCs exposed under /rpc. Namespace and schema
+// administration, queue configuration, lease revocation and the RPCs used
+// between services are left out, as nothing authenticates gateway callers.
+var gatewayMethods = []string{
+	pb.KvStoreService_Get_FullMethodName,
+	pb.KvStoreService_Set_FullMethodName,
+	pb.KvStoreService_Delete_FullMethodName,
+	pb.KvStoreService_ListKeys_FullMethodName,
+	pb.KvStoreService_GetPath_FullMethodName,
+	pb.KvStoreService_SetPath_FullMethodName,
+	pb.KvStoreService_Patch_FullMethodName,
+	pb.KvStoreService_HashSet_FullMethodName,
+	pb.KvStoreService_HashGet_FullMethodName,
+	pb.KvStoreService_HashDelete_FullMethodName,
+	pb.KvStoreService_HashGetAll_FullMethodName,
+	pb.KvStoreService_ListPush_FullMethodName,
+	pb.KvStoreService_ListPop_FullMethodName,
+	pb.KvStoreService_ListRange_FullMethodName,
+	pb.KvStoreService_SetAdd_FullMethodName,
+	pb.KvStoreService_SetRemove_FullMethodName,
+	pb.KvStoreService_SetMembers_FullMethodName,
+	pb.KvStoreService_SetIntersect_FullMethodName,
+	pb.KvStoreService_SortedSetAdd_FullMethodName,
+	pb.KvStoreService_SortedSetRemove_FullMethodName,
+	pb.KvStoreService_SortedSetIncrement_FullMethodName,
+	pb.KvStoreService_SortedSetRank_FullMethodName,
+	pb.KvStoreService_SortedSetRangeByScore_FullMethodName,
+	pb.KvStoreService_SortedSetRangeByRank_FullMethodName,
+	pb.KvStoreService_Publish_FullMethodName,
+	pb.KvStoreService_Subscribe_FullMethodName,
+	pb.KvStoreService_Enqueue_FullMethodName,
+	pb.KvStoreService_Dequeue_FullMethodName,
+	pb.KvStoreService_Ack_FullMethodName,
+	pb.KvStoreService_Nack_FullMethodName,
+	pb.KvStoreService_LeaseGrant_FullMethodName,
+	pb.KvStoreService_LeaseKeepAlive_FullMethodName,
+	pb.KvStoreService_LeaseTimeToLive_FullMethodName,
+	pb.KvStoreService_Lock_FullMethodName,
+	pb.KvStoreService_Unlock_FullMethodName,
+	pb.KvStoreService_Campaign_FullMethodName,
+	pb.KvStoreService_Leader_FullMethodName,
+	pb.KvStoreService_Observe_FullMethodName,
+	pb.KvStoreService_Resign_FullMethodName,
 }
 
 // LoadConfig loads config from .env
@@ -33,24 +160,82 @@ func main() {
 	// Load config from .env
 	LoadConfig()
 
-	// Connect to kvstore service
-	grpcConnection := fmt.Sprintf("%s:%s", os.Getenv("KVSTORE_HOST"), os.Getenv("KVSTORE_PORT"))
-	conn, err := grpc.NewClient(grpcConnection,
-		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	// Connect to every kvstore endpoint, bounding, retrying and hedging
+	// calls as configured
+	policy, err := client.PolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load kvstore call policy: %s", err)
+	}
+	var endpoints []grpc.ClientConnInterface
+	for _, endpoint := range client.EndpointsFromEnv() {
+		endpointConn, err := grpc.NewClient(endpoint,
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			log.Fatalf("Failed to connect to kvstore: %s", err)
+		}
+		endpoints = append(endpoints, endpointConn)
+	}
+	conn := client.NewConn(policy, endpoints...)
+	expvar.Publish("kvstore_client", expvar.Func(func() any {
+		return conn.Stats()
+	}))
 
+	// Load the key-value validation policy
+	validation, err := util.PolicyFromEnv()
 	if err != nil {
-		log.Fatalf("Failed to connect to kvstore: %s", err)
+		log.Fatalf("Failed to load validation policy: %s", err)
 	}
 
 	// Create grpc client to communicate with kvstore
 	store := pb.NewKvStoreServiceClient(conn)
 	server := &transport.GrpcServer{
-		Store: store,
+		Store:  store,
+		Policy: &validation,
+	}
+
+	expvar.Publish("coalescing", expvar.Func(func() any {
+		return server.CoalescingStats()
+	}))
+
+	// Cache hot keys when configured, kept coherent by the kvstore
+	// invalidation stream
+	cacheConfig, err := nearcache.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load cache config: %s", err)
+	}
+	if cacheConfig.Enabled() {
+		server.Cache = nearcache.New(cacheConfig)
+		server.Cache.OnInvalidation(server.Forget)
+		go server.Cache.Watch(context.Background(), store)
+		expvar.Publish("near_cache", expvar.Func(func() any {
+			return server.Cache.Stats()
+		}))
+	}
+
+	// Load the rate limits, sharing buckets through the kvstore if asked to
+	var buckets ratelimit.Store = &ratelimit.MemoryStore{}
+	if os.Getenv("RATE_LIMIT_STORE") == "kvstore" {
+		buckets = &ratelimit.KvStore{Client: store}
+	}
+	limiter, err := ratelimit.FromEnv(buckets)
+	if err != nil {
+		log.Fatalf("Failed to load rate limits: %s", err)
+	}
+
+	// Expose the data-plane RPCs as JSON over HTTP from their annotations
+	gw, err := gateway.New(conn, gatewayMethods, pb.File_proto_kvstore_proto.Services().Get(0))
+	if err != nil {
+		log.Fatalf("Failed to create gateway: %s", err)
+	}
+
+	spec, err := transport.OpenAPI()
+	if err != nil {
+		log.Fatalf("Failed to load the OpenAPI document: %s", err)
 	}
 
 	// Start http server
 	apiPort := fmt.Sprintf(":%s", os.Getenv("API_PORT"))
-	err = http.ListenAndServe(apiPort, NewServer(server))
+	err = http.ListenAndServe(apiPort, NewServer(server, spec, limiter, gw))
 	if err != nil {
 		log.Fatalf("Failed to start server: %s", err)
 	}
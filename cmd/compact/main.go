@@ -0,0 +1,68 @@
+// Command compact rewrites a btree database file without its free pages.
+//
+//	compact [-o output] path
+//
+// Without -o, the file is compacted in place, and must not be open.
+package main
+
+import (
+	"censys/internal/kvstore/btree"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// pages returns the number of pages and free pages of the database at path
+func pages(path string) (int, int, error) {
+	db, err := btree.Open(path, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+	stats := db.Stats()
+	return stats.Pages, stats.FreePages, nil
+}
+
+func main() {
+	output := flag.String("o", "", "path of the compacted file, defaults to compacting in place")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-o output] path\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+	if _, err := os.Stat(path); err != nil {
+		log.Fatalf("Failed to open database: %s", err)
+	}
+
+	before, free, err := pages(path)
+	if err != nil {
+		log.Fatalf("Failed to open database: %s", err)
+	}
+
+	dst := *output
+	if dst == "" {
+		dst = path + ".compact"
+	}
+	if err := btree.Compact(dst, path, nil); err != nil {
+		log.Fatalf("Failed to compact database: %s", err)
+	}
+	if *output == "" {
+		if err := os.Rename(dst, path); err != nil {
+			os.Remove(dst)
+			log.Fatalf("Failed to replace database: %s", err)
+		}
+		dst = path
+	}
+
+	after, _, err := pages(dst)
+	if err != nil {
+		log.Fatalf("Failed to open compacted database: %s", err)
+	}
+	fmt.Printf("%s: %d pages (%d free) -> %d pages\n", dst, before, free, after)
+}
@@ -1,18 +1,79 @@
 package main
 
 import (
-	inmemorystore "censys/internal/kvstore/inmemory"
+	"censys/internal/kvstore"
+	"censys/internal/kvstore/backend"
+	_ "censys/internal/kvstore/btree"
+	_ "censys/internal/kvstore/inmemory"
+	_ "censys/internal/kvstore/lsm"
+	_ "censys/internal/kvstore/sqlite"
+	_ "censys/internal/kvstore/wal"
+	"censys/internal/lease"
+	"censys/internal/pubsub"
+	"censys/pkg/admission"
+	"censys/pkg/schema"
 	"censys/pkg/transport"
-	kvstore "censys/proto/gen/proto"
+	"censys/pkg/util"
+	"censys/pkg/webrpc"
+	pb "censys/proto/gen/proto"
+	"context"
+	"expvar"
+	"flag"
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 	"log"
 	"net"
+	"net/http"
 	"os"
-	"sync"
+	"strconv"
+	"strings"
 )
 
+// configFromFlags returns the backend config from the file named by
+// -config or STORE_CONFIG, with the backend overridden by -backend or, if
+// the file names none, STORE_ENGINE. The backend defaults to memory.
+func configFromFlags(path string, name string) (backend.Config, error) {
+	var config backend.Config
+	if path != "" {
+		var err error
+		if config, err = backend.LoadConfig(path); err != nil {
+			return config, err
+		}
+	}
+	if name != "" {
+		config.Backend = name
+	}
+	if config.Backend == "" {
+		config.Backend = os.Getenv("STORE_ENGINE")
+	}
+	if config.Backend == "" {
+		config.Backend = "memory"
+	}
+	return config, nil
+}
+
+// printBackends prints the registered backends and their options
+func printBackends() {
+	for _, name := range backend.Names() {
+		description, options, _ := backend.Describe(name)
+		fmt.Printf("%s: %s\n", name, description)
+		for _, option := range options {
+			fmt.Printf("  %s %s (default %v)\n", option.Name, option.Type, option.Default)
+		}
+	}
+}
+
 func main() {
+	configPath := flag.String("config", os.Getenv("STORE_CONFIG"), "JSON file selecting and configuring the storage backend")
+	backendName := flag.String("backend", "", "storage backend, overriding the config file")
+	listBackends := flag.Bool("backends", false, "list the storage backends and their options, then exit")
+	flag.Parse()
+	if *listBackends {
+		printBackends()
+		return
+	}
+
 	// Load config from .env
 	grpcConnection := fmt.Sprintf(":%s", os.Getenv("KVSTORE_PORT"))
 	listen, err := net.Listen("tcp", grpcConnection)
@@ -20,16 +81,94 @@ func main() {
 		log.Fatalf("Failed to listen: %s", err)
 	}
 
+	// Load the key-value validation policy
+	policy, err := util.PolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load validation policy: %s", err)
+	}
+
+	// Open the storage backend of the keyspace and of every namespace
+	backendConfig, err := configFromFlags(*configPath, *backendName)
+	if err != nil {
+		log.Fatalf("Failed to load storage backend config: %s", err)
+	}
+	stores, err := backend.Open(backendConfig)
+	if err != nil {
+		log.Fatalf("Failed to open storage backend: %s", err)
+	}
+
+	// Load the per-subscriber message buffer size
+	bufferSize := pubsub.DefaultBufferSize
+	if value := os.Getenv("PUBSUB_BUFFER_SIZE"); value != "" {
+		if bufferSize, err = strconv.Atoi(value); err != nil {
+			log.Fatalf("Invalid PUBSUB_BUFFER_SIZE: %s", err)
+		}
+	}
+
+	// Load the admission control limits. RPCs that block until a lock or
+	// leadership is acquired are exempt, as they hold no server resources.
+	config, err := admission.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load admission control: %s", err)
+	}
+	config.Exempt = map[string]bool{
+		pb.KvStoreService_Lock_FullMethodName:     true,
+		pb.KvStoreService_Campaign_FullMethodName: true,
+	}
+	controller := admission.New(config)
+
 	// Create a gRPC server
-	serverRegistrar := grpc.NewServer()
+	serverRegistrar := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(controller.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(controller.StreamServerInterceptor()),
+	)
+	namespaces, err := kvstore.OpenNamespaces(context.Background(), stores.Namespaces)
+	if err != nil {
+		log.Fatalf("Failed to open namespaces: %s", err)
+	}
 	service := &transport.KvStoreServer{
-		Store: &inmemorystore.InMemoryStore{
-			Data: sync.Map{},
-		},
+		Store:      stores.Keyspace,
+		Namespaces: namespaces,
+		Policies:   util.NewPolicies(policy),
+		Schemas:    schema.NewRegistry(),
+		Broker:     pubsub.NewBroker(bufferSize),
+	}
+	service.Leases = lease.NewLessor(service.Release)
+	if err := service.RecoverLeases(context.Background()); err != nil {
+		log.Fatalf("Failed to recover leases: %s", err)
 	}
+	if err := service.LoadPolicies(context.Background()); err != nil {
+		log.Fatalf("Failed to load namespace policies: %s", err)
+	}
+	go service.SweepRateLimits(context.Background(), transport.DefaultRateLimitSweepInterval)
+	expvar.Publish("coalescing", expvar.Func(func() any {
+		return service.CoalescingStats()
+	}))
+
+	// Register the gRPC server, with reflection for tools such as grpcurl
+	pb.RegisterKvStoreServiceServer(serverRegistrar, service)
+	reflection.Register(serverRegistrar)
 
-	// Register the gRPC server
-	kvstore.RegisterKvStoreServiceServer(serverRegistrar, service)
+	// Serve the Connect and gRPC-Web protocols for browser and curl clients,
+	// and the server statistics at /debug/vars
+	if port := os.Getenv("WEB_PORT"); port != "" {
+		web := &webrpc.Handler{
+			UnaryInterceptor:  controller.UnaryServerInterceptor(),
+			StreamInterceptor: controller.StreamServerInterceptor(),
+		}
+		if origins := os.Getenv("CORS_ALLOWED_ORIGINS"); origins != "" {
+			web.AllowedOrigins = strings.Split(origins, ",")
+		}
+		pb.RegisterKvStoreServiceServer(web, service)
+		mux := http.NewServeMux()
+		mux.Handle("/", web)
+		mux.Handle("GET /debug/vars", expvar.Handler())
+		go func() {
+			if err := http.ListenAndServe(fmt.Sprintf(":%s", port), mux); err != nil {
+				log.Fatalf("Failed to serve web clients: %s", err)
+			}
+		}()
+	}
 
 	// Start the gRPC server
 	if err = serverRegistrar.Serve(listen); err != nil {
@@ -0,0 +1,203 @@
+// Package backend is a registry of the storage engines of the kvstore
+// service. Engines register under a name with a config struct, whose JSON
+// fields are the options of the engine, and the service opens the engine
+// named by its config.
+package backend
+
+import (
+	"bytes"
+	"censys/internal/kvstore"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownBackend is returned when a config names no registered backend
+var ErrUnknownBackend = errors.New("unknown storage backend")
+
+// Stores are the stores opened by a backend: the store of the keyspace and
+// the catalog of the stores of namespaces
+type Stores struct {
+	Keyspace   kvstore.KeyValueStore
+	Namespaces kvstore.Catalog
+}
+
+// backend is a registered backend
+type backend struct {
+	description string
+	// config returns a pointer to the default config of the backend
+	config func() any
+	// open opens the stores of the backend from a pointer to its config
+	open func(config any) (Stores, error)
+}
+
+var (
+	mu       sync.RWMutex
+	backends = make(map[string]backend)
+)
+
+// Register registers a backend under name, and is meant to be called from
+// the init functions of engines. defaults returns the default config of
+// the backend, whose options are the JSON fields of C, and open opens its
+// stores. Register panics if name is already registered.
+func Register[C any](name string, description string, defaults func() C, open func(config C) (Stores, error)) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := backends[name]; ok {
+		panic("backend: " + name + " registered twice")
+	}
+	backends[name] = backend{
+		description: description,
+		config: func() any {
+			config := defaults()
+			return &config
+		},
+		open: func(config any) (Stores, error) {
+			return open(*config.(*C))
+		},
+	}
+}
+
+// Names returns the names of the registered backends in order
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return namesLocked()
+}
+
+// lookup returns the backend registered under name
+func lookup(name string) (backend, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	b, ok := backends[name]
+	if !ok {
+		return backend{}, fmt.Errorf("%w %q, want one of %s", ErrUnknownBackend, name, strings.Join(namesLocked(), ", "))
+	}
+	return b, nil
+}
+
+// namesLocked returns the names of the registered backends, with mu held
+func namesLocked() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DirFromEnv returns the directory of the backends keeping their stores on
+// disk by default, STORE_DIR or data if it is unset
+func DirFromEnv() string {
+	if dir := os.Getenv("STORE_DIR"); dir != "" {
+		return dir
+	}
+	return "data"
+}
+
+// OnDisk opens the stores of a backend keeping them under dir. The
+// keyspace is opened at dir/name, and every namespace at name in its own
+// directory under dir/namespaces, which records the namespaces so that they
+// are reopened on restart.
+func OnDisk(dir string, name string, open func(path string) (kvstore.KeyValueStore, error)) (Stores, error) {
+	catalog, err := openCatalog(filepath.Join(dir, "namespaces"), name, open)
+	if err != nil {
+		return Stores{}, err
+	}
+	keyspace, err := open(filepath.Join(dir, name))
+	if err != nil {
+		return Stores{}, err
+	}
+	return Stores{Keyspace: keyspace, Namespaces: catalog}, nil
+}
+
+// Option is an option of a backend
+type Option struct {
+	Name    string
+	Type    string
+	Default any
+}
+
+// Describe returns the description of a backend and its options with
+// their defaults
+func Describe(name string) (string, []Option, error) {
+	b, err := lookup(name)
+	if err != nil {
+		return "", nil, err
+	}
+	config := reflect.ValueOf(b.config()).Elem()
+	var options []Option
+	for i := 0; i < config.NumField(); i++ {
+		field := config.Type().Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		options = append(options, Option{
+			Name:    tag,
+			Type:    field.Type.String(),
+			Default: config.Field(i).Interface(),
+		})
+	}
+	return b.description, options, nil
+}
+
+// Config selects a backend and configures backends. Options holds the
+// options of backends by name, so that a config file may configure several
+// backends and the one used be picked separately.
+type Config struct {
+	Backend string                     `json:"backend"`
+	Options map[string]json.RawMessage `json:"options,omitempty"`
+}
+
+// LoadConfig loads a config from a JSON file
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Open opens the stores of the backend selected by config. Its options
+// override the defaults of the backend, and unknown options are rejected.
+func Open(config Config) (Stores, error) {
+	for name := range config.Options {
+		if _, err := lookup(name); err != nil {
+			return Stores{}, fmt.Errorf("options of %w", err)
+		}
+	}
+	b, err := lookup(config.Backend)
+	if err != nil {
+		return Stores{}, err
+	}
+
+	options := b.config()
+	if raw, ok := config.Options[config.Backend]; ok {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(options); err != nil {
+			return Stores{}, fmt.Errorf("invalid options of backend %q: %w", config.Backend, err)
+		}
+	}
+	stores, err := b.open(options)
+	if err != nil {
+		return Stores{}, fmt.Errorf("opening backend %q: %w", config.Backend, err)
+	}
+	return stores, nil
+}
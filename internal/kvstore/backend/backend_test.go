@@ -0,0 +1,282 @@
+package backend_test
+
+import (
+	"censys/internal/kvstore"
+	"censys/internal/kvstore/backend"
+	_ "censys/internal/kvstore/btree"
+	_ "censys/internal/kvstore/inmemory"
+	"censys/internal/kvstore/kvstoretest"
+	_ "censys/internal/kvstore/lsm"
+	_ "censys/internal/kvstore/sqlite"
+	_ "censys/internal/kvstore/wal"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testConfig returns a config of a backend keeping its stores in a
+// temporary directory if it has a dir option
+func testConfig(t *testing.T, name string) backend.Config {
+	t.Helper()
+	_, options, err := backend.Describe(name)
+	if err != nil {
+		t.Fatalf("Describe() error = %v", err)
+	}
+	config := backend.Config{Backend: name}
+	for _, option := range options {
+		if option.Name == "dir" {
+			raw, _ := json.Marshal(map[string]string{"dir": t.TempDir()})
+			config.Options = map[string]json.RawMessage{name: raw}
+		}
+	}
+	return config
+}
+
+// closeStore closes a store if it needs closing
+func closeStore(t *testing.T, store kvstore.KeyValueStore) {
+	t.Cleanup(func() {
+		if closer, ok := store.(io.Closer); ok {
+			closer.Close()
+		}
+	})
+}
+
+// TestBackends runs the conformance tests against the keyspace and
+// namespace stores of every registered backend
+func TestBackends(t *testing.T) {
+	names := backend.Names()
+	if want := []string{"btree", "lsm", "memory", "sharded", "sqlite", "wal"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Names() = %v, want %v", names, want)
+	}
+
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			t.Run("keyspace", func(t *testing.T) {
+				kvstoretest.TestStore(t, func(t *testing.T) kvstore.KeyValueStore {
+					stores, err := backend.Open(testConfig(t, name))
+					if err != nil {
+						t.Fatalf("Open() error = %v", err)
+					}
+					closeStore(t, stores.Keyspace)
+					return stores.Keyspace
+				})
+			})
+			t.Run("namespace", func(t *testing.T) {
+				stores, err := backend.Open(testConfig(t, name))
+				if err != nil {
+					t.Fatalf("Open() error = %v", err)
+				}
+				closeStore(t, stores.Keyspace)
+				kvstoretest.TestStore(t, func(t *testing.T) kvstore.KeyValueStore {
+					store, err := stores.Namespaces.Create(t.Name(), kvstore.Quota{})
+					if err != nil {
+						t.Fatalf("Create() error = %v", err)
+					}
+					closeStore(t, store)
+					return store
+				})
+			})
+		})
+	}
+}
+
+// TestOnDisk checks that the namespaces of every backend keeping its stores
+// on disk are reopened with their keys, and deleted once dropped
+func TestOnDisk(t *testing.T) {
+	for _, name := range []string{"btree", "lsm", "sqlite", "wal"} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			config := testConfig(t, name)
+			open := func() *kvstore.Namespaces {
+				t.Helper()
+				stores, err := backend.Open(config)
+				if err != nil {
+					t.Fatalf("Open() error = %v", err)
+				}
+				closeStore(t, stores.Keyspace)
+				namespaces, err := kvstore.OpenNamespaces(ctx, stores.Namespaces)
+				if err != nil {
+					t.Fatalf("OpenNamespaces() error = %v", err)
+				}
+				return namespaces
+			}
+
+			namespaces := open()
+			quota := kvstore.Quota{MaxKeys: 10}
+			for _, ns := range []string{"team-a", "team-b"} {
+				namespace, err := namespaces.Create(ns, quota)
+				if err != nil {
+					t.Fatalf("Create() error = %v", err)
+				}
+				if err := namespace.Set(ctx, "key", ns); err != nil {
+					t.Fatalf("Set() error = %v", err)
+				}
+			}
+			namespaces.Close()
+
+			// Namespaces are reopened with their quota, keys and usage
+			namespaces = open()
+			for _, ns := range []string{"team-a", "team-b"} {
+				namespace, err := namespaces.Get(ns)
+				if err != nil {
+					t.Fatalf("Get() after a restart error = %v", err)
+				}
+				if namespace.Quota != quota {
+					t.Errorf("Quota = %+v, want %+v", namespace.Quota, quota)
+				}
+				if value, ok := namespace.Get(ctx, "key"); !ok || value != ns {
+					t.Errorf("Get(%q) = %q, %v, want %q", "key", value, ok, ns)
+				}
+				if usage, want := namespace.Usage(), (kvstore.Usage{Keys: 1, Bytes: int64(len("key") + len(ns))}); usage != want {
+					t.Errorf("Usage() = %+v, want %+v", usage, want)
+				}
+			}
+
+			// A dropped namespace is deleted from the disk
+			if err := namespaces.Drop("team-a"); err != nil {
+				t.Fatalf("Drop() error = %v", err)
+			}
+			namespaces.Close()
+			namespaces = open()
+			defer namespaces.Close()
+			var names []string
+			for _, namespace := range namespaces.List() {
+				names = append(names, namespace.Name)
+			}
+			if want := []string{"team-b"}; !reflect.DeepEqual(names, want) {
+				t.Errorf("List() after a drop = %v, want %v", names, want)
+			}
+			var dir struct{ Dir string }
+			json.Unmarshal(config.Options[name], &dir)
+			files, err := os.ReadDir(filepath.Join(dir.Dir, "namespaces"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 2 {
+				t.Errorf("namespaces holds %d files, want the catalog and one namespace", len(files))
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	t.Setenv("STORE_DIR", "/var/lib/kvstore")
+	t.Setenv("STORE_SHARDS", "8")
+
+	tests := []struct {
+		name string
+		want map[string]any
+	}{
+		{name: "memory", want: map[string]any{}},
+		{name: "sharded", want: map[string]any{"shards": 8}},
+		{name: "btree", want: map[string]any{"dir": "/var/lib/kvstore", "page_size": 4096, "no_sync": false}},
+		{name: "sqlite", want: map[string]any{"dir": "/var/lib/kvstore", "sweep_interval_ms": int64(60000)}},
+		{name: "wal", want: map[string]any{"dir": "/var/lib/kvstore", "sync_writes": false, "compaction_size": int64(4 << 20)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			description, options, err := backend.Describe(tt.name)
+			if err != nil {
+				t.Fatalf("Describe() error = %v", err)
+			}
+			if description == "" {
+				t.Error("Describe() returned no description")
+			}
+			got := make(map[string]any)
+			for _, option := range options {
+				got[option.Name] = option.Default
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Describe() options = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := backend.Describe("rocksdb"); !errors.Is(err, backend.ErrUnknownBackend) {
+		t.Errorf("Describe() error = %v, want %v", err, backend.ErrUnknownBackend)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	t.Setenv("STORE_SHARDS", "")
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{name: "defaults", config: `{"backend": "sharded"}`},
+		{name: "options", config: `{"backend": "sharded", "options": {"sharded": {"shards": 4}}}`},
+		{
+			name:   "other backend options",
+			config: `{"backend": "memory", "options": {"sharded": {"shards": 4}}}`,
+		},
+		{
+			name:    "unknown backend",
+			config:  `{"backend": "rocksdb"}`,
+			wantErr: `unknown storage backend "rocksdb"`,
+		},
+		{
+			name:    "unknown backend options",
+			config:  `{"backend": "memory", "options": {"rocksdb": {}}}`,
+			wantErr: `options of unknown storage backend "rocksdb"`,
+		},
+		{
+			name:    "unknown option",
+			config:  `{"backend": "sharded", "options": {"sharded": {"stripes": 4}}}`,
+			wantErr: `unknown field "stripes"`,
+		},
+		{
+			name:    "invalid option",
+			config:  `{"backend": "sharded", "options": {"sharded": {"shards": 0}}}`,
+			wantErr: "invalid shards 0",
+		},
+		{
+			name:    "unknown field",
+			config:  `{"engine": "memory"}`,
+			wantErr: `unknown field "engine"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			config, err := backend.LoadConfig(path)
+			var stores backend.Stores
+			if err == nil {
+				stores, err = backend.Open(config)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if stores.Keyspace == nil || stores.Namespaces == nil {
+				t.Errorf("Open() = %+v, want stores", stores)
+			}
+		})
+	}
+}
+
+func TestRegister_Twice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Register() of a registered name did not panic")
+		}
+	}()
+	backend.Register("memory", "", func() struct{} { return struct{}{} }, func(struct{}) (backend.Stores, error) {
+		return backend.Stores{}, nil
+	})
+}
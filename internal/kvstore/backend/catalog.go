@@ -0,0 +1,201 @@
+package backend
+
+import (
+	"censys/internal/kvstore"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// catalogFile is the name of the file recording the namespaces of a disk
+// catalog
+const catalogFile = "catalog.json"
+
+// record is a namespace recorded by a disk catalog
+type record struct {
+	Name     string `json:"name"`
+	Dir      string `json:"dir"`
+	MaxKeys  int64  `json:"max_keys,omitempty"`
+	MaxBytes int64  `json:"max_bytes,omitempty"`
+}
+
+// diskCatalog keeps every namespace in its own directory under dir, and
+// records them in dir/catalog.json
+type diskCatalog struct {
+	dir  string
+	name string
+	open func(path string) (kvstore.KeyValueStore, error)
+
+	mu      sync.Mutex
+	records []record
+}
+
+// openCatalog reads the namespaces recorded under dir, and deletes the
+// directories of namespaces that were not recorded or not fully removed
+func openCatalog(dir string, name string, open func(path string) (kvstore.KeyValueStore, error)) (*diskCatalog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &diskCatalog{dir: dir, name: name, open: open}
+	data, err := os.ReadFile(filepath.Join(dir, catalogFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &c.records); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", filepath.Join(dir, catalogFile), err)
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		recorded := slices.ContainsFunc(c.records, func(r record) bool {
+			return r.Dir == file.Name()
+		})
+		if file.IsDir() && !recorded {
+			if err := os.RemoveAll(filepath.Join(dir, file.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// Load opens the stores of the recorded namespaces
+func (c *diskCatalog) Load() ([]kvstore.StoredNamespace, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var stored []kvstore.StoredNamespace
+	for _, r := range c.records {
+		store, err := c.open(c.path(r))
+		if err != nil {
+			for _, s := range stored {
+				closeStore(s.Store)
+			}
+			return nil, fmt.Errorf("opening namespace %q: %w", r.Name, err)
+		}
+		stored = append(stored, kvstore.StoredNamespace{
+			Name:  r.Name,
+			Quota: kvstore.Quota{MaxKeys: r.MaxKeys, MaxBytes: r.MaxBytes},
+			Store: store,
+		})
+	}
+	return stored, nil
+}
+
+// Create opens a store in a new directory and records its namespace
+func (c *diskCatalog) Create(name string, quota kvstore.Quota) (kvstore.KeyValueStore, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dir, err := os.MkdirTemp(c.dir, "")
+	if err != nil {
+		return nil, err
+	}
+	r := record{
+		Name:     name,
+		Dir:      filepath.Base(dir),
+		MaxKeys:  quota.MaxKeys,
+		MaxBytes: quota.MaxBytes,
+	}
+	store, err := c.open(c.path(r))
+	if err == nil {
+		if err = c.save(append(slices.Clone(c.records), r)); err != nil {
+			closeStore(store)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	c.records = append(c.records, r)
+	return store, nil
+}
+
+// Remove forgets a namespace and deletes its directory. A directory that
+// fails to be deleted is logged rather than failing the removal, as the
+// namespace is not recorded anymore and the directory is deleted on the
+// next start.
+func (c *diskCatalog) Remove(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := slices.IndexFunc(c.records, func(r record) bool {
+		return r.Name == name
+	})
+	if i < 0 {
+		return kvstore.ErrNamespaceNotFound
+	}
+	r := c.records[i]
+	records := slices.Delete(slices.Clone(c.records), i, i+1)
+	if err := c.save(records); err != nil {
+		return err
+	}
+	c.records = records
+	if err := os.RemoveAll(filepath.Join(c.dir, r.Dir)); err != nil {
+		log.Printf("Failed to delete the directory of namespace %q: %s", name, err)
+	}
+	return nil
+}
+
+// path returns the path of the store of a recorded namespace
+func (c *diskCatalog) path(r record) string {
+	return filepath.Join(c.dir, r.Dir, c.name)
+}
+
+// save replaces the catalog file with records, writing and syncing a new
+// file and renaming it over the old one so that a crash leaves either one
+// intact
+func (c *diskCatalog) save(records []record) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(c.dir, catalogFile)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	// The directory entries of the catalog and of created namespaces are
+	// durable once the directory is synced
+	return syncDir(c.dir)
+}
+
+// syncDir makes the creation, renaming and removal of the files of dir
+// durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// closeStore closes a store if it needs closing
+func closeStore(store kvstore.KeyValueStore) {
+	if closer, ok := store.(io.Closer); ok {
+		closer.Close()
+	}
+}
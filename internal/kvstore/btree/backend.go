@@ -0,0 +1,33 @@
+package btree
+
+import (
+	"censys/internal/kvstore"
+	"censys/internal/kvstore/backend"
+)
+
+// Config configures the btree backend
+type Config struct {
+	// Dir is the directory of the stores, defaulting to STORE_DIR or data
+	Dir      string `json:"dir"`
+	PageSize int    `json:"page_size"`
+	NoSync   bool   `json:"no_sync"`
+}
+
+func init() {
+	backend.Register("btree", "keys on disk in a copy-on-write B+tree file",
+		func() Config {
+			return Config{
+				Dir:      backend.DirFromEnv(),
+				PageSize: DefaultPageSize,
+			}
+		},
+		func(config Config) (backend.Stores, error) {
+			options := &Options{
+				PageSize: config.PageSize,
+				NoSync:   config.NoSync,
+			}
+			return backend.OnDisk(config.Dir, "keyspace.db", func(path string) (kvstore.KeyValueStore, error) {
+				return Open(path, options)
+			})
+		})
+}
@@ -0,0 +1,70 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// compactBatch is the number of keys copied per transaction by Compact
+const compactBatch = 1 << 16
+
+// Compact copies the database at src to a new database at dst, which must
+// not exist. Keys are copied in order into full pages, which reclaims the
+// free pages of src and the space left in its sparse pages.
+func Compact(dst string, src string, options *Options) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	from, err := Open(src, options)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	if options == nil {
+		options = &Options{}
+	}
+	to, err := Open(dst, &Options{
+		PageSize: from.pageSize,
+		NoSync:   options.NoSync,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = from.View(func(source *Tx) error {
+		var tx *Tx
+		var err error
+		count := 0
+		scanErr := source.Scan("", func(key string, value string) bool {
+			if tx == nil {
+				if tx, err = to.Begin(true); err != nil {
+					return false
+				}
+				tx.FillPercent = 1
+			}
+			if err = tx.Put(key, value); err != nil {
+				return false
+			}
+			if count++; count%compactBatch == 0 {
+				err, tx = tx.Commit(), nil
+			}
+			return err == nil
+		})
+		if tx != nil {
+			if err == nil && scanErr == nil {
+				err = tx.Commit()
+			} else {
+				tx.Rollback()
+			}
+		}
+		return errors.Join(scanErr, err)
+	})
+	if closeErr := to.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
@@ -0,0 +1,328 @@
+// Package btree is an embedded key-value store kept in a single file as a
+// copy-on-write B+tree of fixed-size pages. A single write transaction at a
+// time copies the pages it modifies to free pages, then commits by
+// atomically swapping in a new meta page, while any number of read
+// transactions see the version committed when they began.
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+)
+
+// DefaultPageSize is the page size of new databases
+const DefaultPageSize = 4096
+
+// Errors returned by transactions
+var (
+	ErrClosed     = errors.New("database is closed")
+	ErrTxClosed   = errors.New("transaction is closed")
+	ErrTxReadOnly = errors.New("transaction is read-only")
+)
+
+// Options configure a database
+type Options struct {
+	// PageSize is the page size of a new database. Existing databases keep
+	// the page size they were created with. Defaults to DefaultPageSize.
+	PageSize int
+	// NoSync skips syncing commits to disk, trading their durability on a
+	// machine crash for speed
+	NoSync bool
+}
+
+// Stats are the statistics of a database
+type Stats struct {
+	PageSize int `json:"page_size"`
+	// Pages is the number of pages of the file in use
+	Pages int `json:"pages"`
+	// FreePages is the number of pages no longer used by the tree, which
+	// are reused by later commits or reclaimed by Compact
+	FreePages int    `json:"free_pages"`
+	TxID      uint64 `json:"txid"`
+}
+
+// DB is a database file
+type DB struct {
+	file     *os.File
+	pageSize int
+	noSync   bool
+
+	// writer is held by the write transaction
+	writer sync.Mutex
+	// free is only used by the write transaction
+	free freelist
+
+	mu      sync.RWMutex
+	meta    meta
+	readers map[uint64]int
+	closed  bool
+}
+
+// Open opens the database file at path, creating it if needed. The
+// version committed last is recovered, and the pages it does not use are
+// free.
+func Open(path string, options *Options) (*DB, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{
+		file:    file,
+		noSync:  options.NoSync,
+		readers: make(map[uint64]int),
+	}
+
+	info, err := file.Stat()
+	if err == nil && info.Size() == 0 {
+		pageSize := options.PageSize
+		if pageSize <= 0 {
+			pageSize = DefaultPageSize
+		}
+		err = db.init(pageSize)
+	} else if err == nil {
+		err = db.load()
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// init writes the meta pages and empty root leaf of a new database
+func (db *DB) init(pageSize int) error {
+	if pageSize < 2*metaSize || pageSize < 256 {
+		return fmt.Errorf("page size %d is too small", pageSize)
+	}
+	db.pageSize = pageSize
+	root := &node{leaf: true}
+	if _, err := db.file.WriteAt(root.encode(pageSize), 2*int64(pageSize)); err != nil {
+		return err
+	}
+	for txid := uint64(0); txid < 2; txid++ {
+		db.meta = meta{
+			pageSize:  uint32(pageSize),
+			root:      2,
+			highWater: 3,
+			txid:      txid,
+		}
+		if err := db.writeMeta(db.meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load reads the latest valid meta page and rebuilds the freelist from the
+// pages reachable from its root
+func (db *DB) load() error {
+	var metas []meta
+	buf := make([]byte, metaSize)
+	for page := int64(0); page < 2; page++ {
+		// The page size is unknown until a meta is read, so the second
+		// meta is looked for at every supported page size
+		for _, offset := range db.metaOffsets(page) {
+			if _, err := db.file.ReadAt(buf, offset); err != nil {
+				continue
+			}
+			if m, err := decodeMeta(buf); err == nil && int64(m.pageSize)*page == offset {
+				metas = append(metas, m)
+				break
+			}
+		}
+	}
+	if len(metas) == 0 {
+		return errors.New("no valid meta page")
+	}
+	db.meta = metas[0]
+	for _, m := range metas[1:] {
+		if m.txid > db.meta.txid {
+			db.meta = m
+		}
+	}
+	db.pageSize = int(db.meta.pageSize)
+
+	used := make(map[pgid]bool)
+	if err := db.walk(db.meta.root, used); err != nil {
+		return err
+	}
+	for id := pgid(2); id < db.meta.highWater; id++ {
+		if !used[id] {
+			db.free.ids = append(db.free.ids, id)
+		}
+	}
+	return nil
+}
+
+// metaOffsets returns the offsets at which the meta in page may be
+func (db *DB) metaOffsets(page int64) []int64 {
+	if page == 0 {
+		return []int64{0}
+	}
+	var offsets []int64
+	for size := int64(256); size <= 1<<16; size *= 2 {
+		offsets = append(offsets, size)
+	}
+	return offsets
+}
+
+// walk marks the pages of the subtree rooted at id as used
+func (db *DB) walk(id pgid, used map[pgid]bool) error {
+	n, err := db.readNode(id)
+	if err != nil {
+		return err
+	}
+	for page := id; page <= id+pgid(n.overflow); page++ {
+		used[page] = true
+	}
+	for _, child := range n.children {
+		if err := db.walk(child, used); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readNode reads and decodes the node stored at id and its overflow pages
+func (db *DB) readNode(id pgid) (*node, error) {
+	if id < 2 {
+		return nil, errCorrupt
+	}
+	buf := make([]byte, db.pageSize)
+	if _, err := db.file.ReadAt(buf, int64(id)*int64(db.pageSize)); err != nil {
+		return nil, err
+	}
+	if overflow := int64(binary.LittleEndian.Uint32(buf[8:])); overflow > 0 {
+		if overflow > math.MaxInt32/int64(db.pageSize) {
+			return nil, errCorrupt
+		}
+		buf = make([]byte, (overflow+1)*int64(db.pageSize))
+		if _, err := db.file.ReadAt(buf, int64(id)*int64(db.pageSize)); err != nil {
+			return nil, err
+		}
+	}
+	return decodeNode(id, buf)
+}
+
+// writeMeta writes a meta to the meta page of its transaction and syncs it
+func (db *DB) writeMeta(m meta) error {
+	if _, err := db.file.WriteAt(m.encode(), int64(m.txid%2)*int64(db.pageSize)); err != nil {
+		return err
+	}
+	return db.sync()
+}
+
+func (db *DB) sync() error {
+	if db.noSync {
+		return nil
+	}
+	return db.file.Sync()
+}
+
+// Begin starts a transaction. Only one write transaction runs at a time,
+// so Begin blocks until the previous one ends. Every transaction must be
+// committed or rolled back.
+func (db *DB) Begin(writable bool) (*Tx, error) {
+	if writable {
+		db.writer.Lock()
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		if writable {
+			db.writer.Unlock()
+		}
+		return nil, ErrClosed
+	}
+	tx := &Tx{
+		db:          db,
+		writable:    writable,
+		meta:        db.meta,
+		FillPercent: DefaultFillPercent,
+	}
+	if !writable {
+		db.readers[tx.meta.txid]++
+	}
+	return tx, nil
+}
+
+// View runs fn in a read transaction
+func (db *DB) View(fn func(*Tx) error) error {
+	tx, err := db.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(tx)
+}
+
+// Update runs fn in a write transaction, committed if fn succeeds and
+// rolled back otherwise
+func (db *DB) Update(fn func(*Tx) error) error {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// endRead ends a read transaction of the version txid
+func (db *DB) endRead(txid uint64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.readers[txid]--; db.readers[txid] == 0 {
+		delete(db.readers, txid)
+	}
+}
+
+// oldestReader returns the oldest version read by a transaction, or the
+// current version if none is
+func (db *DB) oldestReader() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	oldest := db.meta.txid
+	for txid := range db.readers {
+		oldest = min(oldest, txid)
+	}
+	return oldest
+}
+
+// Stats returns the statistics of the database
+func (db *DB) Stats() Stats {
+	db.writer.Lock()
+	defer db.writer.Unlock()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return Stats{
+		PageSize:  db.pageSize,
+		Pages:     int(db.meta.highWater),
+		FreePages: db.free.count(),
+		TxID:      db.meta.txid,
+	}
+}
+
+// Close waits for the write transaction to end and closes the file. Read
+// transactions must have ended.
+func (db *DB) Close() error {
+	db.writer.Lock()
+	defer db.writer.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	return db.file.Close()
+}
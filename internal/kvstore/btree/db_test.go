@@ -0,0 +1,417 @@
+package btree
+
+import (
+	"censys/internal/kvstore"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// smallOptions make small pages so that tests grow trees several levels
+// deep
+var smallOptions = &Options{PageSize: 256, NoSync: true}
+
+func open(t *testing.T, path string) *DB {
+	t.Helper()
+	db, err := Open(path, smallOptions)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// checkContents checks that the database holds exactly the keys of want,
+// in order
+func checkContents(t *testing.T, db *DB, want map[string]string) {
+	t.Helper()
+	ctx := context.Background()
+	for key, value := range want {
+		if got, ok := db.Get(ctx, key); !ok || got != value {
+			t.Errorf("Get(%q) = %q, %v, want %q", key, got, ok, value)
+		}
+	}
+	if _, ok := db.Get(ctx, "missing"); ok {
+		t.Error("Get() found a missing key")
+	}
+
+	keys := make([]string, 0, len(want))
+	for key := range want {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	got, err := db.Keys(ctx, "")
+	if err != nil {
+		t.Fatalf("Keys() error = %v", err)
+	}
+	if len(got) != 0 || len(keys) != 0 {
+		if !reflect.DeepEqual(got, keys) {
+			t.Errorf("Keys() = %d keys, want %d in order", len(got), len(keys))
+		}
+	}
+}
+
+func TestDB_CRUD(t *testing.T) {
+	ctx := context.Background()
+	db := open(t, filepath.Join(t.TempDir(), "db"))
+
+	want := make(map[string]string)
+	for _, i := range rand.Perm(2000) {
+		key := fmt.Sprintf("key-%04d", i)
+		value := strings.Repeat("v", i%50)
+		if err := db.Set(ctx, key, value); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+		want[key] = value
+	}
+	// A value larger than a page is stored in overflow pages
+	want["key-big"] = strings.Repeat("b", 3*smallOptions.PageSize)
+	if err := db.Set(ctx, "key-big", want["key-big"]); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	checkContents(t, db, want)
+
+	for _, i := range rand.Perm(2000)[:1500] {
+		key := fmt.Sprintf("key-%04d", i)
+		if err := db.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		delete(want, key)
+	}
+	if err := db.Delete(ctx, "missing"); err != nil {
+		t.Errorf("Delete() of a missing key error = %v", err)
+	}
+	checkContents(t, db, want)
+
+	for key := range want {
+		if err := db.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+	}
+	checkContents(t, db, nil)
+}
+
+func TestDB_InvalidKey(t *testing.T) {
+	ctx := context.Background()
+	db := open(t, filepath.Join(t.TempDir(), "db"))
+	if err := db.Set(ctx, "", "value"); !errors.Is(err, kvstore.ErrInvalidKey) {
+		t.Errorf("Set() error = %v, want %v", err, kvstore.ErrInvalidKey)
+	}
+	if err := db.Delete(ctx, ""); !errors.Is(err, kvstore.ErrInvalidKey) {
+		t.Errorf("Delete() error = %v, want %v", err, kvstore.ErrInvalidKey)
+	}
+}
+
+func TestDB_Keys(t *testing.T) {
+	ctx := context.Background()
+	db := open(t, filepath.Join(t.TempDir(), "db"))
+	for i := range 500 {
+		for _, prefix := range []string{"a", "b", "c"} {
+			if err := db.Set(ctx, fmt.Sprintf("%s/%03d", prefix, i), "value"); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		want   int
+	}{
+		{prefix: "", want: 1500},
+		{prefix: "b/", want: 500},
+		{prefix: "c/1", want: 100},
+		{prefix: "c/499", want: 1},
+		{prefix: "d", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			keys, err := db.Keys(ctx, tt.prefix)
+			if err != nil {
+				t.Fatalf("Keys() error = %v", err)
+			}
+			if len(keys) != tt.want {
+				t.Errorf("Keys() = %d keys, want %d", len(keys), tt.want)
+			}
+			if !sort.StringsAreSorted(keys) {
+				t.Error("Keys() are not in order")
+			}
+			for _, key := range keys {
+				if !strings.HasPrefix(key, tt.prefix) {
+					t.Errorf("Keys() returned %q", key)
+				}
+			}
+		})
+	}
+}
+
+func TestDB_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := Open(path, smallOptions)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	want := make(map[string]string)
+	for i := range 1000 {
+		key := fmt.Sprintf("key-%04d", i)
+		want[key] = fmt.Sprint(i)
+		if err := db.Set(ctx, key, want[key]); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	for i := 0; i < 1000; i += 3 {
+		key := fmt.Sprintf("key-%04d", i)
+		delete(want, key)
+		if err := db.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+	}
+	stats := db.Stats()
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := db.Set(ctx, "key", "value"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Set() after Close() error = %v, want %v", err, ErrClosed)
+	}
+
+	// The page size of the file wins over the options
+	db, err = Open(path, &Options{PageSize: 8192})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+	checkContents(t, db, want)
+	reopened := db.Stats()
+	if reopened.PageSize != stats.PageSize || reopened.TxID != stats.TxID || reopened.Pages != stats.Pages {
+		t.Errorf("Stats() after reopening = %+v, want %+v", reopened, stats)
+	}
+}
+
+func TestDB_SnapshotIsolation(t *testing.T) {
+	ctx := context.Background()
+	db := open(t, filepath.Join(t.TempDir(), "db"))
+	for i := range 300 {
+		if err := db.Set(ctx, fmt.Sprintf("key-%03d", i), "old"); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	reader, err := db.Begin(false)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	defer reader.Rollback()
+	if err := reader.Put("key", "value"); !errors.Is(err, ErrTxReadOnly) {
+		t.Errorf("Put() in a read transaction error = %v, want %v", err, ErrTxReadOnly)
+	}
+
+	// Overwrite every key many times, so that the pages of the version
+	// seen by the reader would be reused if they were freed
+	for round := range 5 {
+		for i := range 300 {
+			key := fmt.Sprintf("key-%03d", i)
+			if i%2 == 0 {
+				err = db.Set(ctx, key, fmt.Sprint("new-", round))
+			} else {
+				err = db.Delete(ctx, key)
+			}
+			if err != nil {
+				t.Fatalf("writing %q error = %v", key, err)
+			}
+		}
+	}
+
+	count := 0
+	err = reader.Scan("", func(key string, value string) bool {
+		if value != "old" {
+			t.Errorf("reader saw %q = %q", key, value)
+		}
+		count++
+		return true
+	})
+	if err != nil || count != 300 {
+		t.Errorf("Scan() = %d keys, %v, want 300 keys", count, err)
+	}
+	if value, ok, err := reader.Get("key-001"); err != nil || !ok || value != "old" {
+		t.Errorf("Get() = %q, %v, %v, want old", value, ok, err)
+	}
+	if value, ok := db.Get(ctx, "key-002"); !ok || value != "new-4" {
+		t.Errorf("Get() = %q, %v, want new-4", value, ok)
+	}
+}
+
+func TestDB_Rollback(t *testing.T) {
+	ctx := context.Background()
+	db := open(t, filepath.Join(t.TempDir(), "db"))
+	if err := db.Set(ctx, "kept", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	failure := errors.New("failure")
+	err := db.Update(func(tx *Tx) error {
+		for i := range 500 {
+			if err := tx.Put(fmt.Sprint("discarded-", i), "value"); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete("kept"); err != nil {
+			return err
+		}
+		// The transaction sees its own writes
+		if _, ok, _ := tx.Get("kept"); ok {
+			t.Error("Get() found a key deleted by the transaction")
+		}
+		if _, ok, _ := tx.Get("discarded-7"); !ok {
+			t.Error("Get() did not find a key put by the transaction")
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Update() error = %v, want %v", err, failure)
+	}
+	checkContents(t, db, map[string]string{"kept": "value"})
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	tx.Rollback()
+	if err := tx.Put("key", "value"); !errors.Is(err, ErrTxClosed) {
+		t.Errorf("Put() after Rollback() error = %v, want %v", err, ErrTxClosed)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrTxClosed) {
+		t.Errorf("Commit() after Rollback() error = %v, want %v", err, ErrTxClosed)
+	}
+}
+
+func TestDB_TornMeta(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := Open(path, smallOptions)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	for i := range 200 {
+		if err := db.Set(ctx, fmt.Sprintf("key-%03d", i), "committed"); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	if err := db.Set(ctx, "key-000", "torn"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	torn := db.Stats().TxID
+	db.Close()
+
+	// Corrupt the meta of the last commit, as if the machine crashed while
+	// it was written
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(torn%2) * int64(smallOptions.PageSize)
+	if _, err := file.WriteAt([]byte{0xff, 0xff}, offset+20); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	db = open(t, path)
+	if got := db.Stats().TxID; got != torn-1 {
+		t.Errorf("Stats().TxID = %d, want %d", got, torn-1)
+	}
+	if value, ok := db.Get(ctx, "key-000"); !ok || value != "committed" {
+		t.Errorf("Get() = %q, %v, want the previous version", value, ok)
+	}
+	if value, ok := db.Get(ctx, "key-199"); !ok || value != "committed" {
+		t.Errorf("Get() = %q, %v, want committed", value, ok)
+	}
+
+	// The recovered database keeps working
+	if err := db.Set(ctx, "key-000", "rewritten"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if value, _ := db.Get(ctx, "key-000"); value != "rewritten" {
+		t.Errorf("Get() = %q, want rewritten", value)
+	}
+}
+
+func TestDB_PageReuse(t *testing.T) {
+	ctx := context.Background()
+	db := open(t, filepath.Join(t.TempDir(), "db"))
+	for i := range 500 {
+		if err := db.Set(ctx, fmt.Sprintf("key-%03d", i), "value"); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	pages := db.Stats().Pages
+
+	// Rewriting the keys copies their pages, which the freed pages of
+	// earlier commits absorb
+	for range 20 {
+		for i := range 500 {
+			if err := db.Set(ctx, fmt.Sprintf("key-%03d", i), "other"); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+		}
+	}
+	if got := db.Stats().Pages; got > 2*pages {
+		t.Errorf("Stats().Pages = %d after rewrites, want at most %d", got, 2*pages)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "db")
+	db, err := Open(src, smallOptions)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	want := make(map[string]string)
+	for _, i := range rand.Perm(3000) {
+		key := fmt.Sprintf("key-%04d", i)
+		if err := db.Set(ctx, key, "value"); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+		if i%10 == 0 {
+			want[key] = "value"
+		}
+	}
+	for i := range 3000 {
+		if i%10 != 0 {
+			if err := db.Delete(ctx, fmt.Sprintf("key-%04d", i)); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+		}
+	}
+	before := db.Stats()
+	db.Close()
+
+	dst := filepath.Join(dir, "compacted")
+	if err := Compact(dst, src, smallOptions); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	if err := Compact(dst, src, smallOptions); err == nil {
+		t.Error("Compact() over an existing file succeeded")
+	}
+
+	compacted := open(t, dst)
+	checkContents(t, compacted, want)
+	after := compacted.Stats()
+	if after.PageSize != before.PageSize || after.FreePages > 1 || after.Pages >= before.Pages/4 {
+		t.Errorf("Stats() = %+v after compacting %+v", after, before)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := int64(after.Pages) * int64(after.PageSize); info.Size() != size {
+		t.Errorf("compacted file is %d bytes, want %d", info.Size(), size)
+	}
+}
@@ -0,0 +1,276 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sort"
+)
+
+// pgid is the id of a page, its offset in the file in pages
+type pgid uint64
+
+// span is the pages of a node: its first page and its overflow pages
+type span struct {
+	start    pgid
+	overflow uint32
+}
+
+// metaMagic starts the meta pages of a database file
+const metaMagic = 0x62747265
+
+// metaVersion is the version of the file format
+const metaVersion = 1
+
+// metaSize is the encoded size of a meta page
+const metaSize = 40
+
+// nodeHeaderSize is the size of the header of a node page: its flags, its
+// number of elements and its number of overflow pages
+const nodeHeaderSize = 12
+
+// Flags of node pages
+const (
+	branchPage = 1
+	leafPage   = 2
+)
+
+// errCorrupt is returned when a page cannot be decoded
+var errCorrupt = errors.New("corrupt page")
+
+// meta is the root of a committed version of the database. The first two
+// pages of the file hold a meta each, and commits alternate between them
+// so that a torn meta write leaves the previous version intact.
+type meta struct {
+	pageSize uint32
+	root     pgid
+	// highWater is the number of pages of the file in use
+	highWater pgid
+	txid      uint64
+}
+
+// encode encodes the meta followed by its checksum
+func (m meta) encode() []byte {
+	buf := make([]byte, 0, metaSize)
+	buf = binary.LittleEndian.AppendUint32(buf, metaMagic)
+	buf = binary.LittleEndian.AppendUint32(buf, metaVersion)
+	buf = binary.LittleEndian.AppendUint32(buf, m.pageSize)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(m.root))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(m.highWater))
+	buf = binary.LittleEndian.AppendUint64(buf, m.txid)
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+// decodeMeta decodes a meta page, failing if it is torn or not a meta
+func decodeMeta(buf []byte) (meta, error) {
+	if len(buf) < metaSize ||
+		binary.LittleEndian.Uint32(buf) != metaMagic ||
+		binary.LittleEndian.Uint32(buf[4:]) != metaVersion ||
+		crc32.ChecksumIEEE(buf[:metaSize-4]) != binary.LittleEndian.Uint32(buf[metaSize-4:]) {
+		return meta{}, errCorrupt
+	}
+	return meta{
+		pageSize:  binary.LittleEndian.Uint32(buf[8:]),
+		root:      pgid(binary.LittleEndian.Uint64(buf[12:])),
+		highWater: pgid(binary.LittleEndian.Uint64(buf[20:])),
+		txid:      binary.LittleEndian.Uint64(buf[28:]),
+	}, nil
+}
+
+// node is a node of the tree decoded from its page, or a copy being
+// modified by a write transaction. Branch nodes hold the smallest key of
+// every child, and the children modified by the transaction.
+type node struct {
+	pgid     pgid
+	overflow uint32
+	leaf     bool
+	keys     []string
+	values   []string
+	children []pgid
+	// childNodes holds the modified children of a branch node, nil for
+	// the children left untouched
+	childNodes []*node
+}
+
+// size returns the encoded size of the node
+func (n *node) size() int {
+	size := nodeHeaderSize
+	for i := range n.keys {
+		size += n.elementSize(i)
+	}
+	return size
+}
+
+// elementSize returns the encoded size of the i-th element of the node
+func (n *node) elementSize(i int) int {
+	size := uvarintSize(len(n.keys[i])) + len(n.keys[i])
+	if n.leaf {
+		return size + uvarintSize(len(n.values[i])) + len(n.values[i])
+	}
+	return size + 8
+}
+
+// pages returns the number of pages the node takes
+func (n *node) pages(pageSize int) int {
+	return (n.size() + pageSize - 1) / pageSize
+}
+
+// encode encodes the node into pages of pageSize bytes
+func (n *node) encode(pageSize int) []byte {
+	pages := n.pages(pageSize)
+	buf := make([]byte, nodeHeaderSize, pages*pageSize)
+	flags := uint32(branchPage)
+	if n.leaf {
+		flags = leafPage
+	}
+	binary.LittleEndian.PutUint32(buf, flags)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(n.keys)))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(pages-1))
+	for i, key := range n.keys {
+		buf = binary.AppendUvarint(buf, uint64(len(key)))
+		buf = append(buf, key...)
+		if n.leaf {
+			buf = binary.AppendUvarint(buf, uint64(len(n.values[i])))
+			buf = append(buf, n.values[i]...)
+		} else {
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(n.children[i]))
+		}
+	}
+	return buf[:cap(buf)]
+}
+
+// decodeNode decodes the node stored in buf, which must hold all of its
+// pages
+func decodeNode(id pgid, buf []byte) (*node, error) {
+	if len(buf) < nodeHeaderSize {
+		return nil, errCorrupt
+	}
+	flags := binary.LittleEndian.Uint32(buf)
+	count := int(binary.LittleEndian.Uint32(buf[4:]))
+	if (flags != branchPage && flags != leafPage) || count > len(buf) {
+		return nil, errCorrupt
+	}
+
+	n := &node{
+		pgid:     id,
+		overflow: binary.LittleEndian.Uint32(buf[8:]),
+		leaf:     flags == leafPage,
+		keys:     make([]string, 0, count),
+	}
+	data := buf[nodeHeaderSize:]
+	next := func() (string, bool) {
+		length, size := binary.Uvarint(data)
+		if size <= 0 || uint64(len(data)-size) < length {
+			return "", false
+		}
+		s := string(data[size : size+int(length)])
+		data = data[size+int(length):]
+		return s, true
+	}
+	for i := 0; i < count; i++ {
+		key, ok := next()
+		if !ok {
+			return nil, errCorrupt
+		}
+		n.keys = append(n.keys, key)
+		if n.leaf {
+			value, ok := next()
+			if !ok {
+				return nil, errCorrupt
+			}
+			n.values = append(n.values, value)
+			continue
+		}
+		if len(data) < 8 {
+			return nil, errCorrupt
+		}
+		n.children = append(n.children, pgid(binary.LittleEndian.Uint64(data)))
+		data = data[8:]
+	}
+	if !n.leaf {
+		n.childNodes = make([]*node, count)
+	}
+	return n, nil
+}
+
+// childIndex returns the index of the child of a branch node whose keys
+// may hold key
+func (n *node) childIndex(key string) int {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return n.keys[i] > key
+	})
+	return max(i-1, 0)
+}
+
+func uvarintSize(n int) int {
+	size := 1
+	for ; n >= 0x80; n >>= 7 {
+		size++
+	}
+	return size
+}
+
+// freelist tracks the free pages of the file. Pages freed by a commit are
+// pending until no reader of an earlier version may still read them.
+type freelist struct {
+	ids     []pgid
+	pending map[uint64][]pgid
+}
+
+// allocate takes n contiguous free pages, returning the first one or 0 if
+// there are no such pages
+func (f *freelist) allocate(n int) pgid {
+	for i := 0; i+n <= len(f.ids); i++ {
+		if f.ids[i+n-1]-f.ids[i] == pgid(n-1) {
+			start := f.ids[i]
+			f.ids = append(f.ids[:i], f.ids[i+n:]...)
+			return start
+		}
+	}
+	return 0
+}
+
+// free frees the pages of a node once the transaction txid is no longer
+// the oldest version readers may see
+func (f *freelist) free(txid uint64, start pgid, overflow uint32) {
+	if f.pending == nil {
+		f.pending = make(map[uint64][]pgid)
+	}
+	for id := start; id <= start+pgid(overflow); id++ {
+		f.pending[txid] = append(f.pending[txid], id)
+	}
+}
+
+// release frees the pages of the transactions up to txid
+func (f *freelist) release(txid uint64) {
+	for id, pages := range f.pending {
+		if id <= txid {
+			f.ids = append(f.ids, pages...)
+			delete(f.pending, id)
+		}
+	}
+	sort.Slice(f.ids, func(i, j int) bool {
+		return f.ids[i] < f.ids[j]
+	})
+}
+
+// count returns the number of free and pending pages
+func (f *freelist) count() int {
+	n := len(f.ids)
+	for _, pages := range f.pending {
+		n += len(pages)
+	}
+	return n
+}
+
+// clone returns a copy of the freelist, to restore if a commit fails
+func (f *freelist) clone() freelist {
+	clone := freelist{
+		ids:     append([]pgid(nil), f.ids...),
+		pending: make(map[uint64][]pgid, len(f.pending)),
+	}
+	for id, pages := range f.pending {
+		clone.pending[id] = append([]pgid(nil), pages...)
+	}
+	return clone
+}
@@ -0,0 +1,80 @@
+package btree
+
+import (
+	"censys/internal/kvstore"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Set sets a value for a key in its own write transaction
+func (db *DB) Set(ctx context.Context, key string, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := db.Update(func(tx *Tx) error {
+		return tx.Put(key, value)
+	})
+	if errors.Is(err, ErrEmptyKey) {
+		return fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey)
+	}
+	return err
+}
+
+// Get gets a value for a key in its own read transaction, logging read
+// errors as the key not being found
+func (db *DB) Get(ctx context.Context, key string) (string, bool) {
+	if ctx.Err() != nil {
+		return "", false
+	}
+	var value string
+	var ok bool
+	err := db.View(func(tx *Tx) error {
+		var err error
+		value, ok, err = tx.Get(key)
+		return err
+	})
+	if err != nil {
+		log.Printf("btree: reading %q: %s", key, err)
+		return "", false
+	}
+	return value, ok
+}
+
+// Delete deletes a value for a key in its own write transaction
+func (db *DB) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := db.Update(func(tx *Tx) error {
+		return tx.Delete(key)
+	})
+	if errors.Is(err, ErrEmptyKey) {
+		return fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey)
+	}
+	return err
+}
+
+// Keys returns the keys starting with prefix in ascending order, as of a
+// single version of the database
+func (db *DB) Keys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := db.View(func(tx *Tx) error {
+		return tx.Scan(prefix, func(key string, _ string) bool {
+			if !strings.HasPrefix(key, prefix) || ctx.Err() != nil {
+				return false
+			}
+			keys = append(keys, key)
+			return true
+		})
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
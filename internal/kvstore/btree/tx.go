@@ -0,0 +1,407 @@
+package btree
+
+import (
+	"errors"
+	"sort"
+)
+
+// DefaultFillPercent is how full nodes are left when split
+const DefaultFillPercent = 0.5
+
+// ErrEmptyKey is returned when writing an empty key
+var ErrEmptyKey = errors.New("key cannot be empty")
+
+// Tx is a transaction on a version of the database. Read transactions see
+// the version committed when they began until they end. The write
+// transaction copies the nodes it modifies, which are written to free
+// pages on commit.
+type Tx struct {
+	// FillPercent is how full the nodes split by the transaction are left,
+	// from 0.1 to 1. Transactions writing keys in ascending order fill
+	// pages best with 1.
+	FillPercent float64
+
+	db       *DB
+	writable bool
+	meta     meta
+	// root is the root copied by the write transaction once it modifies
+	// the tree
+	root *node
+	// freed holds the pages of the nodes the write transaction removed or
+	// copied
+	freed []span
+	done  bool
+}
+
+// Writable reports whether the transaction may write
+func (tx *Tx) Writable() bool {
+	return tx.writable
+}
+
+// child returns the i-th child of a branch node, the copy modified by the
+// transaction if any
+func (tx *Tx) child(n *node, i int) (*node, error) {
+	if c := n.childNodes[i]; c != nil {
+		return c, nil
+	}
+	return tx.db.readNode(n.children[i])
+}
+
+// rootNode returns the root of the version seen by the transaction
+func (tx *Tx) rootNode() (*node, error) {
+	if tx.root != nil {
+		return tx.root, nil
+	}
+	return tx.db.readNode(tx.meta.root)
+}
+
+// Get returns the value of a key
+func (tx *Tx) Get(key string) (string, bool, error) {
+	if tx.done {
+		return "", false, ErrTxClosed
+	}
+	n, err := tx.rootNode()
+	for err == nil && !n.leaf {
+		n, err = tx.child(n, n.childIndex(key))
+	}
+	if err != nil {
+		return "", false, err
+	}
+	i := sort.SearchStrings(n.keys, key)
+	if i < len(n.keys) && n.keys[i] == key {
+		return n.values[i], true, nil
+	}
+	return "", false, nil
+}
+
+// Scan calls fn with the keys from start and their values in ascending
+// order, until fn returns false
+func (tx *Tx) Scan(start string, fn func(key string, value string) bool) error {
+	if tx.done {
+		return ErrTxClosed
+	}
+	root, err := tx.rootNode()
+	if err != nil {
+		return err
+	}
+	_, err = tx.scan(root, start, fn)
+	return err
+}
+
+// scan scans the subtree of n, reporting whether fn asked to stop
+func (tx *Tx) scan(n *node, start string, fn func(string, string) bool) (bool, error) {
+	if n.leaf {
+		for i := sort.SearchStrings(n.keys, start); i < len(n.keys); i++ {
+			if !fn(n.keys[i], n.values[i]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	for i := n.childIndex(start); i < len(n.keys); i++ {
+		child, err := tx.child(n, i)
+		if err != nil {
+			return true, err
+		}
+		if stop, err := tx.scan(child, start, fn); stop || err != nil {
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+// writableRoot returns the root copied by the write transaction
+func (tx *Tx) writableRoot() (*node, error) {
+	if tx.done {
+		return nil, ErrTxClosed
+	}
+	if !tx.writable {
+		return nil, ErrTxReadOnly
+	}
+	if tx.root == nil {
+		root, err := tx.db.readNode(tx.meta.root)
+		if err != nil {
+			return nil, err
+		}
+		tx.root = root
+	}
+	return tx.root, nil
+}
+
+// writableChild returns the i-th child of a branch node copied by the
+// write transaction
+func (tx *Tx) writableChild(n *node, i int) (*node, error) {
+	if n.childNodes[i] == nil {
+		child, err := tx.db.readNode(n.children[i])
+		if err != nil {
+			return nil, err
+		}
+		n.childNodes[i] = child
+	}
+	return n.childNodes[i], nil
+}
+
+// Put sets the value of a key
+func (tx *Tx) Put(key string, value string) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	root, err := tx.writableRoot()
+	if err != nil {
+		return err
+	}
+	right, err := tx.put(root, key, value)
+	if err != nil {
+		return err
+	}
+	if right != nil {
+		// The root was split, so the tree grows a level
+		tx.root = &node{
+			keys:       []string{root.keys[0], right.keys[0]},
+			children:   []pgid{0, 0},
+			childNodes: []*node{root, right},
+		}
+	}
+	return nil
+}
+
+// put sets the value of a key in the subtree of n, returning the node
+// split off n if it grew too large
+func (tx *Tx) put(n *node, key string, value string) (*node, error) {
+	if n.leaf {
+		i := sort.SearchStrings(n.keys, key)
+		if i < len(n.keys) && n.keys[i] == key {
+			n.values[i] = value
+		} else {
+			n.keys = insert(n.keys, i, key)
+			n.values = insert(n.values, i, value)
+		}
+		return tx.split(n), nil
+	}
+
+	i := n.childIndex(key)
+	child, err := tx.writableChild(n, i)
+	if err != nil {
+		return nil, err
+	}
+	right, err := tx.put(child, key, value)
+	if err != nil {
+		return nil, err
+	}
+	n.keys[i] = child.keys[0]
+	if right != nil {
+		n.keys = insert(n.keys, i+1, right.keys[0])
+		n.children = insert(n.children, i+1, 0)
+		n.childNodes = insert(n.childNodes, i+1, right)
+	}
+	return tx.split(n), nil
+}
+
+// split splits a node larger than a page, leaving it as full as the fill
+// percent allows and returning the node holding the rest of its elements
+func (tx *Tx) split(n *node) *node {
+	pageSize := tx.db.pageSize
+	if len(n.keys) < 2 || n.size() <= pageSize {
+		return nil
+	}
+
+	fill := min(max(tx.FillPercent, 0.1), 1)
+	threshold := int(float64(pageSize) * fill)
+	i, size := 1, nodeHeaderSize+n.elementSize(0)
+	for ; i < len(n.keys)-1; i++ {
+		if size+n.elementSize(i) > threshold {
+			break
+		}
+		size += n.elementSize(i)
+	}
+
+	right := &node{
+		leaf: n.leaf,
+		keys: append([]string(nil), n.keys[i:]...),
+	}
+	n.keys = n.keys[:i:i]
+	if n.leaf {
+		right.values = append([]string(nil), n.values[i:]...)
+		n.values = n.values[:i:i]
+	} else {
+		right.children = append([]pgid(nil), n.children[i:]...)
+		right.childNodes = append([]*node(nil), n.childNodes[i:]...)
+		n.children = n.children[:i:i]
+		n.childNodes = n.childNodes[:i:i]
+	}
+	return right
+}
+
+// Delete deletes a key. Nodes left empty are removed, while nodes left
+// sparse keep their pages until Compact rewrites the file.
+func (tx *Tx) Delete(key string) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	if _, ok, err := tx.Get(key); err != nil || !ok {
+		return err
+	}
+	root, err := tx.writableRoot()
+	if err != nil {
+		return err
+	}
+	if err := tx.delete(root, key); err != nil {
+		return err
+	}
+
+	// Collapse roots left with a single child
+	for !tx.root.leaf && len(tx.root.keys) <= 1 {
+		old := tx.root
+		if len(old.keys) == 0 {
+			tx.root = &node{leaf: true}
+		} else if tx.root, err = tx.writableChild(old, 0); err != nil {
+			return err
+		}
+		tx.release(old)
+	}
+	return nil
+}
+
+// delete deletes a key from the subtree of n
+func (tx *Tx) delete(n *node, key string) error {
+	if n.leaf {
+		i := sort.SearchStrings(n.keys, key)
+		if i < len(n.keys) && n.keys[i] == key {
+			n.keys = remove(n.keys, i)
+			n.values = remove(n.values, i)
+		}
+		return nil
+	}
+
+	i := n.childIndex(key)
+	child, err := tx.writableChild(n, i)
+	if err != nil {
+		return err
+	}
+	if err := tx.delete(child, key); err != nil {
+		return err
+	}
+	if len(child.keys) > 0 {
+		n.keys[i] = child.keys[0]
+		return nil
+	}
+	n.keys = remove(n.keys, i)
+	n.children = remove(n.children, i)
+	n.childNodes = remove(n.childNodes, i)
+	tx.release(child)
+	return nil
+}
+
+// release records that the pages of a node are no longer used by the
+// version the transaction writes
+func (tx *Tx) release(n *node) {
+	if n.pgid != 0 {
+		tx.freed = append(tx.freed, span{n.pgid, n.overflow})
+	}
+}
+
+// Commit writes the nodes modified by the transaction to free pages, then
+// makes them the current version by writing a new meta page
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxClosed
+	}
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	defer tx.close()
+	if tx.root == nil {
+		return nil
+	}
+
+	// Pages are only allocated once the commit succeeds
+	db := tx.db
+	db.free.release(db.oldestReader())
+	free := db.free.clone()
+	if err := tx.spill(tx.root); err != nil {
+		db.free = free
+		return err
+	}
+	if err := db.sync(); err != nil {
+		db.free = free
+		return err
+	}
+
+	committed := tx.meta
+	committed.root = tx.root.pgid
+	committed.txid++
+	if err := db.writeMeta(committed); err != nil {
+		db.free = free
+		return err
+	}
+
+	// Readers of earlier versions may still read the pages this commit
+	// stopped using
+	for _, pages := range tx.freed {
+		db.free.free(committed.txid, pages.start, pages.overflow)
+	}
+	db.mu.Lock()
+	db.meta = committed
+	db.mu.Unlock()
+	return nil
+}
+
+// spill writes the modified nodes of the subtree of n to new pages,
+// children first so that their parents point to their new pages
+func (tx *Tx) spill(n *node) error {
+	for i, child := range n.childNodes {
+		if child == nil {
+			continue
+		}
+		if err := tx.spill(child); err != nil {
+			return err
+		}
+		n.children[i] = child.pgid
+	}
+
+	tx.release(n)
+	buf := n.encode(tx.db.pageSize)
+	pages := len(buf) / tx.db.pageSize
+	id := tx.db.free.allocate(pages)
+	if id == 0 {
+		id = tx.meta.highWater
+		tx.meta.highWater += pgid(pages)
+	}
+	if _, err := tx.db.file.WriteAt(buf, int64(id)*int64(tx.db.pageSize)); err != nil {
+		return err
+	}
+	n.pgid, n.overflow = id, uint32(pages-1)
+	return nil
+}
+
+// Rollback ends the transaction, discarding its changes
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxClosed
+	}
+	tx.close()
+	return nil
+}
+
+// close ends the transaction
+func (tx *Tx) close() {
+	tx.done = true
+	tx.root, tx.freed = nil, nil
+	if tx.writable {
+		tx.db.writer.Unlock()
+	} else {
+		tx.db.endRead(tx.meta.txid)
+	}
+}
+
+func insert[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func remove[T any](s []T, i int) []T {
+	return append(s[:i], s[i+1:]...)
+}
@@ -0,0 +1,24 @@
+package kvstore
+
+import (
+	"errors"
+)
+
+// Errors returned by stores and namespaces. Stores wrap them with details,
+// so they must be matched with errors.Is.
+var (
+	// ErrNotFound is returned when a key does not exist
+	ErrNotFound = errors.New("key not found")
+	// ErrInvalidKey is returned when a key cannot be stored, such as an
+	// empty key
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrConflict is returned when a write conflicts with a concurrent write
+	// to the same key and may succeed if retried
+	ErrConflict = errors.New("conflicting write")
+	// ErrQuotaExceeded is returned when a write would take a namespace over
+	// its quota
+	ErrQuotaExceeded = errors.New("namespace quota exceeded")
+
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	ErrNamespaceExists   = errors.New("namespace already exists")
+)
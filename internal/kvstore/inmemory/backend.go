@@ -0,0 +1,52 @@
+package kvstore
+
+import (
+	"censys/internal/kvstore"
+	"censys/internal/kvstore/backend"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// MemoryConfig configures the memory backend, which has no options
+type MemoryConfig struct{}
+
+// ShardedConfig configures the sharded backend
+type ShardedConfig struct {
+	// Shards is the number of shards of every store, defaulting to
+	// STORE_SHARDS or DefaultShards
+	Shards int `json:"shards"`
+}
+
+func init() {
+	backend.Register("memory", "keys in a sync.Map",
+		func() MemoryConfig { return MemoryConfig{} },
+		func(MemoryConfig) (backend.Stores, error) {
+			newStore := func() (kvstore.KeyValueStore, error) {
+				return &InMemoryStore{Data: sync.Map{}}, nil
+			}
+			keyspace, _ := newStore()
+			return backend.Stores{Keyspace: keyspace, Namespaces: kvstore.CatalogFunc(newStore)}, nil
+		})
+
+	backend.Register("sharded", "keys striped across maps guarded by their own lock",
+		func() ShardedConfig {
+			config := ShardedConfig{Shards: DefaultShards}
+			if value := os.Getenv("STORE_SHARDS"); value != "" {
+				// An invalid value is rejected when the backend is opened
+				config.Shards, _ = strconv.Atoi(value)
+			}
+			return config
+		},
+		func(config ShardedConfig) (backend.Stores, error) {
+			if config.Shards < 1 {
+				return backend.Stores{}, fmt.Errorf("invalid shards %d", config.Shards)
+			}
+			newStore := func() (kvstore.KeyValueStore, error) {
+				return NewShardedStore(config.Shards), nil
+			}
+			keyspace, _ := newStore()
+			return backend.Stores{Keyspace: keyspace, Namespaces: kvstore.CatalogFunc(newStore)}, nil
+		})
+}
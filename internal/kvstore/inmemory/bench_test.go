@@ -0,0 +1,67 @@
+package kvstore
+
+import (
+	"censys/internal/kvstore"
+	"context"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+)
+
+// benchmarkKeys is the number of distinct keys accessed by the benchmarks
+const benchmarkKeys = 1 << 14
+
+// BenchmarkStores compares the in-memory engines under workloads mixing
+// reads and writes of random keys in different proportions, from every
+// GOMAXPROCS goroutine at once. Run them with
+//
+//	go test -bench Stores -cpu 1,4,16 ./internal/kvstore/inmemory
+func BenchmarkStores(b *testing.B) {
+	stores := []struct {
+		name     string
+		newStore func() kvstore.KeyValueStore
+	}{
+		{"InMemoryStore", func() kvstore.KeyValueStore { return &InMemoryStore{} }},
+		{"ShardedStore", func() kvstore.KeyValueStore { return NewShardedStore(DefaultShards) }},
+	}
+	workloads := []struct {
+		name string
+		// writes is the percentage of operations that are writes
+		writes int
+	}{
+		{"read-heavy", 10},
+		{"mixed", 50},
+		{"write-heavy", 90},
+	}
+
+	keys := make([]string, benchmarkKeys)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+	}
+
+	for _, workload := range workloads {
+		for _, store := range stores {
+			b.Run(workload.name+"/"+store.name, func(b *testing.B) {
+				ctx := context.Background()
+				s := store.newStore()
+				for _, key := range keys {
+					s.Set(ctx, key, "value")
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					random := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+					for pb.Next() {
+						key := keys[random.IntN(len(keys))]
+						if random.IntN(100) < workload.writes {
+							s.Set(ctx, key, "value")
+						} else {
+							s.Get(ctx, key)
+						}
+					}
+				})
+			})
+		}
+	}
+}
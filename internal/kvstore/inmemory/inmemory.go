@@ -1,8 +1,11 @@
 package kvstore
 
 import (
+	"censys/internal/kvstore"
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -18,7 +21,7 @@ func (s *InMemoryStore) Set(ctx context.Context, key string, value string) error
 		return ctx.Err()
 	default:
 		if key == "" {
-			return fmt.Errorf("key cannot be empty")
+			return fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey)
 		}
 		s.Data.Store(key, value)
 		return nil
@@ -46,9 +49,26 @@ func (s *InMemoryStore) Delete(ctx context.Context, key string) error {
 		return ctx.Err()
 	default:
 		if key == "" {
-			return fmt.Errorf("key cannot be empty")
+			return fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey)
 		}
 		s.Data.Delete(key)
 		return nil
 	}
 }
+
+// Keys returns the keys starting with prefix in ascending order
+func (s *InMemoryStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	s.Data.Range(func(key, _ any) bool {
+		if k := key.(string); strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -1,8 +1,10 @@
 package kvstore
 
 import (
+	"censys/internal/kvstore"
 	"context"
-
+	"errors"
+	"reflect"
 	"sync"
 	"testing"
 )
@@ -95,6 +97,9 @@ func TestInMemoryStore_Set(t *testing.T) {
 				t.Errorf("Set() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if err != nil && !errors.Is(err, kvstore.ErrInvalidKey) {
+				t.Errorf("Set() error = %v, want %v", err, kvstore.ErrInvalidKey)
+			}
 		})
 	}
 }
@@ -142,3 +147,44 @@ func TestInMemoryStore_Delete(t *testing.T) {
 		})
 	}
 }
+
+func TestInMemoryStore_Keys(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{
+			name: "all keys",
+			want: []string{"a", "user-1", "user-2"},
+		},
+		{
+			name:   "prefix",
+			prefix: "user-",
+			want:   []string{"user-1", "user-2"},
+		},
+		{
+			name:   "no match",
+			prefix: "x",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &InMemoryStore{
+				Data: sync.Map{},
+			}
+			for _, key := range []string{"user-2", "a", "user-1"} {
+				store.Set(context.Background(), key, "test-value")
+			}
+
+			keys, err := store.Keys(context.Background(), tt.prefix)
+			if err != nil {
+				t.Fatalf("Keys() error = %v", err)
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("Keys() = %v, want %v", keys, tt.want)
+			}
+		})
+	}
+}
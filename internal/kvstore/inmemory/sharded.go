@@ -0,0 +1,158 @@
+package kvstore
+
+import (
+	"censys/internal/kvstore"
+	"context"
+	"fmt"
+	"hash/maphash"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultShards is the number of shards of a sharded store created with no
+// shard count
+const DefaultShards = 32
+
+// ShardedStore is an in-memory store striping its keys across shards, each
+// a map guarded by its own lock. Writes to keys of different shards do not
+// contend, and a key can be read, modified and written atomically.
+type ShardedStore struct {
+	seed   maphash.Seed
+	shards []shard
+}
+
+// shard is a stripe of a sharded store
+type shard struct {
+	mu   sync.RWMutex
+	data map[string]string
+}
+
+// NewShardedStore creates a store with the given number of shards, or
+// DefaultShards if it is not positive
+func NewShardedStore(shards int) *ShardedStore {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+	s := &ShardedStore{
+		seed:   maphash.MakeSeed(),
+		shards: make([]shard, shards),
+	}
+	for i := range s.shards {
+		s.shards[i].data = make(map[string]string)
+	}
+	return s
+}
+
+// shard returns the shard holding key
+func (s *ShardedStore) shard(key string) *shard {
+	return &s.shards[maphash.String(s.seed, key)%uint64(len(s.shards))]
+}
+
+// Set sets a value for a key
+func (s *ShardedStore) Set(ctx context.Context, key string, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if key == "" {
+		return fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey)
+	}
+
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.data[key] = value
+	return nil
+}
+
+// Get gets a value for a key
+func (s *ShardedStore) Get(ctx context.Context, key string) (string, bool) {
+	if ctx.Err() != nil {
+		return "", false
+	}
+
+	shard := s.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	value, ok := shard.data[key]
+	return value, ok
+}
+
+// Delete deletes a value for a key
+func (s *ShardedStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if key == "" {
+		return fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey)
+	}
+
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	delete(shard.data, key)
+	return nil
+}
+
+// Update atomically replaces the value of a key with the result of fn,
+// which is given the current value and whether the key exists. The key is
+// deleted if fn reports it should not exist, and left unchanged if fn
+// fails.
+func (s *ShardedStore) Update(ctx context.Context, key string, fn func(value string, ok bool) (string, bool, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if key == "" {
+		return fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey)
+	}
+
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	old, exists := shard.data[key]
+	value, keep, err := fn(old, exists)
+	switch {
+	case err != nil:
+		return err
+	case keep:
+		shard.data[key] = value
+	default:
+		delete(shard.data, key)
+	}
+	return nil
+}
+
+// Keys returns the keys starting with prefix in ascending order. Every
+// shard is scanned under its own lock, so the keys are not a snapshot of
+// the whole store.
+func (s *ShardedStore) Keys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for i := range s.shards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		for key := range shard.data {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Len returns the number of keys in the store
+func (s *ShardedStore) Len() int {
+	n := 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		n += len(shard.data)
+		shard.mu.RUnlock()
+	}
+	return n
+}
@@ -0,0 +1,111 @@
+package kvstore
+
+import (
+	"censys/internal/kvstore"
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestShardedStore(t *testing.T) {
+	tests := []struct {
+		name   string
+		shards int
+	}{
+		{"default shards", 0},
+		{"single shard", 1},
+		{"many shards", 64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := NewShardedStore(tt.shards)
+			if tt.shards == 0 && len(store.shards) != DefaultShards {
+				t.Errorf("NewShardedStore(0) has %d shards, want %d", len(store.shards), DefaultShards)
+			}
+
+			for _, key := range []string{"user-2", "a", "user-1"} {
+				if err := store.Set(ctx, key, "value-"+key); err != nil {
+					t.Fatalf("Set() error = %v", err)
+				}
+			}
+			if value, ok := store.Get(ctx, "user-1"); !ok || value != "value-user-1" {
+				t.Errorf("Get() = %q, %v, want value-user-1", value, ok)
+			}
+			if _, ok := store.Get(ctx, "missing"); ok {
+				t.Error("Get() found a missing key")
+			}
+
+			keys, err := store.Keys(ctx, "user-")
+			if err != nil || !reflect.DeepEqual(keys, []string{"user-1", "user-2"}) {
+				t.Errorf("Keys() = %v, %v", keys, err)
+			}
+
+			if err := store.Delete(ctx, "user-1"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, ok := store.Get(ctx, "user-1"); ok {
+				t.Error("Get() found a deleted key")
+			}
+			if got := store.Len(); got != 2 {
+				t.Errorf("Len() = %d, want 2", got)
+			}
+
+			for name, err := range map[string]error{
+				"Set":    store.Set(ctx, "", "value"),
+				"Delete": store.Delete(ctx, ""),
+			} {
+				if !errors.Is(err, kvstore.ErrInvalidKey) {
+					t.Errorf("%s() of an empty key error = %v, want %v", name, err, kvstore.ErrInvalidKey)
+				}
+			}
+		})
+	}
+}
+
+func TestShardedStore_Update(t *testing.T) {
+	ctx := context.Background()
+	store := NewShardedStore(4)
+	increment := func(value string, ok bool) (string, bool, error) {
+		n, _ := strconv.Atoi(value)
+		return strconv.Itoa(n + 1), true, nil
+	}
+
+	// Concurrent read-modify-writes of a key are not lost
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Update(ctx, "counter", increment)
+		}()
+	}
+	wg.Wait()
+	if value, _ := store.Get(ctx, "counter"); value != "100" {
+		t.Errorf("Get() after concurrent updates = %q, want 100", value)
+	}
+
+	// A failed update leaves the key unchanged
+	failure := errors.New("failed")
+	err := store.Update(ctx, "counter", func(string, bool) (string, bool, error) {
+		return "", false, failure
+	})
+	if err != failure {
+		t.Errorf("Update() error = %v, want %v", err, failure)
+	}
+	if value, _ := store.Get(ctx, "counter"); value != "100" {
+		t.Errorf("Get() after a failed update = %q, want 100", value)
+	}
+
+	// Updates may delete the key
+	store.Update(ctx, "counter", func(string, bool) (string, bool, error) {
+		return "", false, nil
+	})
+	if _, ok := store.Get(ctx, "counter"); ok {
+		t.Error("Get() found a key deleted by Update()")
+	}
+}
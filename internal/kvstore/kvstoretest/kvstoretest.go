@@ -0,0 +1,234 @@
+// Package kvstoretest is a conformance test suite for implementations of
+// kvstore.KeyValueStore
+package kvstoretest
+
+import (
+	"censys/internal/kvstore"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestStore tests that the stores created by newStore behave as a
+// kvstore.KeyValueStore, and as a kvstore.Scanner if they implement it.
+// Every subtest runs against a new, empty store.
+func TestStore(t *testing.T, newStore func(t *testing.T) kvstore.KeyValueStore) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s kvstore.KeyValueStore)
+	}{
+		{name: "SetGet", test: testSetGet},
+		{name: "Overwrite", test: testOverwrite},
+		{name: "Delete", test: testDelete},
+		{name: "InvalidKey", test: testInvalidKey},
+		{name: "Values", test: testValues},
+		{name: "Canceled", test: testCanceled},
+		{name: "Concurrent", test: testConcurrent},
+		{name: "Keys", test: testKeys},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+// checkGet checks the value of a key, or that it is missing if ok is false
+func checkGet(t *testing.T, s kvstore.KeyValueStore, key string, want string, ok bool) {
+	t.Helper()
+	value, found := s.Get(context.Background(), key)
+	if found != ok || value != want {
+		t.Errorf("Get(%q) = %q, %v, want %q, %v", key, value, found, want, ok)
+	}
+}
+
+func testSetGet(t *testing.T, s kvstore.KeyValueStore) {
+	ctx := context.Background()
+	checkGet(t, s, "key", "", false)
+	for i := range 100 {
+		if err := s.Set(ctx, fmt.Sprint("key-", i), fmt.Sprint("value-", i)); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	for i := range 100 {
+		checkGet(t, s, fmt.Sprint("key-", i), fmt.Sprint("value-", i), true)
+	}
+	checkGet(t, s, "key", "", false)
+}
+
+func testOverwrite(t *testing.T, s kvstore.KeyValueStore) {
+	ctx := context.Background()
+	for _, value := range []string{"first", "second", "third"} {
+		if err := s.Set(ctx, "key", value); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+		checkGet(t, s, "key", value, true)
+	}
+}
+
+func testDelete(t *testing.T, s kvstore.KeyValueStore) {
+	ctx := context.Background()
+	if err := s.Set(ctx, "key", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := s.Set(ctx, "other", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := s.Delete(ctx, "key"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	checkGet(t, s, "key", "", false)
+	checkGet(t, s, "other", "value", true)
+
+	// Deleting is idempotent, and a deleted key can be set again
+	if err := s.Delete(ctx, "key"); err != nil {
+		t.Errorf("Delete() of a deleted key error = %v", err)
+	}
+	if err := s.Delete(ctx, "missing"); err != nil {
+		t.Errorf("Delete() of a missing key error = %v", err)
+	}
+	if err := s.Set(ctx, "key", "again"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	checkGet(t, s, "key", "again", true)
+}
+
+func testInvalidKey(t *testing.T, s kvstore.KeyValueStore) {
+	ctx := context.Background()
+	if err := s.Set(ctx, "", "value"); !errors.Is(err, kvstore.ErrInvalidKey) {
+		t.Errorf("Set() of an empty key error = %v, want %v", err, kvstore.ErrInvalidKey)
+	}
+	if err := s.Delete(ctx, ""); !errors.Is(err, kvstore.ErrInvalidKey) {
+		t.Errorf("Delete() of an empty key error = %v, want %v", err, kvstore.ErrInvalidKey)
+	}
+	checkGet(t, s, "", "", false)
+}
+
+func testValues(t *testing.T, s kvstore.KeyValueStore) {
+	ctx := context.Background()
+	pairs := map[string]string{
+		"empty":           "",
+		"unicode-ключ-鍵":  "значение-値",
+		"spaces and\ttab": "line\nbreak",
+		"nul\x00byte":     "nul\x00byte",
+		"large":           strings.Repeat("0123456789", 10_000),
+	}
+	for key, value := range pairs {
+		if err := s.Set(ctx, key, value); err != nil {
+			t.Fatalf("Set(%q) error = %v", key, err)
+		}
+	}
+	for key, value := range pairs {
+		checkGet(t, s, key, value, true)
+	}
+}
+
+func testCanceled(t *testing.T, s kvstore.KeyValueStore) {
+	if err := s.Set(context.Background(), "key", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Set(ctx, "key", "canceled"); err == nil {
+		t.Error("Set() with a canceled context succeeded")
+	}
+	if err := s.Delete(ctx, "key"); err == nil {
+		t.Error("Delete() with a canceled context succeeded")
+	}
+	if _, ok := s.Get(ctx, "key"); ok {
+		t.Error("Get() with a canceled context found the key")
+	}
+	checkGet(t, s, "key", "value", true)
+}
+
+func testConcurrent(t *testing.T, s kvstore.KeyValueStore) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for writer := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				key := fmt.Sprintf("key-%d-%d", writer, i)
+				if err := s.Set(ctx, key, key); err != nil {
+					t.Errorf("Set() error = %v", err)
+					return
+				}
+				if value, ok := s.Get(ctx, key); !ok || value != key {
+					t.Errorf("Get(%q) = %q, %v, want its own write", key, value, ok)
+				}
+				if i%2 == 0 {
+					if err := s.Delete(ctx, key); err != nil {
+						t.Errorf("Delete() error = %v", err)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for writer := range 8 {
+		for i := range 50 {
+			key := fmt.Sprintf("key-%d-%d", writer, i)
+			if i%2 == 0 {
+				checkGet(t, s, key, "", false)
+			} else {
+				checkGet(t, s, key, key, true)
+			}
+		}
+	}
+}
+
+func testKeys(t *testing.T, s kvstore.KeyValueStore) {
+	scanner, ok := s.(kvstore.Scanner)
+	if !ok {
+		t.Skip("store does not implement kvstore.Scanner")
+	}
+	ctx := context.Background()
+	if keys, err := scanner.Keys(ctx, ""); err != nil || len(keys) != 0 {
+		t.Errorf("Keys() of an empty store = %q, %v, want none", keys, err)
+	}
+
+	all := []string{"a", "a/1", "a/2", "a/10", "ab", "b/1", "b/2", "c"}
+	for _, key := range all {
+		if err := s.Set(ctx, key, "value"); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	if err := s.Set(ctx, "b/3", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := s.Delete(ctx, "b/3"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	sort.Strings(all)
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "", want: all},
+		{prefix: "a", want: []string{"a", "a/1", "a/10", "a/2", "ab"}},
+		{prefix: "a/", want: []string{"a/1", "a/10", "a/2"}},
+		{prefix: "b/", want: []string{"b/1", "b/2"}},
+		{prefix: "c", want: []string{"c"}},
+		{prefix: "d", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			keys, err := scanner.Keys(ctx, tt.prefix)
+			if err != nil {
+				t.Fatalf("Keys() error = %v", err)
+			}
+			if len(keys) != 0 || len(tt.want) != 0 {
+				if !reflect.DeepEqual(keys, tt.want) {
+					t.Errorf("Keys(%q) = %q, want %q", tt.prefix, keys, tt.want)
+				}
+			}
+		})
+	}
+}
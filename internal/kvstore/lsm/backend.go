@@ -0,0 +1,50 @@
+package lsm
+
+import (
+	"censys/internal/kvstore"
+	"censys/internal/kvstore/backend"
+)
+
+// Config configures the lsm backend
+type Config struct {
+	// Dir is the directory of the stores, defaulting to STORE_DIR or data
+	Dir             string `json:"dir"`
+	MemtableSize    int    `json:"memtable_size"`
+	BlockSize       int    `json:"block_size"`
+	BloomBitsPerKey int    `json:"bloom_bits_per_key"`
+	TableSize       uint64 `json:"table_size"`
+	L0Tables        int    `json:"l0_tables"`
+	LevelSize       int64  `json:"level_size"`
+	SyncWrites      bool   `json:"sync_writes"`
+}
+
+func init() {
+	backend.Register("lsm", "keys on disk in a log-structured merge tree",
+		func() Config {
+			options := Options{}.withDefaults()
+			return Config{
+				Dir:             backend.DirFromEnv(),
+				MemtableSize:    options.MemtableSize,
+				BlockSize:       options.BlockSize,
+				BloomBitsPerKey: options.BloomBitsPerKey,
+				TableSize:       options.TableSize,
+				L0Tables:        options.L0Tables,
+				LevelSize:       options.LevelSize,
+				SyncWrites:      options.SyncWrites,
+			}
+		},
+		func(config Config) (backend.Stores, error) {
+			options := Options{
+				MemtableSize:    config.MemtableSize,
+				BlockSize:       config.BlockSize,
+				BloomBitsPerKey: config.BloomBitsPerKey,
+				TableSize:       config.TableSize,
+				L0Tables:        config.L0Tables,
+				LevelSize:       config.LevelSize,
+				SyncWrites:      config.SyncWrites,
+			}
+			return backend.OnDisk(config.Dir, "keyspace", func(path string) (kvstore.KeyValueStore, error) {
+				return Open(path, options)
+			})
+		})
+}
@@ -0,0 +1,360 @@
+package lsm
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// manifestName is the name of the file recording the tables of every level
+const manifestName = "MANIFEST"
+
+// tableMeta describes an SSTable in the manifest
+type tableMeta struct {
+	Number   uint64 `json:"number"`
+	Smallest string `json:"smallest"`
+	Largest  string `json:"largest"`
+	Size     int64  `json:"size"`
+}
+
+// manifest is the persisted state of a store. It is replaced atomically
+// on every flush and compaction, so a crash leaves either the old or the
+// new set of tables.
+type manifest struct {
+	// NextFile is the number of the next table or log created
+	NextFile uint64 `json:"next_file"`
+	// LogNumber is the oldest write-ahead log not yet flushed to a table
+	LogNumber uint64        `json:"log_number"`
+	Levels    [][]tableMeta `json:"levels"`
+}
+
+// readManifest reads the manifest of dir, returning an empty one if the
+// store is new
+func readManifest(dir string) (manifest, error) {
+	var m manifest
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	return m, json.Unmarshal(data, &m)
+}
+
+// writeManifest replaces the manifest of dir by writing a temporary file
+// and renaming it over the current one
+func writeManifest(dir string, m manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, manifestName+".tmp")
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, manifestName)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes the creation, renaming and removal of the files of dir
+// durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// iterator iterates over entries in ascending key order
+type iterator interface {
+	valid() bool
+	entry() entry
+	next()
+}
+
+// sliceIterator iterates over sorted entries in memory
+type sliceIterator struct {
+	entries []entry
+}
+
+func (it *sliceIterator) valid() bool  { return len(it.entries) > 0 }
+func (it *sliceIterator) entry() entry { return it.entries[0] }
+func (it *sliceIterator) next()        { it.entries = it.entries[1:] }
+
+// mergeIterator merges iterators ordered from newest to oldest, yielding
+// only the newest entry of every key
+type mergeIterator struct {
+	iterators []iterator
+	current   entry
+	ok        bool
+}
+
+func newMergeIterator(iterators ...iterator) *mergeIterator {
+	it := &mergeIterator{iterators: iterators}
+	it.next()
+	return it
+}
+
+func (it *mergeIterator) valid() bool  { return it.ok }
+func (it *mergeIterator) entry() entry { return it.current }
+
+func (it *mergeIterator) next() {
+	it.ok = false
+	for _, source := range it.iterators {
+		if source.valid() && (!it.ok || source.entry().key < it.current.key) {
+			it.current, it.ok = source.entry(), true
+		}
+	}
+	if !it.ok {
+		return
+	}
+	for _, source := range it.iterators {
+		if source.valid() && source.entry().key == it.current.key {
+			source.next()
+		}
+	}
+}
+
+// compaction merges input tables of a level and of the level below into
+// new tables of the level below
+type compaction struct {
+	level  int
+	inputs [2][]*table
+}
+
+// levelLimit returns the maximum total size of the tables of a level
+// below level 0
+func (s *Store) levelLimit(level int) int64 {
+	return s.options.LevelSize * int64(math.Pow(10, float64(level-1)))
+}
+
+// pickCompaction picks the next compaction: all of level 0 once it holds
+// too many tables, or a table of the first level over its size limit,
+// taken in turns across the key space. The caller must hold s.mu.
+func (s *Store) pickCompaction() *compaction {
+	if len(s.levels[0]) >= s.options.L0Tables {
+		c := &compaction{level: 0}
+		c.inputs[0] = append(c.inputs[0], s.levels[0]...)
+		c.inputs[1] = overlapping(s.levels[1], c.inputs[0])
+		return c
+	}
+
+	for level := 1; level < len(s.levels)-1; level++ {
+		var size int64
+		for _, t := range s.levels[level] {
+			size += t.Size
+		}
+		if size <= s.levelLimit(level) {
+			continue
+		}
+
+		tables := s.levels[level]
+		pick := tables[0]
+		for _, t := range tables {
+			if t.Smallest > s.compactFrom[level] {
+				pick = t
+				break
+			}
+		}
+		s.compactFrom[level] = pick.Largest
+		c := &compaction{level: level}
+		c.inputs[0] = []*table{pick}
+		c.inputs[1] = overlapping(s.levels[level+1], c.inputs[0])
+		return c
+	}
+	return nil
+}
+
+// overlapping returns the tables whose key range overlaps the range of
+// inputs
+func overlapping(tables []*table, inputs []*table) []*table {
+	smallest, largest := inputs[0].Smallest, inputs[0].Largest
+	for _, t := range inputs[1:] {
+		smallest = min(smallest, t.Smallest)
+		largest = max(largest, t.Largest)
+	}
+	var overlaps []*table
+	for _, t := range tables {
+		if t.Largest >= smallest && t.Smallest <= largest {
+			overlaps = append(overlaps, t)
+		}
+	}
+	return overlaps
+}
+
+// compact runs one compaction if any is needed, reporting whether it did.
+// Only the background goroutine compacts, so the input tables cannot
+// change while they are merged without holding s.mu.
+func (s *Store) compact() (bool, error) {
+	s.mu.Lock()
+	c := s.pickCompaction()
+	bottom := true
+	if c != nil {
+		for _, tables := range s.levels[c.level+2:] {
+			bottom = bottom && len(tables) == 0
+		}
+	}
+	s.mu.Unlock()
+	if c == nil {
+		return false, nil
+	}
+
+	// Newer tables come first: the newest level 0 table, then the level
+	// being compacted, then the level below
+	var iterators []iterator
+	for i := len(c.inputs[0]) - 1; i >= 0; i-- {
+		iterators = append(iterators, c.inputs[0][i].iterator(""))
+	}
+	for _, t := range c.inputs[1] {
+		iterators = append(iterators, t.iterator(""))
+	}
+	merged := newMergeIterator(iterators...)
+
+	// Tombstones are dropped once no older write to their key may remain
+	// below the output level
+	outputs, err := s.writeTables(merged, s.options.TableSize, bottom)
+	if err != nil {
+		return false, err
+	}
+	for _, it := range iterators {
+		if err := it.(*tableIterator).err; err != nil {
+			s.removeTables(outputs)
+			return false, err
+		}
+	}
+
+	s.mu.Lock()
+	obsolete := make(map[*table]bool)
+	for _, tables := range c.inputs {
+		for _, t := range tables {
+			obsolete[t] = true
+		}
+	}
+	for _, level := range []int{c.level, c.level + 1} {
+		var kept []*table
+		for _, t := range s.levels[level] {
+			if !obsolete[t] {
+				kept = append(kept, t)
+			}
+		}
+		s.levels[level] = kept
+	}
+	s.levels[c.level+1] = append(s.levels[c.level+1], outputs...)
+	sort.Slice(s.levels[c.level+1], func(i, j int) bool {
+		return s.levels[c.level+1][i].Smallest < s.levels[c.level+1][j].Smallest
+	})
+	err = s.saveManifest()
+	s.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	for t := range obsolete {
+		t.close()
+		os.Remove(s.tablePath(t.Number))
+	}
+	return true, nil
+}
+
+// writeTables writes the entries of it to new tables of at most size
+// bytes, dropping tombstones if asked to. The tables are opened but not
+// yet part of any level.
+func (s *Store) writeTables(it iterator, size uint64, dropTombstones bool) ([]*table, error) {
+	var tables []*table
+	var writer *tableWriter
+	var number uint64
+	finish := func() error {
+		meta, err := writer.finish(number)
+		writer = nil
+		if err != nil {
+			os.Remove(s.tablePath(number))
+			return err
+		}
+		t, err := openTable(s.tablePath(number), meta)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, t)
+		return nil
+	}
+
+	for ; it.valid(); it.next() {
+		e := it.entry()
+		if e.deleted && dropTombstones {
+			continue
+		}
+		if writer == nil {
+			number = s.newFileNumber()
+			var err error
+			if writer, err = newTableWriter(s.tablePath(number), s.options.BlockSize, s.options.BloomBitsPerKey); err != nil {
+				s.removeTables(tables)
+				return nil, err
+			}
+		}
+		if err := writer.add(e); err != nil {
+			writer.abort()
+			s.removeTables(tables)
+			return nil, err
+		}
+		if writer.size() >= size {
+			if err := finish(); err != nil {
+				s.removeTables(tables)
+				return nil, err
+			}
+		}
+	}
+	if writer != nil {
+		if err := finish(); err != nil {
+			s.removeTables(tables)
+			return nil, err
+		}
+	}
+	return tables, nil
+}
+
+// removeTables closes and deletes tables that were never installed
+func (s *Store) removeTables(tables []*table) {
+	for _, t := range tables {
+		t.close()
+		os.Remove(s.tablePath(t.Number))
+	}
+}
+
+// saveManifest persists the current levels, and the oldest log holding
+// writes not yet flushed to them. The caller must hold s.mu.
+func (s *Store) saveManifest() error {
+	logNumber := s.logNumber
+	if s.imm != nil {
+		logNumber = s.immLog
+	}
+	m := manifest{
+		NextFile:  s.nextFile,
+		LogNumber: logNumber,
+		Levels:    make([][]tableMeta, len(s.levels)),
+	}
+	for level, tables := range s.levels {
+		for _, t := range tables {
+			m.Levels[level] = append(m.Levels[level], t.tableMeta)
+		}
+	}
+	return writeManifest(s.dir, m)
+}
@@ -0,0 +1,543 @@
+// Package lsm is a disk-based key-value store built as a log-structured
+// merge tree. Writes are logged and buffered in a memtable, which is
+// flushed to an immutable SSTable once full. SSTables are organized in
+// levels and merged by background compactions, and deletes are recorded as
+// tombstones until compaction drops them.
+package lsm
+
+import (
+	"censys/internal/kvstore"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// levels is the number of levels of SSTables
+const levels = 7
+
+// ErrClosed is returned when writing to a closed store
+var ErrClosed = errors.New("store is closed")
+
+// Options configure a store. Zero values are replaced by the defaults.
+type Options struct {
+	// MemtableSize is the size of the keys and values buffered in memory
+	// before they are flushed to a table. Defaults to 4 MiB.
+	MemtableSize int
+	// BlockSize is the size of the data blocks of tables. Defaults to
+	// 4 KiB.
+	BlockSize int
+	// BloomBitsPerKey is the size of the bloom filter of tables per key.
+	// Defaults to 10, for a false positive rate of about 1%.
+	BloomBitsPerKey int
+	// TableSize is the size of the tables written by compactions. Defaults
+	// to 2 MiB.
+	TableSize uint64
+	// L0Tables is the number of tables flushed to level 0 that triggers
+	// their compaction into level 1. Defaults to 4.
+	L0Tables int
+	// LevelSize is the size of level 1, every next level being ten times
+	// larger. Defaults to 10 MiB.
+	LevelSize int64
+	// SyncWrites syncs the write-ahead log on every write, so that writes
+	// survive a machine crash and not only a process crash
+	SyncWrites bool
+}
+
+// withDefaults returns the options with their zero values defaulted
+func (o Options) withDefaults() Options {
+	if o.MemtableSize <= 0 {
+		o.MemtableSize = 4 << 20
+	}
+	if o.BlockSize <= 0 {
+		o.BlockSize = 4 << 10
+	}
+	if o.BloomBitsPerKey <= 0 {
+		o.BloomBitsPerKey = 10
+	}
+	if o.TableSize == 0 {
+		o.TableSize = 2 << 20
+	}
+	if o.L0Tables <= 0 {
+		o.L0Tables = 4
+	}
+	if o.LevelSize <= 0 {
+		o.LevelSize = 10 << 20
+	}
+	return o
+}
+
+// Store is a key-value store persisted to a directory as a log-structured
+// merge tree
+type Store struct {
+	dir     string
+	options Options
+	work    chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+
+	mu sync.RWMutex
+	// flushed is signalled whenever the immutable memtable is flushed or
+	// the store fails or closes
+	flushed     *sync.Cond
+	mem         *memtable
+	log         *wal
+	logNumber   uint64
+	imm         *memtable
+	immLog      uint64
+	levels      [levels][]*table
+	compactFrom [levels]string
+	nextFile    uint64
+	err         error
+	closed      bool
+}
+
+// Open opens the store in dir, creating it if needed, and recovers the
+// writes logged before it was last closed
+func Open(dir string, options Options) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	s := &Store{
+		dir:      dir,
+		options:  options.withDefaults(),
+		work:     make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+		mem:      newMemtable(),
+		nextFile: max(m.NextFile, 1),
+	}
+	s.flushed = sync.NewCond(&s.mu)
+	if err := s.recover(m); err != nil {
+		s.closeTables()
+		return nil, err
+	}
+
+	// Compact the tables recovered from the logs if they are too many
+	s.work <- struct{}{}
+	go s.background()
+	return s, nil
+}
+
+// recover opens the tables of the manifest, flushes the writes of the logs
+// it does not cover to level 0 and starts a new log
+func (s *Store) recover(m manifest) error {
+	live := make(map[uint64]bool)
+	for level, metas := range m.Levels {
+		if level >= levels {
+			return fmt.Errorf("manifest has %d levels, want at most %d", len(m.Levels), levels)
+		}
+		for _, meta := range metas {
+			t, err := openTable(s.tablePath(meta.Number), meta)
+			if err != nil {
+				return err
+			}
+			s.levels[level] = append(s.levels[level], t)
+			live[meta.Number] = true
+		}
+	}
+
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var logs []uint64
+	var obsolete []string
+	for _, file := range files {
+		name := file.Name()
+		number, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch filepath.Ext(name) {
+		case ".log":
+			if number >= m.LogNumber {
+				logs = append(logs, number)
+			} else {
+				obsolete = append(obsolete, name)
+			}
+		case ".sst":
+			// Tables written by a compaction or flush that did not finish
+			if !live[number] {
+				obsolete = append(obsolete, name)
+			}
+		}
+		s.nextFile = max(s.nextFile, number+1)
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i] < logs[j]
+	})
+	for _, number := range logs {
+		if err := replayWAL(s.logPath(number), s.mem.put); err != nil {
+			return fmt.Errorf("replaying %s: %w", s.logPath(number), err)
+		}
+		obsolete = append(obsolete, filepath.Base(s.logPath(number)))
+	}
+
+	if len(s.mem.entries) > 0 {
+		tables, err := s.writeTables(&sliceIterator{s.mem.sorted()}, ^uint64(0), false)
+		if err != nil {
+			return err
+		}
+		s.levels[0] = append(s.levels[0], tables...)
+		s.mem = newMemtable()
+	}
+	if err := s.newLog(); err != nil {
+		return err
+	}
+	if err := s.saveManifest(); err != nil {
+		return err
+	}
+	for _, name := range obsolete {
+		os.Remove(filepath.Join(s.dir, name))
+	}
+	return nil
+}
+
+// newLog starts the write-ahead log of a new memtable. The caller must
+// hold s.mu.
+func (s *Store) newLog() error {
+	number := s.nextFile
+	s.nextFile++
+	log, err := createWAL(s.logPath(number), s.options.SyncWrites)
+	if err != nil {
+		return err
+	}
+	s.log, s.logNumber = log, number
+	return nil
+}
+
+// newFileNumber allocates the number of a new table
+func (s *Store) newFileNumber() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	number := s.nextFile
+	s.nextFile++
+	return number
+}
+
+func (s *Store) tablePath(number uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%06d.sst", number))
+}
+
+func (s *Store) logPath(number uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%06d.log", number))
+}
+
+// Set sets a value for a key
+func (s *Store) Set(ctx context.Context, key string, value string) error {
+	return s.write(ctx, entry{key: key, value: value})
+}
+
+// Delete deletes a value for a key by writing a tombstone
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return s.write(ctx, entry{key: key, deleted: true})
+}
+
+// write logs a write and applies it to the memtable, which is handed to
+// the background flush once full. Writes stall while the previous
+// memtable is still being flushed.
+func (s *Store) write(ctx context.Context, e entry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if e.key == "" {
+		return fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if err := s.log.append(e); err != nil {
+		return err
+	}
+	s.mem.put(e)
+	if s.mem.size >= s.options.MemtableSize {
+		return s.rotate()
+	}
+	return nil
+}
+
+// writable returns why the store cannot be written to, if it cannot. The
+// caller must hold s.mu.
+func (s *Store) writable() error {
+	if s.closed {
+		return ErrClosed
+	}
+	return s.err
+}
+
+// rotate makes the memtable immutable and starts a new one, waiting for
+// the previous immutable memtable to be flushed first. The caller must
+// hold s.mu.
+func (s *Store) rotate() error {
+	for s.imm != nil {
+		if err := s.writable(); err != nil {
+			return err
+		}
+		s.flushed.Wait()
+	}
+	if err := s.writable(); err != nil {
+		return err
+	}
+
+	previous := s.log
+	s.imm, s.immLog = s.mem, s.logNumber
+	s.mem = newMemtable()
+	if err := s.newLog(); err != nil {
+		s.err = err
+		return err
+	}
+	previous.close()
+
+	select {
+	case s.work <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Flush flushes the writes buffered in memory to a table and waits until
+// it is written
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.mem.entries) > 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	for s.imm != nil {
+		if err := s.writable(); err != nil {
+			return err
+		}
+		s.flushed.Wait()
+	}
+	return nil
+}
+
+// Get gets a value for a key, logging read errors as the key not being
+// found. A table that fails to be read fails the read rather than letting
+// older writes of the key be returned.
+func (s *Store) Get(ctx context.Context, key string) (string, bool) {
+	if ctx.Err() != nil {
+		return "", false
+	}
+	e, ok, err := s.lookup(key)
+	if err != nil {
+		log.Printf("lsm: %s", err)
+		return "", false
+	}
+	return e.value, ok && !e.deleted
+}
+
+// lookup looks a key up from the newest writes to the oldest: the
+// memtables, level 0 from its newest table, then every deeper level
+func (s *Store) lookup(key string) (entry, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, m := range []*memtable{s.mem, s.imm} {
+		if m == nil {
+			continue
+		}
+		if e, ok := m.get(key); ok {
+			return e, true, nil
+		}
+	}
+
+	for i := len(s.levels[0]) - 1; i >= 0; i-- {
+		if e, ok, err := s.tableGet(s.levels[0][i], key); ok || err != nil {
+			return e, ok, err
+		}
+	}
+	for _, tables := range s.levels[1:] {
+		// Tables below level 0 do not overlap and are sorted by key
+		i := sort.Search(len(tables), func(i int) bool {
+			return tables[i].Largest >= key
+		})
+		if i < len(tables) {
+			if e, ok, err := s.tableGet(tables[i], key); ok || err != nil {
+				return e, ok, err
+			}
+		}
+	}
+	return entry{}, false, nil
+}
+
+// tableGet looks a key up in a table
+func (s *Store) tableGet(t *table, key string) (entry, bool, error) {
+	e, ok, err := t.get(key)
+	if err != nil {
+		return entry{}, false, fmt.Errorf("reading %s: %w", s.tablePath(t.Number), err)
+	}
+	return e, ok, nil
+}
+
+// Keys returns the keys starting with prefix in ascending order
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Merge every source from the newest to the oldest
+	var iterators []iterator
+	var tables []*tableIterator
+	for _, m := range []*memtable{s.mem, s.imm} {
+		if m != nil {
+			iterators = append(iterators, &sliceIterator{m.sorted()})
+		}
+	}
+	for i := len(s.levels[0]) - 1; i >= 0; i-- {
+		tables = append(tables, s.levels[0][i].iterator(prefix))
+	}
+	for _, level := range s.levels[1:] {
+		for _, t := range level {
+			if t.Largest >= prefix && (t.Smallest <= prefix || strings.HasPrefix(t.Smallest, prefix)) {
+				tables = append(tables, t.iterator(prefix))
+			}
+		}
+	}
+	for _, t := range tables {
+		iterators = append(iterators, t)
+	}
+
+	var keys []string
+	for it := newMergeIterator(iterators...); it.valid(); it.next() {
+		e := it.entry()
+		if e.key < prefix {
+			continue
+		}
+		if !strings.HasPrefix(e.key, prefix) {
+			break
+		}
+		if !e.deleted {
+			keys = append(keys, e.key)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range tables {
+		if t.err != nil {
+			return nil, t.err
+		}
+	}
+	return keys, nil
+}
+
+// background flushes immutable memtables and runs compactions until the
+// store is closed. A failure stops further writes, which report it.
+func (s *Store) background() {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-s.work:
+		}
+
+		err := s.flush()
+		for compacted := err == nil; compacted && err == nil; {
+			select {
+			case <-s.closing:
+				return
+			default:
+			}
+			if compacted, err = s.compact(); err == nil {
+				err = s.flush()
+			}
+		}
+		if err != nil {
+			log.Printf("lsm: %s", err)
+			s.mu.Lock()
+			s.err = err
+			s.flushed.Broadcast()
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// flush writes the immutable memtable to a level 0 table, then drops it
+// and its log
+func (s *Store) flush() error {
+	s.mu.RLock()
+	imm, immLog := s.imm, s.immLog
+	s.mu.RUnlock()
+	if imm == nil {
+		return nil
+	}
+
+	tables, err := s.writeTables(&sliceIterator{imm.sorted()}, ^uint64(0), false)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	n := len(s.levels[0])
+	s.levels[0] = append(s.levels[0], tables...)
+	s.imm = nil
+	err = s.saveManifest()
+	if err == nil {
+		s.flushed.Broadcast()
+	} else {
+		// The memtable is flushed again by the next attempt, which would
+		// otherwise add its tables a second time
+		s.levels[0] = s.levels[0][:n]
+		s.imm = imm
+	}
+	s.mu.Unlock()
+	if err != nil {
+		s.removeTables(tables)
+		return err
+	}
+	os.Remove(s.logPath(immLog))
+	return nil
+}
+
+// Close stops the background work and closes the files of the store.
+// Writes not yet flushed to a table are recovered from their logs when the
+// store is opened again.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.flushed.Broadcast()
+	s.mu.Unlock()
+
+	close(s.closing)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeTables()
+	return s.log.close()
+}
+
+// closeTables closes every open table
+func (s *Store) closeTables() {
+	for _, tables := range s.levels {
+		for _, t := range tables {
+			t.close()
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package lsm
+
+import (
+	"censys/internal/kvstore"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smallOptions make tiny memtables and tables so that tests flush and
+// compact often
+var smallOptions = Options{
+	MemtableSize: 256,
+	BlockSize:    64,
+	TableSize:    512,
+	L0Tables:     2,
+	LevelSize:    1024,
+}
+
+// waitForCompactions waits until level 0 is compacted and nothing is left
+// to flush
+func waitForCompactions(t *testing.T, s *Store) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.RLock()
+		idle := s.imm == nil && len(s.levels[0]) < s.options.L0Tables
+		s.mu.RUnlock()
+		if idle {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("compactions did not finish")
+}
+
+// checkContents checks that the store holds exactly the keys of want
+func checkContents(t *testing.T, s *Store, want map[string]string) {
+	t.Helper()
+	ctx := context.Background()
+	for key, value := range want {
+		if got, ok := s.Get(ctx, key); !ok || got != value {
+			t.Errorf("Get(%q) = %q, %v, want %q", key, got, ok, value)
+		}
+	}
+	if _, ok := s.Get(ctx, "missing"); ok {
+		t.Error("Get() found a missing key")
+	}
+
+	var keys []string
+	for key := range want {
+		if strings.HasPrefix(key, "key-1") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	got, err := s.Keys(ctx, "key-1")
+	if err != nil {
+		t.Fatalf("Keys() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, keys) {
+		t.Errorf("Keys() = %v, want %v", got, keys)
+	}
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := Open(dir, smallOptions)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	// Overwrite and delete keys across many flushes and compactions
+	want := make(map[string]string)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 200; i++ {
+			key := fmt.Sprintf("key-%03d", i)
+			if (i+round)%5 == 0 {
+				if err := s.Delete(ctx, key); err != nil {
+					t.Fatalf("Delete() error = %v", err)
+				}
+				delete(want, key)
+				continue
+			}
+			value := fmt.Sprintf("value-%d-%d", round, i)
+			if err := s.Set(ctx, key, value); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			want[key] = value
+		}
+	}
+	checkContents(t, s, want)
+
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	waitForCompactions(t, s)
+	s.mu.RLock()
+	deeper := len(s.levels[1]) + len(s.levels[2])
+	s.mu.RUnlock()
+	if deeper == 0 {
+		t.Error("no table was compacted below level 0")
+	}
+	checkContents(t, s, want)
+
+	// The store is recovered from its manifest and logs
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := s.Set(ctx, "key", "value"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Set() after Close() error = %v, want %v", err, ErrClosed)
+	}
+	s, err = Open(dir, smallOptions)
+	if err != nil {
+		t.Fatalf("Open() again error = %v", err)
+	}
+	defer s.Close()
+	checkContents(t, s, want)
+
+	for name, err := range map[string]error{
+		"Set":    s.Set(ctx, "", "value"),
+		"Delete": s.Delete(ctx, ""),
+	} {
+		if !errors.Is(err, kvstore.ErrInvalidKey) {
+			t.Errorf("%s() of an empty key error = %v, want %v", name, err, kvstore.ErrInvalidKey)
+		}
+	}
+}
+
+func TestStore_Recovery(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage damages the log left by the closed store
+		damage func(t *testing.T, path string)
+	}{
+		{"clean log", func(*testing.T, string) {}},
+		{"torn last write", func(t *testing.T, path string) {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(path, info.Size()-3); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			s, err := Open(dir, Options{})
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			s.Set(ctx, "kept", "value")
+			s.Set(ctx, "deleted", "value")
+			s.Delete(ctx, "deleted")
+			s.Set(ctx, "last", "value")
+			path := s.logPath(s.logNumber)
+			s.Close()
+			tt.damage(t, path)
+
+			s, err = Open(dir, Options{})
+			if err != nil {
+				t.Fatalf("Open() after Close() error = %v", err)
+			}
+			defer s.Close()
+			if value, ok := s.Get(ctx, "kept"); !ok || value != "value" {
+				t.Errorf("Get() of a logged write = %q, %v", value, ok)
+			}
+			if _, ok := s.Get(ctx, "deleted"); ok {
+				t.Error("Get() found a key deleted before the restart")
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("the recovered log %s was not removed", path)
+			}
+		})
+	}
+}
+
+func TestStore_CrashBeforeFlush(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	// Stop the background work so that the rotated memtable is not flushed
+	close(s.closing)
+	<-s.done
+
+	s.Set(ctx, "rotated", "value")
+	s.mu.Lock()
+	previous := s.log
+	s.imm, s.immLog = s.mem, s.logNumber
+	s.mem = newMemtable()
+	if err := s.newLog(); err != nil {
+		t.Fatal(err)
+	}
+	previous.close()
+	s.mu.Unlock()
+	s.Set(ctx, "current", "value")
+
+	// A compaction installed before the flush saves the manifest, then the
+	// process crashes
+	s.mu.Lock()
+	err = s.saveManifest()
+	s.closeTables()
+	s.log.close()
+	s.mu.Unlock()
+	if err != nil {
+		t.Fatalf("saveManifest() error = %v", err)
+	}
+
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("Open() after a crash error = %v", err)
+	}
+	defer s.Close()
+	for _, key := range []string{"rotated", "current"} {
+		if value, ok := s.Get(ctx, key); !ok || value != "value" {
+			t.Errorf("Get(%q) after a crash = %q, %v", key, value, ok)
+		}
+	}
+}
+
+func TestStore_FlushManifestFailure(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	// Stop the background work so that the memtable is only flushed here
+	close(s.closing)
+	<-s.done
+	defer func() {
+		s.closeTables()
+		s.log.close()
+	}()
+
+	s.Set(ctx, "key", "value")
+	s.mu.Lock()
+	previous := s.log
+	s.imm, s.immLog = s.mem, s.logNumber
+	s.mem = newMemtable()
+	if err := s.newLog(); err != nil {
+		t.Fatal(err)
+	}
+	previous.close()
+	s.mu.Unlock()
+
+	// The manifest cannot be written while a directory takes its place
+	blocker := filepath.Join(dir, manifestName+".tmp")
+	if err := os.Mkdir(blocker, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.flush(); err == nil {
+		t.Fatal("flush() error = nil, want the manifest error")
+	}
+	if len(s.levels[0]) != 0 || s.imm == nil {
+		t.Errorf("flush() failure left %d tables and memtable %v, want the memtable only", len(s.levels[0]), s.imm)
+	}
+	tables, _ := filepath.Glob(filepath.Join(dir, "*.sst"))
+	if len(tables) != 0 {
+		t.Errorf("flush() failure left tables %v", tables)
+	}
+
+	os.Remove(blocker)
+	if err := s.flush(); err != nil {
+		t.Fatalf("flush() again error = %v", err)
+	}
+	if len(s.levels[0]) != 1 {
+		t.Errorf("flush() again installed %d tables, want 1", len(s.levels[0]))
+	}
+	if value, ok := s.Get(ctx, "key"); !ok || value != "value" {
+		t.Errorf("Get() after flush() = %q, %v", value, ok)
+	}
+}
+
+func TestStore_CorruptTable(t *testing.T) {
+	ctx := context.Background()
+	s, err := Open(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer s.Close()
+	for _, value := range []string{"old", "new"} {
+		s.Set(ctx, "key", value)
+		if err := s.Flush(); err != nil {
+			t.Fatalf("Flush() error = %v", err)
+		}
+	}
+
+	// Flip a byte of the data block of the newest table
+	s.mu.RLock()
+	path := s.tablePath(s.levels[0][len(s.levels[0])-1].Number)
+	s.mu.RUnlock()
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.WriteAt([]byte{0xff}, 5)
+
+	if value, ok := s.Get(ctx, "key"); ok {
+		t.Errorf("Get() from a corrupt table = %q, want the read to fail", value)
+	}
+	if _, _, err := s.lookup("key"); !errors.Is(err, errCorrupt) {
+		t.Errorf("lookup() from a corrupt table error = %v, want %v", err, errCorrupt)
+	}
+}
+
+func TestStore_TombstonesDropped(t *testing.T) {
+	ctx := context.Background()
+	// Every flush is compacted into level 1, the bottom level
+	s, err := Open(t.TempDir(), Options{MemtableSize: 256, BlockSize: 64, L0Tables: 1})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 100; i++ {
+		s.Set(ctx, fmt.Sprintf("key-%03d", i), "value")
+	}
+	for i := 0; i < 100; i++ {
+		s.Delete(ctx, fmt.Sprintf("key-%03d", i))
+	}
+	s.Flush()
+	waitForCompactions(t, s)
+
+	// Compacting the deletes with the writes they shadow leaves nothing
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for level, tables := range s.levels {
+		for _, table := range tables {
+			for it := table.iterator(""); it.valid(); it.next() {
+				t.Errorf("level %d holds %+v", level, it.entry())
+			}
+		}
+	}
+}
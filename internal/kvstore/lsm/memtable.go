@@ -0,0 +1,170 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+// entry is a write to a key: a value or, for deletes, a tombstone
+type entry struct {
+	key     string
+	value   string
+	deleted bool
+}
+
+// memtable holds the latest writes in memory until they are flushed to an
+// SSTable
+type memtable struct {
+	entries map[string]entry
+	size    int
+}
+
+func newMemtable() *memtable {
+	return &memtable{entries: make(map[string]entry)}
+}
+
+// put records a write, replacing any earlier write to the key
+func (m *memtable) put(e entry) {
+	if old, ok := m.entries[e.key]; ok {
+		m.size -= len(old.key) + len(old.value)
+	}
+	m.entries[e.key] = e
+	m.size += len(e.key) + len(e.value)
+}
+
+// get returns the latest write to key
+func (m *memtable) get(key string) (entry, bool) {
+	e, ok := m.entries[key]
+	return e, ok
+}
+
+// sorted returns the writes in ascending key order
+func (m *memtable) sorted() []entry {
+	entries := make([]entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return entries
+}
+
+// wal is the write-ahead log of a memtable, replayed to rebuild the
+// memtable after a restart. Every record is a CRC32 checksum and a length
+// followed by the encoded entry.
+type wal struct {
+	file *os.File
+	sync bool
+}
+
+// createWAL creates an empty log, syncing every append if sync is set
+func createWAL(path string, sync bool) (*wal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &wal{file: file, sync: sync}, nil
+}
+
+// append logs a write
+func (w *wal) append(e entry) error {
+	payload := encodeEntry(nil, e)
+	record := make([]byte, 8, 8+len(payload))
+	binary.LittleEndian.PutUint32(record, crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(record[4:], uint32(len(payload)))
+	if _, err := w.file.Write(append(record, payload...)); err != nil {
+		return err
+	}
+	if w.sync {
+		return w.file.Sync()
+	}
+	return nil
+}
+
+func (w *wal) close() error {
+	return w.file.Close()
+}
+
+// replayWAL calls fn with every write of a log in order. A torn or corrupt
+// record ends the log, as it can only be the last write before a crash.
+func replayWAL(path string, fn func(entry)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return err
+		}
+		payload := make([]byte, binary.LittleEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return err
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header) {
+			return nil
+		}
+		e, n := decodeEntry(payload)
+		if n <= 0 {
+			return nil
+		}
+		fn(e)
+	}
+}
+
+// encodeEntry appends an entry to buf as the length of its key, its kind,
+// the length of its value, its key and its value
+func encodeEntry(buf []byte, e entry) []byte {
+	kind := byte(0)
+	if e.deleted {
+		kind = 1
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(e.key)))
+	buf = append(buf, kind)
+	buf = binary.AppendUvarint(buf, uint64(len(e.value)))
+	buf = append(buf, e.key...)
+	return append(buf, e.value...)
+}
+
+// decodeEntry decodes the entry at the start of buf, returning its encoded
+// size or 0 if buf is malformed
+func decodeEntry(buf []byte) (entry, int) {
+	keyLength, n := binary.Uvarint(buf)
+	if n <= 0 || n >= len(buf) {
+		return entry{}, 0
+	}
+	kind := buf[n]
+	valueLength, m := binary.Uvarint(buf[n+1:])
+	if m <= 0 {
+		return entry{}, 0
+	}
+	start := n + 1 + m
+	if kind > 1 || keyLength > uint64(len(buf)) || valueLength > uint64(len(buf)) {
+		return entry{}, 0
+	}
+	end := uint64(start) + keyLength + valueLength
+	if end > uint64(len(buf)) {
+		return entry{}, 0
+	}
+	keyEnd := start + int(keyLength)
+	return entry{
+		key:     string(buf[start:keyEnd]),
+		value:   string(buf[keyEnd:end]),
+		deleted: kind == 1,
+	}, int(end)
+}
@@ -0,0 +1,370 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"os"
+	"sort"
+)
+
+// tableMagic ends the footer of every SSTable
+const tableMagic = 0x6b7673746f72654c
+
+// footerSize is the size of the footer: the offsets and lengths of the
+// index and bloom filter blocks, and the magic number
+const footerSize = 40
+
+// errCorrupt is returned when an SSTable fails its checksums
+var errCorrupt = errors.New("corrupt sstable")
+
+// An SSTable is an immutable file of entries sorted by key. It is made of
+// data blocks of encoded entries, a bloom filter of its keys, an index
+// block holding the last key and location of every data block, and a
+// footer locating the index and filter. Every block ends with its CRC32.
+
+// blockHandle locates a data block by the last key it holds
+type blockHandle struct {
+	lastKey string
+	offset  uint64
+	length  uint64
+}
+
+// tableWriter writes the entries added in ascending key order to an
+// SSTable
+type tableWriter struct {
+	file       *os.File
+	w          *bufio.Writer
+	blockSize  int
+	bitsPerKey int
+
+	offset   uint64
+	block    []byte
+	lastKey  string
+	smallest string
+	index    []blockHandle
+	hashes   []uint64
+}
+
+func newTableWriter(path string, blockSize int, bitsPerKey int) (*tableWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{
+		file:       file,
+		w:          bufio.NewWriter(file),
+		blockSize:  blockSize,
+		bitsPerKey: bitsPerKey,
+	}, nil
+}
+
+// add appends an entry, which must sort after every entry added before
+func (t *tableWriter) add(e entry) error {
+	if len(t.hashes) == 0 {
+		t.smallest = e.key
+	}
+	t.block = encodeEntry(t.block, e)
+	t.lastKey = e.key
+	t.hashes = append(t.hashes, hashKey(e.key))
+	if len(t.block) >= t.blockSize {
+		return t.flushBlock()
+	}
+	return nil
+}
+
+// size returns the number of bytes written so far
+func (t *tableWriter) size() uint64 {
+	return t.offset + uint64(len(t.block))
+}
+
+// flushBlock writes the pending data block and indexes it
+func (t *tableWriter) flushBlock() error {
+	if len(t.block) == 0 {
+		return nil
+	}
+	offset, length, err := t.writeBlock(t.block)
+	if err != nil {
+		return err
+	}
+	t.index = append(t.index, blockHandle{t.lastKey, offset, length})
+	t.block = t.block[:0]
+	return nil
+}
+
+// writeBlock writes a block followed by its checksum
+func (t *tableWriter) writeBlock(block []byte) (uint64, uint64, error) {
+	block = binary.LittleEndian.AppendUint32(block, crc32.ChecksumIEEE(block))
+	if _, err := t.w.Write(block); err != nil {
+		return 0, 0, err
+	}
+	offset := t.offset
+	t.offset += uint64(len(block))
+	return offset, uint64(len(block)), nil
+}
+
+// finish writes the filter, index and footer and syncs the table, returning
+// its metadata
+func (t *tableWriter) finish(number uint64) (tableMeta, error) {
+	defer t.file.Close()
+	if err := t.flushBlock(); err != nil {
+		return tableMeta{}, err
+	}
+
+	bloomOffset, bloomLength, err := t.writeBlock(newBloom(t.hashes, t.bitsPerKey))
+	if err != nil {
+		return tableMeta{}, err
+	}
+	var index []byte
+	for _, handle := range t.index {
+		index = binary.AppendUvarint(index, uint64(len(handle.lastKey)))
+		index = append(index, handle.lastKey...)
+		index = binary.AppendUvarint(index, handle.offset)
+		index = binary.AppendUvarint(index, handle.length)
+	}
+	indexOffset, indexLength, err := t.writeBlock(index)
+	if err != nil {
+		return tableMeta{}, err
+	}
+
+	footer := make([]byte, 0, footerSize)
+	for _, n := range []uint64{indexOffset, indexLength, bloomOffset, bloomLength, tableMagic} {
+		footer = binary.LittleEndian.AppendUint64(footer, n)
+	}
+	if _, err := t.w.Write(footer); err != nil {
+		return tableMeta{}, err
+	}
+	if err := t.w.Flush(); err != nil {
+		return tableMeta{}, err
+	}
+	if err := t.file.Sync(); err != nil {
+		return tableMeta{}, err
+	}
+	return tableMeta{
+		Number:   number,
+		Smallest: t.smallest,
+		Largest:  t.lastKey,
+		Size:     int64(t.offset) + footerSize,
+	}, nil
+}
+
+// abort removes a table that could not be written
+func (t *tableWriter) abort() {
+	t.file.Close()
+	os.Remove(t.file.Name())
+}
+
+// table is an open SSTable, whose index and bloom filter are kept in memory
+type table struct {
+	tableMeta
+	file  *os.File
+	index []blockHandle
+	bloom []byte
+}
+
+// openTable opens an SSTable and loads its index and bloom filter
+func openTable(path string, meta tableMeta) (*table, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{tableMeta: meta, file: file}
+	if err := t.load(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// load reads the footer, index and bloom filter of the table
+func (t *table) load() error {
+	info, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < footerSize {
+		return errCorrupt
+	}
+	footer := make([]byte, footerSize)
+	if _, err := t.file.ReadAt(footer, info.Size()-footerSize); err != nil {
+		return err
+	}
+	var fields [5]uint64
+	for i := range fields {
+		fields[i] = binary.LittleEndian.Uint64(footer[i*8:])
+	}
+	if fields[4] != tableMagic {
+		return errCorrupt
+	}
+
+	if t.bloom, err = t.readBlock(fields[2], fields[3]); err != nil {
+		return err
+	}
+	index, err := t.readBlock(fields[0], fields[1])
+	if err != nil {
+		return err
+	}
+	for len(index) > 0 {
+		var handle blockHandle
+		length, n := binary.Uvarint(index)
+		if n <= 0 || uint64(len(index)-n) < length {
+			return errCorrupt
+		}
+		handle.lastKey = string(index[n : n+int(length)])
+		index = index[n+int(length):]
+		if handle.offset, n = binary.Uvarint(index); n <= 0 {
+			return errCorrupt
+		}
+		index = index[n:]
+		if handle.length, n = binary.Uvarint(index); n <= 0 {
+			return errCorrupt
+		}
+		index = index[n:]
+		t.index = append(t.index, handle)
+	}
+	return nil
+}
+
+// readBlock reads a block and verifies its checksum
+func (t *table) readBlock(offset uint64, length uint64) ([]byte, error) {
+	if length < 4 || offset+length > uint64(t.Size) {
+		return nil, errCorrupt
+	}
+	block := make([]byte, length)
+	if _, err := t.file.ReadAt(block, int64(offset)); err != nil {
+		return nil, err
+	}
+	data := block[:length-4]
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(block[length-4:]) {
+		return nil, errCorrupt
+	}
+	return data, nil
+}
+
+// get returns the entry of key, skipping the disk when the bloom filter
+// rules the key out
+func (t *table) get(key string) (entry, bool, error) {
+	if key < t.Smallest || key > t.Largest || !bloomContains(t.bloom, hashKey(key)) {
+		return entry{}, false, nil
+	}
+	it := t.iterator(key)
+	if it.valid() && it.entry().key == key {
+		return it.entry(), true, nil
+	}
+	return entry{}, false, it.err
+}
+
+// iterator returns an iterator over the entries of the table from the
+// first key not less than start
+func (t *table) iterator(start string) *tableIterator {
+	it := &tableIterator{
+		table: t,
+		block: sort.Search(len(t.index), func(i int) bool {
+			return t.index[i].lastKey >= start
+		}),
+	}
+	it.load()
+	for it.valid() && it.entry().key < start {
+		it.next()
+	}
+	return it
+}
+
+func (t *table) close() error {
+	return t.file.Close()
+}
+
+// tableIterator iterates over the entries of a table in key order, one
+// data block at a time
+type tableIterator struct {
+	table   *table
+	block   int
+	entries []entry
+	err     error
+}
+
+// load decodes the current block, moving past empty blocks
+func (it *tableIterator) load() {
+	it.entries = it.entries[:0]
+	for it.err == nil && len(it.entries) == 0 && it.block < len(it.table.index) {
+		handle := it.table.index[it.block]
+		data, err := it.table.readBlock(handle.offset, handle.length)
+		if err != nil {
+			it.err = err
+			return
+		}
+		for len(data) > 0 {
+			e, n := decodeEntry(data)
+			if n == 0 {
+				it.err = errCorrupt
+				return
+			}
+			it.entries = append(it.entries, e)
+			data = data[n:]
+		}
+		if len(it.entries) == 0 {
+			it.block++
+		}
+	}
+}
+
+func (it *tableIterator) valid() bool {
+	return it.err == nil && len(it.entries) > 0
+}
+
+func (it *tableIterator) entry() entry {
+	return it.entries[0]
+}
+
+func (it *tableIterator) next() {
+	it.entries = it.entries[1:]
+	if len(it.entries) == 0 {
+		it.block++
+		it.load()
+	}
+}
+
+// hashKey hashes a key for the bloom filters
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// newBloom builds a bloom filter of the given key hashes using bitsPerKey
+// bits per key. Its last byte is the number of probes per key.
+func newBloom(hashes []uint64, bitsPerKey int) []byte {
+	probes := max(1, min(30, bitsPerKey*69/100))
+	bits := max(64, len(hashes)*bitsPerKey)
+	filter := make([]byte, (bits+7)/8+1)
+	bits = (len(filter) - 1) * 8
+	for _, h := range hashes {
+		h1, h2 := uint32(h), uint32(h>>32)
+		for i := 0; i < probes; i++ {
+			bit := (h1 + uint32(i)*h2) % uint32(bits)
+			filter[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	filter[len(filter)-1] = byte(probes)
+	return filter
+}
+
+// bloomContains reports whether a key hash may be in a bloom filter
+func bloomContains(filter []byte, h uint64) bool {
+	if len(filter) < 2 {
+		return true
+	}
+	probes := int(filter[len(filter)-1])
+	bits := uint32(len(filter)-1) * 8
+	h1, h2 := uint32(h), uint32(h>>32)
+	for i := 0; i < probes; i++ {
+		bit := (h1 + uint32(i)*h2) % bits
+		if filter[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
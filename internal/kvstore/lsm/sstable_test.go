@@ -0,0 +1,123 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestTable writes a table of n keys, deleting every tenth
+func writeTestTable(t *testing.T, path string, n int) *table {
+	t.Helper()
+	w, err := newTableWriter(path, 128, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		e := entry{key: fmt.Sprintf("key-%04d", i), value: fmt.Sprintf("value-%d", i)}
+		if i%10 == 0 {
+			e = entry{key: e.key, deleted: true}
+		}
+		if err := w.add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	meta, err := w.finish(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := openTable(path, meta)
+	if err != nil {
+		t.Fatalf("openTable() error = %v", err)
+	}
+	t.Cleanup(func() { table.close() })
+	return table
+}
+
+func TestTable_Get(t *testing.T) {
+	table := writeTestTable(t, filepath.Join(t.TempDir(), "000001.sst"), 1000)
+	if len(table.index) < 2 {
+		t.Fatalf("table has %d blocks, want several", len(table.index))
+	}
+
+	tests := []struct {
+		name        string
+		key         string
+		wantFound   bool
+		wantDeleted bool
+		wantValue   string
+	}{
+		{"first key", "key-0001", true, false, "value-1"},
+		{"last key", "key-0999", true, false, "value-999"},
+		{"tombstone", "key-0500", true, true, ""},
+		{"between keys", "key-0500a", false, false, ""},
+		{"before the table", "a", false, false, ""},
+		{"after the table", "z", false, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, found, err := table.get(tt.key)
+			if err != nil {
+				t.Fatalf("get() error = %v", err)
+			}
+			if found != tt.wantFound || e.deleted != tt.wantDeleted || e.value != tt.wantValue {
+				t.Errorf("get() = %+v, %v, want found %v, deleted %v, value %q",
+					e, found, tt.wantFound, tt.wantDeleted, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestTable_Iterator(t *testing.T) {
+	table := writeTestTable(t, filepath.Join(t.TempDir(), "000001.sst"), 100)
+
+	var keys []string
+	for it := table.iterator("key-0095"); it.valid(); it.next() {
+		keys = append(keys, it.entry().key)
+	}
+	if len(keys) != 5 || keys[0] != "key-0095" || keys[4] != "key-0099" {
+		t.Errorf("iterator(key-0095) = %v", keys)
+	}
+}
+
+func TestTable_Corruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "000001.sst")
+	table := writeTestTable(t, path, 100)
+
+	// Flip a byte of the first data block
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.WriteAt([]byte{0xff}, 5)
+
+	if _, _, err := table.get("key-0001"); err != errCorrupt {
+		t.Errorf("get() from a corrupt block error = %v, want %v", err, errCorrupt)
+	}
+}
+
+func TestBloom(t *testing.T) {
+	var hashes []uint64
+	for i := 0; i < 1000; i++ {
+		hashes = append(hashes, hashKey(fmt.Sprintf("key-%d", i)))
+	}
+	filter := newBloom(hashes, 10)
+
+	for _, h := range hashes {
+		if !bloomContains(filter, h) {
+			t.Fatal("bloom filter is missing an added key")
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if bloomContains(filter, hashKey(fmt.Sprintf("other-%d", i))) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / 10000; rate > 0.03 {
+		t.Errorf("bloom filter false positive rate = %.3f, want about 0.01", rate)
+	}
+}
@@ -0,0 +1,323 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultNamespace is the namespace used when a request does not name one
+const DefaultNamespace = "default"
+
+// Quota limits the size of a namespace. A zero value means unlimited.
+type Quota struct {
+	MaxKeys  int64
+	MaxBytes int64
+}
+
+// Usage is the number of keys and bytes (keys plus values) held by a namespace
+type Usage struct {
+	Keys  int64
+	Bytes int64
+}
+
+// Catalog creates the stores of namespaces and records them, so that the
+// namespaces of a backend keeping its stores on disk survive restarts
+type Catalog interface {
+	// Load returns the recorded namespaces with their stores reopened
+	Load() ([]StoredNamespace, error)
+	// Create creates the store of a new namespace and records it
+	Create(name string, quota Quota) (KeyValueStore, error)
+	// Remove forgets a namespace and deletes its store, which is closed
+	// afterwards. The namespace is kept if Remove fails.
+	Remove(name string) error
+}
+
+// StoredNamespace is a namespace recorded by a catalog and its store
+type StoredNamespace struct {
+	Name  string
+	Quota Quota
+	Store KeyValueStore
+}
+
+// CatalogFunc is a catalog that records nothing, creating the stores of
+// namespaces by calling itself, so that namespaces last until restarts
+type CatalogFunc func() (KeyValueStore, error)
+
+// Load returns no namespaces
+func (f CatalogFunc) Load() ([]StoredNamespace, error) {
+	return nil, nil
+}
+
+// Create creates a store by calling f
+func (f CatalogFunc) Create(string, Quota) (KeyValueStore, error) {
+	return f()
+}
+
+// Remove does nothing, the store being dropped with its namespace
+func (f CatalogFunc) Remove(string) error {
+	return nil
+}
+
+// Namespaces manages a set of isolated keyspaces, each backed by its own store
+type Namespaces struct {
+	catalog Catalog
+
+	mu     sync.RWMutex
+	spaces map[string]*Namespace
+}
+
+// NewNamespaces creates a namespace manager that calls newStore to create the
+// backing store of every new namespace
+func NewNamespaces(newStore func() (KeyValueStore, error)) *Namespaces {
+	return &Namespaces{
+		catalog: CatalogFunc(newStore),
+		spaces:  make(map[string]*Namespace),
+	}
+}
+
+// OpenNamespaces creates a namespace manager keeping its namespaces in
+// catalog, starting with the namespaces it recorded. Their usage is counted
+// from the keys of their stores, or starts at zero if a store cannot list
+// its keys.
+func OpenNamespaces(ctx context.Context, catalog Catalog) (*Namespaces, error) {
+	stored, err := catalog.Load()
+	if err != nil {
+		return nil, err
+	}
+	n := &Namespaces{
+		catalog: catalog,
+		spaces:  make(map[string]*Namespace),
+	}
+	for _, s := range stored {
+		ns := &Namespace{
+			Name:  s.Name,
+			Quota: s.Quota,
+			store: s.Store,
+		}
+		if err := ns.count(ctx); err != nil {
+			for _, s := range stored {
+				closeStore(s.Store)
+			}
+			return nil, fmt.Errorf("counting the keys of namespace %q: %w", s.Name, err)
+		}
+		n.spaces[s.Name] = ns
+	}
+	return n, nil
+}
+
+// Create creates a new, empty namespace
+func (n *Namespaces) Create(name string, quota Quota) (*Namespace, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.spaces[name]; ok || name == DefaultNamespace {
+		return nil, ErrNamespaceExists
+	}
+	store, err := n.catalog.Create(name, quota)
+	if err != nil {
+		return nil, err
+	}
+	ns := &Namespace{
+		Name:  name,
+		Quota: quota,
+		store: store,
+	}
+	n.spaces[name] = ns
+	return ns, nil
+}
+
+// Get returns the namespace with the given name
+func (n *Namespaces) Get(name string) (*Namespace, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	ns, ok := n.spaces[name]
+	if !ok {
+		return nil, ErrNamespaceNotFound
+	}
+	return ns, nil
+}
+
+// List returns all namespaces sorted by name
+func (n *Namespaces) List() []*Namespace {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	list := make([]*Namespace, 0, len(n.spaces))
+	for _, ns := range n.spaces {
+		list = append(list, ns)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Drop removes a namespace and all of its keys from the catalog, then
+// closes its store if it holds resources such as open files. The namespace
+// is kept if the catalog fails to remove it, and dropped even if its store
+// fails to close.
+func (n *Namespaces) Drop(name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ns, ok := n.spaces[name]
+	if !ok {
+		return ErrNamespaceNotFound
+	}
+	if err := n.catalog.Remove(name); err != nil {
+		return err
+	}
+	delete(n.spaces, name)
+	closeStore(ns.store)
+	return nil
+}
+
+// Close closes the stores of every namespace
+func (n *Namespaces) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, ns := range n.spaces {
+		closeStore(ns.store)
+	}
+}
+
+// closeStore closes a store if it holds resources such as open files
+func closeStore(store KeyValueStore) {
+	if closer, ok := store.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// Namespace is an isolated keyspace that enforces its quota on writes. Its
+// usage counts every key it holds, including the keys servers keep their
+// state in, such as locks or queue configs.
+type Namespace struct {
+	Name  string
+	Quota Quota
+
+	store KeyValueStore
+	mu    sync.Mutex
+	usage Usage
+}
+
+// Usage returns the current usage of the namespace
+func (n *Namespace) Usage() Usage {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.usage
+}
+
+// Set sets a value for a key, failing with ErrQuotaExceeded if the write would
+// take the namespace over its quota
+func (n *Namespace) Set(ctx context.Context, key string, value string) error {
+	return n.write(ctx, key, value, func() error {
+		return n.store.Set(ctx, key, value)
+	})
+}
+
+// SetWithTTL sets a value for a key that expires after ttl, failing with
+// ErrQuotaExceeded like Set, or with errors.ErrUnsupported if its store
+// cannot expire keys. Expired keys keep counting towards the usage of the
+// namespace until it is reopened.
+func (n *Namespace) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	expirer, ok := n.store.(Expirer)
+	if !ok {
+		return fmt.Errorf("%w: store cannot expire keys", errors.ErrUnsupported)
+	}
+	return n.write(ctx, key, value, func() error {
+		return expirer.SetWithTTL(ctx, key, value, ttl)
+	})
+}
+
+// write writes a value for a key with set unless the write would take the
+// namespace over its quota
+func (n *Namespace) write(ctx context.Context, key string, value string, set func() error) error {
+	// The old value of the key is not found once ctx is done, which would
+	// count the key twice
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	usage := n.usage
+	old, ok := n.store.Get(ctx, key)
+	if ok {
+		usage.Bytes -= int64(len(key) + len(old))
+	} else {
+		usage.Keys++
+	}
+	usage.Bytes += int64(len(key) + len(value))
+
+	if n.Quota.MaxKeys > 0 && usage.Keys > n.Quota.MaxKeys {
+		return ErrQuotaExceeded
+	}
+	if n.Quota.MaxBytes > 0 && usage.Bytes > n.Quota.MaxBytes {
+		return ErrQuotaExceeded
+	}
+
+	if err := set(); err != nil {
+		return err
+	}
+	n.usage = usage
+	return nil
+}
+
+// Get gets a value for a key
+func (n *Namespace) Get(ctx context.Context, key string) (string, bool) {
+	return n.store.Get(ctx, key)
+}
+
+// Delete deletes a value for a key
+func (n *Namespace) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	old, ok := n.store.Get(ctx, key)
+	if err := n.store.Delete(ctx, key); err != nil {
+		return err
+	}
+	if ok {
+		n.usage.Keys--
+		n.usage.Bytes -= int64(len(key) + len(old))
+	}
+	return nil
+}
+
+// count counts the keys and bytes held by the store of the namespace
+func (n *Namespace) count(ctx context.Context) error {
+	scanner, ok := n.store.(Scanner)
+	if !ok {
+		return nil
+	}
+	keys, err := scanner.Keys(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if value, ok := n.store.Get(ctx, key); ok {
+			n.usage.Keys++
+			n.usage.Bytes += int64(len(key) + len(value))
+		}
+	}
+	return nil
+}
+
+// Keys lists the keys of the namespace starting with prefix, failing with
+// errors.ErrUnsupported if its store cannot list keys
+func (n *Namespace) Keys(ctx context.Context, prefix string) ([]string, error) {
+	scanner, ok := n.store.(Scanner)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return scanner.Keys(ctx, prefix)
+}
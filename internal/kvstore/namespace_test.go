@@ -0,0 +1,214 @@
+package kvstore_test
+
+import (
+	"censys/internal/kvstore"
+	inmemorystore "censys/internal/kvstore/inmemory"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func newTestNamespaces() *kvstore.Namespaces {
+	return kvstore.NewNamespaces(func() (kvstore.KeyValueStore, error) {
+		return &inmemorystore.InMemoryStore{
+			Data: sync.Map{},
+		}, nil
+	})
+}
+
+func TestNamespaces_Isolation(t *testing.T) {
+	namespaces := newTestNamespaces()
+	a, err := namespaces.Create("team-a", kvstore.Quota{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := namespaces.Create("team-b", kvstore.Quota{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Set(context.Background(), "key", "a-value"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.Get(context.Background(), "key"); ok {
+		t.Errorf("Get() found key written to another namespace")
+	}
+	if value, _ := a.Get(context.Background(), "key"); value != "a-value" {
+		t.Errorf("Get() response = %v, want %v", value, "a-value")
+	}
+}
+
+func TestNamespaces_Create(t *testing.T) {
+	tests := []struct {
+		name    string
+		ns      string
+		wantErr error
+	}{
+		{
+			name: "new namespace",
+			ns:   "team-a",
+		},
+		{
+			name:    "existing namespace",
+			ns:      "existing",
+			wantErr: kvstore.ErrNamespaceExists,
+		},
+		{
+			name:    "default namespace",
+			ns:      kvstore.DefaultNamespace,
+			wantErr: kvstore.ErrNamespaceExists,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespaces := newTestNamespaces()
+			namespaces.Create("existing", kvstore.Quota{})
+
+			_, err := namespaces.Create(tt.ns, kvstore.Quota{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNamespaces_Drop(t *testing.T) {
+	namespaces := newTestNamespaces()
+	namespaces.Create("team-a", kvstore.Quota{})
+
+	if err := namespaces.Drop("team-a"); err != nil {
+		t.Fatalf("Drop() error = %v", err)
+	}
+	if _, err := namespaces.Get("team-a"); !errors.Is(err, kvstore.ErrNamespaceNotFound) {
+		t.Errorf("Get() error = %v, wantErr %v", err, kvstore.ErrNamespaceNotFound)
+	}
+	if err := namespaces.Drop("team-a"); !errors.Is(err, kvstore.ErrNamespaceNotFound) {
+		t.Errorf("Drop() error = %v, wantErr %v", err, kvstore.ErrNamespaceNotFound)
+	}
+}
+
+// failingCatalog is a catalog failing to remove namespaces
+type failingCatalog struct {
+	kvstore.CatalogFunc
+}
+
+func (failingCatalog) Remove(string) error {
+	return errors.New("disk full")
+}
+
+func TestNamespaces_DropFailure(t *testing.T) {
+	ctx := context.Background()
+	namespaces, err := kvstore.OpenNamespaces(ctx, failingCatalog{func() (kvstore.KeyValueStore, error) {
+		return &inmemorystore.InMemoryStore{}, nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns, err := namespaces.Create("team-a", kvstore.Quota{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.Set(ctx, "key", "value")
+
+	if err := namespaces.Drop("team-a"); err == nil {
+		t.Fatal("Drop() error = nil, want the catalog error")
+	}
+	kept, err := namespaces.Get("team-a")
+	if err != nil {
+		t.Fatalf("Get() after a failed Drop() error = %v", err)
+	}
+	if value, ok := kept.Get(ctx, "key"); !ok || value != "value" {
+		t.Errorf("Get() after a failed Drop() = %q, %v, want %q", value, ok, "value")
+	}
+}
+
+func TestNamespace_Quota(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   kvstore.Quota
+		key     string
+		value   string
+		wantErr error
+	}{
+		{
+			name:  "within quota",
+			quota: kvstore.Quota{MaxKeys: 2, MaxBytes: 100},
+			key:   "new-key",
+			value: "value",
+		},
+		{
+			name:    "too many keys",
+			quota:   kvstore.Quota{MaxKeys: 1},
+			key:     "new-key",
+			value:   "value",
+			wantErr: kvstore.ErrQuotaExceeded,
+		},
+		{
+			name:    "too many bytes",
+			quota:   kvstore.Quota{MaxBytes: 20},
+			key:     "new-key",
+			value:   "a-long-value",
+			wantErr: kvstore.ErrQuotaExceeded,
+		},
+		{
+			name:  "overwrite existing key at key limit",
+			quota: kvstore.Quota{MaxKeys: 1},
+			key:   "existing",
+			value: "updated",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespaces := newTestNamespaces()
+			ns, _ := namespaces.Create("team-a", tt.quota)
+			if err := ns.Set(context.Background(), "existing", "value"); err != nil {
+				t.Fatal(err)
+			}
+
+			err := ns.Set(context.Background(), tt.key, tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNamespace_Usage(t *testing.T) {
+	namespaces := newTestNamespaces()
+	ns, _ := namespaces.Create("team-a", kvstore.Quota{})
+
+	ns.Set(context.Background(), "a", "12345")
+	ns.Set(context.Background(), "b", "123")
+	ns.Set(context.Background(), "a", "1")
+	ns.Delete(context.Background(), "b")
+
+	// Writes with a done context fail without counting the key
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ns.Set(cancelled, "a", "12345"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Set() with a cancelled context error = %v, want %v", err, context.Canceled)
+	}
+
+	want := kvstore.Usage{Keys: 1, Bytes: 2}
+	if got := ns.Usage(); got != want {
+		t.Errorf("Usage() = %+v, want %+v", got, want)
+	}
+}
+
+func TestNamespace_Keys(t *testing.T) {
+	namespaces := newTestNamespaces()
+	ns, _ := namespaces.Create("team-a", kvstore.Quota{})
+	ns.Set(context.Background(), "b", "value")
+	ns.Set(context.Background(), "a", "value")
+
+	keys, err := ns.Keys(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Keys() error = %v", err)
+	}
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("Keys() = %v, want [a b]", keys)
+	}
+}
@@ -0,0 +1,34 @@
+package sqlite
+
+import (
+	"censys/internal/kvstore"
+	"censys/internal/kvstore/backend"
+	"time"
+)
+
+// Config configures the sqlite backend
+type Config struct {
+	// Dir is the directory of the stores, defaulting to STORE_DIR or data
+	Dir string `json:"dir"`
+	// SweepIntervalMs is how often expired keys are deleted, and a
+	// negative interval disables sweeping
+	SweepIntervalMs int64 `json:"sweep_interval_ms"`
+}
+
+func init() {
+	backend.Register("sqlite", "keys on disk in a SQLite database",
+		func() Config {
+			return Config{
+				Dir:             backend.DirFromEnv(),
+				SweepIntervalMs: DefaultSweepInterval.Milliseconds(),
+			}
+		},
+		func(config Config) (backend.Stores, error) {
+			options := Options{
+				SweepInterval: time.Duration(config.SweepIntervalMs) * time.Millisecond,
+			}
+			return backend.OnDisk(config.Dir, "keyspace.sqlite", func(path string) (kvstore.KeyValueStore, error) {
+				return Open(path, options)
+			})
+		})
+}
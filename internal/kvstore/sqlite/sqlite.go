@@ -0,0 +1,284 @@
+// Package sqlite is a key-value store kept in a SQLite database file, so
+// that its data can be inspected with SQL and backed up with SQLite tools.
+// Keys are rows of the kv table:
+//
+//	CREATE TABLE kv (
+//		key        TEXT PRIMARY KEY,
+//		value      TEXT NOT NULL,
+//		version    INTEGER NOT NULL,
+//		expires_at INTEGER
+//	)
+//
+// version counts the writes of a key since it was created, and expires_at
+// is when a key set with a TTL expires, in Unix milliseconds.
+package sqlite
+
+import (
+	"censys/internal/kvstore"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	_ "modernc.org/sqlite"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultSweepInterval is how often expired keys are deleted by default
+const DefaultSweepInterval = time.Minute
+
+// schema creates the kv table and the index of the keys that expire
+const schema = `
+CREATE TABLE IF NOT EXISTS kv (
+	key        TEXT PRIMARY KEY,
+	value      TEXT NOT NULL,
+	version    INTEGER NOT NULL,
+	expires_at INTEGER
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS kv_expires_at ON kv (expires_at) WHERE expires_at IS NOT NULL;
+`
+
+// Statements of the store, prepared when it is opened. Expired keys are
+// ignored until they are swept, and a key set again after it expired
+// starts over at version 1.
+const (
+	getStatement = `SELECT value, version FROM kv
+WHERE key = ?1 AND (expires_at IS NULL OR expires_at > ?2)`
+	setStatement = `INSERT INTO kv (key, value, version, expires_at) VALUES (?1, ?2, 1, ?3)
+ON CONFLICT (key) DO UPDATE SET
+	value = excluded.value,
+	version = CASE WHEN kv.expires_at <= ?4 THEN 1 ELSE kv.version + 1 END,
+	expires_at = excluded.expires_at`
+	deleteStatement = `DELETE FROM kv WHERE key = ?1`
+	// The key range of a prefix is scanned through the primary key index
+	keysStatement = `SELECT key FROM kv
+WHERE key >= ?1 AND (?2 IS NULL OR key < ?2) AND (expires_at IS NULL OR expires_at > ?3)
+ORDER BY key`
+	sweepStatement = `DELETE FROM kv WHERE expires_at <= ?1`
+)
+
+// Options configure a store
+type Options struct {
+	// SweepInterval is how often expired keys are deleted from the file.
+	// Defaults to DefaultSweepInterval, and a negative interval disables
+	// sweeping.
+	SweepInterval time.Duration
+}
+
+// Store is a key-value store kept in a SQLite database file
+type Store struct {
+	db *sql.DB
+	// now returns the current time, replaced by tests
+	now func() time.Time
+
+	get    *sql.Stmt
+	set    *sql.Stmt
+	delete *sql.Stmt
+	keys   *sql.Stmt
+	sweep  *sql.Stmt
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// Open opens the SQLite database file at path, creating it and the kv
+// table if needed. The file is in WAL mode, so readers do not block the
+// writer.
+func Open(path string, options Options) (*Store, error) {
+	// Pragmas are set on every connection of the pool
+	query := url.Values{}
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Add("_pragma", "synchronous(NORMAL)")
+	query.Add("_pragma", "busy_timeout(5000)")
+	dsn := url.URL{Scheme: "file", Opaque: path, RawQuery: query.Encode()}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		db:   db,
+		now:  time.Now,
+		stop: make(chan struct{}),
+	}
+	if err := s.prepare(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+
+	interval := options.SweepInterval
+	if interval == 0 {
+		interval = DefaultSweepInterval
+	}
+	if interval > 0 {
+		s.done.Add(1)
+		go s.sweepEvery(interval)
+	}
+	return s, nil
+}
+
+// prepare creates the kv table and prepares the statements of the store
+func (s *Store) prepare() error {
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.get, getStatement},
+		{&s.set, setStatement},
+		{&s.delete, deleteStatement},
+		{&s.keys, keysStatement},
+		{&s.sweep, sweepStatement},
+	}
+	for _, statement := range statements {
+		stmt, err := s.db.Prepare(statement.query)
+		if err != nil {
+			return err
+		}
+		*statement.stmt = stmt
+	}
+	return nil
+}
+
+// millis returns the current time in Unix milliseconds
+func (s *Store) millis() int64 {
+	return s.now().UnixMilli()
+}
+
+// Set sets a value for a key
+func (s *Store) Set(ctx context.Context, key string, value string) error {
+	return s.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL sets a value for a key that expires after ttl, or never if
+// ttl is 0
+func (s *Store) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey)
+	}
+	if ttl < 0 {
+		return fmt.Errorf("invalid TTL %s", ttl)
+	}
+	now := s.millis()
+	var expiresAt sql.NullInt64
+	if ttl > 0 {
+		expiresAt = sql.NullInt64{Int64: now + ttl.Milliseconds(), Valid: true}
+	}
+	_, err := s.set.ExecContext(ctx, key, value, expiresAt, now)
+	return err
+}
+
+// Get gets a value for a key, logging read errors as the key not being
+// found
+func (s *Store) Get(ctx context.Context, key string) (string, bool) {
+	value, _, ok, err := s.lookup(ctx, key)
+	if err != nil {
+		log.Printf("sqlite: reading %q: %s", key, err)
+		return "", false
+	}
+	return value, ok
+}
+
+// Version returns the number of writes of a key since it was created
+func (s *Store) Version(ctx context.Context, key string) (int64, bool, error) {
+	_, version, ok, err := s.lookup(ctx, key)
+	return version, ok, err
+}
+
+// lookup returns the value and version of a key that has not expired
+func (s *Store) lookup(ctx context.Context, key string) (string, int64, bool, error) {
+	var value string
+	var version int64
+	err := s.get.QueryRowContext(ctx, key, s.millis()).Scan(&value, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", 0, false, nil
+	}
+	if err != nil {
+		return "", 0, false, err
+	}
+	return value, version, true, nil
+}
+
+// Delete deletes a value for a key
+func (s *Store) Delete(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey)
+	}
+	_, err := s.delete.ExecContext(ctx, key)
+	return err
+}
+
+// Keys returns the keys starting with prefix in ascending order
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	rows, err := s.keys.QueryContext(ctx, prefix, prefixEnd(prefix), s.millis())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or nil if there is none
+func prefixEnd(prefix string) any {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return nil
+}
+
+// DeleteExpired deletes the expired keys from the file, returning how many
+// were deleted
+func (s *Store) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := s.sweep.ExecContext(ctx, s.millis())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// sweepEvery deletes the expired keys every interval until the store is
+// closed
+func (s *Store) sweepEvery(interval time.Duration) {
+	defer s.done.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if _, err := s.DeleteExpired(context.Background()); err != nil {
+				log.Printf("sqlite: deleting expired keys: %s", err)
+			}
+		}
+	}
+}
+
+// Close stops sweeping expired keys and closes the database
+func (s *Store) Close() error {
+	select {
+	case <-s.stop:
+		return nil
+	default:
+		close(s.stop)
+	}
+	s.done.Wait()
+	return s.db.Close()
+}
@@ -0,0 +1,306 @@
+package sqlite
+
+import (
+	"censys/internal/kvstore"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clock is a manual clock for testing expiry
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// open opens a store in a temporary directory, with a manual clock and
+// sweeping disabled
+func open(t *testing.T) (*Store, *clock) {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "kv.sqlite"), Options{SweepInterval: -1})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	c := &clock{now: time.UnixMilli(1_700_000_000_000)}
+	s.now = c.Now
+	return s, c
+}
+
+func TestStore_CRUD(t *testing.T) {
+	ctx := context.Background()
+	s, _ := open(t)
+
+	if err := s.Set(ctx, "key", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if value, ok := s.Get(ctx, "key"); !ok || value != "value" {
+		t.Errorf("Get() = %q, %v, want value", value, ok)
+	}
+	if err := s.Set(ctx, "key", "other"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if value, ok := s.Get(ctx, "key"); !ok || value != "other" {
+		t.Errorf("Get() = %q, %v, want other", value, ok)
+	}
+	if err := s.Set(ctx, "empty", ""); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if value, ok := s.Get(ctx, "empty"); !ok || value != "" {
+		t.Errorf("Get() = %q, %v, want an empty value", value, ok)
+	}
+
+	if err := s.Delete(ctx, "key"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok := s.Get(ctx, "key"); ok {
+		t.Error("Get() found a deleted key")
+	}
+	if err := s.Delete(ctx, "missing"); err != nil {
+		t.Errorf("Delete() of a missing key error = %v", err)
+	}
+
+	if err := s.Set(ctx, "", "value"); !errors.Is(err, kvstore.ErrInvalidKey) {
+		t.Errorf("Set() error = %v, want %v", err, kvstore.ErrInvalidKey)
+	}
+	if err := s.Delete(ctx, ""); !errors.Is(err, kvstore.ErrInvalidKey) {
+		t.Errorf("Delete() error = %v, want %v", err, kvstore.ErrInvalidKey)
+	}
+}
+
+func TestStore_Version(t *testing.T) {
+	ctx := context.Background()
+	s, c := open(t)
+
+	for want := int64(1); want <= 3; want++ {
+		if err := s.Set(ctx, "key", fmt.Sprint(want)); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+		if version, ok, err := s.Version(ctx, "key"); err != nil || !ok || version != want {
+			t.Errorf("Version() = %d, %v, %v, want %d", version, ok, err, want)
+		}
+	}
+
+	// A key created again starts over
+	if err := s.Delete(ctx, "key"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok, _ := s.Version(ctx, "key"); ok {
+		t.Error("Version() found a deleted key")
+	}
+	if err := s.SetWithTTL(ctx, "key", "value", time.Second); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+	c.Advance(time.Second)
+	if err := s.Set(ctx, "key", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if version, _, _ := s.Version(ctx, "key"); version != 1 {
+		t.Errorf("Version() of a key set after it expired = %d, want 1", version)
+	}
+}
+
+func TestStore_TTL(t *testing.T) {
+	ctx := context.Background()
+	s, c := open(t)
+
+	if err := s.SetWithTTL(ctx, "short", "value", time.Second); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+	if err := s.SetWithTTL(ctx, "long", "value", time.Minute); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+	if err := s.Set(ctx, "forever", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := s.SetWithTTL(ctx, "key", "value", -time.Second); err == nil {
+		t.Error("SetWithTTL() with a negative TTL succeeded")
+	}
+
+	c.Advance(999 * time.Millisecond)
+	if _, ok := s.Get(ctx, "short"); !ok {
+		t.Error("Get() did not find a key before it expired")
+	}
+	c.Advance(time.Millisecond)
+	if _, ok := s.Get(ctx, "short"); ok {
+		t.Error("Get() found an expired key")
+	}
+	keys, err := s.Keys(ctx, "")
+	if err != nil {
+		t.Fatalf("Keys() error = %v", err)
+	}
+	if want := []string{"forever", "long"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keys() = %v, want %v", keys, want)
+	}
+
+	// Setting a key without a TTL makes it persistent
+	if err := s.Set(ctx, "long", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	c.Advance(time.Hour)
+	if _, ok := s.Get(ctx, "long"); !ok {
+		t.Error("Get() did not find a key set again without a TTL")
+	}
+
+	// Expired keys stay in the file until they are swept
+	if err := s.SetWithTTL(ctx, "swept", "value", time.Second); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+	c.Advance(time.Second)
+	if n, err := s.DeleteExpired(ctx); err != nil || n != 2 {
+		t.Errorf("DeleteExpired() = %d, %v, want 2", n, err)
+	}
+	var rows int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM kv").Scan(&rows); err != nil || rows != 2 {
+		t.Errorf("kv holds %d rows, %v, want 2", rows, err)
+	}
+}
+
+func TestStore_Keys(t *testing.T) {
+	ctx := context.Background()
+	s, _ := open(t)
+	for _, key := range []string{"a", "a/1", "a/2", "ab", "b/1", "b\xff", "b\xff\xff", "c"} {
+		if err := s.Set(ctx, key, "value"); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "", want: []string{"a", "a/1", "a/2", "ab", "b/1", "b\xff", "b\xff\xff", "c"}},
+		{prefix: "a", want: []string{"a", "a/1", "a/2", "ab"}},
+		{prefix: "a/", want: []string{"a/1", "a/2"}},
+		{prefix: "b\xff", want: []string{"b\xff", "b\xff\xff"}},
+		{prefix: "d", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			keys, err := s.Keys(ctx, tt.prefix)
+			if err != nil {
+				t.Fatalf("Keys() error = %v", err)
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("Keys() = %q, want %q", keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestStore_Queries(t *testing.T) {
+	s, _ := open(t)
+
+	// Scans and sweeps must search an index rather than the whole table
+	tests := []struct {
+		name  string
+		query string
+		args  []any
+	}{
+		{name: "keys", query: keysStatement, args: []any{"a", "b", 0}},
+		{name: "sweep", query: sweepStatement, args: []any{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := s.db.Query("EXPLAIN QUERY PLAN "+tt.query, tt.args...)
+			if err != nil {
+				t.Fatalf("EXPLAIN error = %v", err)
+			}
+			defer rows.Close()
+			var plan []string
+			for rows.Next() {
+				var id, parent, unused int
+				var detail string
+				if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+					t.Fatal(err)
+				}
+				plan = append(plan, detail)
+			}
+			if joined := strings.Join(plan, "; "); !strings.Contains(joined, "SEARCH") {
+				t.Errorf("query plan = %q, want an index search", joined)
+			}
+		})
+	}
+}
+
+func TestStore_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "kv.sqlite")
+	s, err := Open(path, Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := s.Set(ctx, "key", "value"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	s, err = Open(path, Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer s.Close()
+	if value, ok := s.Get(ctx, "key"); !ok || value != "value" {
+		t.Errorf("Get() after reopening = %q, %v, want value", value, ok)
+	}
+
+	// The file is in WAL mode and readable by any SQLite client
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var mode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("journal_mode = %q, %v, want wal", mode, err)
+	}
+}
+
+func TestStore_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	s, _ := open(t)
+
+	var wg sync.WaitGroup
+	for writer := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				key := fmt.Sprintf("key-%d-%d", writer, i)
+				if err := s.Set(ctx, key, "value"); err != nil {
+					t.Errorf("Set() error = %v", err)
+					return
+				}
+				if _, ok := s.Get(ctx, key); !ok {
+					t.Errorf("Get(%q) did not find the key", key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	keys, err := s.Keys(ctx, "key-")
+	if err != nil || len(keys) != 400 {
+		t.Errorf("Keys() = %d keys, %v, want 400", len(keys), err)
+	}
+}
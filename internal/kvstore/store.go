@@ -2,6 +2,7 @@ package kvstore
 
 import (
 	"context"
+	"time"
 )
 
 // KeyValueStore is an interface for a key-value store
@@ -10,3 +11,16 @@ type KeyValueStore interface {
 	Get(ctx context.Context, key string) (string, bool)
 	Delete(ctx context.Context, key string) error
 }
+
+// Scanner is implemented by stores that can list their keys
+type Scanner interface {
+	// Keys returns the keys starting with prefix in ascending order
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Expirer is implemented by stores whose keys can expire
+type Expirer interface {
+	// SetWithTTL sets a value for a key that expires after ttl, or never if
+	// ttl is 0
+	SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error
+}
@@ -0,0 +1,107 @@
+package types
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrInvalidReceipt is returned when a message is acknowledged with a
+	// receipt from an earlier delivery, after it was redelivered or released
+	ErrInvalidReceipt = errors.New("receipt does not match the latest delivery of the message")
+)
+
+// QueueMessage is a message held by a queue. A message is invisible to
+// consumers until VisibleAt, either because its delivery was delayed or
+// because it is leased to the consumer holding Receipt.
+type QueueMessage struct {
+	ID        string    `json:"id"`
+	Body      string    `json:"body"`
+	Attempts  int       `json:"attempts"`
+	VisibleAt time.Time `json:"visible_at"`
+	Receipt   string    `json:"receipt,omitempty"`
+}
+
+// Enqueue appends a message that becomes visible after delay
+func (v *Value) Enqueue(body string, delay time.Duration, now time.Time) QueueMessage {
+	msg := QueueMessage{
+		ID:        newID(),
+		Body:      body,
+		VisibleAt: now.Add(delay),
+	}
+	v.Queue = append(v.Queue, msg)
+	return msg
+}
+
+// Dequeue leases up to limit visible messages in queue order, hiding them for
+// the visibility timeout. Visible messages already delivered maxAttempts
+// times are removed and returned as dead letters instead. A maxAttempts of
+// zero or less never dead-letters messages.
+func (v *Value) Dequeue(limit int, visibility time.Duration, maxAttempts int, now time.Time) (delivered []QueueMessage, dead []QueueMessage) {
+	delivered = []QueueMessage{}
+	kept := v.Queue[:0]
+	for _, msg := range v.Queue {
+		if msg.VisibleAt.After(now) || len(delivered) >= limit {
+			kept = append(kept, msg)
+			continue
+		}
+		if maxAttempts > 0 && msg.Attempts >= maxAttempts {
+			msg.Receipt = ""
+			dead = append(dead, msg)
+			continue
+		}
+
+		msg.Attempts++
+		msg.VisibleAt = now.Add(visibility)
+		msg.Receipt = newID()
+		delivered = append(delivered, msg)
+		kept = append(kept, msg)
+	}
+	v.Queue = kept
+	return delivered, dead
+}
+
+// Ack removes a delivered message from the queue
+func (v *Value) Ack(id string, receipt string) error {
+	i, err := v.leased(id, receipt)
+	if err != nil {
+		return err
+	}
+	v.Queue = append(v.Queue[:i], v.Queue[i+1:]...)
+	return nil
+}
+
+// Nack releases a delivered message so it is redelivered after delay
+func (v *Value) Nack(id string, receipt string, delay time.Duration, now time.Time) error {
+	i, err := v.leased(id, receipt)
+	if err != nil {
+		return err
+	}
+	v.Queue[i].VisibleAt = now.Add(delay)
+	v.Queue[i].Receipt = ""
+	return nil
+}
+
+// leased returns the position of a message delivered with receipt
+func (v *Value) leased(id string, receipt string) (int, error) {
+	for i, msg := range v.Queue {
+		if msg.ID != id {
+			continue
+		}
+		if receipt == "" || msg.Receipt != receipt {
+			return 0, ErrInvalidReceipt
+		}
+		return i, nil
+	}
+	return 0, ErrMessageNotFound
+}
+
+// newID returns a random identifier for messages and receipts
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
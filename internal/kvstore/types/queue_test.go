@@ -0,0 +1,91 @@
+package types
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValue_Dequeue(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := New(Queue)
+	first := v.Enqueue("first", 0, now)
+	v.Enqueue("delayed", time.Minute, now)
+	v.Enqueue("second", 0, now)
+
+	delivered, dead := v.Dequeue(10, 30*time.Second, 0, now)
+	if len(delivered) != 2 || delivered[0].Body != "first" || delivered[1].Body != "second" || len(dead) != 0 {
+		t.Fatalf("Dequeue() = %v, %v", delivered, dead)
+	}
+	if delivered[0].ID != first.ID || delivered[0].Attempts != 1 || delivered[0].Receipt == "" {
+		t.Errorf("Dequeue() delivered %+v", delivered[0])
+	}
+
+	// Leased messages stay hidden until the visibility timeout expires
+	if delivered, _ := v.Dequeue(10, 30*time.Second, 0, now.Add(10*time.Second)); len(delivered) != 0 {
+		t.Errorf("Dequeue() during lease = %v, want none", delivered)
+	}
+
+	delivered, _ = v.Dequeue(1, 30*time.Second, 0, now.Add(time.Minute))
+	if len(delivered) != 1 || delivered[0].Body != "first" || delivered[0].Attempts != 2 {
+		t.Errorf("Dequeue() after lease = %v", delivered)
+	}
+	if v.Len() != 3 {
+		t.Errorf("Len() = %v, want 3", v.Len())
+	}
+}
+
+func TestValue_DequeueDeadLetter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := New(Queue)
+	v.Enqueue("poison", 0, now)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		delivered, dead := v.Dequeue(1, time.Second, 2, now)
+		if len(delivered) != 1 || len(dead) != 0 {
+			t.Fatalf("attempt %d: Dequeue() = %v, %v", attempt, delivered, dead)
+		}
+		if err := v.Nack(delivered[0].ID, delivered[0].Receipt, 0, now); err != nil {
+			t.Fatalf("attempt %d: Nack() error = %v", attempt, err)
+		}
+	}
+
+	delivered, dead := v.Dequeue(1, time.Second, 2, now)
+	if len(delivered) != 0 || len(dead) != 1 || dead[0].Body != "poison" || dead[0].Attempts != 2 {
+		t.Errorf("Dequeue() = %v, %v, want one dead letter", delivered, dead)
+	}
+	if v.Len() != 0 {
+		t.Errorf("Len() = %v, want 0", v.Len())
+	}
+}
+
+func TestValue_Ack(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := New(Queue)
+	v.Enqueue("job", 0, now)
+	stale, _ := v.Dequeue(1, time.Second, 0, now)
+	current, _ := v.Dequeue(1, time.Second, 0, now.Add(time.Minute))
+
+	tests := []struct {
+		name    string
+		id      string
+		receipt string
+		wantErr error
+	}{
+		{name: "unknown message", id: "missing", receipt: current[0].Receipt, wantErr: ErrMessageNotFound},
+		{name: "stale receipt", id: stale[0].ID, receipt: stale[0].Receipt, wantErr: ErrInvalidReceipt},
+		{name: "missing receipt", id: current[0].ID, wantErr: ErrInvalidReceipt},
+		{name: "current receipt", id: current[0].ID, receipt: current[0].Receipt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := v.Ack(tt.id, tt.receipt); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Ack() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if v.Len() != 0 {
+		t.Errorf("Len() = %v, want 0", v.Len())
+	}
+}
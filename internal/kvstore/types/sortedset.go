@@ -0,0 +1,100 @@
+package types
+
+import (
+	"sort"
+)
+
+// ScoredMember is a sorted set member with its score
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// Sorted returns the members of a sorted set in ascending order of score,
+// ties broken by member
+func (v *Value) Sorted() []ScoredMember {
+	members := make([]ScoredMember, 0, len(v.Scores))
+	for m, score := range v.Scores {
+		members = append(members, ScoredMember{Member: m, Score: score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score < members[j].Score
+		}
+		return members[i].Member < members[j].Member
+	})
+	return members
+}
+
+// Rank returns the zero-based position of a member in ascending order, or in
+// descending order when reverse is set
+func (v *Value) Rank(member string, reverse bool) (int, bool) {
+	if _, ok := v.Scores[member]; !ok {
+		return 0, false
+	}
+	sorted := v.Sorted()
+	for i, m := range sorted {
+		if m.Member == member {
+			if reverse {
+				return len(sorted) - 1 - i, true
+			}
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// RangeByRank returns the members between two inclusive ranks. Negative
+// ranks count from the end, so 0 and -1 select every member.
+func (v *Value) RangeByRank(start int, stop int, reverse bool) []ScoredMember {
+	sorted := ordered(v.Sorted(), reverse)
+
+	n := len(sorted)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return []ScoredMember{}
+	}
+	return sorted[start : stop+1]
+}
+
+// RangeByScore returns the members whose score lies between minScore and
+// maxScore inclusive, skipping offset members and returning at most limit of
+// them. A limit of zero or less returns every remaining member.
+func (v *Value) RangeByScore(minScore float64, maxScore float64, offset int, limit int, reverse bool) []ScoredMember {
+	members := []ScoredMember{}
+	for _, m := range ordered(v.Sorted(), reverse) {
+		if m.Score < minScore || m.Score > maxScore {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		members = append(members, m)
+		if limit > 0 && len(members) == limit {
+			break
+		}
+	}
+	return members
+}
+
+// ordered reverses members in place when reverse is set
+func ordered(members []ScoredMember, reverse bool) []ScoredMember {
+	if reverse {
+		for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+			members[i], members[j] = members[j], members[i]
+		}
+	}
+	return members
+}
@@ -0,0 +1,96 @@
+package types
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func newLeaderboard() *Value {
+	v := New(SortedSet)
+	v.Scores["ada"] = 30
+	v.Scores["bob"] = 10
+	v.Scores["cy"] = 20
+	v.Scores["dee"] = 20
+	return v
+}
+
+func TestValue_Rank(t *testing.T) {
+	tests := []struct {
+		name     string
+		member   string
+		reverse  bool
+		wantRank int
+		wantOk   bool
+	}{
+		{name: "lowest score", member: "bob", wantRank: 0, wantOk: true},
+		{name: "tie broken by member", member: "dee", wantRank: 2, wantOk: true},
+		{name: "reverse", member: "ada", reverse: true, wantRank: 0, wantOk: true},
+		{name: "missing member", member: "eve"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rank, ok := newLeaderboard().Rank(tt.member, tt.reverse)
+			if rank != tt.wantRank || ok != tt.wantOk {
+				t.Errorf("Rank() = %v, %v, want %v, %v", rank, ok, tt.wantRank, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestValue_RangeByRank(t *testing.T) {
+	tests := []struct {
+		name        string
+		start, stop int
+		reverse     bool
+		want        []string
+	}{
+		{name: "all", start: 0, stop: -1, want: []string{"bob", "cy", "dee", "ada"}},
+		{name: "top two", start: 0, stop: 1, reverse: true, want: []string{"ada", "dee"}},
+		{name: "last", start: -1, stop: -1, want: []string{"ada"}},
+		{name: "empty", start: 5, stop: 10, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := memberNames(newLeaderboard().RangeByRank(tt.start, tt.stop, tt.reverse))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RangeByRank() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValue_RangeByScore(t *testing.T) {
+	tests := []struct {
+		name          string
+		min, max      float64
+		offset, limit int
+		reverse       bool
+		want          []string
+	}{
+		{name: "all", min: math.Inf(-1), max: math.Inf(1), want: []string{"bob", "cy", "dee", "ada"}},
+		{name: "inclusive bounds", min: 10, max: 20, want: []string{"bob", "cy", "dee"}},
+		{name: "paginated", min: 0, max: 100, offset: 1, limit: 2, want: []string{"cy", "dee"}},
+		{name: "reverse paginated", min: 0, max: 100, offset: 1, limit: 2, reverse: true, want: []string{"dee", "cy"}},
+		{name: "no match", min: 40, max: 50, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := memberNames(newLeaderboard().RangeByScore(tt.min, tt.max, tt.offset, tt.limit, tt.reverse))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RangeByScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func memberNames(members []ScoredMember) []string {
+	names := []string{}
+	for _, m := range members {
+		names = append(names, m.Member)
+	}
+	return names
+}
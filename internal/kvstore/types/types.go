@@ -0,0 +1,204 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Type is the kind of value held by a key
+type Type string
+
+const (
+	String Type = "string"
+	Hash   Type = "hash"
+	List   Type = "list"
+	Set    Type = "set"
+	// SortedSet maps members to float scores, ordered by score then member
+	SortedSet Type = "zset"
+	// Queue holds messages in delivery order
+	Queue Type = "queue"
+)
+
+// marker prefixes the stored encoding of every value that is not a plain
+// string. Typed values live in the same keyspace as plain strings, so they
+// share their persistence, namespaces and quotas.
+const marker = "\x00kvtype:"
+
+// ErrWrongType is returned when an operation is applied to a key holding a
+// different kind of value
+var ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+// Value is a decoded value. Only the field matching Type is set.
+type Value struct {
+	Type   Type
+	Hash   map[string]string
+	List   []string
+	Set    map[string]struct{}
+	Scores map[string]float64
+	Queue  []QueueMessage
+}
+
+// New returns an empty value of the given type
+func New(t Type) *Value {
+	v := &Value{Type: t}
+	switch t {
+	case Hash:
+		v.Hash = make(map[string]string)
+	case Set:
+		v.Set = make(map[string]struct{})
+	case SortedSet:
+		v.Scores = make(map[string]float64)
+	}
+	return v
+}
+
+// IsTyped reports whether a stored value holds a data structure rather than
+// a plain string
+func IsTyped(raw string) bool {
+	return strings.HasPrefix(raw, marker)
+}
+
+// TypeOf returns the type of a stored value
+func TypeOf(raw string) Type {
+	if !IsTyped(raw) {
+		return String
+	}
+	t, _, _ := strings.Cut(raw[len(marker):], ":")
+	return Type(t)
+}
+
+// Decode decodes a stored value, checking that it holds the wanted type
+func Decode(raw string, want Type) (*Value, error) {
+	if got := TypeOf(raw); got != want {
+		return nil, fmt.Errorf("%w: key holds a %s, not a %s", ErrWrongType, got, want)
+	}
+	if want == String {
+		return nil, fmt.Errorf("plain strings are not decoded")
+	}
+
+	_, payload, _ := strings.Cut(raw[len(marker):], ":")
+	v := New(want)
+	var err error
+	switch want {
+	case Hash:
+		err = json.Unmarshal([]byte(payload), &v.Hash)
+	case List:
+		err = json.Unmarshal([]byte(payload), &v.List)
+	case Set:
+		var members []string
+		err = json.Unmarshal([]byte(payload), &members)
+		for _, m := range members {
+			v.Set[m] = struct{}{}
+		}
+	case SortedSet:
+		err = json.Unmarshal([]byte(payload), &v.Scores)
+	case Queue:
+		err = json.Unmarshal([]byte(payload), &v.Queue)
+	default:
+		err = fmt.Errorf("unknown type %q", want)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("corrupt %s value: %w", want, err)
+	}
+	return v, nil
+}
+
+// Encode encodes the value for storage
+func (v *Value) Encode() (string, error) {
+	var payload any
+	switch v.Type {
+	case Hash:
+		payload = v.Hash
+	case List:
+		payload = v.List
+	case Set:
+		payload = v.Members()
+	case SortedSet:
+		payload = v.Scores
+	case Queue:
+		payload = v.Queue
+	default:
+		return "", fmt.Errorf("cannot encode type %q", v.Type)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return marker + string(v.Type) + ":" + string(data), nil
+}
+
+// Len returns the number of fields, elements or members of the value
+func (v *Value) Len() int {
+	switch v.Type {
+	case Hash:
+		return len(v.Hash)
+	case List:
+		return len(v.List)
+	case Set:
+		return len(v.Set)
+	case SortedSet:
+		return len(v.Scores)
+	case Queue:
+		return len(v.Queue)
+	default:
+		return 0
+	}
+}
+
+// Members returns the members of a set in sorted order
+func (v *Value) Members() []string {
+	members := make([]string, 0, len(v.Set))
+	for m := range v.Set {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Range returns the list elements between start and stop inclusive. Negative
+// indexes count from the end of the list, so 0 and -1 select every element.
+func (v *Value) Range(start int, stop int) []string {
+	n := len(v.List)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return []string{}
+	}
+	return append([]string{}, v.List[start:stop+1]...)
+}
+
+// Intersect returns the members present in every given set, in sorted order
+func Intersect(sets ...*Value) []string {
+	if len(sets) == 0 {
+		return []string{}
+	}
+
+	members := []string{}
+	for _, m := range sets[0].Members() {
+		inAll := true
+		for _, other := range sets[1:] {
+			if _, ok := other.Set[m]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			members = append(members, m)
+		}
+	}
+	return members
+}
@@ -0,0 +1,106 @@
+package types
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		value *Value
+	}{
+		{
+			name:  "hash",
+			value: &Value{Type: Hash, Hash: map[string]string{"a": "1", "b": ""}},
+		},
+		{
+			name:  "list",
+			value: &Value{Type: List, List: []string{"a", "b", "a"}},
+		},
+		{
+			name:  "set",
+			value: &Value{Type: Set, Set: map[string]struct{}{"a": {}, "b": {}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := tt.value.Encode()
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if !IsTyped(raw) || TypeOf(raw) != tt.value.Type {
+				t.Errorf("TypeOf() = %v, want %v", TypeOf(raw), tt.value.Type)
+			}
+
+			decoded, err := Decode(raw, tt.value.Type)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(decoded, tt.value) {
+				t.Errorf("Decode() = %+v, want %+v", decoded, tt.value)
+			}
+		})
+	}
+}
+
+func TestDecode_WrongType(t *testing.T) {
+	raw, _ := New(List).Encode()
+
+	tests := []struct {
+		name string
+		raw  string
+		want Type
+	}{
+		{name: "list as hash", raw: raw, want: Hash},
+		{name: "plain string as set", raw: "plain", want: Set},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.raw, tt.want); !errors.Is(err, ErrWrongType) {
+				t.Errorf("Decode() error = %v, want %v", err, ErrWrongType)
+			}
+		})
+	}
+}
+
+func TestValue_Range(t *testing.T) {
+	list := &Value{Type: List, List: []string{"a", "b", "c", "d"}}
+
+	tests := []struct {
+		name        string
+		start, stop int
+		want        []string
+	}{
+		{name: "whole list", start: 0, stop: -1, want: []string{"a", "b", "c", "d"}},
+		{name: "middle", start: 1, stop: 2, want: []string{"b", "c"}},
+		{name: "negative start", start: -2, stop: -1, want: []string{"c", "d"}},
+		{name: "stop past end", start: 2, stop: 10, want: []string{"c", "d"}},
+		{name: "start past stop", start: 3, stop: 1, want: []string{}},
+		{name: "start before beginning", start: -10, stop: 0, want: []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := list.Range(tt.start, tt.stop); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Range() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	a := &Value{Type: Set, Set: map[string]struct{}{"x": {}, "y": {}, "z": {}}}
+	b := &Value{Type: Set, Set: map[string]struct{}{"y": {}, "z": {}}}
+	c := &Value{Type: Set, Set: map[string]struct{}{"z": {}, "w": {}}}
+
+	if got := Intersect(a, b, c); !reflect.DeepEqual(got, []string{"z"}) {
+		t.Errorf("Intersect() = %v, want [z]", got)
+	}
+	if got := Intersect(a, New(Set)); len(got) != 0 {
+		t.Errorf("Intersect() with empty set = %v, want []", got)
+	}
+}
@@ -0,0 +1,33 @@
+package wal
+
+import (
+	"censys/internal/kvstore"
+	"censys/internal/kvstore/backend"
+)
+
+// Config configures the wal backend
+type Config struct {
+	// Dir is the directory of the stores, defaulting to STORE_DIR or data
+	Dir            string `json:"dir"`
+	SyncWrites     bool   `json:"sync_writes"`
+	CompactionSize int64  `json:"compaction_size"`
+}
+
+func init() {
+	backend.Register("wal", "keys in memory, logged to an append-only file",
+		func() Config {
+			return Config{
+				Dir:            backend.DirFromEnv(),
+				CompactionSize: DefaultCompactionSize,
+			}
+		},
+		func(config Config) (backend.Stores, error) {
+			options := Options{
+				SyncWrites:     config.SyncWrites,
+				CompactionSize: config.CompactionSize,
+			}
+			return backend.OnDisk(config.Dir, "keyspace.wal", func(path string) (kvstore.KeyValueStore, error) {
+				return Open(path, options)
+			})
+		})
+}
@@ -0,0 +1,337 @@
+// Package wal is a key-value store held in memory and persisted to an
+// append-only log. Every write is appended to the log before it is applied,
+// and the log is replayed to rebuild the keys when the store is opened. The
+// log is rewritten with only the live keys once most of it is overwritten
+// or deleted writes.
+package wal
+
+import (
+	"bufio"
+	"censys/internal/kvstore"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultCompactionSize is the default size of the log below which it is
+// never rewritten
+const DefaultCompactionSize = 4 << 20
+
+// ErrClosed is returned when writing to a closed store
+var ErrClosed = errors.New("store is closed")
+
+// Options configure a store
+type Options struct {
+	// SyncWrites syncs the log on every write, so that writes survive a
+	// machine crash and not only a process crash
+	SyncWrites bool
+	// CompactionSize is the size of the log from which it is rewritten
+	// once more than half of it is overwritten or deleted writes. Defaults
+	// to DefaultCompactionSize.
+	CompactionSize int64
+}
+
+// Store is a key-value store held in memory and persisted to a log
+type Store struct {
+	path    string
+	options Options
+
+	mu   sync.RWMutex
+	data map[string]string
+	file *os.File
+	// size is the size of the log, and live the size of the records of
+	// the keys it holds
+	size int64
+	live int64
+	err  error
+}
+
+// Open opens the store logged at path, creating the log if needed, and
+// replays it. A torn or corrupt record ends the log, as it can only be the
+// last write before a crash, and is truncated away.
+func Open(path string, options Options) (*Store, error) {
+	if options.CompactionSize <= 0 {
+		options.CompactionSize = DefaultCompactionSize
+	}
+	s := &Store{
+		path:    path,
+		options: options,
+		data:    make(map[string]string),
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.replay(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("replaying %s: %w", path, err)
+	}
+	s.file = file
+	return s, nil
+}
+
+// replay applies the records of the log and truncates it after the last
+// valid one
+func (s *Store) replay(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(file)
+	for {
+		e, n, err := readRecord(r, info.Size()-s.size)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		s.apply(e, n)
+	}
+	if err := file.Truncate(s.size); err != nil {
+		return err
+	}
+	_, err = file.Seek(s.size, io.SeekStart)
+	return err
+}
+
+// apply applies a write logged in a record of n bytes
+func (s *Store) apply(e entry, n int64) {
+	if old, ok := s.data[e.key]; ok {
+		s.live -= recordSize(e.key, old)
+	}
+	if e.deleted {
+		delete(s.data, e.key)
+	} else {
+		s.data[e.key] = e.value
+		s.live += n
+	}
+	s.size += n
+}
+
+// Set sets a value for a key
+func (s *Store) Set(ctx context.Context, key string, value string) error {
+	return s.write(ctx, entry{key: key, value: value})
+}
+
+// Delete deletes a value for a key
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return s.write(ctx, entry{key: key, deleted: true})
+}
+
+// write logs a write and applies it, then rewrites the log if most of it
+// is garbage. A failed rewrite is logged and retried by the next write.
+func (s *Store) write(ctx context.Context, e entry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if e.key == "" {
+		return fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return ErrClosed
+	}
+	if s.err != nil {
+		return s.err
+	}
+	record := appendRecord(nil, e)
+	if _, err := s.file.Write(record); err != nil {
+		// A partial record would end the log before later writes
+		s.err = fmt.Errorf("writing %s: %w", s.path, err)
+		return s.err
+	}
+	if s.options.SyncWrites {
+		if err := s.file.Sync(); err != nil {
+			s.err = fmt.Errorf("syncing %s: %w", s.path, err)
+			return s.err
+		}
+	}
+	s.apply(e, int64(len(record)))
+	if s.size >= s.options.CompactionSize && s.size > 2*s.live {
+		if err := s.compact(); err != nil {
+			log.Printf("wal: %s", err)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the log with only the live keys, writing a new log and
+// renaming it over the old one so that a crash leaves either one intact,
+// then syncing the directory so that the rename survives a crash.
+// The caller must hold s.mu.
+func (s *Store) compact() error {
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	var record []byte
+	for key, value := range s.data {
+		record = appendRecord(record[:0], entry{key: key, value: value})
+		if _, err = w.Write(record); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("compacting %s: %w", s.path, err)
+	}
+	s.file.Close()
+	s.file, s.size = file, s.live
+	// Until the rename is durable, a crash may bring back the old log
+	// without the writes appended to the new one, so writes fail from then
+	// on like after a failed write
+	if err := syncDir(filepath.Dir(s.path)); err != nil {
+		s.err = fmt.Errorf("syncing the directory of %s: %w", s.path, err)
+		return s.err
+	}
+	return nil
+}
+
+// syncDir makes the creation, renaming and removal of the files of dir
+// durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Get gets a value for a key
+func (s *Store) Get(ctx context.Context, key string) (string, bool) {
+	if ctx.Err() != nil {
+		return "", false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.data[key]
+	return value, ok
+}
+
+// Keys returns the keys starting with prefix in ascending order
+func (s *Store) Keys(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	var keys []string
+	for key := range s.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Close closes the log. Writes after Close fail with ErrClosed.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// entry is a write to a key: a value or, for deletes, a tombstone
+type entry struct {
+	key     string
+	value   string
+	deleted bool
+}
+
+// Every record of the log is a CRC32 checksum and a length followed by its
+// payload: its kind, the length of its key, its key and its value
+const (
+	headerSize = 8
+	kindSet    = 0
+	kindDelete = 1
+)
+
+// appendRecord appends the record of a write to buf
+func appendRecord(buf []byte, e entry) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, headerSize)...)
+	kind := byte(kindSet)
+	if e.deleted {
+		kind = kindDelete
+	}
+	buf = append(buf, kind)
+	buf = binary.AppendUvarint(buf, uint64(len(e.key)))
+	buf = append(buf, e.key...)
+	buf = append(buf, e.value...)
+	payload := buf[start+headerSize:]
+	binary.LittleEndian.PutUint32(buf[start:], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(buf[start+4:], uint32(len(payload)))
+	return buf
+}
+
+// recordSize returns the size of the record setting a key to value
+func recordSize(key string, value string) int64 {
+	var varint [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(varint[:], uint64(len(key)))
+	return int64(headerSize + 1 + n + len(key) + len(value))
+}
+
+// readRecord reads the next record of a log, of which remaining bytes are
+// left, returning its size or 0 at the end of the log or at a torn or
+// corrupt record
+func readRecord(r io.Reader, remaining int64) (entry, int64, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return entry{}, 0, nil
+		}
+		return entry{}, 0, err
+	}
+	length := int64(binary.LittleEndian.Uint32(header[4:]))
+	if length > remaining-headerSize {
+		return entry{}, 0, nil
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return entry{}, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header) || len(payload) < 2 || payload[0] > kindDelete {
+		return entry{}, 0, nil
+	}
+	keyLength, n := binary.Uvarint(payload[1:])
+	if n <= 0 || keyLength > uint64(len(payload)-1-n) {
+		return entry{}, 0, nil
+	}
+	keyEnd := 1 + n + int(keyLength)
+	return entry{
+		key:     string(payload[1+n : keyEnd]),
+		value:   string(payload[keyEnd:]),
+		deleted: payload[0] == kindDelete,
+	}, headerSize + length, nil
+}
@@ -0,0 +1,151 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_Recovery(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage damages the log left by the closed store
+		damage func(t *testing.T, path string)
+		// last is whether the last write survives the damage
+		last bool
+	}{
+		{"clean log", func(*testing.T, string) {}, true},
+		{"torn last write", func(t *testing.T, path string) {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(path, info.Size()-3); err != nil {
+				t.Fatal(err)
+			}
+		}, false},
+		{"corrupt last write", func(t *testing.T, path string) {
+			file, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			info, err := file.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.WriteAt([]byte{0xff}, info.Size()-1)
+		}, false},
+		{"garbage length", func(t *testing.T, path string) {
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			file.Write([]byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff})
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "kv.wal")
+			s, err := Open(path, Options{})
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			s.Set(ctx, "kept", "value")
+			s.Set(ctx, "deleted", "value")
+			s.Delete(ctx, "deleted")
+			s.Set(ctx, "last", "value")
+			s.Close()
+			tt.damage(t, path)
+
+			s, err = Open(path, Options{})
+			if err != nil {
+				t.Fatalf("Open() after Close() error = %v", err)
+			}
+			defer s.Close()
+			if value, ok := s.Get(ctx, "kept"); !ok || value != "value" {
+				t.Errorf("Get() of a logged write = %q, %v", value, ok)
+			}
+			if _, ok := s.Get(ctx, "deleted"); ok {
+				t.Error("Get() found a key deleted before the restart")
+			}
+			if _, ok := s.Get(ctx, "last"); ok != tt.last {
+				t.Errorf("Get() of the last write found = %v, want %v", ok, tt.last)
+			}
+
+			// Writes after the damage are appended after the valid records
+			if err := s.Set(ctx, "after", "value"); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			s.Close()
+			s, err = Open(path, Options{})
+			if err != nil {
+				t.Fatalf("Open() again error = %v", err)
+			}
+			defer s.Close()
+			if value, ok := s.Get(ctx, "after"); !ok || value != "value" {
+				t.Errorf("Get() of a write after the damage = %q, %v", value, ok)
+			}
+		})
+	}
+}
+
+func TestStore_Compaction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "kv.wal")
+	s, err := Open(path, Options{CompactionSize: 4096})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	// Overwriting the same keys keeps rewriting the log
+	want := make(map[string]string)
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 20; i++ {
+			key, value := fmt.Sprint("key-", i), fmt.Sprint("value-", round)
+			if err := s.Set(ctx, key, value); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			want[key] = value
+		}
+	}
+	if err := s.Delete(ctx, "key-0"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	delete(want, "key-0")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= 2*4096 {
+		t.Errorf("log size = %d after compactions, want less than %d", info.Size(), 2*4096)
+	}
+
+	s.Close()
+	if err := s.Set(ctx, "key", "value"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Set() after Close() error = %v, want %v", err, ErrClosed)
+	}
+	s, err = Open(path, Options{})
+	if err != nil {
+		t.Fatalf("Open() again error = %v", err)
+	}
+	defer s.Close()
+	keys, err := s.Keys(ctx, "")
+	if err != nil {
+		t.Fatalf("Keys() error = %v", err)
+	}
+	if len(keys) != len(want) {
+		t.Errorf("Keys() = %v, want %d keys", keys, len(want))
+	}
+	for key, value := range want {
+		if got, ok := s.Get(ctx, key); !ok || got != value {
+			t.Errorf("Get(%q) = %q, %v, want %q", key, got, ok, value)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package lease
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLeaseNotFound = errors.New("lease not found")
+	ErrInvalidTTL    = errors.New("lease TTL must be positive")
+)
+
+// Key identifies a key attached to a lease
+type Key struct {
+	Namespace string
+	Key       string
+}
+
+// Lessor grants leases that expire unless they are kept alive. When a lease
+// expires or is revoked, the keys attached to it are passed to the release
+// function so they can be deleted.
+type Lessor struct {
+	release func(id int64, keys []Key)
+
+	mu     sync.Mutex
+	nextID int64
+	leases map[int64]*lease
+	// owners maps keys to their lease. The keys of an ended lease stay
+	// until they are released, unless they are attached again meanwhile.
+	owners map[Key]int64
+}
+
+type lease struct {
+	ttl   time.Duration
+	timer *time.Timer
+	// expiry is when the timer fires, kept to report the remaining TTL
+	expiry time.Time
+	keys   map[Key]struct{}
+}
+
+// NewLessor creates a lessor calling release with the keys of every lease
+// that expires or is revoked. A key attached again while release runs is
+// reported by Attached, so release can leave it alone.
+func NewLessor(release func(id int64, keys []Key)) *Lessor {
+	return &Lessor{
+		release: release,
+		leases:  make(map[int64]*lease),
+		owners:  make(map[Key]int64),
+	}
+}
+
+// Seed makes the lessor grant IDs greater than id, so that a lessor created
+// after a restart does not reuse the IDs granted before
+func (l *Lessor) Seed(id int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextID = max(l.nextID, id)
+}
+
+// Grant grants a lease that expires after ttl unless it is kept alive
+func (l *Lessor) Grant(ttl time.Duration) (int64, error) {
+	if ttl <= 0 {
+		return 0, ErrInvalidTTL
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.nextID++
+	id := l.nextID
+	// The expiry is taken before the timer starts so it never fires early
+	expiry := time.Now().Add(ttl)
+	l.leases[id] = &lease{
+		ttl:    ttl,
+		timer:  time.AfterFunc(ttl, func() { l.expire(id) }),
+		expiry: expiry,
+		keys:   make(map[Key]struct{}),
+	}
+	return id, nil
+}
+
+// KeepAlive restarts the TTL of a lease and returns it
+func (l *Lessor) KeepAlive(id int64) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	le, ok := l.leases[id]
+	if !ok {
+		return 0, ErrLeaseNotFound
+	}
+	le.expiry = time.Now().Add(le.ttl)
+	le.timer.Reset(le.ttl)
+	return le.ttl, nil
+}
+
+// TimeToLive returns the remaining TTL of a lease and its attached keys
+func (l *Lessor) TimeToLive(id int64) (time.Duration, []Key, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	le, ok := l.leases[id]
+	if !ok {
+		return 0, nil, ErrLeaseNotFound
+	}
+	keys := make([]Key, 0, len(le.keys))
+	for k := range le.keys {
+		keys = append(keys, k)
+	}
+	return max(time.Until(le.expiry), 0), keys, nil
+}
+
+// Revoke ends a lease immediately, releasing its keys
+func (l *Lessor) Revoke(id int64) error {
+	l.mu.Lock()
+	keys, ok := l.remove(id)
+	l.mu.Unlock()
+	if !ok {
+		return ErrLeaseNotFound
+	}
+	l.releaseKeys(id, keys)
+	return nil
+}
+
+// Attach attaches a key to a lease, detaching it from any other lease
+func (l *Lessor) Attach(id int64, key Key) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	le, ok := l.leases[id]
+	if !ok {
+		return ErrLeaseNotFound
+	}
+	l.detach(key)
+	le.keys[key] = struct{}{}
+	l.owners[key] = id
+	return nil
+}
+
+// Detach detaches a key from its lease, if any
+func (l *Lessor) Detach(key Key) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.detach(key)
+}
+
+// Owner returns the live lease a key is attached to
+func (l *Lessor) Owner(key Key) (int64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	id, ok := l.owners[key]
+	if _, live := l.leases[id]; !ok || !live {
+		return 0, false
+	}
+	return id, true
+}
+
+// Attached reports whether a key is still attached to a lease, which may
+// have ended and be releasing its keys
+func (l *Lessor) Attached(id int64, key Key) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	owner, ok := l.owners[key]
+	return ok && owner == id
+}
+
+// detach detaches a key from its lease. The caller must hold l.mu.
+func (l *Lessor) detach(key Key) {
+	if id, ok := l.owners[key]; ok {
+		if le, ok := l.leases[id]; ok {
+			delete(le.keys, key)
+		}
+		delete(l.owners, key)
+	}
+}
+
+// expire ends a lease whose timer fired, unless it was kept alive since
+func (l *Lessor) expire(id int64) {
+	l.mu.Lock()
+	le, ok := l.leases[id]
+	// A keep-alive may have raced with the timer firing, so the expiry is
+	// checked under the same lock hold as the lease is removed
+	if !ok || time.Now().Before(le.expiry) {
+		l.mu.Unlock()
+		return
+	}
+	keys, _ := l.remove(id)
+	l.mu.Unlock()
+
+	l.releaseKeys(id, keys)
+}
+
+// remove removes a lease and returns the keys that were attached to it.
+// The keys stay attached to the lease until they are released. The caller
+// must hold l.mu.
+func (l *Lessor) remove(id int64) ([]Key, bool) {
+	le, ok := l.leases[id]
+	if !ok {
+		return nil, false
+	}
+	le.timer.Stop()
+	delete(l.leases, id)
+
+	keys := make([]Key, 0, len(le.keys))
+	for k := range le.keys {
+		keys = append(keys, k)
+	}
+	return keys, true
+}
+
+// releaseKeys releases the keys of an ended lease, then detaches the ones
+// that were not attached to another lease meanwhile
+func (l *Lessor) releaseKeys(id int64, keys []Key) {
+	l.release(id, keys)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, k := range keys {
+		if l.owners[k] == id {
+			delete(l.owners, k)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package lease
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLessor_Expire(t *testing.T) {
+	released := make(chan []Key, 1)
+	l := NewLessor(func(id int64, keys []Key) { released <- keys })
+
+	id, err := l.Grant(20 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("Grant() error = %v", err)
+	}
+	key := Key{Namespace: "default", Key: "worker-1"}
+	if err := l.Attach(id, key); err != nil {
+		t.Fatalf("Attach() error = %v", err)
+	}
+
+	select {
+	case keys := <-released:
+		if !reflect.DeepEqual(keys, []Key{key}) {
+			t.Errorf("released %v, want %v", keys, []Key{key})
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lease did not expire")
+	}
+	if _, err := l.KeepAlive(id); !errors.Is(err, ErrLeaseNotFound) {
+		t.Errorf("KeepAlive() after expiry error = %v, want %v", err, ErrLeaseNotFound)
+	}
+	if _, ok := l.Owner(key); ok {
+		t.Error("Owner() found the key of an expired lease")
+	}
+}
+
+func TestLessor_KeepAlive(t *testing.T) {
+	released := make(chan []Key, 1)
+	l := NewLessor(func(id int64, keys []Key) { released <- keys })
+
+	id, _ := l.Grant(200 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if ttl, err := l.KeepAlive(id); err != nil || ttl != 200*time.Millisecond {
+			t.Fatalf("KeepAlive() = %v, %v", ttl, err)
+		}
+	}
+	select {
+	case <-released:
+		t.Fatal("lease expired while kept alive")
+	default:
+	}
+
+	if err := l.Revoke(id); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if keys := <-released; len(keys) != 0 {
+		t.Errorf("released %v, want no keys", keys)
+	}
+	if err := l.Revoke(id); !errors.Is(err, ErrLeaseNotFound) {
+		t.Errorf("Revoke() twice error = %v, want %v", err, ErrLeaseNotFound)
+	}
+}
+
+func TestLessor_Attach(t *testing.T) {
+	l := NewLessor(func(id int64, keys []Key) {})
+	first, _ := l.Grant(time.Minute)
+	second, _ := l.Grant(time.Minute)
+	key := Key{Namespace: "default", Key: "config"}
+
+	if err := l.Attach(first, key); err != nil {
+		t.Fatalf("Attach() error = %v", err)
+	}
+	if err := l.Attach(second, key); err != nil {
+		t.Fatalf("Attach() error = %v", err)
+	}
+	if owner, _ := l.Owner(key); owner != second {
+		t.Errorf("Owner() = %v, want %v", owner, second)
+	}
+	if _, keys, _ := l.TimeToLive(first); len(keys) != 0 {
+		t.Errorf("TimeToLive() keys of previous lease = %v, want none", keys)
+	}
+
+	l.Detach(key)
+	if _, ok := l.Owner(key); ok {
+		t.Error("Owner() found a detached key")
+	}
+	if err := l.Attach(42, key); !errors.Is(err, ErrLeaseNotFound) {
+		t.Errorf("Attach() unknown lease error = %v, want %v", err, ErrLeaseNotFound)
+	}
+	if _, err := l.Grant(0); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("Grant(0) error = %v, want %v", err, ErrInvalidTTL)
+	}
+}
+
+func TestLessor_AttachWhileReleasing(t *testing.T) {
+	key := Key{Namespace: "default", Key: "config"}
+	var l *Lessor
+	var second int64
+	l = NewLessor(func(id int64, keys []Key) {
+		if !l.Attached(id, key) {
+			t.Error("Attached() = false for a key being released")
+		}
+		if _, ok := l.Owner(key); ok {
+			t.Error("Owner() found the key of an ended lease")
+		}
+		// The key is written again with another lease before it is deleted
+		second, _ = l.Grant(time.Minute)
+		if err := l.Attach(second, key); err != nil {
+			t.Errorf("Attach() error = %v", err)
+		}
+		if l.Attached(id, key) {
+			t.Error("Attached() = true for a key attached to another lease")
+		}
+	})
+
+	first, _ := l.Grant(time.Minute)
+	if err := l.Attach(first, key); err != nil {
+		t.Fatalf("Attach() error = %v", err)
+	}
+	if err := l.Revoke(first); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if owner, ok := l.Owner(key); !ok || owner != second {
+		t.Errorf("Owner() = %v, %v, want %v", owner, ok, second)
+	}
+}
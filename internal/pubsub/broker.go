@@ -0,0 +1,163 @@
+package pubsub
+
+import (
+	"errors"
+	"path"
+	"sync"
+)
+
+// DefaultBufferSize is the number of undelivered messages a subscription
+// holds before it is disconnected as a slow consumer
+const DefaultBufferSize = 256
+
+var (
+	// ErrSlowConsumer is the reason a subscription whose buffer filled up
+	// was disconnected
+	ErrSlowConsumer = errors.New("subscriber disconnected for falling behind")
+	// ErrClosed is the reason a subscription closed by its owner ended
+	ErrClosed = errors.New("subscription closed")
+	// ErrInvalidPattern is returned when a subscription pattern is malformed
+	ErrInvalidPattern = errors.New("invalid channel pattern")
+)
+
+// Message is a message delivered to a subscription. Pattern is the pattern
+// that matched the channel, or empty for a channel subscription.
+type Message struct {
+	Channel string
+	Pattern string
+	Payload string
+}
+
+// Broker fans published messages out to the subscriptions of a namespace.
+// Delivery is at most once: messages are not stored, and a subscription
+// that cannot keep up is disconnected instead of slowing down publishers.
+type Broker struct {
+	bufferSize int
+
+	mu            sync.Mutex
+	subscriptions map[*Subscription]struct{}
+}
+
+// NewBroker creates a broker whose subscriptions buffer up to bufferSize
+// messages. A bufferSize below 1 uses DefaultBufferSize.
+func NewBroker(bufferSize int) *Broker {
+	if bufferSize < 1 {
+		bufferSize = DefaultBufferSize
+	}
+	return &Broker{
+		bufferSize:    bufferSize,
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe subscribes to the given channels, and to every channel matching
+// one of the glob patterns, such as news.* or user.[0-9]?
+func (b *Broker) Subscribe(namespace string, channels []string, patterns []string) (*Subscription, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, ErrInvalidPattern
+		}
+	}
+
+	sub := &Subscription{
+		broker:    b,
+		namespace: namespace,
+		channels:  make(map[string]struct{}, len(channels)),
+		patterns:  patterns,
+		messages:  make(chan Message, b.bufferSize),
+		done:      make(chan struct{}),
+	}
+	for _, channel := range channels {
+		sub.channels[channel] = struct{}{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions[sub] = struct{}{}
+	return sub, nil
+}
+
+// Publish delivers a message to every matching subscription of the
+// namespace and returns the number of subscriptions that received it
+func (b *Broker) Publish(namespace string, channel string, payload string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	receivers := 0
+	for sub := range b.subscriptions {
+		if sub.namespace != namespace {
+			continue
+		}
+		pattern, ok := sub.match(channel)
+		if !ok {
+			continue
+		}
+		select {
+		case sub.messages <- Message{Channel: channel, Pattern: pattern, Payload: payload}:
+			receivers++
+		default:
+			b.remove(sub, ErrSlowConsumer)
+		}
+	}
+	return receivers
+}
+
+// remove ends a subscription. The caller must hold b.mu.
+func (b *Broker) remove(sub *Subscription, reason error) {
+	if _, ok := b.subscriptions[sub]; !ok {
+		return
+	}
+	delete(b.subscriptions, sub)
+	sub.err = reason
+	close(sub.done)
+}
+
+// Subscription is a set of channel and pattern subscriptions
+type Subscription struct {
+	broker    *Broker
+	namespace string
+	channels  map[string]struct{}
+	patterns  []string
+	messages  chan Message
+	done      chan struct{}
+	err       error
+}
+
+// Messages returns the channel on which messages are delivered
+func (s *Subscription) Messages() <-chan Message {
+	return s.messages
+}
+
+// Done is closed when the subscription ends
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the subscription ended, ErrSlowConsumer or ErrClosed, or
+// nil while it is active
+func (s *Subscription) Err() error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	return s.err
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.remove(s, ErrClosed)
+}
+
+// match reports whether the subscription receives messages published to
+// channel, and the pattern that matched it
+func (s *Subscription) match(channel string) (string, bool) {
+	if _, ok := s.channels[channel]; ok {
+		return "", true
+	}
+	for _, pattern := range s.patterns {
+		if ok, _ := path.Match(pattern, channel); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+package pubsub
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBroker_Publish(t *testing.T) {
+	tests := []struct {
+		name          string
+		namespace     string
+		channels      []string
+		patterns      []string
+		publishTo     string
+		wantReceivers int
+		wantMessage   Message
+	}{
+		{
+			name:          "channel",
+			channels:      []string{"news"},
+			publishTo:     "news",
+			wantReceivers: 1,
+			wantMessage:   Message{Channel: "news", Payload: "hello"},
+		},
+		{
+			name:          "pattern",
+			patterns:      []string{"news.*"},
+			publishTo:     "news.sport",
+			wantReceivers: 1,
+			wantMessage:   Message{Channel: "news.sport", Pattern: "news.*", Payload: "hello"},
+		},
+		{
+			name:      "no match",
+			channels:  []string{"news"},
+			patterns:  []string{"news.?"},
+			publishTo: "news.sport",
+		},
+		{
+			name:      "other namespace",
+			namespace: "team-a",
+			channels:  []string{"news"},
+			publishTo: "news",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBroker(1)
+			sub, err := b.Subscribe(tt.namespace, tt.channels, tt.patterns)
+			if err != nil {
+				t.Fatalf("Subscribe() error = %v", err)
+			}
+			defer sub.Close()
+
+			if got := b.Publish("", tt.publishTo, "hello"); got != tt.wantReceivers {
+				t.Errorf("Publish() = %v, want %v", got, tt.wantReceivers)
+			}
+			if tt.wantReceivers == 0 {
+				return
+			}
+			if got := <-sub.Messages(); !reflect.DeepEqual(got, tt.wantMessage) {
+				t.Errorf("Messages() = %v, want %v", got, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestBroker_SlowConsumer(t *testing.T) {
+	b := NewBroker(2)
+	slow, _ := b.Subscribe("", []string{"events"}, nil)
+	fast, _ := b.Subscribe("", []string{"events"}, nil)
+	defer fast.Close()
+
+	for i := 0; i < 3; i++ {
+		b.Publish("", "events", "tick")
+		<-fast.Messages()
+	}
+
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("slow subscription was not disconnected")
+	}
+	if !errors.Is(slow.Err(), ErrSlowConsumer) {
+		t.Errorf("Err() = %v, want %v", slow.Err(), ErrSlowConsumer)
+	}
+	if fast.Err() != nil {
+		t.Errorf("Err() = %v, want nil", fast.Err())
+	}
+	if got := b.Publish("", "events", "tick"); got != 1 {
+		t.Errorf("Publish() after disconnect = %v, want 1", got)
+	}
+}
+
+func TestBroker_Subscribe(t *testing.T) {
+	b := NewBroker(0)
+	if _, err := b.Subscribe("", nil, []string{"news.["}); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("Subscribe() error = %v, want %v", err, ErrInvalidPattern)
+	}
+
+	sub, _ := b.Subscribe("", []string{"news"}, nil)
+	sub.Close()
+	sub.Close()
+	if !errors.Is(sub.Err(), ErrClosed) {
+		t.Errorf("Err() = %v, want %v", sub.Err(), ErrClosed)
+	}
+	if got := b.Publish("", "news", "hello"); got != 0 {
+		t.Errorf("Publish() after Close = %v, want 0", got)
+	}
+}
@@ -0,0 +1,274 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"math"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientIDHeader is the metadata key with which trusted proxies identify
+// the client they call on behalf of. Other clients are identified by their
+// IP address.
+const ClientIDHeader = "x-client-id"
+
+// DefaultRetryDelay is the minimum retry delay suggested to rejected clients
+const DefaultRetryDelay = 100 * time.Millisecond
+
+// Config configures admission control. Zero values disable the matching
+// limit.
+type Config struct {
+	// MaxInFlight is the maximum number of unary RPCs served at once
+	MaxInFlight int
+	// MaxPerClient is the maximum number of unary RPCs served at once for
+	// a single client
+	MaxPerClient int
+	// TargetLatency enables load shedding: while the average latency is
+	// above it, the in-flight limit is lowered below MaxInFlight until
+	// latency recovers
+	TargetLatency time.Duration
+	// RetryDelay is the minimum retry delay suggested to rejected clients
+	RetryDelay time.Duration
+	// Exempt lists the full names of methods that are never limited, such
+	// as RPCs that block until a lock is acquired
+	Exempt map[string]bool
+	// TrustedProxies lists the addresses of the proxies, such as the REST
+	// API, whose ClientIDHeader metadata is trusted
+	TrustedProxies []netip.Prefix
+}
+
+// Controller admits RPCs while the server has capacity for them and
+// rejects the others with ResourceExhausted and a RetryInfo detail
+type Controller struct {
+	config Config
+
+	mu        sync.Mutex
+	inFlight  int
+	perClient map[string]int
+	// limit is the adaptive in-flight limit, at most MaxInFlight
+	limit float64
+	// latency is a moving average of unary RPC latency in seconds
+	latency      float64
+	lastDecrease time.Time
+}
+
+// New creates an admission controller
+func New(config Config) *Controller {
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = DefaultRetryDelay
+	}
+	return &Controller{
+		config:    config,
+		perClient: make(map[string]int),
+		limit:     float64(config.MaxInFlight),
+	}
+}
+
+// ConfigFromEnv reads the MAX_IN_FLIGHT, MAX_IN_FLIGHT_PER_CLIENT,
+// TARGET_LATENCY_MS and TRUSTED_PROXIES environment variables
+func ConfigFromEnv() (Config, error) {
+	var config Config
+	for name, target := range map[string]*int{
+		"MAX_IN_FLIGHT":            &config.MaxInFlight,
+		"MAX_IN_FLIGHT_PER_CLIENT": &config.MaxPerClient,
+	} {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return config, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*target = n
+		}
+	}
+	if value := os.Getenv("TARGET_LATENCY_MS"); value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms < 0 {
+			return config, fmt.Errorf("invalid TARGET_LATENCY_MS %q", value)
+		}
+		config.TargetLatency = time.Duration(ms) * time.Millisecond
+	}
+	if value := os.Getenv("TRUSTED_PROXIES"); value != "" {
+		for _, proxy := range strings.Split(value, ",") {
+			prefix, err := parsePrefix(strings.TrimSpace(proxy))
+			if err != nil {
+				return config, fmt.Errorf("invalid TRUSTED_PROXIES %q: %w", value, err)
+			}
+			config.TrustedProxies = append(config.TrustedProxies, prefix)
+		}
+	}
+	return config, nil
+}
+
+// parsePrefix parses a CIDR prefix or a single IP address
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		return netip.ParsePrefix(s)
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// UnaryServerInterceptor limits the unary RPCs served at once
+func (c *Controller) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if c.config.Exempt[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		client := c.clientID(ctx)
+		if err := c.admit(client); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		defer func() {
+			c.release(client, time.Since(start))
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects new streams while the server is at its
+// limits. Streams are long-lived, so they do not hold an in-flight slot once
+// admitted.
+func (c *Controller) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if c.config.Exempt[info.FullMethod] {
+			return handler(srv, ss)
+		}
+
+		c.mu.Lock()
+		err := c.check(c.clientID(ss.Context()))
+		c.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// admit takes an in-flight slot for a client
+func (c *Controller) admit(client string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.check(client); err != nil {
+		return err
+	}
+	c.inFlight++
+	c.perClient[client]++
+	return nil
+}
+
+// check rejects a client when the server or the client is at its limit.
+// The caller must hold c.mu.
+func (c *Controller) check(client string) error {
+	if c.config.MaxInFlight > 0 && c.inFlight >= int(c.limit) {
+		return c.reject("server is overloaded")
+	}
+	if c.config.MaxPerClient > 0 && c.perClient[client] >= c.config.MaxPerClient {
+		return c.reject("too many concurrent requests from this client")
+	}
+	return nil
+}
+
+// release returns the slot of a completed RPC and adapts the in-flight limit
+// to its latency
+func (c *Controller) release(client string, latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inFlight--
+	c.perClient[client]--
+	c.cleanup(client)
+
+	if c.config.TargetLatency <= 0 || c.config.MaxInFlight <= 0 {
+		return
+	}
+	const weight = 0.1
+	c.latency = (1-weight)*c.latency + weight*latency.Seconds()
+
+	// Decrease multiplicatively at most once per target latency, and
+	// increase additively by about one slot per limit completions
+	if c.latency > c.config.TargetLatency.Seconds() {
+		if now := time.Now(); now.Sub(c.lastDecrease) >= c.config.TargetLatency {
+			c.limit = math.Max(1, c.limit*0.9)
+			c.lastDecrease = now
+		}
+	} else {
+		c.limit = math.Min(float64(c.config.MaxInFlight), c.limit+1/c.limit)
+	}
+}
+
+// cleanup forgets clients without requests in flight. The caller must hold
+// c.mu.
+func (c *Controller) cleanup(client string) {
+	if c.perClient[client] <= 0 {
+		delete(c.perClient, client)
+	}
+}
+
+// reject builds a ResourceExhausted error suggesting when to retry. The
+// caller must hold c.mu.
+func (c *Controller) reject(reason string) error {
+	delay := c.config.RetryDelay
+	if average := time.Duration(c.latency * float64(time.Second)); average > delay {
+		delay = average
+	}
+
+	st := status.New(codes.ResourceExhausted, reason)
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(delay),
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// clientID identifies the client of an RPC by its IP address, or by its
+// ClientIDHeader metadata when it is a trusted proxy
+func (c *Controller) clientID(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil && c.trusted(addr.Unmap()) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if ids := md.Get(ClientIDHeader); len(ids) > 0 && ids[0] != "" {
+				return "id:" + ids[0]
+			}
+		}
+	}
+	return "addr:" + host
+}
+
+// trusted reports whether an address is one of the trusted proxies
+func (c *Controller) trusted(addr netip.Addr) bool {
+	for _, prefix := range c.config.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
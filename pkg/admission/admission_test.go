@@ -0,0 +1,225 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// peerContext returns the context of an RPC from addr with the given
+// ClientIDHeader metadata, if any
+func peerContext(addr string, id string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr))})
+	if id != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(ClientIDHeader, id))
+	}
+	return ctx
+}
+
+// blockingCall starts a unary RPC that stays in flight until release is
+// closed and returns its error on the result channel. Clients are named by
+// the last byte of their IP address.
+func blockingCall(c *Controller, client string, method string, release chan struct{}) chan error {
+	started := make(chan struct{})
+	result := make(chan error, 1)
+	ctx := peerContext(fmt.Sprintf("10.0.0.%d:5000", client[0]), "")
+	go func() {
+		_, err := c.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req any) (any, error) {
+			close(started)
+			<-release
+			return nil, nil
+		})
+		if err != nil {
+			close(started)
+		}
+		result <- err
+	}()
+	<-started
+	return result
+}
+
+func TestController_Limits(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		client   string
+		method   string
+		wantCode codes.Code
+	}{
+		{
+			name:     "server limit",
+			config:   Config{MaxInFlight: 1},
+			client:   "b",
+			method:   "/KvStoreService/Get",
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name:     "client limit",
+			config:   Config{MaxPerClient: 1},
+			client:   "a",
+			method:   "/KvStoreService/Get",
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name:     "other client",
+			config:   Config{MaxPerClient: 1},
+			client:   "b",
+			method:   "/KvStoreService/Get",
+			wantCode: codes.OK,
+		},
+		{
+			name:     "exempt method",
+			config:   Config{MaxInFlight: 1, Exempt: map[string]bool{"/KvStoreService/Lock": true}},
+			client:   "b",
+			method:   "/KvStoreService/Lock",
+			wantCode: codes.OK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(tt.config)
+			release := make(chan struct{})
+			first := blockingCall(c, "a", "/KvStoreService/Get", release)
+
+			second := make(chan struct{})
+			close(second)
+			result := blockingCall(c, tt.client, tt.method, second)
+			err := <-result
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("interceptor error = %v, want %v", err, tt.wantCode)
+			}
+			if tt.wantCode == codes.ResourceExhausted {
+				assertRetryInfo(t, err)
+			}
+
+			close(release)
+			if err := <-first; err != nil {
+				t.Errorf("first call error = %v", err)
+			}
+			// The slot is free again once the first call completes
+			if err := <-blockingCall(c, tt.client, tt.method, second); err != nil {
+				t.Error("call after release was rejected")
+			}
+		})
+	}
+}
+
+func assertRetryInfo(t *testing.T, err error) {
+	t.Helper()
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			if info.RetryDelay.AsDuration() < DefaultRetryDelay {
+				t.Errorf("RetryInfo delay = %v, want at least %v", info.RetryDelay.AsDuration(), DefaultRetryDelay)
+			}
+			return
+		}
+	}
+	t.Error("error has no RetryInfo detail")
+}
+
+func TestController_Shedding(t *testing.T) {
+	c := New(Config{MaxInFlight: 10, TargetLatency: time.Millisecond})
+
+	// Slow completions lower the limit until requests are shed
+	for i := 0; i < 50; i++ {
+		c.admit("a")
+		c.release("a", 50*time.Millisecond)
+		c.lastDecrease = time.Time{}
+	}
+	if c.limit >= 10 {
+		t.Fatalf("limit = %v, want below 10 after slow requests", c.limit)
+	}
+
+	// Fast completions raise it back to MaxInFlight
+	for i := 0; i < 5000; i++ {
+		c.admit("a")
+		c.release("a", 0)
+	}
+	if c.limit != 10 {
+		t.Errorf("limit = %v, want 10 after latency recovered", c.limit)
+	}
+}
+
+func TestController_ClientID(t *testing.T) {
+	c := New(Config{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")}})
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{
+			name: "client",
+			ctx:  peerContext("10.0.0.1:5000", ""),
+			want: "addr:10.0.0.1",
+		},
+		{
+			name: "untrusted client id",
+			ctx:  peerContext("10.0.0.1:5000", "forged"),
+			want: "addr:10.0.0.1",
+		},
+		{
+			name: "trusted proxy",
+			ctx:  peerContext("10.1.2.3:5000", "10.9.9.9"),
+			want: "id:10.9.9.9",
+		},
+		{
+			name: "trusted proxy without client id",
+			ctx:  peerContext("10.1.2.3:5000", ""),
+			want: "addr:10.1.2.3",
+		},
+		{
+			name: "IPv4-mapped trusted proxy",
+			ctx:  peerContext("[::ffff:10.1.2.3]:5000", "10.9.9.9"),
+			want: "id:10.9.9.9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.clientID(tt.ctx); got != tt.want {
+				t.Errorf("clientID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("MAX_IN_FLIGHT", "100")
+	t.Setenv("MAX_IN_FLIGHT_PER_CLIENT", "10")
+	t.Setenv("TARGET_LATENCY_MS", "50")
+	t.Setenv("TRUSTED_PROXIES", "10.1.0.0/16, 192.168.0.7")
+
+	config, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("ConfigFromEnv() error = %v", err)
+	}
+	if config.MaxInFlight != 100 || config.MaxPerClient != 10 || config.TargetLatency != 50*time.Millisecond {
+		t.Errorf("ConfigFromEnv() = %+v", config)
+	}
+	wantProxies := []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16"), netip.MustParsePrefix("192.168.0.7/32")}
+	if !reflect.DeepEqual(config.TrustedProxies, wantProxies) {
+		t.Errorf("ConfigFromEnv() trusted proxies = %v, want %v", config.TrustedProxies, wantProxies)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "api")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Error("ConfigFromEnv() accepted an invalid trusted proxy")
+	}
+	t.Setenv("TRUSTED_PROXIES", "")
+
+	t.Setenv("MAX_IN_FLIGHT", "-1")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Error("ConfigFromEnv() accepted a negative limit")
+	}
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"sync"
+	"time"
+)
+
+// Breaker is a circuit breaker failing calls fast while the kvstore is
+// unhealthy. It opens after a number of consecutive failures, rejects calls
+// for a cooldown, then lets a single probe call through: the breaker closes
+// if the probe succeeds and opens again otherwise.
+type Breaker struct {
+	failures int
+	cooldown time.Duration
+
+	mu          sync.Mutex
+	consecutive int
+	openUntil   time.Time
+	probing     bool
+}
+
+// NewBreaker creates a breaker opening after failures consecutive failures
+// for cooldown. A breaker with no failures never opens.
+func NewBreaker(failures int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		failures: failures,
+		cooldown: cooldown,
+	}
+}
+
+// Allow reports whether a call may be made, returning an Unavailable error
+// suggesting when to retry otherwise. Calls allowed as the probe of a half
+// open breaker are reported so their outcome is recorded as such.
+func (b *Breaker) Allow() (probe bool, err error) {
+	if b == nil || b.failures <= 0 {
+		return false, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.consecutive < b.failures {
+		return false, nil
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return false, breakerOpen(wait)
+	}
+	if b.probing {
+		return false, breakerOpen(b.cooldown)
+	}
+	b.probing = true
+	return true, nil
+}
+
+// Record records the outcome of an allowed call. Only failures signalling
+// an unhealthy kvstore count towards opening the breaker, while cancelled
+// calls tell nothing of its health.
+func (b *Breaker) Record(probe bool, err error) {
+	if b == nil || b.failures <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	switch code := status.Code(err); {
+	case code == codes.Unavailable || code == codes.DeadlineExceeded:
+		b.consecutive++
+		if b.consecutive >= b.failures {
+			b.openUntil = time.Now().Add(b.cooldown)
+		}
+	case code != codes.Canceled:
+		b.consecutive = 0
+	}
+}
+
+// Open reports whether the breaker is rejecting calls
+func (b *Breaker) Open() bool {
+	if b == nil || b.failures <= 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.consecutive >= b.failures && (b.probing || time.Now().Before(b.openUntil))
+}
+
+// breakerOpen builds the error failing a call fast, suggesting to retry
+// after wait
+func breakerOpen(wait time.Duration) error {
+	st := status.New(codes.Unavailable, "kvstore is unavailable: circuit breaker is open")
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(wait),
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "down")
+	tests := []struct {
+		name     string
+		outcomes []error
+		wantOpen bool
+	}{
+		{"closed below the threshold", []error{unavailable, unavailable}, false},
+		{"opens at the threshold", []error{unavailable, unavailable, unavailable}, true},
+		{"deadlines count as failures", []error{unavailable, unavailable, status.Error(codes.DeadlineExceeded, "slow")}, true},
+		{"successes reset the count", []error{unavailable, unavailable, nil, unavailable}, false},
+		{"answers reset the count", []error{unavailable, unavailable, status.Error(codes.NotFound, "missing"), unavailable}, false},
+		{"cancellations are ignored", []error{unavailable, unavailable, status.Error(codes.Canceled, "gone"), unavailable}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker := NewBreaker(3, time.Minute)
+			for _, err := range tt.outcomes {
+				breaker.Record(false, err)
+			}
+			_, err := breaker.Allow()
+			if got := err != nil; got != tt.wantOpen {
+				t.Errorf("Allow() error = %v, want open %v", err, tt.wantOpen)
+			}
+			if got := breaker.Open(); got != tt.wantOpen {
+				t.Errorf("Open() = %v, want %v", got, tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestBreaker_Probe(t *testing.T) {
+	breaker := NewBreaker(1, 20*time.Millisecond)
+	breaker.Record(false, status.Error(codes.Unavailable, "down"))
+	if _, err := breaker.Allow(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Allow() on an open breaker error = %v, want Unavailable", err)
+	}
+
+	// Once cooled down a single probe is let through
+	time.Sleep(30 * time.Millisecond)
+	probe, err := breaker.Allow()
+	if !probe || err != nil {
+		t.Fatalf("Allow() after cooldown = %v, %v, want a probe", probe, err)
+	}
+	if _, err := breaker.Allow(); err == nil {
+		t.Error("Allow() during the probe let another call through")
+	}
+
+	// A failed probe opens the breaker again
+	breaker.Record(probe, status.Error(codes.Unavailable, "down"))
+	if _, err := breaker.Allow(); err == nil {
+		t.Error("Allow() after a failed probe let a call through")
+	}
+
+	// A successful probe closes it
+	time.Sleep(30 * time.Millisecond)
+	probe, _ = breaker.Allow()
+	breaker.Record(probe, nil)
+	if probe, err := breaker.Allow(); probe || err != nil {
+		t.Errorf("Allow() after a successful probe = %v, %v, want a closed breaker", probe, err)
+	}
+}
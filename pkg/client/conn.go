@@ -0,0 +1,297 @@
+package client
+
+import (
+	pb "censys/proto/gen/proto"
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Idempotent lists the RPCs that only read the kvstore, which are safe to
+// retry and to hedge
+var Idempotent = map[string]bool{
+	pb.KvStoreService_Get_FullMethodName:                   true,
+	pb.KvStoreService_ListKeys_FullMethodName:              true,
+	pb.KvStoreService_ListNamespaces_FullMethodName:        true,
+	pb.KvStoreService_ListSchemas_FullMethodName:           true,
+	pb.KvStoreService_GetPath_FullMethodName:               true,
+	pb.KvStoreService_HashGet_FullMethodName:               true,
+	pb.KvStoreService_HashGetAll_FullMethodName:            true,
+	pb.KvStoreService_ListRange_FullMethodName:             true,
+	pb.KvStoreService_SetMembers_FullMethodName:            true,
+	pb.KvStoreService_SetIntersect_FullMethodName:          true,
+	pb.KvStoreService_SortedSetRank_FullMethodName:         true,
+	pb.KvStoreService_SortedSetRangeByScore_FullMethodName: true,
+	pb.KvStoreService_SortedSetRangeByRank_FullMethodName:  true,
+	pb.KvStoreService_LeaseTimeToLive_FullMethodName:       true,
+	pb.KvStoreService_Leader_FullMethodName:                true,
+}
+
+// Policy configures how calls to the kvstore are made. Zero values disable
+// the matching behavior.
+type Policy struct {
+	// Timeout bounds every unary call, retries included
+	Timeout time.Duration
+	// Retries is how many times an idempotent call failing with
+	// Unavailable is retried
+	Retries int
+	// Backoff is the base delay before a retry, doubled on every retry and
+	// fully jittered
+	Backoff time.Duration
+	// MaxBackoff caps the delay before a retry
+	MaxBackoff time.Duration
+	// BreakerFailures is how many consecutive failures open the circuit
+	// breaker
+	BreakerFailures int
+	// BreakerCooldown is how long an open breaker fails calls fast
+	BreakerCooldown time.Duration
+	// HedgeDelay is how long an idempotent call waits for an endpoint
+	// before also being sent to the next one
+	HedgeDelay time.Duration
+	// Idempotent lists the full names of the methods safe to retry and
+	// hedge
+	Idempotent map[string]bool
+}
+
+// DefaultPolicy is the policy used unless overridden by the environment
+var DefaultPolicy = Policy{
+	Timeout:         5 * time.Second,
+	Retries:         2,
+	Backoff:         50 * time.Millisecond,
+	MaxBackoff:      time.Second,
+	BreakerFailures: 5,
+	BreakerCooldown: 5 * time.Second,
+	HedgeDelay:      50 * time.Millisecond,
+	Idempotent:      Idempotent,
+}
+
+// PolicyFromEnv reads the KVSTORE_TIMEOUT_MS, KVSTORE_RETRIES,
+// KVSTORE_RETRY_BACKOFF_MS, KVSTORE_RETRY_MAX_BACKOFF_MS,
+// KVSTORE_BREAKER_FAILURES, KVSTORE_BREAKER_COOLDOWN_MS and
+// KVSTORE_HEDGE_DELAY_MS environment variables, defaulting to DefaultPolicy
+func PolicyFromEnv() (Policy, error) {
+	policy := DefaultPolicy
+	for name, target := range map[string]*int{
+		"KVSTORE_RETRIES":          &policy.Retries,
+		"KVSTORE_BREAKER_FAILURES": &policy.BreakerFailures,
+	} {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return policy, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*target = n
+		}
+	}
+	for name, target := range map[string]*time.Duration{
+		"KVSTORE_TIMEOUT_MS":           &policy.Timeout,
+		"KVSTORE_RETRY_BACKOFF_MS":     &policy.Backoff,
+		"KVSTORE_RETRY_MAX_BACKOFF_MS": &policy.MaxBackoff,
+		"KVSTORE_BREAKER_COOLDOWN_MS":  &policy.BreakerCooldown,
+		"KVSTORE_HEDGE_DELAY_MS":       &policy.HedgeDelay,
+	} {
+		if value := os.Getenv(name); value != "" {
+			ms, err := strconv.Atoi(value)
+			if err != nil || ms < 0 {
+				return policy, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*target = time.Duration(ms) * time.Millisecond
+		}
+	}
+	return policy, nil
+}
+
+// EndpointsFromEnv returns the kvstore endpoints listed by the
+// comma-separated KVSTORE_ENDPOINTS environment variable, or the single
+// endpoint at KVSTORE_HOST and KVSTORE_PORT
+func EndpointsFromEnv() []string {
+	var endpoints []string
+	for _, endpoint := range strings.Split(os.Getenv("KVSTORE_ENDPOINTS"), ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	if len(endpoints) == 0 {
+		endpoints = []string{os.Getenv("KVSTORE_HOST") + ":" + os.Getenv("KVSTORE_PORT")}
+	}
+	return endpoints
+}
+
+// Stats are the statistics of a connection
+type Stats struct {
+	Calls uint64 `json:"calls"`
+	// Retries counts the retried idempotent calls
+	Retries uint64 `json:"retries"`
+	// Hedges counts the idempotent calls also sent to another endpoint
+	Hedges uint64 `json:"hedges"`
+	// Rejected counts the calls failed fast by the open breaker
+	Rejected    uint64 `json:"rejected"`
+	BreakerOpen bool   `json:"breaker_open"`
+}
+
+// Conn is a connection to the kvstore applying a Policy to its calls. It
+// sends every call to its first endpoint, hedging idempotent unary calls
+// across the others.
+type Conn struct {
+	policy    Policy
+	endpoints []grpc.ClientConnInterface
+	breaker   *Breaker
+
+	calls    atomic.Uint64
+	retries  atomic.Uint64
+	hedges   atomic.Uint64
+	rejected atomic.Uint64
+}
+
+// NewConn creates a connection applying policy to the calls made to
+// endpoints, of which there must be at least one
+func NewConn(policy Policy, endpoints ...grpc.ClientConnInterface) *Conn {
+	return &Conn{
+		policy:    policy,
+		endpoints: endpoints,
+		breaker:   NewBreaker(policy.BreakerFailures, policy.BreakerCooldown),
+	}
+}
+
+// Invoke makes a unary call within the policy timeout, retrying and
+// hedging it when idempotent
+func (c *Conn) Invoke(ctx context.Context, method string, args any, reply any, opts ...grpc.CallOption) error {
+	c.calls.Add(1)
+	if c.policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.policy.Timeout)
+		defer cancel()
+	}
+
+	idempotent := c.policy.Idempotent[method]
+	for attempt := 0; ; attempt++ {
+		probe, err := c.breaker.Allow()
+		if err != nil {
+			c.rejected.Add(1)
+			return err
+		}
+		if idempotent {
+			err = c.hedge(ctx, method, args, reply, opts...)
+		} else {
+			err = c.endpoints[0].Invoke(ctx, method, args, reply, opts...)
+		}
+		c.breaker.Record(probe, err)
+		if !idempotent || attempt >= c.policy.Retries || status.Code(err) != codes.Unavailable {
+			return err
+		}
+
+		c.retries.Add(1)
+		timer := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// NewStream opens a stream on the first endpoint unless the breaker is
+// open. Streams outlive the policy timeout and are never retried.
+func (c *Conn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	c.calls.Add(1)
+	probe, err := c.breaker.Allow()
+	if err != nil {
+		c.rejected.Add(1)
+		return nil, err
+	}
+	stream, err := c.endpoints[0].NewStream(ctx, desc, method, opts...)
+	c.breaker.Record(probe, err)
+	return stream, err
+}
+
+// Stats returns the statistics of the connection
+func (c *Conn) Stats() Stats {
+	return Stats{
+		Calls:       c.calls.Load(),
+		Retries:     c.retries.Load(),
+		Hedges:      c.hedges.Load(),
+		Rejected:    c.rejected.Load(),
+		BreakerOpen: c.breaker.Open(),
+	}
+}
+
+// hedge sends a call to the first endpoint, then to the next one whenever
+// the hedge delay passes or an outstanding call fails with Unavailable. The
+// first other outcome wins and cancels the outstanding calls.
+func (c *Conn) hedge(ctx context.Context, method string, args any, reply any, opts ...grpc.CallOption) error {
+	message, ok := reply.(proto.Message)
+	if !ok || len(c.endpoints) == 1 || c.policy.HedgeDelay <= 0 {
+		return c.endpoints[0].Invoke(ctx, method, args, reply, opts...)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		reply proto.Message
+		err   error
+	}
+	results := make(chan result, len(c.endpoints))
+	sent := 0
+	send := func() {
+		endpoint := c.endpoints[sent]
+		sent++
+		out := message.ProtoReflect().New().Interface()
+		go func() {
+			results <- result{out, endpoint.Invoke(ctx, method, args, out, opts...)}
+		}()
+	}
+
+	send()
+	timer := time.NewTimer(c.policy.HedgeDelay)
+	defer timer.Stop()
+	var err error
+	for pending := 1; pending > 0; {
+		select {
+		case <-timer.C:
+			if sent < len(c.endpoints) {
+				c.hedges.Add(1)
+				send()
+				pending++
+				timer.Reset(c.policy.HedgeDelay)
+			}
+		case res := <-results:
+			pending--
+			if err = res.err; status.Code(err) != codes.Unavailable {
+				if err == nil {
+					proto.Reset(message)
+					proto.Merge(message, res.reply)
+				}
+				return err
+			}
+			if sent < len(c.endpoints) {
+				send()
+				pending++
+				timer.Reset(c.policy.HedgeDelay)
+			}
+		}
+	}
+	return err
+}
+
+// backoff returns the fully jittered delay before retry attempt
+func (c *Conn) backoff(attempt int) time.Duration {
+	delay := c.policy.Backoff << min(attempt, 30)
+	if c.policy.MaxBackoff > 0 && (delay > c.policy.MaxBackoff || delay <= 0) {
+		delay = c.policy.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return rand.N(delay)
+}
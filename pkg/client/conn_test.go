@@ -0,0 +1,166 @@
+package client
+
+import (
+	"censys/pkg/util"
+	pb "censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeEndpoint answers Get calls with value after delay, failing the first
+// calls with errs
+type fakeEndpoint struct {
+	value string
+	delay time.Duration
+	errs  []error
+	calls atomic.Int32
+}
+
+func (e *fakeEndpoint) Invoke(ctx context.Context, method string, args any, reply any, opts ...grpc.CallOption) error {
+	n := int(e.calls.Add(1)) - 1
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-time.After(e.delay):
+	}
+	if n < len(e.errs) && e.errs[n] != nil {
+		return e.errs[n]
+	}
+	if resp, ok := reply.(*pb.GetResponse); ok {
+		resp.Value = e.value
+	}
+	return nil
+}
+
+func (e *fakeEndpoint) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, status.Error(codes.Unimplemented, "streams are not supported")
+}
+
+func TestConn_Retries(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	tests := []struct {
+		name      string
+		method    string
+		errs      []error
+		wantCode  codes.Code
+		wantCalls int32
+	}{
+		{"idempotent recovers", pb.KvStoreService_Get_FullMethodName, []error{unavailable, unavailable}, codes.OK, 3},
+		{"idempotent exhausts retries", pb.KvStoreService_Get_FullMethodName, []error{unavailable, unavailable, unavailable}, codes.Unavailable, 3},
+		{"not found is final", pb.KvStoreService_Get_FullMethodName, []error{status.Error(codes.NotFound, "missing")}, codes.NotFound, 1},
+		{"non-idempotent is not retried", pb.KvStoreService_Set_FullMethodName, []error{unavailable}, codes.Unavailable, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := &fakeEndpoint{errs: tt.errs}
+			conn := NewConn(Policy{
+				Retries:    2,
+				Backoff:    time.Millisecond,
+				Idempotent: Idempotent,
+			}, endpoint)
+
+			err := conn.Invoke(context.Background(), tt.method, &pb.GetRequest{}, &pb.GetResponse{})
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Invoke() error = %v, want code %v", err, tt.wantCode)
+			}
+			if got := endpoint.calls.Load(); got != tt.wantCalls {
+				t.Errorf("Invoke() made %d calls, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestConn_Timeout(t *testing.T) {
+	conn := NewConn(Policy{Timeout: 20 * time.Millisecond}, &fakeEndpoint{delay: time.Minute})
+
+	start := time.Now()
+	err := conn.Invoke(context.Background(), pb.KvStoreService_Set_FullMethodName, &pb.SetRequest{}, &pb.SetResponse{})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Invoke() error = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Invoke() returned after %v", elapsed)
+	}
+}
+
+func TestConn_Hedge(t *testing.T) {
+	tests := []struct {
+		name       string
+		primary    *fakeEndpoint
+		wantHedges uint64
+	}{
+		{"slow primary", &fakeEndpoint{value: "primary", delay: time.Minute}, 1},
+		{"unavailable primary", &fakeEndpoint{value: "primary", errs: []error{status.Error(codes.Unavailable, "down")}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secondary := &fakeEndpoint{value: "secondary"}
+			conn := NewConn(Policy{
+				HedgeDelay: 10 * time.Millisecond,
+				Idempotent: Idempotent,
+			}, tt.primary, secondary)
+
+			var resp pb.GetResponse
+			if err := conn.Invoke(context.Background(), pb.KvStoreService_Get_FullMethodName, &pb.GetRequest{}, &resp); err != nil {
+				t.Fatalf("Invoke() error = %v", err)
+			}
+			if resp.Value != "secondary" {
+				t.Errorf("Invoke() value = %q, want secondary", resp.Value)
+			}
+			if got := conn.Stats().Hedges; got != tt.wantHedges {
+				t.Errorf("Stats().Hedges = %d, want %d", got, tt.wantHedges)
+			}
+		})
+	}
+
+	// Calls that may write are only sent to the primary
+	primary, secondary := &fakeEndpoint{}, &fakeEndpoint{}
+	conn := NewConn(Policy{HedgeDelay: time.Millisecond, Idempotent: Idempotent}, primary, secondary)
+	if err := conn.Invoke(context.Background(), pb.KvStoreService_Set_FullMethodName, &pb.SetRequest{}, &pb.SetResponse{}); err != nil {
+		t.Fatalf("Invoke() error = %v", err)
+	}
+	if secondary.calls.Load() != 0 {
+		t.Error("Invoke() hedged a non-idempotent call")
+	}
+}
+
+func TestConn_Breaker(t *testing.T) {
+	endpoint := &fakeEndpoint{errs: []error{
+		status.Error(codes.Unavailable, "down"),
+		status.Error(codes.Unavailable, "down"),
+	}}
+	conn := NewConn(Policy{
+		BreakerFailures: 2,
+		BreakerCooldown: time.Minute,
+	}, endpoint)
+
+	for range 2 {
+		conn.Invoke(context.Background(), pb.KvStoreService_Set_FullMethodName, &pb.SetRequest{}, &pb.SetResponse{})
+	}
+	err := conn.Invoke(context.Background(), pb.KvStoreService_Set_FullMethodName, &pb.SetRequest{}, &pb.SetResponse{})
+	if got := endpoint.calls.Load(); got != 2 {
+		t.Errorf("Invoke() with an open breaker reached the kvstore, %d calls", got)
+	}
+	if stats := conn.Stats(); !stats.BreakerOpen || stats.Rejected != 1 {
+		t.Errorf("Stats() = %+v, want an open breaker and 1 rejected call", stats)
+	}
+
+	// The API fails fast with a 503 suggesting when to retry
+	recorder := httptest.NewRecorder()
+	util.HandleGrpcError(recorder, err)
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("HandleGrpcError() status = %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+	if got := recorder.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("HandleGrpcError() Retry-After = %q, want 60", got)
+	}
+}
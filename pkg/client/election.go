@@ -0,0 +1,197 @@
+package client
+
+import (
+	pb "censys/proto/gen/proto"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotLeader is returned when resigning an election that is not led
+var ErrNotLeader = errors.New("not the leader")
+
+// Session holds a lease kept alive in the background until the session is
+// closed or the lease is lost
+type Session struct {
+	client pb.KvStoreServiceClient
+	lease  int64
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewSession grants a lease with the given TTL and keeps it alive
+func NewSession(ctx context.Context, client pb.KvStoreServiceClient, ttl time.Duration) (*Session, error) {
+	grant, err := client.LeaseGrant(ctx, &pb.LeaseGrantRequest{
+		TtlMs: ttl.Milliseconds(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keepAliveCtx, cancel := context.WithCancel(context.Background())
+	stream, err := client.LeaseKeepAlive(keepAliveCtx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	s := &Session{
+		client: client,
+		lease:  grant.Id,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go s.keepAlive(keepAliveCtx, stream, ttl/3)
+	return s, nil
+}
+
+// keepAlive refreshes the lease until ctx is cancelled or the lease expires
+func (s *Session) keepAlive(ctx context.Context, stream pb.KvStoreService_LeaseKeepAliveClient, interval time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := stream.Send(&pb.LeaseKeepAliveRequest{Id: s.lease}); err != nil {
+			return
+		}
+		resp, err := stream.Recv()
+		if err != nil || resp.TtlMs <= 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Lease returns the id of the session lease
+func (s *Session) Lease() int64 {
+	return s.lease
+}
+
+// Done is closed when the session ends, because it was closed or because
+// its lease was lost
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close stops keeping the lease alive and revokes it, releasing everything
+// held by the session
+func (s *Session) Close(ctx context.Context) error {
+	s.cancel()
+	<-s.done
+	_, err := s.client.LeaseRevoke(ctx, &pb.LeaseRevokeRequest{
+		Id: s.lease,
+	})
+	return err
+}
+
+// Leader is the leader of an election
+type Leader struct {
+	Candidate string
+	// Revision is when the leader was elected, usable as a fencing token
+	Revision int64
+}
+
+// Election campaigns for the leadership of a named election with the lease
+// of a session
+type Election struct {
+	session   *Session
+	name      string
+	namespace string
+
+	mu     sync.Mutex
+	leader *Leader
+}
+
+// NewElection creates an election client. An empty namespace is the
+// default namespace.
+func NewElection(session *Session, namespace string, name string) *Election {
+	return &Election{
+		session:   session,
+		name:      name,
+		namespace: namespace,
+	}
+}
+
+// Campaign blocks until the candidate is elected or ctx is done. The
+// candidate stays leader until it resigns or its session ends.
+func (e *Election) Campaign(ctx context.Context, candidate string) (Leader, error) {
+	resp, err := e.session.client.Campaign(ctx, &pb.CampaignRequest{
+		Election:  e.name,
+		Namespace: e.namespace,
+		Candidate: candidate,
+		Lease:     e.session.lease,
+	})
+	if err != nil {
+		return Leader{}, err
+	}
+
+	leader := leaderFromProto(resp.Leader)
+	e.mu.Lock()
+	e.leader = &leader
+	e.mu.Unlock()
+	return leader, nil
+}
+
+// Resign gives up leadership won by Campaign
+func (e *Election) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leader == nil {
+		return ErrNotLeader
+	}
+
+	_, err := e.session.client.Resign(ctx, &pb.ResignRequest{
+		Election:  e.name,
+		Namespace: e.namespace,
+		Revision:  e.leader.Revision,
+	})
+	if err != nil {
+		return err
+	}
+	e.leader = nil
+	return nil
+}
+
+// Observe returns a channel receiving the current leader and every change
+// of leader until ctx is done. A leader with an empty candidate means the
+// election has no leader.
+func (e *Election) Observe(ctx context.Context) (<-chan Leader, error) {
+	stream, err := e.session.client.Observe(ctx, &pb.ObserveRequest{
+		Election:  e.name,
+		Namespace: e.namespace,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	leaders := make(chan Leader)
+	go func() {
+		defer close(leaders)
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			select {
+			case leaders <- leaderFromProto(resp):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return leaders, nil
+}
+
+func leaderFromProto(leader *pb.ElectionLeader) Leader {
+	return Leader{
+		Candidate: leader.GetCandidate(),
+		Revision:  leader.GetRevision(),
+	}
+}
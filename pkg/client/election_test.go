@@ -0,0 +1,114 @@
+package client
+
+import (
+	inmemorystore "censys/internal/kvstore/inmemory"
+	"censys/internal/lease"
+	"censys/pkg/transport"
+	pb "censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestClient serves a kvstore over an in-memory connection
+func newTestClient(t *testing.T) pb.KvStoreServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	service := &transport.KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	service.Leases = lease.NewLessor(service.Release)
+
+	server := grpc.NewServer()
+	pb.RegisterKvStoreServiceServer(server, service)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewKvStoreServiceClient(conn)
+}
+
+func TestElection(t *testing.T) {
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first, err := NewSession(ctx, client, time.Second)
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	second, err := NewSession(ctx, client, time.Second)
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	defer second.Close(ctx)
+
+	leader, err := NewElection(first, "", "compaction").Campaign(ctx, "replica-1")
+	if err != nil || leader.Candidate != "replica-1" {
+		t.Fatalf("Campaign() = %v, %v", leader, err)
+	}
+
+	leaders, err := NewElection(second, "", "compaction").Observe(ctx)
+	if err != nil {
+		t.Fatalf("Observe() error = %v", err)
+	}
+	if got := <-leaders; got != leader {
+		t.Errorf("Observe() = %v, want %v", got, leader)
+	}
+
+	elected := make(chan Leader)
+	go func() {
+		next, _ := NewElection(second, "", "compaction").Campaign(ctx, "replica-2")
+		elected <- next
+	}()
+
+	// Ending the leader's session hands leadership to the waiting candidate
+	if err := first.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	next := <-elected
+	if next.Candidate != "replica-2" || next.Revision <= leader.Revision {
+		t.Errorf("Campaign() after session end = %v", next)
+	}
+	for got := range leaders {
+		if got == next {
+			return
+		}
+	}
+	t.Error("Observe() did not report the new leader")
+}
+
+func TestElection_Resign(t *testing.T) {
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, err := NewSession(ctx, client, time.Second)
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	defer session.Close(ctx)
+
+	election := NewElection(session, "", "compaction")
+	if err := election.Resign(ctx); err != ErrNotLeader {
+		t.Errorf("Resign() before Campaign error = %v, want %v", err, ErrNotLeader)
+	}
+	if _, err := election.Campaign(ctx, "replica-1"); err != nil {
+		t.Fatalf("Campaign() error = %v", err)
+	}
+	if err := election.Resign(ctx); err != nil {
+		t.Fatalf("Resign() error = %v", err)
+	}
+	if _, err := client.Leader(ctx, &pb.LeaderRequest{Election: "compaction"}); err == nil {
+		t.Error("Leader() after Resign found a leader")
+	}
+}
@@ -0,0 +1,26 @@
+package client
+
+import (
+	"censys/pkg/admission"
+	"google.golang.org/grpc/metadata"
+	"net"
+	"net/http"
+)
+
+// ForwardClientIP identifies the kvstore calls made while serving a request
+// by the IP address of the request's client, so that the kvstore applies its
+// per-client limits to the clients of a proxy such as the REST API rather
+// than to the proxy. The kvstore only trusts the identity from the proxies
+// listed in its TRUSTED_PROXIES.
+func ForwardClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		md, _ := metadata.FromOutgoingContext(r.Context())
+		md = md.Copy()
+		md.Set(admission.ClientIDHeader, host)
+		next.ServeHTTP(w, r.WithContext(metadata.NewOutgoingContext(r.Context(), md)))
+	})
+}
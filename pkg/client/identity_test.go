@@ -0,0 +1,27 @@
+package client
+
+import (
+	"censys/pkg/admission"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestForwardClientIP(t *testing.T) {
+	var got []string
+	handler := ForwardClientIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		md, _ := metadata.FromOutgoingContext(r.Context())
+		got = md.Get(admission.ClientIDHeader)
+	}))
+
+	req := httptest.NewRequest("GET", "/store/alpha", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	// Identities set by the client itself are replaced
+	req = req.WithContext(metadata.AppendToOutgoingContext(req.Context(), admission.ClientIDHeader, "forged"))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if want := []string{"203.0.113.7"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("forwarded client id = %v, want %v", got, want)
+	}
+}
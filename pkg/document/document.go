@@ -0,0 +1,197 @@
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNotJSON      = errors.New("value is not a JSON document")
+	ErrInvalidPath  = errors.New("invalid path")
+	ErrPathNotFound = errors.New("path not found")
+	ErrInvalidValue = errors.New("new value is not valid JSON")
+)
+
+// segment is one step of a path: an object member name or an array index
+type segment struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// parsePath parses a JSONPath subset made of member names and array indexes,
+// such as $.user.emails[0] or $['user']['first name']
+func parsePath(path string) ([]segment, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("%w: path must start with $", ErrInvalidPath)
+	}
+
+	var segments []segment
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : end+1]
+			if name == "" {
+				return nil, fmt.Errorf("%w: empty member name in %q", ErrInvalidPath, path)
+			}
+			segments = append(segments, segment{name: name})
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated member name in %q", ErrInvalidPath, path)
+			}
+			segments = append(segments, segment{name: rest[2:end]})
+			rest = rest[end+2:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated index in %q", ErrInvalidPath, path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("%w: invalid index %q", ErrInvalidPath, rest[1:end])
+			}
+			segments = append(segments, segment{index: index, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("%w: unexpected %q in %q", ErrInvalidPath, rest[0], path)
+		}
+	}
+	return segments, nil
+}
+
+// Get returns the JSON encoding of the value at path in a document
+func Get(doc string, path string) (string, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return "", err
+	}
+	root, err := decode(doc)
+	if err != nil {
+		return "", err
+	}
+
+	value := root
+	for _, seg := range segments {
+		var ok bool
+		if value, ok = child(value, seg); !ok {
+			return "", fmt.Errorf("%w: %s", ErrPathNotFound, path)
+		}
+	}
+	return encode(value)
+}
+
+// Set replaces the value at path in a document with the given JSON value.
+// Missing object members along the path are created, and an index equal to
+// the length of an array appends to it.
+func Set(doc string, path string, value string) (string, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return "", err
+	}
+	root, err := decode(doc)
+	if err != nil {
+		return "", err
+	}
+	newValue, err := decode(value)
+	if err != nil {
+		return "", ErrInvalidValue
+	}
+
+	root, err = setAt(root, segments, newValue, path)
+	if err != nil {
+		return "", err
+	}
+	return encode(root)
+}
+
+func setAt(node any, segments []segment, value any, path string) (any, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+	seg := segments[0]
+
+	if seg.isIndex {
+		array, ok := node.([]any)
+		if !ok || seg.index > len(array) {
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+		}
+		if seg.index == len(array) {
+			if len(segments) > 1 {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+			}
+			return append(array, value), nil
+		}
+		updated, err := setAt(array[seg.index], segments[1:], value, path)
+		if err != nil {
+			return nil, err
+		}
+		array[seg.index] = updated
+		return array, nil
+	}
+
+	if node == nil {
+		node = map[string]any{}
+	}
+	object, ok := node.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+	}
+	updated, err := setAt(object[seg.name], segments[1:], value, path)
+	if err != nil {
+		return nil, err
+	}
+	object[seg.name] = updated
+	return object, nil
+}
+
+func child(node any, seg segment) (any, bool) {
+	if seg.isIndex {
+		array, ok := node.([]any)
+		if !ok || seg.index >= len(array) {
+			return nil, false
+		}
+		return array[seg.index], true
+	}
+	object, ok := node.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	value, ok := object[seg.name]
+	return value, ok
+}
+
+// decode decodes a JSON document, keeping numbers in their original form
+func decode(doc string) (any, error) {
+	decoder := json.NewDecoder(strings.NewReader(doc))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, ErrNotJSON
+	}
+	if decoder.More() {
+		return nil, ErrNotJSON
+	}
+	return value, nil
+}
+
+func encode(value any) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
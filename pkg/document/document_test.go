@@ -0,0 +1,220 @@
+package document
+
+import (
+	"errors"
+	"testing"
+)
+
+const testDocument = `{"user":{"email":"a@b.c","emails":["a@b.c","d@e.f"],"first name":"Ada"},"count":1}`
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		path    string
+		want    string
+		wantErr error
+	}{
+		{name: "root", doc: `{"a":1}`, path: "$", want: `{"a":1}`},
+		{name: "nested member", doc: testDocument, path: "$.user.email", want: `"a@b.c"`},
+		{name: "array index", doc: testDocument, path: "$.user.emails[1]", want: `"d@e.f"`},
+		{name: "bracket member", doc: testDocument, path: "$['user']['first name']", want: `"Ada"`},
+		{name: "missing member", doc: testDocument, path: "$.user.phone", wantErr: ErrPathNotFound},
+		{name: "index out of range", doc: testDocument, path: "$.user.emails[2]", wantErr: ErrPathNotFound},
+		{name: "invalid path", doc: testDocument, path: "user.email", wantErr: ErrInvalidPath},
+		{name: "invalid index", doc: testDocument, path: "$.user.emails[-1]", wantErr: ErrInvalidPath},
+		{name: "not JSON", doc: `plain text`, path: "$", wantErr: ErrNotJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Get(tt.doc, tt.path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		path    string
+		value   string
+		want    string
+		wantErr error
+	}{
+		{name: "replace member", doc: `{"a":{"b":1}}`, path: "$.a.b", value: `2`, want: `{"a":{"b":2}}`},
+		{name: "create members", doc: `{}`, path: "$.a.b", value: `"x"`, want: `{"a":{"b":"x"}}`},
+		{name: "create document", doc: `null`, path: "$.a", value: `true`, want: `{"a":true}`},
+		{name: "replace index", doc: `{"a":[1,2]}`, path: "$.a[0]", value: `3`, want: `{"a":[3,2]}`},
+		{name: "append index", doc: `{"a":[1,2]}`, path: "$.a[2]", value: `3`, want: `{"a":[1,2,3]}`},
+		{name: "keeps number precision", doc: `{"big":12345678901234567890}`, path: "$.a", value: `1`, want: `{"a":1,"big":12345678901234567890}`},
+		{name: "index past end", doc: `{"a":[1]}`, path: "$.a[3]", value: `3`, wantErr: ErrPathNotFound},
+		{name: "member of scalar", doc: `{"a":1}`, path: "$.a.b", value: `3`, wantErr: ErrPathNotFound},
+		{name: "invalid value", doc: `{}`, path: "$.a", value: `{`, wantErr: ErrInvalidValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Set(tt.doc, tt.path, tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Set() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		{
+			name:  "add member",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+			want:  `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			name:  "add array element",
+			doc:   `{"foo":["bar","baz"]}`,
+			patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			want:  `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			name:  "append array element",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/-","value":"qux"}]`,
+			want:  `{"foo":["bar","qux"]}`,
+		},
+		{
+			name:  "remove and replace",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/baz"},{"op":"replace","path":"/foo","value":"boo"}]`,
+			want:  `{"foo":"boo"}`,
+		},
+		{
+			name:  "move",
+			doc:   `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			want:  `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			name:  "copy",
+			doc:   `{"a":{"b":1}}`,
+			patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`,
+			want:  `{"a":{"b":1},"c":{"b":2}}`,
+		},
+		{
+			name:  "escaped pointer",
+			doc:   `{"a/b":1,"m~n":2}`,
+			patch: `[{"op":"test","path":"/a~1b","value":1},{"op":"remove","path":"/m~0n"}]`,
+			want:  `{"a/b":1}`,
+		},
+		{
+			name:  "test numbers by value",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"test","path":"/a","value":1.0}]`,
+			want:  `{"a":1}`,
+		},
+		{
+			name:    "test failure",
+			doc:     `{"a":1}`,
+			patch:   `[{"op":"test","path":"/a","value":2}]`,
+			wantErr: ErrTestFailed,
+		},
+		{
+			name:    "remove missing member",
+			doc:     `{"a":1}`,
+			patch:   `[{"op":"remove","path":"/b"}]`,
+			wantErr: ErrPathNotFound,
+		},
+		{
+			name:    "add to missing parent",
+			doc:     `{"a":1}`,
+			patch:   `[{"op":"add","path":"/b/c","value":1}]`,
+			wantErr: ErrPathNotFound,
+		},
+		{
+			name:    "unknown op",
+			doc:     `{"a":1}`,
+			patch:   `[{"op":"frobnicate","path":"/a"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "move into itself",
+			doc:     `{"a":{"b":1}}`,
+			patch:   `[{"op":"move","from":"/a","path":"/a/c"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:    "not an array",
+			doc:     `{"a":1}`,
+			patch:   `{"op":"remove","path":"/a"}`,
+			wantErr: ErrInvalidPatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyPatch(tt.doc, tt.patch)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ApplyPatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ApplyPatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{
+			name:  "rfc example",
+			doc:   `{"title":"Goodbye!","author":{"givenName":"John","familyName":"Doe"},"tags":["example","sample"],"content":"This will be unchanged"}`,
+			patch: `{"title":"Hello!","phoneNumber":"+01-123-456-7890","author":{"familyName":null},"tags":["example"]}`,
+			want:  `{"author":{"givenName":"John"},"content":"This will be unchanged","phoneNumber":"+01-123-456-7890","tags":["example"],"title":"Hello!"}`,
+		},
+		{
+			name:  "replace non-object",
+			doc:   `{"a":"b"}`,
+			patch: `["c"]`,
+			want:  `["c"]`,
+		},
+		{
+			name:  "create nested object",
+			doc:   `{"a":"b"}`,
+			patch: `{"a":{"c":"d"}}`,
+			want:  `{"a":{"c":"d"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch(tt.doc, tt.patch)
+			if err != nil {
+				t.Fatalf("MergePatch() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("MergePatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package document
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidPatch = errors.New("invalid patch")
+	ErrTestFailed   = errors.New("patch test operation failed")
+)
+
+// operation is a single RFC 6902 JSON Patch operation
+type operation struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// ApplyPatch applies an RFC 6902 JSON Patch to a document. Operations are
+// applied in order and the document is left untouched if any of them fails.
+func ApplyPatch(doc string, patch string) (string, error) {
+	var ops []operation
+	if err := json.Unmarshal([]byte(patch), &ops); err != nil {
+		return "", fmt.Errorf("%w: patch must be an array of operations", ErrInvalidPatch)
+	}
+	root, err := decode(doc)
+	if err != nil {
+		return "", err
+	}
+
+	for i, op := range ops {
+		if root, err = applyOperation(root, op); err != nil {
+			return "", fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return encode(root)
+}
+
+func applyOperation(root any, op operation) (any, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("%w: missing path", ErrInvalidPatch)
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", ErrInvalidPatch)
+		}
+		if value, err = decode(string(*op.Value)); err != nil {
+			return nil, fmt.Errorf("%w: invalid value", ErrInvalidPatch)
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: missing from", ErrInvalidPatch)
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = lookup(root, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("%w: cannot move a value into itself", ErrInvalidPatch)
+			}
+			if root, err = remove(root, from); err != nil {
+				return nil, err
+			}
+		} else {
+			// Copy through the encoding so the two locations do not share containers
+			encoded, _ := encode(value)
+			value, _ = decode(encoded)
+		}
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return add(root, path, value)
+	case "remove":
+		return remove(root, path)
+	case "replace":
+		if _, err := lookup(root, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		if root, err = remove(root, path); err != nil {
+			return nil, err
+		}
+		return add(root, path, value)
+	case "test":
+		current, err := lookup(root, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(current, value) {
+			return nil, fmt.Errorf("%w at %s", ErrTestFailed, *op.Path)
+		}
+		return root, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, op.Op)
+	}
+}
+
+// MergePatch applies an RFC 7386 JSON Merge Patch to a document
+func MergePatch(doc string, patch string) (string, error) {
+	root, err := decode(doc)
+	if err != nil {
+		return "", err
+	}
+	mergeValue, err := decode(patch)
+	if err != nil {
+		return "", fmt.Errorf("%w: merge patch is not valid JSON", ErrInvalidPatch)
+	}
+	return encode(merge(root, mergeValue))
+}
+
+func merge(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = merge(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// parsePointer parses an RFC 6901 JSON Pointer into its reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: pointer %q must start with /", ErrInvalidPatch, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func lookup(node any, tokens []string) (any, error) {
+	for _, token := range tokens {
+		switch container := node.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: /%s", ErrPathNotFound, strings.Join(tokens, "/"))
+			}
+			node = value
+		case []any:
+			index, err := arrayIndex(token, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = container[index]
+		default:
+			return nil, fmt.Errorf("%w: /%s", ErrPathNotFound, strings.Join(tokens, "/"))
+		}
+	}
+	return node, nil
+}
+
+func add(root any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return update(root, tokens, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			c[token] = value
+			return c, nil
+		case []any:
+			index := len(c)
+			if token != "-" {
+				var err error
+				if index, err = arrayIndex(token, len(c)); err != nil {
+					return nil, err
+				}
+			}
+			c = append(c, nil)
+			copy(c[index+1:], c[index:])
+			c[index] = value
+			return c, nil
+		default:
+			return nil, fmt.Errorf("%w: /%s", ErrPathNotFound, strings.Join(tokens, "/"))
+		}
+	})
+}
+
+func remove(root any, tokens []string) (any, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w: cannot remove the whole document", ErrInvalidPatch)
+	}
+	return update(root, tokens, func(container any, token string) (any, error) {
+		switch c := container.(type) {
+		case map[string]any:
+			if _, ok := c[token]; !ok {
+				return nil, fmt.Errorf("%w: /%s", ErrPathNotFound, strings.Join(tokens, "/"))
+			}
+			delete(c, token)
+			return c, nil
+		case []any:
+			index, err := arrayIndex(token, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:index], c[index+1:]...), nil
+		default:
+			return nil, fmt.Errorf("%w: /%s", ErrPathNotFound, strings.Join(tokens, "/"))
+		}
+	})
+}
+
+// update walks to the container holding the last token and replaces it with
+// the result of fn, so that arrays can grow or shrink
+func update(node any, tokens []string, fn func(container any, token string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return fn(node, tokens[0])
+	}
+	switch container := node.(type) {
+	case map[string]any:
+		child, ok := container[tokens[0]]
+		if !ok {
+			return nil, fmt.Errorf("%w: /%s", ErrPathNotFound, strings.Join(tokens, "/"))
+		}
+		updated, err := update(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		container[tokens[0]] = updated
+		return container, nil
+	case []any:
+		index, err := arrayIndex(tokens[0], len(container)-1)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := update(container[index], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		container[index] = updated
+		return container, nil
+	default:
+		return nil, fmt.Errorf("%w: /%s", ErrPathNotFound, strings.Join(tokens, "/"))
+	}
+}
+
+// arrayIndex parses an array index token no greater than max
+func arrayIndex(token string, max int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+	if index > max {
+		return 0, fmt.Errorf("%w: array index %d", ErrPathNotFound, index)
+	}
+	return index, nil
+}
+
+func isPrefix(prefix []string, tokens []string) bool {
+	if len(prefix) > len(tokens) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != tokens[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// equal compares two decoded documents, treating numbers by value
+func equal(a any, b any) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func normalize(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return v.String()
+		}
+		return f
+	case map[string]any:
+		normalized := make(map[string]any, len(v))
+		for name, child := range v {
+			normalized[name] = normalize(child)
+		}
+		return normalized
+	case []any:
+		normalized := make([]any, len(v))
+		for i, child := range v {
+			normalized[i] = normalize(child)
+		}
+		return normalized
+	default:
+		return value
+	}
+}
@@ -0,0 +1,411 @@
+package gateway
+
+import (
+	"bytes"
+	"censys/pkg/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// MetadataHeaderPrefix prefixes the HTTP headers forwarded to the backend as
+// gRPC metadata, such as Grpc-Metadata-X-Client-Id
+const MetadataHeaderPrefix = "Grpc-Metadata-"
+
+// StreamContentType is the media type of streaming responses, which hold one
+// JSON message per line
+const StreamContentType = "application/x-ndjson"
+
+var (
+	marshal   = protojson.MarshalOptions{EmitUnpopulated: true}
+	unmarshal = protojson.UnmarshalOptions{}
+)
+
+// Route is an HTTP binding of an RPC
+type Route struct {
+	// Pattern is the http.ServeMux pattern of the route, such as
+	// "GET /keys/{key}"
+	Pattern string
+	// Method is the full name of the RPC, such as "/KvStoreService/Get"
+	Method string
+}
+
+// Gateway exposes RPCs of gRPC services as JSON over HTTP. RPCs are bound to
+// the routes of their google.api.http annotation, and streaming RPCs read
+// and write newline-delimited JSON messages.
+type Gateway struct {
+	conn   grpc.ClientConnInterface
+	mux    *http.ServeMux
+	routes []Route
+}
+
+// New creates a gateway calling RPCs of services over conn. Only the
+// methods named by allowed, such as "/KvStoreService/Get", are exposed, so
+// that adding an RPC does not expose it, and every one of them must have a
+// google.api.http annotation.
+func New(conn grpc.ClientConnInterface, allowed []string, services ...protoreflect.ServiceDescriptor) (*Gateway, error) {
+	g := &Gateway{
+		conn: conn,
+		mux:  http.NewServeMux(),
+	}
+	unknown := make(map[string]bool, len(allowed))
+	for _, method := range allowed {
+		unknown[method] = true
+	}
+	for _, service := range services {
+		methods := service.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			method := methods.Get(i)
+			fullMethod := fmt.Sprintf("/%s/%s", service.FullName(), method.Name())
+			if !unknown[fullMethod] {
+				continue
+			}
+			delete(unknown, fullMethod)
+			if err := g.register(method, fullMethod); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for method := range unknown {
+		return nil, fmt.Errorf("%s is not a method of the services", method)
+	}
+	return g, nil
+}
+
+// Routes returns the routes of the gateway in registration order
+func (g *Gateway) Routes() []Route {
+	return g.routes
+}
+
+// ServeHTTP serves the RPC bound to the request route
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// register adds the routes of a method
+func (g *Gateway) register(method protoreflect.MethodDescriptor, fullMethod string) error {
+	rule, _ := proto.GetExtension(method.Options(), annotations.E_Http).(*annotations.HttpRule)
+	if rule == nil || rule.GetPattern() == nil {
+		return fmt.Errorf("%s has no google.api.http annotation", fullMethod)
+	}
+
+	for _, binding := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+		b, err := newBinding(method, fullMethod, binding)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fullMethod, err)
+		}
+		g.mux.HandleFunc(b.pattern, func(w http.ResponseWriter, r *http.Request) {
+			g.serve(w, r, b)
+		})
+		g.routes = append(g.routes, Route{Pattern: b.pattern, Method: fullMethod})
+	}
+	return nil
+}
+
+// binding is a route bound to an RPC
+type binding struct {
+	method     protoreflect.MethodDescriptor
+	fullMethod string
+	pattern    string
+	// pathFields are the request fields bound to path variables
+	pathFields []protoreflect.FieldDescriptor
+	// body is the request field bound to the body, nil if the body is not
+	// bound and the request itself if all unbound fields are
+	body         protoreflect.FieldDescriptor
+	bodyAll      bool
+	responseBody protoreflect.FieldDescriptor
+	input        protoreflect.MessageType
+	output       protoreflect.MessageType
+}
+
+// pathVariable matches the variables of a path template
+var pathVariable = regexp.MustCompile(`\{([^}]*)\}`)
+
+func newBinding(method protoreflect.MethodDescriptor, fullMethod string, rule *annotations.HttpRule) (*binding, error) {
+	var verb, path string
+	switch pattern := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		verb, path = http.MethodGet, pattern.Get
+	case *annotations.HttpRule_Put:
+		verb, path = http.MethodPut, pattern.Put
+	case *annotations.HttpRule_Post:
+		verb, path = http.MethodPost, pattern.Post
+	case *annotations.HttpRule_Delete:
+		verb, path = http.MethodDelete, pattern.Delete
+	case *annotations.HttpRule_Patch:
+		verb, path = http.MethodPatch, pattern.Patch
+	case *annotations.HttpRule_Custom:
+		verb, path = pattern.Custom.GetKind(), pattern.Custom.GetPath()
+	default:
+		return nil, errors.New("http rule has no pattern")
+	}
+
+	b := &binding{
+		method:     method,
+		fullMethod: fullMethod,
+		pattern:    verb + " " + path,
+		input:      messageType(method.Input()),
+		output:     messageType(method.Output()),
+	}
+
+	fields := method.Input().Fields()
+	for _, match := range pathVariable.FindAllStringSubmatch(path, -1) {
+		field := fields.ByName(protoreflect.Name(match[1]))
+		if field == nil || field.IsList() || field.IsMap() || field.Message() != nil {
+			return nil, fmt.Errorf("path variable %q is not a scalar field of %s", match[1], method.Input().FullName())
+		}
+		b.pathFields = append(b.pathFields, field)
+	}
+
+	switch body := rule.GetBody(); body {
+	case "":
+	case "*":
+		b.bodyAll = true
+	default:
+		if b.body = fields.ByName(protoreflect.Name(body)); b.body == nil {
+			return nil, fmt.Errorf("body %q is not a field of %s", body, method.Input().FullName())
+		}
+	}
+	if name := rule.GetResponseBody(); name != "" {
+		if b.responseBody = method.Output().Fields().ByName(protoreflect.Name(name)); b.responseBody == nil {
+			return nil, fmt.Errorf("response body %q is not a field of %s", name, method.Output().FullName())
+		}
+	}
+	return b, nil
+}
+
+// messageType returns the generated type of a message, falling back to a
+// dynamic type for messages without generated code
+func messageType(desc protoreflect.MessageDescriptor) protoreflect.MessageType {
+	if mt, err := protoregistry.GlobalTypes.FindMessageByName(desc.FullName()); err == nil {
+		return mt
+	}
+	return dynamicpb.NewMessageType(desc)
+}
+
+// serve calls the RPC of a binding
+func (g *Gateway) serve(w http.ResponseWriter, r *http.Request, b *binding) {
+	ctx := metadata.NewOutgoingContext(r.Context(), outgoingMetadata(r))
+
+	if !b.method.IsStreamingClient() && !b.method.IsStreamingServer() {
+		req, err := b.decodeRequest(r, r.Body)
+		if err != nil {
+			writeRequestError(w, err)
+			return
+		}
+		resp := b.output.New().Interface()
+		if err := g.conn.Invoke(ctx, b.fullMethod, req, resp); util.HandleGrpcError(w, err) {
+			return
+		}
+		data, err := b.encodeResponse(resp)
+		if err != nil {
+			util.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+
+	g.serveStream(ctx, w, r, b)
+}
+
+// outgoingMetadata forwards the request id and the headers prefixed with
+// MetadataHeaderPrefix as gRPC metadata, along with the outgoing metadata of
+// the request context
+func outgoingMetadata(r *http.Request) metadata.MD {
+	md := metadata.MD{}
+	for name, values := range r.Header {
+		if key, ok := strings.CutPrefix(name, MetadataHeaderPrefix); ok {
+			md.Append(strings.ToLower(key), values...)
+		}
+	}
+	if id := r.Header.Get(util.RequestIDHeader); id != "" {
+		md.Set("x-request-id", id)
+	}
+	// Metadata set by the server, such as the identity of the client,
+	// overrides the headers of the client
+	if set, ok := metadata.FromOutgoingContext(r.Context()); ok {
+		for key, values := range set {
+			md.Set(key, values...)
+		}
+	}
+	return md
+}
+
+// decodeRequest builds the request message from the body read from body,
+// the path variables and the query parameters of r
+func (b *binding) decodeRequest(r *http.Request, body io.Reader) (proto.Message, error) {
+	req := b.input.New().Interface()
+
+	if b.bodyAll || b.body != nil {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.decodeBody(req, data); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.applyPath(req, r); err != nil {
+		return nil, err
+	}
+	if !b.bodyAll {
+		if err := b.applyQuery(req, r); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// decodeBody unmarshals JSON into the request or into its body field
+func (b *binding) decodeBody(req proto.Message, data []byte) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	if b.body != nil {
+		// Decode the field as a one-field request so protojson handles its kind
+		wrapped, err := json.Marshal(map[string]json.RawMessage{b.body.JSONName(): data})
+		if err != nil {
+			return &malformedError{err}
+		}
+		data = wrapped
+	}
+	if err := unmarshal.Unmarshal(data, req); err != nil {
+		return &malformedError{err}
+	}
+	return nil
+}
+
+// applyPath sets the request fields bound to path variables
+func (b *binding) applyPath(req proto.Message, r *http.Request) error {
+	msg := req.ProtoReflect()
+	for _, field := range b.pathFields {
+		value, err := parseScalar(field, r.PathValue(string(field.Name())))
+		if err != nil {
+			return err
+		}
+		msg.Set(field, value)
+	}
+	return nil
+}
+
+// applyQuery sets the request fields named by query parameters
+func (b *binding) applyQuery(req proto.Message, r *http.Request) error {
+	msg := req.ProtoReflect()
+	fields := msg.Descriptor().Fields()
+	for name, values := range r.URL.Query() {
+		field := fields.ByName(protoreflect.Name(name))
+		if field == nil {
+			field = fields.ByJSONName(name)
+		}
+		if field == nil || field == b.body || field.IsMap() || field.Message() != nil {
+			return &invalidError{fmt.Sprintf("unknown query parameter %q", name)}
+		}
+
+		if field.IsList() {
+			list := msg.Mutable(field).List()
+			for _, value := range values {
+				v, err := parseScalar(field, value)
+				if err != nil {
+					return err
+				}
+				list.Append(v)
+			}
+			continue
+		}
+		v, err := parseScalar(field, values[len(values)-1])
+		if err != nil {
+			return err
+		}
+		msg.Set(field, v)
+	}
+	return nil
+}
+
+// parseScalar parses the string form of a scalar field value, as it appears
+// in paths and query strings
+func parseScalar(field protoreflect.FieldDescriptor, value string) (protoreflect.Value, error) {
+	if field.Kind() == protoreflect.StringKind {
+		return protoreflect.ValueOfString(value), nil
+	}
+
+	// Reuse protojson parsing by decoding a one-field message, quoting the
+	// value as protojson accepts quoted numbers, booleans and enum names
+	quoted, _ := json.Marshal(value)
+	if field.Kind() == protoreflect.BoolKind {
+		quoted = []byte(value)
+	}
+	data := fmt.Sprintf(`{%q:%s}`, field.JSONName(), quoted)
+	if field.IsList() {
+		data = fmt.Sprintf(`{%q:[%s]}`, field.JSONName(), quoted)
+	}
+
+	msg := dynamicpb.NewMessage(field.ContainingMessage())
+	if err := unmarshal.Unmarshal([]byte(data), msg); err != nil {
+		return protoreflect.Value{}, &invalidError{fmt.Sprintf("invalid %s %q", field.Name(), value)}
+	}
+	if field.IsList() {
+		return msg.Get(field).List().Get(0), nil
+	}
+	return msg.Get(field), nil
+}
+
+// encodeResponse marshals a response message or its response body field
+func (b *binding) encodeResponse(resp proto.Message) ([]byte, error) {
+	data, err := marshal.Marshal(resp)
+	if err != nil || b.responseBody == nil {
+		return data, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields[b.responseBody.JSONName()], nil
+}
+
+// malformedError is returned for request bodies that are not valid JSON for
+// the request message
+type malformedError struct {
+	err error
+}
+
+func (e *malformedError) Error() string {
+	return "Failed to decode request: " + e.err.Error()
+}
+
+// invalidError is returned for invalid path variables and query parameters
+type invalidError struct {
+	message string
+}
+
+func (e *invalidError) Error() string {
+	return e.message
+}
+
+// writeRequestError writes the error response of an invalid request
+func writeRequestError(w http.ResponseWriter, err error) {
+	var malformed *malformedError
+	if errors.As(err, &malformed) {
+		util.WriteProblem(w, util.Problem{
+			Status: http.StatusBadRequest,
+			Code:   util.CodeMalformedRequest,
+			Detail: err.Error(),
+		})
+		return
+	}
+	util.Error(w, err.Error(), http.StatusBadRequest)
+}
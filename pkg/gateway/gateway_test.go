@@ -0,0 +1,228 @@
+package gateway
+
+import (
+	"bufio"
+	inmemorystore "censys/internal/kvstore/inmemory"
+	"censys/internal/lease"
+	"censys/pkg/transport"
+	pb "censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMethods are the methods exposed by the test gateway
+var testMethods = []string{
+	pb.KvStoreService_Get_FullMethodName,
+	pb.KvStoreService_Set_FullMethodName,
+	pb.KvStoreService_ListKeys_FullMethodName,
+	pb.KvStoreService_LeaseGrant_FullMethodName,
+	pb.KvStoreService_LeaseKeepAlive_FullMethodName,
+	pb.KvStoreService_Campaign_FullMethodName,
+	pb.KvStoreService_Observe_FullMethodName,
+}
+
+// newTestGateway serves a kvstore over an in-memory connection through a
+// gateway
+func newTestGateway(t *testing.T) *Gateway {
+	listener := bufconn.Listen(1 << 20)
+	service := &transport.KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	service.Leases = lease.NewLessor(service.Release)
+
+	server := grpc.NewServer()
+	pb.RegisterKvStoreServiceServer(server, service)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	gw, err := New(conn, testMethods, pb.File_proto_kvstore_proto.Services().Get(0))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return gw
+}
+
+func TestRoutes(t *testing.T) {
+	gw := newTestGateway(t)
+
+	bound := make(map[string]bool)
+	for _, route := range gw.Routes() {
+		bound[route.Method] = true
+	}
+	for _, method := range testMethods {
+		if !bound[method] {
+			t.Errorf("no route for %s", method)
+		}
+		delete(bound, method)
+	}
+	for method := range bound {
+		t.Errorf("route for %s, which is not allowed", method)
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		wantErr string
+	}{
+		{"unannotated method", []string{pb.KvStoreService_TakeToken_FullMethodName}, "has no google.api.http annotation"},
+		{"unknown method", []string{"/KvStoreService/Bogus"}, "is not a method of the services"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(nil, tt.allowed, pb.File_proto_kvstore_proto.Services().Get(0))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("New() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnary(t *testing.T) {
+	gw := newTestGateway(t)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+		want   string
+	}{
+		{"set with path and body", "PUT", "/keys/alpha", `{"value":"1"}`, http.StatusOK, `"success":true`},
+		{"set in namespace", "PUT", "/keys/beta", `{"value":"2","namespace":""}`, http.StatusOK, `"success":true`},
+		{"get", "GET", "/keys/alpha", "", http.StatusOK, `"value":"1"`},
+		{"get missing", "GET", "/keys/missing", "", http.StatusNotFound, `"code":"not_found"`},
+		{"list with query", "GET", "/keys?prefix=b&limit=1", "", http.StatusOK, `"keys":["beta"]`},
+		{"unknown query parameter", "GET", "/keys?bogus=1", "", http.StatusBadRequest, `unknown query parameter`},
+		{"invalid query parameter", "GET", "/keys?limit=many", "", http.StatusBadRequest, `invalid limit`},
+		{"malformed body", "PUT", "/keys/alpha", `{"value":`, http.StatusBadRequest, `"code":"malformed_request"`},
+		{"unknown body field", "PUT", "/keys/alpha", `{"bogus":"1"}`, http.StatusBadRequest, `"code":"malformed_request"`},
+		{"method not allowed", "DELETE", "/keys/alpha", "", http.StatusMethodNotAllowed, ""},
+		{"no default route", "POST", "/KvStoreService/TakeToken", `{"key":"bucket","rate":1,"burst":2}`, http.StatusNotFound, ""},
+		{"unknown route", "GET", "/bogus", "", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			gw.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("body = %s, want it to contain %s", w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	gw := newTestGateway(t)
+
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, httptest.NewRequest("POST", "/leases", strings.NewReader(`{"ttlMs":"60000"}`)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id":"1"`) {
+		t.Fatalf("LeaseGrant = %d %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   []string
+	}{
+		{"one request per line", "{\"id\":\"1\"}\n\n{\"id\":\"1\"}\n", http.StatusOK, []string{`"ttlMs":"60000"`, `"ttlMs":"60000"`}},
+		{"invalid line", "{\"id\":\n", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			gw.ServeHTTP(w, httptest.NewRequest("POST", "/leases/keepalive", strings.NewReader(tt.body)))
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.want == nil {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != StreamContentType {
+				t.Errorf("Content-Type = %q, want %q", got, StreamContentType)
+			}
+			lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+			if len(lines) != len(tt.want) {
+				t.Fatalf("got %d lines, want %d: %s", len(lines), len(tt.want), w.Body)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(lines[i], want) {
+					t.Errorf("line %d = %s, want it to contain %s", i, lines[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	server := httptest.NewServer(newTestGateway(t))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/elections/compaction/observe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Observe error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	// The first leader is sent while the stream stays open
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading stream error = %v", err)
+	}
+	if !strings.Contains(line, `"candidate":""`) {
+		t.Errorf("line = %s, want an empty leader", line)
+	}
+}
+
+func TestOutgoingMetadata(t *testing.T) {
+	req := httptest.NewRequest("GET", "/keys/alpha", nil)
+	req.Header.Set("Grpc-Metadata-X-Client-Id", "forged")
+	req.Header.Set("Grpc-Metadata-X-Tenant", "blue")
+	req = req.WithContext(metadata.AppendToOutgoingContext(req.Context(), "x-client-id", "203.0.113.7"))
+
+	md := outgoingMetadata(req)
+	if got := md.Get("x-client-id"); len(got) != 1 || got[0] != "203.0.113.7" {
+		t.Errorf("x-client-id = %v, want the one set by the server", got)
+	}
+	if got := md.Get("x-tenant"); len(got) != 1 || got[0] != "blue" {
+		t.Errorf("x-tenant = %v, want the forwarded header", got)
+	}
+}
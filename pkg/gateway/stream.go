@@ -0,0 +1,149 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"censys/pkg/util"
+	"context"
+	"encoding/json"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+)
+
+// maxStreamMessage is the maximum length of a streamed request line
+const maxStreamMessage = 4 << 20
+
+// serveStream calls a streaming RPC. Client streams read one request per
+// line of the body, and server streams write one response per line,
+// flushed as it is received.
+func (g *Gateway) serveStream(ctx context.Context, w http.ResponseWriter, r *http.Request, b *binding) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := g.conn.NewStream(ctx, &grpc.StreamDesc{
+		StreamName:    string(b.method.Name()),
+		ServerStreams: b.method.IsStreamingServer(),
+		ClientStreams: b.method.IsStreamingClient(),
+	}, b.fullMethod)
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+
+	invalid := make(chan error, 1)
+	if b.method.IsStreamingClient() {
+		// Responses are written while the body is still being read
+		http.NewResponseController(w).EnableFullDuplex()
+		go func() {
+			if err := sendRequests(r, b, stream); err != nil {
+				invalid <- err
+				cancel()
+			}
+		}()
+	} else {
+		req, err := b.decodeRequest(r, r.Body)
+		if err != nil {
+			writeRequestError(w, err)
+			return
+		}
+		if err := stream.SendMsg(req); err != nil && !errors.Is(err, io.EOF) {
+			util.HandleGrpcError(w, err)
+			return
+		}
+		if err := stream.CloseSend(); util.HandleGrpcError(w, err) {
+			return
+		}
+	}
+
+	flusher := http.NewResponseController(w)
+	started := false
+	for {
+		resp := b.output.New().Interface()
+		err := stream.RecvMsg(resp)
+		if errors.Is(err, io.EOF) {
+			if !started {
+				w.Header().Set("Content-Type", StreamContentType)
+				w.WriteHeader(http.StatusOK)
+			}
+			return
+		}
+		if err != nil {
+			select {
+			case reqErr := <-invalid:
+				err = status.Error(codes.InvalidArgument, reqErr.Error())
+			default:
+			}
+			if !started {
+				util.HandleGrpcError(w, err)
+				return
+			}
+			writeStreamError(w, err)
+			return
+		}
+
+		data, err := b.encodeResponse(resp)
+		if err != nil {
+			if !started {
+				util.Error(w, "Failed to encode response", http.StatusInternalServerError)
+				return
+			}
+			writeStreamError(w, err)
+			return
+		}
+		if !started {
+			w.Header().Set("Content-Type", StreamContentType)
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		w.Write(append(data, '\n'))
+		flusher.Flush()
+	}
+}
+
+// sendRequests sends each line of the request body on a client stream. It
+// returns an error when a line is not a valid request.
+func sendRequests(r *http.Request, b *binding, stream grpc.ClientStream) error {
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(nil, maxStreamMessage)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		req := b.input.New().Interface()
+		if err := b.decodeBody(req, line); err != nil {
+			return err
+		}
+		if err := b.applyPath(req, r); err != nil {
+			return err
+		}
+		if err := stream.SendMsg(req); err != nil {
+			// The error is returned by RecvMsg
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return stream.CloseSend()
+}
+
+// streamError is the last line written when a stream fails after its
+// first response
+type streamError struct {
+	Error util.Problem `json:"error"`
+}
+
+// writeStreamError writes the error ending a stream as a final line
+func writeStreamError(w http.ResponseWriter, err error) {
+	problem := util.GrpcProblem(err)
+	problem.Type = "about:blank"
+	problem.Title = http.StatusText(problem.Status)
+	problem.RequestID = w.Header().Get(util.RequestIDHeader)
+
+	data, _ := json.Marshal(streamError{Error: problem})
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,380 @@
+package nearcache
+
+import (
+	"censys/internal/kvstore"
+	pb "censys/proto/gen/proto"
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Config configures a cache. Zero values disable the matching limit.
+type Config struct {
+	// MaxEntries is the maximum number of cached keys
+	MaxEntries int
+	// MaxBytes is the maximum total size of the cached keys and values
+	MaxBytes int
+	// TTL is how long a value is cached at most. Values are cached until
+	// invalidated or evicted when 0.
+	TTL time.Duration
+}
+
+// ConfigFromEnv reads the NEAR_CACHE_SIZE, NEAR_CACHE_MAX_BYTES and
+// NEAR_CACHE_TTL_MS environment variables
+func ConfigFromEnv() (Config, error) {
+	var config Config
+	for name, target := range map[string]*int{
+		"NEAR_CACHE_SIZE":      &config.MaxEntries,
+		"NEAR_CACHE_MAX_BYTES": &config.MaxBytes,
+	} {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return config, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*target = n
+		}
+	}
+	if value := os.Getenv("NEAR_CACHE_TTL_MS"); value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms < 0 {
+			return config, fmt.Errorf("invalid NEAR_CACHE_TTL_MS %q", value)
+		}
+		config.TTL = time.Duration(ms) * time.Millisecond
+	}
+	return config, nil
+}
+
+// Enabled reports whether the config bounds the size of a cache, which
+// caching requires
+func (c Config) Enabled() bool {
+	return c.MaxEntries > 0 || c.MaxBytes > 0
+}
+
+// Stats are the statistics of a cache
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	// Evictions counts the values dropped to stay within the size limits
+	Evictions uint64 `json:"evictions"`
+	// Expirations counts the values dropped once their TTL passed
+	Expirations uint64 `json:"expirations"`
+	// Invalidations counts the invalidations received from the kvstore
+	Invalidations uint64 `json:"invalidations"`
+	// Resets counts the times every value was dropped, such as when the
+	// invalidation stream reconnects
+	Resets  uint64 `json:"resets"`
+	Entries int    `json:"entries"`
+	Bytes   int    `json:"bytes"`
+}
+
+// Cache is a near-cache of kvstore values, kept coherent with the kvstore
+// by its invalidation stream. Values are only cached while the stream is
+// connected, so a cache that may have missed invalidations is empty.
+type Cache struct {
+	config Config
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[entryKey]*list.Element
+	// lru orders entries from the most to the least recently used
+	lru     *list.List
+	bytes   int
+	fetches map[entryKey]*fetch
+	live    bool
+	stats   Stats
+	// onInvalidation is called with the invalidations of the kvstore
+	onInvalidation func(namespace string, key string)
+}
+
+type entryKey struct {
+	namespace string
+	key       string
+}
+
+type entry struct {
+	key     entryKey
+	value   string
+	expires time.Time
+}
+
+func (e *entry) size() int {
+	return len(e.key.key) + len(e.value)
+}
+
+// fetch tracks the loads of a key in flight. A stale fetch was invalidated
+// while in flight, so the value it loaded is not cached.
+type fetch struct {
+	refs  int
+	stale bool
+}
+
+// New creates a cache. It caches nothing until Watch receives the
+// invalidation stream.
+func New(config Config) *Cache {
+	return &Cache{
+		config:  config,
+		now:     time.Now,
+		entries: make(map[entryKey]*list.Element),
+		lru:     list.New(),
+		fetches: make(map[entryKey]*fetch),
+	}
+}
+
+// Get returns the cached value of a key, or loads and caches it on a miss.
+// Load errors are returned and not cached.
+func (c *Cache) Get(ctx context.Context, namespace string, key string, load func(ctx context.Context) (string, error)) (string, error) {
+	k := entryKey{canonicalNamespace(namespace), key}
+
+	c.mu.Lock()
+	if value, ok := c.lookup(k); ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return value, nil
+	}
+	c.stats.Misses++
+	f := c.begin(k)
+	c.mu.Unlock()
+
+	value, err := load(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f != nil {
+		c.end(k, f)
+		if err == nil && !f.stale {
+			c.store(k, value)
+		}
+	}
+	return value, err
+}
+
+// Invalidate drops the cached value of a key, or of every key of the
+// namespace when key is empty
+func (c *Cache) Invalidate(namespace string, key string) {
+	namespace = canonicalNamespace(namespace)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key != "" {
+		k := entryKey{namespace, key}
+		if el, ok := c.entries[k]; ok {
+			c.remove(el)
+		}
+		if f, ok := c.fetches[k]; ok {
+			f.stale = true
+		}
+		return
+	}
+
+	for k, el := range c.entries {
+		if k.namespace == namespace {
+			c.remove(el)
+		}
+	}
+	for k, f := range c.fetches {
+		if k.namespace == namespace {
+			f.stale = true
+		}
+	}
+}
+
+// Reset drops every cached value
+func (c *Cache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+}
+
+// Stats returns the statistics of the cache
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	stats.Bytes = c.bytes
+	return stats
+}
+
+// OnInvalidation registers fn to be called with every invalidation received
+// from the kvstore once the cache applied it, so that reads coalesced
+// outside the cache are invalidated as well. The key is empty when a whole
+// namespace is invalidated, and the namespace as well when every value is.
+func (c *Cache) OnInvalidation(fn func(namespace string, key string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onInvalidation = fn
+}
+
+// Watch applies the invalidation stream of the kvstore to the cache until
+// ctx is done, reconnecting when the stream fails. Values are cached while
+// the stream is connected.
+func (c *Cache) Watch(ctx context.Context, client pb.KvStoreServiceClient) {
+	const (
+		minBackoff = 100 * time.Millisecond
+		maxBackoff = 5 * time.Second
+	)
+
+	backoff := minBackoff
+	for {
+		connected, err := c.watch(ctx, client)
+		c.setLive(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = minBackoff
+		}
+		log.Printf("Cache invalidation stream failed, retrying in %s: %s", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// watch applies the invalidations of one stream until it fails, reporting
+// whether it connected
+func (c *Cache) watch(ctx context.Context, client pb.KvStoreServiceClient) (bool, error) {
+	stream, err := client.WatchInvalidations(ctx, &pb.WatchInvalidationsRequest{})
+	if err != nil {
+		return false, err
+	}
+
+	connected := false
+	for {
+		invalidation, err := stream.Recv()
+		if err != nil {
+			return connected, err
+		}
+
+		// The stream starts with a reset once invalidations are delivered
+		if invalidation.GetReset_() {
+			c.mu.Lock()
+			c.reset()
+			c.live = true
+			onInvalidation := c.onInvalidation
+			c.mu.Unlock()
+			if onInvalidation != nil {
+				onInvalidation("", "")
+			}
+			connected = true
+			continue
+		}
+		c.mu.Lock()
+		c.stats.Invalidations++
+		onInvalidation := c.onInvalidation
+		c.mu.Unlock()
+		c.Invalidate(invalidation.GetNamespace(), invalidation.GetKey())
+		if onInvalidation != nil {
+			onInvalidation(invalidation.GetNamespace(), invalidation.GetKey())
+		}
+	}
+}
+
+// setLive enables or disables caching, dropping every value when disabled
+func (c *Cache) setLive(live bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.live = live
+	if !live {
+		c.reset()
+	}
+}
+
+// lookup returns the unexpired value of a key. The caller must hold c.mu.
+func (c *Cache) lookup(k entryKey) (string, bool) {
+	el, ok := c.entries[k]
+	if !ok {
+		return "", false
+	}
+	e := el.Value.(*entry)
+	if !e.expires.IsZero() && !c.now().Before(e.expires) {
+		c.remove(el)
+		c.stats.Expirations++
+		return "", false
+	}
+	c.lru.MoveToFront(el)
+	return e.value, true
+}
+
+// begin tracks a load of a key, returning nil when values are not cached.
+// The caller must hold c.mu.
+func (c *Cache) begin(k entryKey) *fetch {
+	if !c.live {
+		return nil
+	}
+	f, ok := c.fetches[k]
+	if !ok {
+		f = &fetch{}
+		c.fetches[k] = f
+	}
+	f.refs++
+	return f
+}
+
+// end stops tracking a load. The caller must hold c.mu.
+func (c *Cache) end(k entryKey, f *fetch) {
+	f.refs--
+	if f.refs == 0 {
+		delete(c.fetches, k)
+	}
+}
+
+// store caches a value, evicting the least recently used values to stay
+// within the size limits. The caller must hold c.mu.
+func (c *Cache) store(k entryKey, value string) {
+	if el, ok := c.entries[k]; ok {
+		c.remove(el)
+	}
+	e := &entry{key: k, value: value}
+	if c.config.MaxBytes > 0 && e.size() > c.config.MaxBytes {
+		return
+	}
+	if c.config.TTL > 0 {
+		e.expires = c.now().Add(c.config.TTL)
+	}
+
+	c.entries[k] = c.lru.PushFront(e)
+	c.bytes += e.size()
+	for (c.config.MaxEntries > 0 && len(c.entries) > c.config.MaxEntries) ||
+		(c.config.MaxBytes > 0 && c.bytes > c.config.MaxBytes) {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove drops an entry. The caller must hold c.mu.
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.key)
+	c.bytes -= e.size()
+}
+
+// reset drops every entry and marks the loads in flight stale. The caller
+// must hold c.mu.
+func (c *Cache) reset() {
+	clear(c.entries)
+	c.lru.Init()
+	c.bytes = 0
+	for _, f := range c.fetches {
+		f.stale = true
+	}
+	c.stats.Resets++
+}
+
+// canonicalNamespace maps the empty namespace name to the default namespace
+func canonicalNamespace(namespace string) string {
+	if namespace == "" {
+		return kvstore.DefaultNamespace
+	}
+	return namespace
+}
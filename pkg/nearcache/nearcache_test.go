@@ -0,0 +1,174 @@
+package nearcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newLiveCache creates a cache caching values as if its invalidation
+// stream was connected
+func newLiveCache(config Config) *Cache {
+	c := New(config)
+	c.live = true
+	return c
+}
+
+// loader loads value, counting its calls
+func loader(value string, calls *int) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) {
+		*calls++
+		return value, nil
+	}
+}
+
+func TestGet(t *testing.T) {
+	ctx := context.Background()
+	failed := errors.New("unavailable")
+
+	tests := []struct {
+		name      string
+		live      bool
+		err       error
+		wantCalls int
+	}{
+		{"cached while live", true, nil, 1},
+		{"not cached while not live", false, nil, 2},
+		{"errors not cached", true, failed, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(Config{MaxEntries: 10})
+			c.live = tt.live
+			calls := 0
+			load := func(context.Context) (string, error) {
+				calls++
+				return "1", tt.err
+			}
+
+			for i := 0; i < 2; i++ {
+				value, err := c.Get(ctx, "", "alpha", load)
+				if !errors.Is(err, tt.err) || (err == nil && value != "1") {
+					t.Fatalf("Get() = %q, %v", value, err)
+				}
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("loads = %d, want %d", calls, tt.wantCalls)
+			}
+			if stats := c.Stats(); stats.Hits+stats.Misses != 2 || stats.Misses != uint64(tt.wantCalls) {
+				t.Errorf("Stats() = %+v, want %d misses of 2 reads", stats, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        Config
+		values        map[string]string
+		wantEntries   int
+		wantEvictions uint64
+	}{
+		{"max entries", Config{MaxEntries: 2}, map[string]string{"a": "1", "b": "2", "c": "3"}, 2, 1},
+		{"max bytes", Config{MaxBytes: 4}, map[string]string{"a": "1", "b": "2", "c": "3"}, 2, 1},
+		{"value over max bytes", Config{MaxBytes: 4}, map[string]string{"a": "12345"}, 0, 0},
+		{"within limits", Config{MaxEntries: 5, MaxBytes: 100}, map[string]string{"a": "1", "b": "2"}, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newLiveCache(tt.config)
+			calls := 0
+			for key, value := range tt.values {
+				c.Get(context.Background(), "", key, loader(value, &calls))
+			}
+
+			stats := c.Stats()
+			if stats.Entries != tt.wantEntries || stats.Evictions != tt.wantEvictions {
+				t.Errorf("Stats() = %+v, want %d entries and %d evictions", stats, tt.wantEntries, tt.wantEvictions)
+			}
+		})
+	}
+}
+
+func TestLeastRecentlyUsed(t *testing.T) {
+	c := newLiveCache(Config{MaxEntries: 2})
+	calls := 0
+	ctx := context.Background()
+
+	c.Get(ctx, "", "a", loader("1", &calls))
+	c.Get(ctx, "", "b", loader("2", &calls))
+	c.Get(ctx, "", "a", loader("1", &calls))
+	c.Get(ctx, "", "c", loader("3", &calls))
+
+	// b was the least recently used key
+	calls = 0
+	c.Get(ctx, "", "a", loader("1", &calls))
+	c.Get(ctx, "", "b", loader("2", &calls))
+	if calls != 1 {
+		t.Errorf("loads = %d, want 1 for the evicted key", calls)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	c := newLiveCache(Config{MaxEntries: 10, TTL: time.Minute})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	calls := 0
+	ctx := context.Background()
+
+	c.Get(ctx, "", "a", loader("1", &calls))
+	now = now.Add(59 * time.Second)
+	c.Get(ctx, "", "a", loader("1", &calls))
+	now = now.Add(time.Second)
+	c.Get(ctx, "", "a", loader("1", &calls))
+
+	if stats := c.Stats(); calls != 2 || stats.Expirations != 1 {
+		t.Errorf("loads = %d, Stats() = %+v, want 2 loads and 1 expiration", calls, stats)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		invalidate func(c *Cache)
+		// during invalidates while the value of a is loading
+		during    bool
+		wantLoads int
+	}{
+		{"key", func(c *Cache) { c.Invalidate("", "a") }, false, 2},
+		{"other key", func(c *Cache) { c.Invalidate("", "b") }, false, 1},
+		{"namespace", func(c *Cache) { c.Invalidate("default", "") }, false, 2},
+		{"other namespace", func(c *Cache) { c.Invalidate("tenant", "") }, false, 1},
+		{"reset", func(c *Cache) { c.Reset() }, false, 2},
+		{"while loading", func(c *Cache) { c.Invalidate("", "a") }, true, 2},
+		{"namespace while loading", func(c *Cache) { c.Invalidate("", "") }, true, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newLiveCache(Config{MaxEntries: 10})
+			loads := 0
+			c.Get(ctx, "", "a", func(context.Context) (string, error) {
+				loads++
+				if tt.during {
+					tt.invalidate(c)
+				}
+				return "1", nil
+			})
+			if !tt.during {
+				tt.invalidate(c)
+			}
+
+			c.Get(ctx, "", "a", loader("1", &loads))
+			if loads != tt.wantLoads {
+				t.Errorf("loads = %d, want %d", loads, tt.wantLoads)
+			}
+		})
+	}
+}
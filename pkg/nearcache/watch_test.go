@@ -0,0 +1,91 @@
+package nearcache_test
+
+import (
+	inmemorystore "censys/internal/kvstore/inmemory"
+	"censys/pkg/nearcache"
+	"censys/pkg/transport"
+	pb "censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	pb.RegisterKvStoreServiceServer(server, &transport.KvStoreServer{Store: &inmemorystore.InMemoryStore{}})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewKvStoreServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := nearcache.New(nearcache.Config{MaxEntries: 10})
+
+	get := func() string {
+		value, err := c.Get(ctx, "", "alpha", func(ctx context.Context) (string, error) {
+			resp, err := client.Get(ctx, &pb.GetRequest{Key: "alpha"})
+			return resp.GetValue(), err
+		})
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		return value
+	}
+	set := func(value string) {
+		if _, err := client.Set(ctx, &pb.SetRequest{Key: "alpha", Value: value}); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	eventually := func(condition func(nearcache.Stats) bool) {
+		for !condition(c.Stats()) {
+			if ctx.Err() != nil {
+				t.Fatalf("Stats() = %+v", c.Stats())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	invalidated := make(chan [2]string, 10)
+	c.OnInvalidation(func(namespace string, key string) {
+		invalidated <- [2]string{namespace, key}
+	})
+
+	set("1")
+	go c.Watch(ctx, client)
+	eventually(func(s nearcache.Stats) bool { return s.Resets > 0 })
+	if value := get(); value != "1" || c.Stats().Entries != 1 {
+		t.Fatalf("Get() = %q with %+v, want a cached 1", value, c.Stats())
+	}
+
+	// A write by another client evicts the cached value
+	set("2")
+	eventually(func(s nearcache.Stats) bool { return s.Entries == 0 })
+	if value := get(); value != "2" {
+		t.Errorf("Get() after invalidation = %q, want 2", value)
+	}
+	if stats := c.Stats(); stats.Invalidations != 1 || stats.Hits != 0 {
+		t.Errorf("Stats() = %+v, want 1 invalidation and no hits", stats)
+	}
+
+	// The reset and the invalidation were passed on
+	for _, want := range [][2]string{{"", ""}, {"default", "alpha"}} {
+		if got := <-invalidated; got != want {
+			t.Errorf("OnInvalidation() called with %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package openapi
+
+import (
+	"censys/pkg/util"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Version is the OpenAPI version of documents
+const Version = "3.0.3"
+
+// Document is an OpenAPI 3 document describing an HTTP API
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components,omitempty"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem holds the operations of a path by lowercase HTTP method
+type PathItem map[string]*Operation
+
+// Components holds the schemas referenced from the document
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+// Operation describes a single API operation on a path
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary,omitempty"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+// Parameter describes a path, query or header parameter of an operation
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes the body of a request by media type
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes a response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType describes content of a media type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema supported by OpenAPI 3.0 that requests
+// are validated against
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinLength            int                `json:"minLength,omitempty"`
+	MaxLength            int                `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+
+	// pattern is Pattern compiled by Document.Compile
+	pattern *regexp.Regexp
+}
+
+// Ref returns a schema referencing a schema of the document components
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// Bool returns a pointer to b, for optional schema fields
+func Bool(b bool) *bool {
+	return &b
+}
+
+// Number returns a pointer to n, for optional schema fields
+func Number(n float64) *float64 {
+	return &n
+}
+
+// Operation returns the operation for a method on a path, or nil if the
+// document does not describe it
+func (d *Document) Operation(method string, path string) *Operation {
+	return d.Paths[path][strings.ToLower(method)]
+}
+
+// Compile compiles the patterns of the schemas requests are validated
+// against, failing on the first invalid one, so that requests are validated
+// without compiling them again. Documents should be compiled once built.
+func (d *Document) Compile() error {
+	for _, name := range sortedKeys(d.Components.Schemas) {
+		if err := d.Components.Schemas[name].compile("#/components/schemas/" + name); err != nil {
+			return err
+		}
+	}
+	for _, path := range sortedKeys(d.Paths) {
+		for _, method := range sortedKeys(d.Paths[path]) {
+			op := d.Paths[path][method]
+			location := strings.ToUpper(method) + " " + path
+			for _, p := range op.Parameters {
+				if err := p.Schema.compile(location + " parameter " + p.Name); err != nil {
+					return err
+				}
+			}
+			if op.RequestBody == nil {
+				continue
+			}
+			for _, mediaType := range sortedKeys(op.RequestBody.Content) {
+				if err := op.RequestBody.Content[mediaType].Schema.compile(location + " body"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// compile compiles the pattern of a schema and of the schemas it holds
+func (s *Schema) compile(location string) error {
+	if s == nil {
+		return nil
+	}
+	if s.Pattern != "" && s.pattern == nil {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("openapi: invalid pattern of %s: %w", location, err)
+		}
+		s.pattern = pattern
+	}
+	for _, name := range sortedKeys(s.Properties) {
+		if err := s.Properties[name].compile(location + "." + name); err != nil {
+			return err
+		}
+	}
+	return s.Items.compile(location + "[]")
+}
+
+// sortedKeys returns the keys of a map in ascending order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// resolve follows the reference of a schema
+func (d *Document) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// ServeHTTP serves the document as JSON
+func (d *Document) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d); err != nil {
+		util.Error(w, "Failed to encode document", http.StatusInternalServerError)
+	}
+}
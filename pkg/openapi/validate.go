@@ -0,0 +1,238 @@
+package openapi
+
+import (
+	"bytes"
+	"censys/pkg/util"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Validate wraps the handler of the operation for a method on a path,
+// rejecting requests that do not match its parameters and request body with
+// a 400 listing every violation. It panics if the document does not
+// describe the operation, or holds an invalid pattern when it was not
+// compiled.
+func (d *Document) Validate(method string, path string, next http.HandlerFunc) http.HandlerFunc {
+	op := d.Operation(method, path)
+	if op == nil {
+		panic(fmt.Sprintf("openapi: %s %s is not described", method, path))
+	}
+	if err := d.Compile(); err != nil {
+		panic(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		violations := d.validateParameters(op, r)
+
+		if op.RequestBody != nil {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType == "" {
+				mediaType = "application/json"
+			}
+			content, ok := op.RequestBody.Content[mediaType]
+			if !ok {
+				util.Error(w, "Unsupported content type "+mediaType, http.StatusUnsupportedMediaType)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				util.Error(w, "Failed to read request", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			switch {
+			case len(body) == 0 && op.RequestBody.Required:
+				violations = append(violations, util.Violation{Field: "body", Description: "body is required"})
+			case len(body) > 0 && mediaType == "application/json":
+				var value any
+				decoder := json.NewDecoder(bytes.NewReader(body))
+				decoder.UseNumber()
+				if err := decoder.Decode(&value); err != nil {
+					util.WriteProblem(w, util.Problem{
+						Status: http.StatusBadRequest,
+						Code:   util.CodeMalformedRequest,
+						Detail: "Failed to decode request: " + err.Error(),
+					})
+					return
+				}
+				violations = append(violations, d.validateValue(content.Schema, value, "body")...)
+			}
+		}
+
+		if len(violations) > 0 {
+			util.WriteProblem(w, util.Problem{
+				Status: http.StatusBadRequest,
+				Detail: "Request does not match the API specification",
+				Errors: violations,
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// validateParameters checks the path, query and header parameters of a
+// request
+func (d *Document) validateParameters(op *Operation, r *http.Request) []util.Violation {
+	var violations []util.Violation
+	for _, p := range op.Parameters {
+		var value string
+		var present bool
+		switch p.In {
+		case "path":
+			value = r.PathValue(p.Name)
+			present = value != ""
+		case "query":
+			value = r.URL.Query().Get(p.Name)
+			present = r.URL.Query().Has(p.Name)
+		case "header":
+			value = r.Header.Get(p.Name)
+			present = value != ""
+		}
+
+		if !present {
+			if p.Required {
+				violations = append(violations, util.Violation{Field: p.Name, Description: p.Name + " is required"})
+			}
+			continue
+		}
+		violations = append(violations, d.validateParameter(p.Schema, value, p.Name)...)
+	}
+	return violations
+}
+
+// validateParameter checks a parameter value, which is always a string on
+// the wire, against a scalar schema
+func (d *Document) validateParameter(s *Schema, value string, field string) []util.Violation {
+	s = d.resolve(s)
+	if s == nil {
+		return nil
+	}
+
+	switch s.Type {
+	case "integer", "number":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || (s.Type == "integer" && n != math.Trunc(n)) {
+			return []util.Violation{{Field: field, Description: fmt.Sprintf("%s must be %s", field, article(s.Type))}}
+		}
+		return d.validateValue(s, json.Number(value), field)
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return []util.Violation{{Field: field, Description: field + " must be a boolean"}}
+		}
+		return nil
+	default:
+		return d.validateValue(s, value, field)
+	}
+}
+
+// validateValue checks a decoded JSON value against a schema
+func (d *Document) validateValue(s *Schema, value any, field string) []util.Violation {
+	s = d.resolve(s)
+	if s == nil {
+		return nil
+	}
+	violation := func(format string, args ...any) []util.Violation {
+		return []util.Violation{{Field: field, Description: field + " " + fmt.Sprintf(format, args...)}}
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return violation("must be an object")
+		}
+		var violations []util.Violation
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				violations = append(violations, util.Violation{Field: name, Description: name + " is required"})
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					violations = append(violations, util.Violation{Field: name, Description: name + " is not allowed"})
+				}
+				continue
+			}
+			violations = append(violations, d.validateValue(property, object[name], name)...)
+		}
+		return violations
+
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return violation("must be an array")
+		}
+		var violations []util.Violation
+		for i, item := range array {
+			violations = append(violations, d.validateValue(s.Items, item, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+		return violations
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return violation("must be a string")
+		}
+		length := utf8.RuneCountInString(str)
+		switch {
+		case length < s.MinLength:
+			if s.MinLength == 1 {
+				return violation("cannot be empty")
+			}
+			return violation("must be at least %d characters", s.MinLength)
+		case s.MaxLength > 0 && length > s.MaxLength:
+			return violation("must be at most %d characters", s.MaxLength)
+		case s.pattern != nil && !s.pattern.MatchString(str):
+			return violation("must match %s", s.Pattern)
+		}
+		return nil
+
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return violation("must be %s", article(s.Type))
+		}
+		n, err := number.Float64()
+		if err != nil || (s.Type == "integer" && n != math.Trunc(n)) {
+			return violation("must be %s", article(s.Type))
+		}
+		switch {
+		case s.Minimum != nil && n < *s.Minimum:
+			return violation("must be at least %v", *s.Minimum)
+		case s.Maximum != nil && n > *s.Maximum:
+			return violation("must be at most %v", *s.Maximum)
+		}
+		return nil
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return violation("must be a boolean")
+		}
+		return nil
+	}
+	return nil
+}
+
+func article(noun string) string {
+	if noun == "integer" {
+		return "an integer"
+	}
+	return "a " + noun
+}
@@ -0,0 +1,189 @@
+package openapi
+
+import (
+	"censys/pkg/util"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testDocument() *Document {
+	return &Document{
+		OpenAPI: Version,
+		Paths: map[string]PathItem{
+			"/items/{id}": {
+				"put": {
+					OperationID: "putItem",
+					Parameters: []Parameter{
+						{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "string", Pattern: "^[a-z]+$"}},
+						{Name: "limit", In: "query", Schema: &Schema{Type: "integer", Minimum: Number(1), Maximum: Number(10)}},
+						{Name: "X-Token", In: "header", Required: true, Schema: &Schema{Type: "string"}},
+					},
+					RequestBody: &RequestBody{
+						Required: true,
+						Content: map[string]MediaType{
+							"application/json": {Schema: Ref("Item")},
+						},
+					},
+				},
+			},
+		},
+		Components: Components{
+			Schemas: map[string]*Schema{
+				"Item": {
+					Type: "object",
+					Properties: map[string]*Schema{
+						"name": {Type: "string", MinLength: 1},
+						"tags": {Type: "array", Items: &Schema{Type: "string"}},
+						"done": {Type: "boolean"},
+					},
+					Required:             []string{"name"},
+					AdditionalProperties: Bool(false),
+				},
+			},
+		},
+	}
+}
+
+func TestDocument_Validate(t *testing.T) {
+	tests := []struct {
+		name           string
+		target         string
+		contentType    string
+		token          string
+		body           string
+		wantCode       int
+		wantViolations []util.Violation
+	}{
+		{
+			name:     "valid request",
+			target:   "/items/abc?limit=5",
+			token:    "secret",
+			body:     `{"name":"a","tags":["x"],"done":true}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "invalid parameters",
+			target:   "/items/ABC?limit=20",
+			body:     `{"name":"a"}`,
+			wantCode: http.StatusBadRequest,
+			wantViolations: []util.Violation{
+				{Field: "id", Description: "id must match ^[a-z]+$"},
+				{Field: "limit", Description: "limit must be at most 10"},
+				{Field: "X-Token", Description: "X-Token is required"},
+			},
+		},
+		{
+			name:     "non-integer parameter",
+			target:   "/items/abc?limit=1.5",
+			token:    "secret",
+			body:     `{"name":"a"}`,
+			wantCode: http.StatusBadRequest,
+			wantViolations: []util.Violation{
+				{Field: "limit", Description: "limit must be an integer"},
+			},
+		},
+		{
+			name:     "invalid body",
+			target:   "/items/abc",
+			token:    "secret",
+			body:     `{"tags":["x",1],"done":"yes","extra":1}`,
+			wantCode: http.StatusBadRequest,
+			wantViolations: []util.Violation{
+				{Field: "name", Description: "name is required"},
+				{Field: "done", Description: "done must be a boolean"},
+				{Field: "extra", Description: "extra is not allowed"},
+				{Field: "tags[1]", Description: "tags[1] must be a string"},
+			},
+		},
+		{
+			name:     "missing body",
+			target:   "/items/abc",
+			token:    "secret",
+			wantCode: http.StatusBadRequest,
+			wantViolations: []util.Violation{
+				{Field: "body", Description: "body is required"},
+			},
+		},
+		{
+			name:     "malformed body",
+			target:   "/items/abc",
+			token:    "secret",
+			body:     `{"name":`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:        "unsupported content type",
+			target:      "/items/abc",
+			contentType: "text/plain",
+			token:       "secret",
+			body:        "name",
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received string
+			router := http.NewServeMux()
+			router.HandleFunc("PUT /items/{id}", testDocument().Validate("PUT", "/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+				// The body is still readable once validated
+				var item map[string]any
+				json.NewDecoder(r.Body).Decode(&item)
+				received, _ = item["name"].(string)
+			}))
+
+			req := httptest.NewRequest("PUT", tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.token != "" {
+				req.Header.Set("X-Token", tt.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("Validate() wrote code %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode == http.StatusOK && received != "a" {
+				t.Errorf("handler received name %q, want %q", received, "a")
+			}
+			if tt.wantViolations != nil {
+				var problem util.Problem
+				if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(problem.Errors, tt.wantViolations) {
+					t.Errorf("Validate() violations = %v, want %v", problem.Errors, tt.wantViolations)
+				}
+			}
+		})
+	}
+}
+
+func TestDocument_Compile(t *testing.T) {
+	doc := testDocument()
+	if err := doc.Compile(); err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	doc = testDocument()
+	doc.Components.Schemas["Item"].Properties["name"].Pattern = "[a-z"
+	err := doc.Compile()
+	if err == nil || !strings.Contains(err.Error(), "#/components/schemas/Item.name") {
+		t.Errorf("Compile() error = %v, want the invalid pattern of Item.name", err)
+	}
+}
+
+func TestDocument_ValidateUndescribed(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Validate() of an undescribed operation did not panic")
+		}
+	}()
+	testDocument().Validate("GET", "/items/{id}", func(w http.ResponseWriter, r *http.Request) {})
+}
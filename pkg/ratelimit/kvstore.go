@@ -0,0 +1,35 @@
+package ratelimit
+
+import (
+	pb "censys/proto/gen/proto"
+	"context"
+	"time"
+)
+
+// KvStore holds token buckets in the kvstore, so limits are shared by every
+// API replica
+type KvStore struct {
+	Client    pb.KvStoreServiceClient
+	Namespace string
+}
+
+// Take takes a token from the bucket of a key
+func (s *KvStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	resp, err := s.Client.TakeToken(ctx, &pb.TakeTokenRequest{
+		Key:       key,
+		Namespace: s.Namespace,
+		Rate:      limit.Rate,
+		Burst:     int64(limit.Burst),
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:    resp.Allowed,
+		Limit:      int(resp.Limit),
+		Remaining:  int(resp.Remaining),
+		RetryAfter: time.Duration(resp.RetryAfterMs) * time.Millisecond,
+		Reset:      time.Duration(resp.ResetMs) * time.Millisecond,
+	}, nil
+}
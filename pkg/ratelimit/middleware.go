@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"bytes"
+	"censys/pkg/util"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyFunc identifies the client a request is counted against
+type KeyFunc func(r *http.Request) string
+
+// ByIP counts requests against the client IP address
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ByAPIKey counts requests against the X-API-Key header, falling back to the
+// client IP address for requests without one
+func ByAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return "key:" + key
+	}
+	return "ip:" + ByIP(r)
+}
+
+// ByKeyPrefix counts requests against the prefix of the key they access, up
+// to the first occurrence of delimiter, so one hot group of keys cannot
+// starve the others. The key is taken from the path, the query or the "key"
+// field of a JSON body, in that order.
+func ByKeyPrefix(delimiter string) KeyFunc {
+	return func(r *http.Request) string {
+		key := r.PathValue("key")
+		if key == "" {
+			key = r.URL.Query().Get("key")
+		}
+		if key == "" {
+			key = bodyKey(r)
+		}
+		prefix, _, _ := strings.Cut(key, delimiter)
+		return prefix
+	}
+}
+
+// bodyKey returns the "key" field of a JSON request body, restoring the
+// body for the handler
+func bodyKey(r *http.Request) string {
+	if r.Body == nil || r.Body == http.NoBody {
+		return ""
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	var fields struct {
+		Key string `json:"key"`
+	}
+	// Bodies that are not JSON objects are left to the handler to reject
+	json.Unmarshal(body, &fields)
+	return fields.Key
+}
+
+// Limiter rate limits requests with a token bucket per route and client
+type Limiter struct {
+	Store Store
+	Key   KeyFunc
+	// Default applies to routes without a limit in Routes. A zero rate
+	// leaves those routes unlimited.
+	Default Limit
+	// Routes holds the limits of routes by pattern, such as "POST /store"
+	Routes map[string]Limit
+}
+
+// Wrap rate limits a handler registered for the given route pattern. The
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers are
+// set on every response, and requests over the limit are rejected with 429
+// Too Many Requests and a Retry-After header.
+func (l *Limiter) Wrap(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	limit, ok := l.Routes[pattern]
+	if !ok {
+		limit = l.Default
+	}
+	if limit.Rate <= 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		result, err := l.Store.Take(r.Context(), pattern+"|"+l.Key(r), limit)
+		if err != nil {
+			// Fail open so an unavailable bucket store does not take the API down
+			log.Printf("Rate limiting unavailable: %s", err)
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			util.WriteProblem(w, util.Problem{
+				Status: http.StatusTooManyRequests,
+				Code:   util.CodeRateLimited,
+				Detail: "Too many requests",
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// FromEnv configures a limiter from the RATE_LIMIT_RPS, RATE_LIMIT_BURST,
+// RATE_LIMIT_KEY, RATE_LIMIT_KEY_DELIMITER and RATE_LIMIT_ROUTES
+// environment variables. Buckets are held in store.
+func FromEnv(store Store) (*Limiter, error) {
+	l := &Limiter{
+		Store:  store,
+		Key:    ByIP,
+		Routes: make(map[string]Limit),
+	}
+
+	var err error
+	if l.Default, err = parseLimit(os.Getenv("RATE_LIMIT_RPS"), os.Getenv("RATE_LIMIT_BURST")); err != nil {
+		return nil, err
+	}
+
+	switch key := os.Getenv("RATE_LIMIT_KEY"); key {
+	case "", "ip":
+	case "api-key":
+		l.Key = ByAPIKey
+	case "key-prefix":
+		delimiter := os.Getenv("RATE_LIMIT_KEY_DELIMITER")
+		if delimiter == "" {
+			delimiter = "-"
+		}
+		l.Key = ByKeyPrefix(delimiter)
+	default:
+		return nil, fmt.Errorf("invalid RATE_LIMIT_KEY %q, must be ip, api-key or key-prefix", key)
+	}
+
+	// Routes are listed as pattern=rate or pattern=rate:burst, separated by
+	// semicolons, such as "POST /store=5:10;GET /store=100"
+	if routes := os.Getenv("RATE_LIMIT_ROUTES"); routes != "" {
+		for _, route := range strings.Split(routes, ";") {
+			pattern, value, ok := strings.Cut(route, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid RATE_LIMIT_ROUTES entry %q", route)
+			}
+			rate, burst, _ := strings.Cut(value, ":")
+			limit, err := parseLimit(rate, burst)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RATE_LIMIT_ROUTES entry %q: %w", route, err)
+			}
+			l.Routes[strings.TrimSpace(pattern)] = limit
+		}
+	}
+	return l, nil
+}
+
+// parseLimit parses a rate and an optional burst, which defaults to the
+// rate rounded up
+func parseLimit(rate string, burst string) (Limit, error) {
+	var limit Limit
+	if rate == "" {
+		return limit, nil
+	}
+
+	var err error
+	if limit.Rate, err = strconv.ParseFloat(rate, 64); err != nil || limit.Rate < 0 {
+		return limit, fmt.Errorf("invalid rate %q", rate)
+	}
+	limit.Burst = int(math.Ceil(limit.Rate))
+	if burst != "" {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst < 1 {
+			return limit, fmt.Errorf("invalid burst %q", burst)
+		}
+	}
+	return limit, nil
+}
@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLimiter_Wrap(t *testing.T) {
+	l := &Limiter{
+		Store:   &MemoryStore{},
+		Key:     ByAPIKey,
+		Default: Limit{Rate: 1, Burst: 1},
+		Routes: map[string]Limit{
+			"GET /store": {Rate: 1, Burst: 2},
+		},
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	get := l.Wrap("GET /store", ok)
+	post := l.Wrap("POST /store", ok)
+
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		apiKey        string
+		wantCode      int
+		wantRemaining string
+		wantRetry     string
+	}{
+		{name: "route limit", handler: get, apiKey: "a", wantCode: http.StatusOK, wantRemaining: "1"},
+		{name: "route burst", handler: get, apiKey: "a", wantCode: http.StatusOK, wantRemaining: "0"},
+		{name: "route exhausted", handler: get, apiKey: "a", wantCode: http.StatusTooManyRequests, wantRemaining: "0", wantRetry: "1"},
+		{name: "other client", handler: get, apiKey: "b", wantCode: http.StatusOK, wantRemaining: "1"},
+		{name: "other route", handler: post, apiKey: "a", wantCode: http.StatusOK, wantRemaining: "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/store", nil)
+			req.Header.Set("X-API-Key", tt.apiKey)
+			tt.handler(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("Wrap() wrote code %d, want %d", w.Code, tt.wantCode)
+			}
+			if got := w.Header().Get("X-RateLimit-Remaining"); got != tt.wantRemaining {
+				t.Errorf("X-RateLimit-Remaining = %q, want %q", got, tt.wantRemaining)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetry {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestByKeyPrefix(t *testing.T) {
+	req := httptest.NewRequest("GET", "/store/users-42", nil)
+	req.SetPathValue("key", "users-42")
+	if got := ByKeyPrefix("-")(req); got != "users" {
+		t.Errorf("ByKeyPrefix() = %q, want %q", got, "users")
+	}
+
+	req = httptest.NewRequest("GET", "/store?key=orders-7", nil)
+	if got := ByKeyPrefix("-")(req); got != "orders" {
+		t.Errorf("ByKeyPrefix() = %q, want %q", got, "orders")
+	}
+}
+
+func TestByKeyPrefix_Body(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"key field", `{"key": "carts-3", "value": "v"}`, "carts"},
+		{"no key field", `{"value": "v"}`, ""},
+		{"not json", `carts-3`, ""},
+		{"empty body", ``, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The key of POST /store is only in the body, which the
+			// handler must still be able to read
+			var key, body string
+			limiter := &Limiter{
+				Store: &MemoryStore{},
+				Key: func(r *http.Request) string {
+					key = ByKeyPrefix("-")(r)
+					return key
+				},
+				Default: Limit{Rate: 1, Burst: 1},
+			}
+			handler := limiter.Wrap("POST /store", func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				body = string(data)
+			})
+
+			handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/store", strings.NewReader(tt.body)))
+			if key != tt.want {
+				t.Errorf("ByKeyPrefix() = %q, want %q", key, tt.want)
+			}
+			if body != tt.body {
+				t.Errorf("handler read body %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantLimit  Limit
+		wantRoutes map[string]Limit
+		wantErr    bool
+	}{
+		{
+			name:       "unlimited",
+			wantRoutes: map[string]Limit{},
+		},
+		{
+			name:       "default burst",
+			env:        map[string]string{"RATE_LIMIT_RPS": "2.5"},
+			wantLimit:  Limit{Rate: 2.5, Burst: 3},
+			wantRoutes: map[string]Limit{},
+		},
+		{
+			name: "routes",
+			env: map[string]string{
+				"RATE_LIMIT_RPS":    "10",
+				"RATE_LIMIT_BURST":  "20",
+				"RATE_LIMIT_ROUTES": "POST /store=5:10; GET /store=100",
+			},
+			wantLimit: Limit{Rate: 10, Burst: 20},
+			wantRoutes: map[string]Limit{
+				"POST /store": {Rate: 5, Burst: 10},
+				"GET /store":  {Rate: 100, Burst: 100},
+			},
+		},
+		{
+			name:    "invalid rate",
+			env:     map[string]string{"RATE_LIMIT_RPS": "fast"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid key",
+			env:     map[string]string{"RATE_LIMIT_KEY": "cookie"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid route",
+			env:     map[string]string{"RATE_LIMIT_ROUTES": "POST /store"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"RATE_LIMIT_RPS", "RATE_LIMIT_BURST", "RATE_LIMIT_KEY", "RATE_LIMIT_ROUTES"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			l, err := FromEnv(&MemoryStore{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if l.Default != tt.wantLimit || !reflect.DeepEqual(l.Routes, tt.wantRoutes) {
+				t.Errorf("FromEnv() = %+v, %+v, want %+v, %+v", l.Default, l.Routes, tt.wantLimit, tt.wantRoutes)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket_Take(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limit := Limit{Rate: 2, Burst: 3}
+
+	tests := []struct {
+		name          string
+		at            time.Duration
+		wantAllowed   bool
+		wantRemaining int
+		wantRetry     time.Duration
+	}{
+		{name: "starts full", at: 0, wantAllowed: true, wantRemaining: 2},
+		{name: "second", at: 0, wantAllowed: true, wantRemaining: 1},
+		{name: "third", at: 0, wantAllowed: true, wantRemaining: 0},
+		{name: "empty", at: 0, wantAllowed: false, wantRemaining: 0, wantRetry: 500 * time.Millisecond},
+		{name: "refilled one token", at: 500 * time.Millisecond, wantAllowed: true, wantRemaining: 0},
+		{name: "refill is capped at burst", at: time.Hour, wantAllowed: true, wantRemaining: 2},
+	}
+
+	var b Bucket
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := b.Take(limit, start.Add(tt.at))
+			if got.Allowed != tt.wantAllowed || got.Remaining != tt.wantRemaining || got.RetryAfter != tt.wantRetry {
+				t.Errorf("Take() = %+v, want allowed %v, remaining %v, retry after %v", got, tt.wantAllowed, tt.wantRemaining, tt.wantRetry)
+			}
+			if got.Limit != limit.Burst {
+				t.Errorf("Take() limit = %v, want %v", got.Limit, limit.Burst)
+			}
+		})
+	}
+}
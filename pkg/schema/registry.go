@@ -0,0 +1,187 @@
+package schema
+
+import (
+	"censys/internal/kvstore"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RegistryKey is the reserved key under which the schemas of a keyspace are
+// stored, as a JSON object mapping key prefixes to schemas
+const RegistryKey = "_schemas"
+
+// ErrSchemaNotFound is returned when no schema is registered for a prefix
+var ErrSchemaNotFound = errors.New("schema not found")
+
+// Registry validates values against the schema registered for the longest
+// matching key prefix. Schemas are read from the keyspace they apply to and
+// cached until Invalidate is called.
+type Registry struct {
+	mu    sync.Mutex
+	cache map[string][]prefixSchema
+}
+
+type prefixSchema struct {
+	prefix string
+	schema *Schema
+}
+
+// NewRegistry creates an empty schema registry
+func NewRegistry() *Registry {
+	return &Registry{
+		cache: make(map[string][]prefixSchema),
+	}
+}
+
+// Validate validates a value about to be written to key. Writes to
+// RegistryKey itself are checked to contain only valid schemas.
+func (r *Registry) Validate(ctx context.Context, namespace string, store kvstore.KeyValueStore, key string, value string) error {
+	if key == RegistryKey {
+		_, err := parse(value)
+		return err
+	}
+
+	schemas, err := r.load(ctx, namespace, store)
+	if err != nil {
+		return err
+	}
+	for _, s := range schemas {
+		if strings.HasPrefix(key, s.prefix) {
+			return s.schema.Validate([]byte(value))
+		}
+	}
+	return nil
+}
+
+// Invalidate drops the cached schemas of a namespace so they are reloaded
+// from the store on next use
+func (r *Registry) Invalidate(namespace string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cache, cacheKey(namespace))
+}
+
+// Register registers a schema for every key starting with prefix, replacing
+// any schema previously registered for the same prefix
+func (r *Registry) Register(ctx context.Context, namespace string, store kvstore.KeyValueStore, prefix string, schema string) error {
+	if _, err := Compile([]byte(schema)); err != nil {
+		return err
+	}
+	return r.update(ctx, namespace, store, func(doc map[string]json.RawMessage) error {
+		doc[prefix] = json.RawMessage(schema)
+		return nil
+	})
+}
+
+// Unregister removes the schema registered for prefix
+func (r *Registry) Unregister(ctx context.Context, namespace string, store kvstore.KeyValueStore, prefix string) error {
+	return r.update(ctx, namespace, store, func(doc map[string]json.RawMessage) error {
+		if _, ok := doc[prefix]; !ok {
+			return ErrSchemaNotFound
+		}
+		delete(doc, prefix)
+		return nil
+	})
+}
+
+// List returns the registered schemas keyed by prefix
+func (r *Registry) List(ctx context.Context, store kvstore.KeyValueStore) (map[string]string, error) {
+	doc, err := read(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	schemas := make(map[string]string, len(doc))
+	for prefix, schema := range doc {
+		schemas[prefix] = string(schema)
+	}
+	return schemas, nil
+}
+
+// update applies fn to the stored registry document and writes it back
+func (r *Registry) update(ctx context.Context, namespace string, store kvstore.KeyValueStore, fn func(map[string]json.RawMessage) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc, err := read(ctx, store)
+	if err != nil {
+		return err
+	}
+	if err := fn(doc); err != nil {
+		return err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if err := store.Set(ctx, RegistryKey, string(data)); err != nil {
+		return err
+	}
+	delete(r.cache, cacheKey(namespace))
+	return nil
+}
+
+// load returns the compiled schemas of a namespace, longest prefix first
+func (r *Registry) load(ctx context.Context, namespace string, store kvstore.KeyValueStore) ([]prefixSchema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if schemas, ok := r.cache[cacheKey(namespace)]; ok {
+		return schemas, nil
+	}
+	value, ok := store.Get(ctx, RegistryKey)
+	if !ok {
+		value = "{}"
+	}
+	schemas, err := parse(value)
+	if err != nil {
+		return nil, err
+	}
+	r.cache[cacheKey(namespace)] = schemas
+	return schemas, nil
+}
+
+// read reads the raw registry document from the store
+func read(ctx context.Context, store kvstore.KeyValueStore) (map[string]json.RawMessage, error) {
+	doc := make(map[string]json.RawMessage)
+	value, ok := store.Get(ctx, RegistryKey)
+	if !ok {
+		return doc, nil
+	}
+	if err := json.Unmarshal([]byte(value), &doc); err != nil {
+		return nil, fmt.Errorf("schema registry is corrupt: %w", err)
+	}
+	return doc, nil
+}
+
+// parse compiles a registry document
+func parse(value string) ([]prefixSchema, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &doc); err != nil {
+		return nil, fmt.Errorf("schema registry must be a JSON object of schemas: %w", err)
+	}
+
+	schemas := make([]prefixSchema, 0, len(doc))
+	for prefix, raw := range doc {
+		compiled, err := Compile(raw)
+		if err != nil {
+			return nil, fmt.Errorf("schema for prefix %q: %w", prefix, err)
+		}
+		schemas = append(schemas, prefixSchema{prefix: prefix, schema: compiled})
+	}
+	sort.Slice(schemas, func(i, j int) bool {
+		return len(schemas[i].prefix) > len(schemas[j].prefix)
+	})
+	return schemas, nil
+}
+
+func cacheKey(namespace string) string {
+	if namespace == "" {
+		return kvstore.DefaultNamespace
+	}
+	return namespace
+}
@@ -0,0 +1,78 @@
+package schema
+
+import (
+	inmemorystore "censys/internal/kvstore/inmemory"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	store := &inmemorystore.InMemoryStore{}
+	registry := NewRegistry()
+
+	if err := registry.Register(ctx, "", store, "config-", `{"type": "object"}`); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := registry.Register(ctx, "", store, "config-ports-", `{"type": "integer"}`); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := registry.Register(ctx, "", store, "bad-", `{"type": 1}`); err == nil {
+		t.Errorf("Register() error = nil, want error")
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		wantErr bool
+	}{
+		{name: "matching prefix", key: "config-api", value: `{}`},
+		{name: "matching prefix invalid", key: "config-api", value: `[]`, wantErr: true},
+		{name: "longest prefix wins", key: "config-ports-api", value: `8081`},
+		{name: "longest prefix invalid", key: "config-ports-api", value: `{}`, wantErr: true},
+		{name: "no matching prefix", key: "other", value: `not json`},
+		{name: "valid registry", key: RegistryKey, value: `{"a": true}`},
+		{name: "invalid registry", key: RegistryKey, value: `{"a": 1}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := registry.Validate(ctx, "", store, tt.key, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegistry_Reload(t *testing.T) {
+	ctx := context.Background()
+	store := &inmemorystore.InMemoryStore{}
+	registry := NewRegistry()
+
+	if err := registry.Validate(ctx, "", store, "config-api", `[]`); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	// Writing the registry key directly takes effect once invalidated
+	store.Set(ctx, RegistryKey, `{"config-": {"type": "object"}}`)
+	if err := registry.Validate(ctx, "", store, "config-api", `[]`); err != nil {
+		t.Errorf("Validate() before Invalidate() error = %v, want cached result", err)
+	}
+	registry.Invalidate("")
+	if err := registry.Validate(ctx, "", store, "config-api", `[]`); err == nil {
+		t.Errorf("Validate() after Invalidate() error = nil, want error")
+	}
+
+	if err := registry.Unregister(ctx, "", store, "config-"); err != nil {
+		t.Fatalf("Unregister() error = %v", err)
+	}
+	if err := registry.Validate(ctx, "", store, "config-api", `[]`); err != nil {
+		t.Errorf("Validate() after Unregister() error = %v", err)
+	}
+	if err := registry.Unregister(ctx, "", store, "config-"); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("Unregister() error = %v, want %v", err, ErrSchemaNotFound)
+	}
+}
@@ -0,0 +1,400 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON Schema. The supported keywords are type, enum,
+// const, properties, required, additionalProperties, items, minItems,
+// maxItems, minLength, maxLength, pattern, minimum, maximum,
+// exclusiveMinimum, exclusiveMaximum, allOf, anyOf, oneOf and not, along
+// with annotations such as title and description. Other keywords are
+// rejected, since they would not be enforced.
+type Schema struct {
+	// reject is set for the boolean schema false, which matches nothing
+	reject bool
+
+	types                []string
+	enum                 []any
+	constant             any
+	hasConst             bool
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	items                *Schema
+	minItems, maxItems   *int
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	minimum, maximum     *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	allOf, anyOf, oneOf  []*Schema
+	not                  *Schema
+}
+
+// ValidationError reports the location of the first value that does not
+// conform to a schema
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Compile parses a JSON Schema document
+func Compile(data []byte) (*Schema, error) {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	return compile(doc, "$")
+}
+
+func compile(doc any, at string) (*Schema, error) {
+	switch d := doc.(type) {
+	case bool:
+		return &Schema{reject: !d}, nil
+	case map[string]any:
+		return compileObject(d, at)
+	default:
+		return nil, fmt.Errorf("%s: schema must be an object or a boolean", at)
+	}
+}
+
+// keywords are the keywords a schema may use: those enforced and the
+// annotations, which have no effect on validation
+var keywords = map[string]bool{
+	"type": true, "enum": true, "const": true, "properties": true, "required": true,
+	"additionalProperties": true, "items": true, "minItems": true, "maxItems": true,
+	"minLength": true, "maxLength": true, "pattern": true, "minimum": true, "maximum": true,
+	"exclusiveMinimum": true, "exclusiveMaximum": true, "allOf": true, "anyOf": true,
+	"oneOf": true, "not": true,
+
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "deprecated": true, "readOnly": true, "writeOnly": true,
+}
+
+func compileObject(d map[string]any, at string) (*Schema, error) {
+	s := &Schema{}
+	var err error
+
+	var unsupported []string
+	for keyword := range d {
+		if !keywords[keyword] {
+			unsupported = append(unsupported, keyword)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return nil, fmt.Errorf("%s.%s: unsupported keyword", at, unsupported[0])
+	}
+
+	switch t := d["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s.type: must be a string or an array of strings", at)
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s.type: must be a string or an array of strings", at)
+	}
+
+	if v, ok := d["enum"]; ok {
+		values, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s.enum: must be an array", at)
+		}
+		s.enum = values
+	}
+	s.constant, s.hasConst = d["const"]
+
+	if v, ok := d["properties"]; ok {
+		props, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s.properties: must be an object", at)
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, prop := range props {
+			if s.properties[name], err = compile(prop, at+".properties."+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if v, ok := d["required"]; ok {
+		names, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s.required: must be an array of strings", at)
+		}
+		for _, n := range names {
+			name, ok := n.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s.required: must be an array of strings", at)
+			}
+			s.required = append(s.required, name)
+		}
+	}
+	if v, ok := d["additionalProperties"]; ok {
+		if s.additionalProperties, err = compile(v, at+".additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := d["items"]; ok {
+		if s.items, err = compile(v, at+".items"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := d["not"]; ok {
+		if s.not, err = compile(v, at+".not"); err != nil {
+			return nil, err
+		}
+	}
+	for keyword, list := range map[string]*[]*Schema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf} {
+		v, ok := d[keyword]
+		if !ok {
+			continue
+		}
+		schemas, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s.%s: must be an array", at, keyword)
+		}
+		for i, sub := range schemas {
+			compiled, err := compile(sub, fmt.Sprintf("%s.%s[%d]", at, keyword, i))
+			if err != nil {
+				return nil, err
+			}
+			*list = append(*list, compiled)
+		}
+	}
+
+	for keyword, dst := range map[string]**int{"minItems": &s.minItems, "maxItems": &s.maxItems, "minLength": &s.minLength, "maxLength": &s.maxLength} {
+		if v, ok := d[keyword]; ok {
+			n, ok := v.(float64)
+			if !ok || n < 0 || n != math.Trunc(n) {
+				return nil, fmt.Errorf("%s.%s: must be a non-negative integer", at, keyword)
+			}
+			i := int(n)
+			*dst = &i
+		}
+	}
+	for keyword, dst := range map[string]**float64{"minimum": &s.minimum, "maximum": &s.maximum, "exclusiveMinimum": &s.exclusiveMinimum, "exclusiveMaximum": &s.exclusiveMaximum} {
+		if v, ok := d[keyword]; ok {
+			n, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("%s.%s: must be a number", at, keyword)
+			}
+			*dst = &n
+		}
+	}
+
+	if v, ok := d["pattern"]; ok {
+		pattern, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s.pattern: must be a string", at)
+		}
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("%s.pattern: %w", at, err)
+		}
+	}
+	return s, nil
+}
+
+// Validate validates a JSON document against the schema
+func (s *Schema) Validate(document []byte) error {
+	var value any
+	if err := json.Unmarshal(document, &value); err != nil {
+		return &ValidationError{Path: "$", Message: "value is not valid JSON"}
+	}
+	return s.validate(value, "$")
+}
+
+func (s *Schema) validate(value any, path string) error {
+	if s.reject {
+		return &ValidationError{Path: path, Message: "no value is allowed here"}
+	}
+
+	if len(s.types) > 0 && !matchesAnyType(value, s.types) {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("expected %s, got %s", joinTypes(s.types), typeOf(value))}
+	}
+	if s.enum != nil && !containsValue(s.enum, value) {
+		return &ValidationError{Path: path, Message: "value is not one of the allowed values"}
+	}
+	if s.hasConst && !reflect.DeepEqual(s.constant, value) {
+		return &ValidationError{Path: path, Message: "value does not match the constant"}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		if err := s.validateObject(v, path); err != nil {
+			return err
+		}
+	case []any:
+		if err := s.validateArray(v, path); err != nil {
+			return err
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.minLength != nil && length < *s.minLength {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("string is shorter than %d", *s.minLength)}
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("string is longer than %d", *s.maxLength)}
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("string does not match pattern %q", s.pattern)}
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("number is less than %v", *s.minimum)}
+		}
+		if s.maximum != nil && v > *s.maximum {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("number is greater than %v", *s.maximum)}
+		}
+		if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("number is not greater than %v", *s.exclusiveMinimum)}
+		}
+		if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("number is not less than %v", *s.exclusiveMaximum)}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(value, path); err != nil {
+			return err
+		}
+	}
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			if sub.validate(value, path) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return &ValidationError{Path: path, Message: "value does not match any of the allowed schemas"}
+		}
+	}
+	if len(s.oneOf) > 0 {
+		matches := 0
+		for _, sub := range s.oneOf {
+			if sub.validate(value, path) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("value matches %d schemas, want exactly one", matches)}
+		}
+	}
+	if s.not != nil && s.not.validate(value, path) == nil {
+		return &ValidationError{Path: path, Message: "value matches a disallowed schema"}
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(object map[string]any, path string) error {
+	for _, name := range s.required {
+		if _, ok := object[name]; !ok {
+			return &ValidationError{Path: path + "." + name, Message: "required property is missing"}
+		}
+	}
+
+	// Visit properties in a stable order so the reported path is deterministic
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		sub, ok := s.properties[name]
+		if !ok {
+			sub = s.additionalProperties
+		}
+		if sub == nil {
+			continue
+		}
+		if err := sub.validate(object[name], path+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateArray(array []any, path string) error {
+	if s.minItems != nil && len(array) < *s.minItems {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("array has fewer than %d items", *s.minItems)}
+	}
+	if s.maxItems != nil && len(array) > *s.maxItems {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("array has more than %d items", *s.maxItems)}
+	}
+	if s.items != nil {
+		for i, item := range array {
+			if err := s.items.validate(item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// typeOf returns the JSON Schema type name of a decoded JSON value
+func typeOf(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func matchesAnyType(value any, types []string) bool {
+	actual := typeOf(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func joinTypes(types []string) string {
+	if len(types) == 1 {
+		return types[0]
+	}
+	return fmt.Sprintf("one of %v", types)
+}
+
+func containsValue(values []any, value any) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+)
+
+const userSchema = `{
+	"type": "object",
+	"required": ["name", "user"],
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+		"tags": {"type": "array", "items": {"enum": ["a", "b"]}, "maxItems": 2},
+		"user": {
+			"type": "object",
+			"properties": {
+				"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"}
+			},
+			"additionalProperties": false
+		}
+	}
+}`
+
+func TestSchema_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		wantPath string
+	}{
+		{
+			name:     "valid document",
+			document: `{"name": "api", "port": 8081, "tags": ["a"], "user": {"email": "a@b.c"}}`,
+		},
+		{
+			name:     "not JSON",
+			document: `{"name": `,
+			wantPath: "$",
+		},
+		{
+			name:     "wrong root type",
+			document: `[]`,
+			wantPath: "$",
+		},
+		{
+			name:     "missing required property",
+			document: `{"name": "api"}`,
+			wantPath: "$.user",
+		},
+		{
+			name:     "integer out of range",
+			document: `{"name": "api", "port": 70000, "user": {}}`,
+			wantPath: "$.port",
+		},
+		{
+			name:     "number instead of integer",
+			document: `{"name": "api", "port": 80.5, "user": {}}`,
+			wantPath: "$.port",
+		},
+		{
+			name:     "enum item mismatch",
+			document: `{"name": "api", "tags": ["a", "c"], "user": {}}`,
+			wantPath: "$.tags[1]",
+		},
+		{
+			name:     "nested pattern mismatch",
+			document: `{"name": "api", "user": {"email": "not-an-email"}}`,
+			wantPath: "$.user.email",
+		},
+		{
+			name:     "additional property",
+			document: `{"name": "api", "user": {"emial": "a@b.c"}}`,
+			wantPath: "$.user.emial",
+		},
+	}
+
+	s, err := Compile([]byte(userSchema))
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Validate([]byte(tt.document))
+			if tt.wantPath == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validate() error = %v, want *ValidationError", err)
+			}
+			if validationErr.Path != tt.wantPath {
+				t.Errorf("Validate() path = %v, want %v", validationErr.Path, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestSchema_Combinators(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		document string
+		wantErr  bool
+	}{
+		{
+			name:     "anyOf match",
+			schema:   `{"anyOf": [{"type": "string"}, {"type": "null"}]}`,
+			document: `null`,
+		},
+		{
+			name:     "anyOf mismatch",
+			schema:   `{"anyOf": [{"type": "string"}, {"type": "null"}]}`,
+			document: `1`,
+			wantErr:  true,
+		},
+		{
+			name:     "oneOf matches both",
+			schema:   `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`,
+			document: `1`,
+			wantErr:  true,
+		},
+		{
+			name:     "not",
+			schema:   `{"not": {"const": "forbidden"}}`,
+			document: `"forbidden"`,
+			wantErr:  true,
+		},
+		{
+			name:     "false schema",
+			schema:   `false`,
+			document: `{}`,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Compile([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			if err := s.Validate([]byte(tt.document)); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr bool
+	}{
+		{
+			name:   "valid schema",
+			schema: userSchema,
+		},
+		{
+			name:    "not JSON",
+			schema:  `{`,
+			wantErr: true,
+		},
+		{
+			name:    "not an object",
+			schema:  `"string"`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid pattern",
+			schema:  `{"pattern": "["}`,
+			wantErr: true,
+		},
+		{
+			name:   "annotations",
+			schema: `{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "user", "description": "a user"}`,
+		},
+		{
+			name:    "unsupported keyword",
+			schema:  `{"type": "array", "uniqueItems": true}`,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported nested keyword",
+			schema:  `{"properties": {"email": {"type": "string", "format": "email"}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "reference",
+			schema:  `{"$ref": "#/$defs/user", "$defs": {"user": {"type": "object"}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "negative length",
+			schema:  `{"properties": {"name": {"minLength": -1}}}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compile([]byte(tt.schema)); (err != nil) != tt.wantErr {
+				t.Errorf("Compile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package singleflight
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Stats are the statistics of a group
+type Stats struct {
+	// Calls counts the calls to Do
+	Calls uint64 `json:"calls"`
+	// Executions counts the functions run, one per call that was not
+	// coalesced
+	Executions uint64 `json:"executions"`
+	// Coalesced counts the calls that shared the result of an execution in
+	// flight instead of running their function
+	Coalesced uint64 `json:"coalesced"`
+	// Abandoned counts the calls that returned when their context was done,
+	// before the result of their execution
+	Abandoned uint64 `json:"abandoned"`
+}
+
+// Group coalesces concurrent calls with the same key into a single execution
+// whose result they share. The zero value is ready to use.
+//
+// Each caller waits for the result only until its own context is done, and
+// an execution is cancelled once every caller waiting for it has returned.
+// The context passed to the function carries the values of the first caller
+// but not its deadline, so a caller with a short deadline does not fail the
+// others.
+type Group[V any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[V]
+	stats Stats
+}
+
+// call is an execution in flight
+type call[V any] struct {
+	done    chan struct{}
+	value   V
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Do runs fn once for all the concurrent calls with the same key and returns
+// its result, or the error of ctx if it is done first
+func (g *Group[V]) Do(ctx context.Context, key string, fn func(ctx context.Context) (V, error)) (V, error) {
+	g.mu.Lock()
+	g.stats.Calls++
+	if g.calls == nil {
+		g.calls = make(map[string]*call[V])
+	}
+	if c, ok := g.calls[key]; ok {
+		c.waiters++
+		g.stats.Coalesced++
+		g.mu.Unlock()
+		return g.wait(ctx, key, c)
+	}
+
+	callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c := &call[V]{
+		done:    make(chan struct{}),
+		waiters: 1,
+		cancel:  cancel,
+	}
+	g.calls[key] = c
+	g.stats.Executions++
+	g.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		c.value, c.err = fn(callCtx)
+
+		g.mu.Lock()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	return g.wait(ctx, key, c)
+}
+
+// wait waits for the result of an execution until ctx is done
+func (g *Group[V]) wait(ctx context.Context, key string, c *call[V]) (V, error) {
+	select {
+	case <-c.done:
+		return c.value, c.err
+	case <-ctx.Done():
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stats.Abandoned++
+	c.waiters--
+	if c.waiters == 0 {
+		// Later calls start a new execution instead of joining a cancelled one
+		c.cancel()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+	}
+	var zero V
+	return zero, ctx.Err()
+}
+
+// Forget makes later calls with key start a new execution instead of
+// sharing the result of the one in flight, such as after a write made it
+// stale
+func (g *Group[V]) Forget(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.calls, key)
+}
+
+// ForgetPrefix forgets every key starting with prefix, or every key when
+// prefix is empty
+func (g *Group[V]) ForgetPrefix(prefix string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key := range g.calls {
+		if strings.HasPrefix(key, prefix) {
+			delete(g.calls, key)
+		}
+	}
+}
+
+// Stats returns the statistics of the group
+func (g *Group[V]) Stats() Stats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stats
+}
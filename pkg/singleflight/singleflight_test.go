@@ -0,0 +1,182 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitCalls waits until a group counted calls
+func waitCalls(t *testing.T, g *Group[string], calls uint64) {
+	deadline := time.Now().Add(time.Second)
+	for g.Stats().Calls < calls {
+		if time.Now().After(deadline) {
+			t.Fatalf("Stats() = %+v, want %d calls", g.Stats(), calls)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDo(t *testing.T) {
+	failed := errors.New("unavailable")
+
+	tests := []struct {
+		name    string
+		callers int
+		err     error
+	}{
+		{"single caller", 1, nil},
+		{"shared value", 10, nil},
+		{"shared error", 10, failed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var g Group[string]
+			release := make(chan struct{})
+			fn := func(ctx context.Context) (string, error) {
+				<-release
+				return "value", tt.err
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < tt.callers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					value, err := g.Do(context.Background(), "key", fn)
+					if !errors.Is(err, tt.err) || value != "value" {
+						t.Errorf("Do() = %q, %v", value, err)
+					}
+				}()
+			}
+			waitCalls(t, &g, uint64(tt.callers))
+			close(release)
+			wg.Wait()
+
+			want := Stats{Calls: uint64(tt.callers), Executions: 1, Coalesced: uint64(tt.callers - 1)}
+			if got := g.Stats(); got != want {
+				t.Errorf("Stats() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDoDeadlines(t *testing.T) {
+	var g Group[string]
+	release := make(chan struct{})
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		select {
+		case <-release:
+			return "value", nil
+		case <-ctx.Done():
+			close(cancelled)
+			return "", ctx.Err()
+		}
+	}
+
+	// A caller with a short deadline gives up without failing the others
+	short, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	long, cancelLong := context.WithCancel(context.Background())
+
+	result := make(chan error)
+	go func() {
+		_, err := g.Do(long, "key", fn)
+		result <- err
+	}()
+	waitCalls(t, &g, 1)
+	if _, err := g.Do(short, "key", fn); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Do() with a short deadline error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-cancelled:
+		t.Fatal("execution was cancelled while a caller was waiting")
+	default:
+	}
+
+	// The execution is cancelled once its last caller gives up
+	cancelLong()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do() error = %v, want %v", err, context.Canceled)
+	}
+	<-cancelled
+	close(release)
+
+	if stats := g.Stats(); stats.Abandoned != 2 {
+		t.Errorf("Stats() = %+v, want 2 abandoned calls", stats)
+	}
+
+	// Later calls start a new execution
+	if value, err := g.Do(context.Background(), "key", fn); value != "value" || err != nil {
+		t.Errorf("Do() after cancellation = %q, %v", value, err)
+	}
+}
+
+func TestForget(t *testing.T) {
+	var g Group[string]
+	release := make(chan struct{})
+	executions := make(chan string, 2)
+
+	do := func(value string) chan string {
+		result := make(chan string)
+		go func() {
+			v, _ := g.Do(context.Background(), "key", func(ctx context.Context) (string, error) {
+				executions <- value
+				<-release
+				return value, nil
+			})
+			result <- v
+		}()
+		return result
+	}
+
+	first := do("old")
+	<-executions
+	g.Forget("key")
+	second := do("new")
+	<-executions
+	close(release)
+
+	if old, new := <-first, <-second; old != "old" || new != "new" {
+		t.Errorf("Do() = %q and %q, want old and new", old, new)
+	}
+}
+
+func TestForgetPrefix(t *testing.T) {
+	var g Group[string]
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		<-release
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	do := func(key string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Do(context.Background(), key, fn)
+		}()
+	}
+	for _, key := range []string{"a/1", "a/2", "b/1"} {
+		do(key)
+	}
+	waitCalls(t, &g, 3)
+
+	// Only the keys starting with the prefix start new executions
+	g.ForgetPrefix("a/")
+	for _, key := range []string{"a/1", "a/2", "b/1"} {
+		do(key)
+	}
+	waitCalls(t, &g, 6)
+	close(release)
+	wg.Wait()
+
+	if stats := g.Stats(); stats.Executions != 5 || stats.Coalesced != 1 {
+		t.Errorf("Stats() = %+v, want 5 executions and 1 coalesced", stats)
+	}
+}
@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"censys/pkg/util"
+	pb "censys/proto/gen/proto"
+	"net/http"
+)
+
+// Leader is the leader of an election. An empty candidate means the
+// election has no leader.
+type Leader struct {
+	Candidate string `json:"candidate"`
+	Revision  int64  `json:"revision"`
+}
+
+// HandleLeader handles GET requests for the current leader of an election
+func (s *GrpcServer) HandleLeader(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.Store.Leader(r.Context(), &pb.LeaderRequest{
+		Election:  r.PathValue("election"),
+		Namespace: r.PathValue("namespace"),
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, leaderFromProto(resp.Leader))
+}
+
+// HandleObserve handles GET requests observing the leader of an election.
+// The current leader and every change of leader are streamed as
+// server-sent events.
+func (s *GrpcServer) HandleObserve(w http.ResponseWriter, r *http.Request) {
+	stream, err := s.Store.Observe(r.Context(), &pb.ObserveRequest{
+		Election:  r.PathValue("election"),
+		Namespace: r.PathValue("namespace"),
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+
+	streamEvents(w, r, stream, "leader", func(leader *pb.ElectionLeader) any {
+		return leaderFromProto(leader)
+	})
+}
+
+func leaderFromProto(leader *pb.ElectionLeader) Leader {
+	return Leader{
+		Candidate: leader.GetCandidate(),
+		Revision:  leader.GetRevision(),
+	}
+}
@@ -0,0 +1,67 @@
+package transport
+
+import (
+	pb "censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func (m *mockStore) Leader(ctx context.Context, in *pb.LeaderRequest, opts ...grpc.CallOption) (*pb.LeaderResponse, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &pb.LeaderResponse{
+		Leader:  &pb.ElectionLeader{Candidate: m.value, Lease: 1, Revision: 7},
+		Success: true,
+	}, nil
+}
+
+// Test the HandleLeader function
+func TestHandleLeader(t *testing.T) {
+	tests := []struct {
+		name           string
+		leader         string
+		wantCode       int
+		wantResp       string
+		grpcStoreError error
+	}{
+		{
+			name:     "leader",
+			leader:   "replica-1",
+			wantCode: http.StatusOK,
+			wantResp: "{\"candidate\":\"replica-1\",\"revision\":7}\n",
+		},
+		{
+			name:           "no leader",
+			wantCode:       http.StatusNotFound,
+			wantResp:       "{\"type\":\"about:blank\",\"title\":\"Not Found\",\"status\":404,\"detail\":\"election has no leader\",\"code\":\"not_found\"}\n",
+			grpcStoreError: status.Errorf(codes.NotFound, "election has no leader"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/elections/compaction", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.SetPathValue("election", "compaction")
+
+			s := &GrpcServer{Store: &mockStore{value: tt.leader, err: tt.grpcStoreError}}
+			s.HandleLeader(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("HandleLeader() wrote code %d, want %d", w.Code, tt.wantCode)
+			}
+			if w.Body.String() != tt.wantResp {
+				t.Errorf("HandleLeader() response = %s, want %s", w.Body.String(), tt.wantResp)
+			}
+		})
+	}
+}
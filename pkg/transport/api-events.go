@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"censys/pkg/util"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+)
+
+// streamEvents relays the messages of a gRPC stream as server-sent events
+// with the given event name, converting each message with convert. It
+// returns when the client goes away or the stream ends, sending a final
+// error event if the stream failed.
+func streamEvents[T any](w http.ResponseWriter, r *http.Request, stream grpc.ServerStreamingClient[T], event string, convert func(*T) any) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		util.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// The kvstore sends headers once the stream is established, so a missing
+	// header means the request was rejected and Recv returns the reason
+	if header, _ := stream.Header(); header == nil {
+		_, err := stream.Recv()
+		util.HandleGrpcError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF && r.Context().Err() == nil {
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", status.Convert(err).Message())
+				flusher.Flush()
+			}
+			return
+		}
+
+		data, err := json.Marshal(convert(msg))
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+		flusher.Flush()
+	}
+}
@@ -1,31 +1,111 @@
 package transport
 
 import (
+	"censys/pkg/nearcache"
+	"censys/pkg/singleflight"
 	"censys/pkg/util"
 	pb "censys/proto/gen/proto"
 	"context"
 	"encoding/json"
+	"io"
+	"mime"
 	"net/http"
 )
 
 // GrpcServer represents the gRPC server
 type GrpcServer struct {
 	Store pb.KvStoreServiceClient
+	// Policy validates key-value pairs before they are sent to the kvstore.
+	// util.DefaultPolicy is used when nil.
+	Policy *util.Policy
+	// Cache serves reads of hot keys without calling the kvstore. Caching is
+	// disabled when nil.
+	Cache *nearcache.Cache
+
+	// reads coalesces concurrent reads of the same key into one Get call
+	reads singleflight.Group[string]
+}
+
+// policy returns the validation policy of the server
+func (s *GrpcServer) policy() util.Policy {
+	if s.Policy != nil {
+		return *s.Policy
+	}
+	return util.DefaultPolicy
+}
+
+// get reads the value of a key, from the cache when enabled. Reserved keys
+// are never cached. Concurrent reads of a key share a single Get call.
+func (s *GrpcServer) get(ctx context.Context, namespace string, key string) (string, error) {
+	load := func(ctx context.Context) (string, error) {
+		return s.reads.Do(ctx, readKey(namespace, key), func(ctx context.Context) (string, error) {
+			resp, err := s.Store.Get(ctx, &pb.GetRequest{
+				Key:       key,
+				Namespace: namespace,
+			})
+			if err != nil {
+				return "", err
+			}
+			return resp.Value, nil
+		})
+	}
+	if s.Cache == nil || isReserved(key) {
+		return load(ctx)
+	}
+	return s.Cache.Get(ctx, namespace, key, load)
+}
+
+// invalidate drops a key written through the API from the cache and from
+// the reads in flight, so reads served by this replica see the write without
+// waiting for the kvstore invalidation
+func (s *GrpcServer) invalidate(namespace string, key string) {
+	s.reads.Forget(readKey(namespace, key))
+	if s.Cache != nil {
+		s.Cache.Invalidate(namespace, key)
+	}
 }
 
-// HandleGet handles GET requests to retrieve a value from the store
+// Forget drops the reads in flight of a key written through another
+// replica, as reported by the kvstore invalidation stream. An empty key
+// drops the reads of a whole namespace, and an empty namespace and key
+// every read.
+func (s *GrpcServer) Forget(namespace string, key string) {
+	switch {
+	case namespace == "" && key == "":
+		s.reads.ForgetPrefix("")
+	case key == "":
+		s.reads.ForgetPrefix(readKey(namespace, ""))
+	default:
+		s.reads.Forget(readKey(namespace, key))
+	}
+}
+
+// CoalescingStats returns the statistics of read coalescing
+func (s *GrpcServer) CoalescingStats() singleflight.Stats {
+	return s.reads.Stats()
+}
+
+// HandleGet handles GET requests to retrieve a value from the store. When a
+// path query parameter is given the value is treated as a JSON document and
+// only the selected part of it is returned.
 func (s *GrpcServer) HandleGet(w http.ResponseWriter, r *http.Request) {
-	// Extract key from query parameter or request body
+	// Extract key from query parameter or request path
 	key := r.URL.Query().Get("key")
 	if key == "" {
-		http.Error(w, "Missing key", http.StatusBadRequest)
+		key = r.PathValue("key")
+	}
+	if key == "" {
+		util.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+
+	if path := r.URL.Query().Get("path"); path != "" {
+		s.handleGetPath(w, r, key, path)
 		return
 	}
 
 	// Make gRPC call to retrieve value
-	resp, err := s.Store.Get(context.Background(), &pb.GetRequest{
-		Key: key,
-	})
+	value, err := s.get(r.Context(), r.PathValue("namespace"), key)
 
 	// Handle error and return appropriate http status code
 	if util.HandleGrpcError(w, err) {
@@ -35,10 +115,34 @@ func (s *GrpcServer) HandleGet(w http.ResponseWriter, r *http.Request) {
 	// Successful response
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(map[string]string{
-		"value": resp.Value,
+		"value": value,
 	})
 	if err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		util.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// handleGetPath retrieves the part of a JSON document value selected by path
+func (s *GrpcServer) handleGetPath(w http.ResponseWriter, r *http.Request, key string, path string) {
+	// Make gRPC call to retrieve the value at path
+	resp, err := s.Store.GetPath(r.Context(), &pb.GetPathRequest{
+		Key:       key,
+		Namespace: r.PathValue("namespace"),
+		Path:      path,
+	})
+
+	// Handle error and return appropriate http status code
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+
+	// Successful response
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]json.RawMessage{
+		"value": json.RawMessage(resp.Value),
+	})
+	if err != nil {
+		util.Error(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
 
@@ -46,23 +150,23 @@ func (s *GrpcServer) HandleGet(w http.ResponseWriter, r *http.Request) {
 func (s *GrpcServer) HandleSet(w http.ResponseWriter, r *http.Request) {
 	// Decode request body
 	var req KvPair
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		http.Error(w, "Failed to decode request", http.StatusInternalServerError)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Validate key and value
-	if util.ValidateKvPair(req.Key, req.Value) != nil {
-		http.Error(w, "Invalid key/value pair", http.StatusBadRequest)
+	namespace := r.PathValue("namespace")
+	if !s.validatePair(w, namespace, req.Key, req.Value) {
 		return
 	}
 
 	// Make gRPC call to set value
-	resp, err := s.Store.Set(context.Background(), &pb.SetRequest{
-		Key:   req.Key,
-		Value: req.Value,
+	resp, err := s.Store.Set(r.Context(), &pb.SetRequest{
+		Key:       req.Key,
+		Value:     req.Value,
+		Namespace: namespace,
 	})
+	s.invalidate(namespace, req.Key)
 
 	// Handle error and return appropriate http status code
 	if util.HandleGrpcError(w, err) {
@@ -75,7 +179,7 @@ func (s *GrpcServer) HandleSet(w http.ResponseWriter, r *http.Request) {
 		"success": resp.Success, // Use the boolean from SetResponse
 	})
 	if err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		util.Error(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
 
@@ -85,14 +189,16 @@ func (s *GrpcServer) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	key := r.PathValue("key")
 
 	if key == "" {
-		http.Error(w, "Missing key", http.StatusBadRequest)
+		util.Error(w, "Missing key", http.StatusBadRequest)
 		return
 	}
 
 	// Make gRPC call to delete value
-	resp, err := s.Store.Delete(context.Background(), &pb.DeleteRequest{
-		Key: key,
+	resp, err := s.Store.Delete(r.Context(), &pb.DeleteRequest{
+		Key:       key,
+		Namespace: r.PathValue("namespace"),
 	})
+	s.invalidate(r.PathValue("namespace"), key)
 
 	// Handle error and return appropriate http status code
 	if util.HandleGrpcError(w, err) {
@@ -105,6 +211,99 @@ func (s *GrpcServer) HandleDelete(w http.ResponseWriter, r *http.Request) {
 		"success": resp.Success,
 	})
 	if err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		util.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// HandlePatch handles PATCH requests that apply a JSON Patch
+// (application/json-patch+json) or a JSON Merge Patch
+// (application/merge-patch+json) to a JSON document value
+func (s *GrpcServer) HandlePatch(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if key == "" {
+		util.Error(w, "Missing key", http.StatusBadRequest)
+		return
+	}
+
+	// Select the patch format from the content type
+	var patchType pb.PatchType
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json-patch+json":
+		patchType = pb.PatchType_JSON_PATCH
+	case "application/merge-patch+json":
+		patchType = pb.PatchType_MERGE_PATCH
+	default:
+		util.Error(w, "Unsupported patch content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		util.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	// Make gRPC call to patch the document
+	resp, err := s.Store.Patch(r.Context(), &pb.PatchRequest{
+		Key:       key,
+		Namespace: r.PathValue("namespace"),
+		Type:      patchType,
+		Patch:     string(patch),
+	})
+	s.invalidate(r.PathValue("namespace"), key)
+
+	// Handle error and return appropriate http status code
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+
+	// Successful response
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]json.RawMessage{
+		"value": json.RawMessage(resp.Value),
+	})
+	if err != nil {
+		util.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// HandleSetPath handles PUT requests that set the nested field of a JSON
+// document value selected by the path query parameter to the JSON body
+func (s *GrpcServer) HandleSetPath(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	path := r.URL.Query().Get("path")
+	if key == "" || path == "" {
+		util.Error(w, "Missing key or path", http.StatusBadRequest)
+		return
+	}
+
+	value, err := io.ReadAll(r.Body)
+	if err != nil {
+		util.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	// Make gRPC call to set the value at path
+	resp, err := s.Store.SetPath(r.Context(), &pb.SetPathRequest{
+		Key:       key,
+		Namespace: r.PathValue("namespace"),
+		Path:      path,
+		Value:     string(value),
+	})
+	s.invalidate(r.PathValue("namespace"), key)
+
+	// Handle error and return appropriate http status code
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+
+	// Successful response
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]json.RawMessage{
+		"value": json.RawMessage(resp.Value),
+	})
+	if err != nil {
+		util.Error(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
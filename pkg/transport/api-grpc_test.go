@@ -2,6 +2,7 @@ package transport
 
 import (
 	"bytes"
+	"censys/pkg/util"
 	pb "censys/proto/gen/proto"
 	"context"
 	"encoding/json"
@@ -10,14 +11,21 @@ import (
 	"google.golang.org/grpc/status"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // Create a mock store
 type mockStore struct {
+	pb.KvStoreServiceClient
 	err     error
 	value   string
 	success bool
+	stream  *mockSubscribeClient
+	// gets counts the Get calls
+	gets int
 }
 
 func (m *mockStore) Set(ctx context.Context, in *pb.SetRequest, opts ...grpc.CallOption) (*pb.SetResponse, error) {
@@ -35,6 +43,7 @@ func (m *mockStore) Delete(ctx context.Context, in *pb.DeleteRequest, opts ...gr
 }
 
 func (m *mockStore) Get(ctx context.Context, in *pb.GetRequest, opts ...grpc.CallOption) (*pb.GetResponse, error) {
+	m.gets++
 	if m.err != nil {
 		return nil, m.err
 	}
@@ -186,6 +195,29 @@ func TestHandleSet(t *testing.T) {
 	}
 }
 
+// Test that HandleSet rejects a malformed body as a bad request
+func TestHandleSet_MalformedBody(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/store", bytes.NewBufferString("{\"key\":"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &GrpcServer{Store: &mockStore{success: true}}
+	s.HandleSet(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("HandleSet() wrote code %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	var problem util.Problem
+	if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Code != util.CodeMalformedRequest {
+		t.Errorf("HandleSet() error code = %s, want %s", problem.Code, util.CodeMalformedRequest)
+	}
+}
+
 // Test the HandleDelete function
 func TestHandleDelete(t *testing.T) {
 	tests := []struct {
@@ -253,3 +285,172 @@ func TestHandleDelete(t *testing.T) {
 		})
 	}
 }
+
+func (m *mockStore) GetPath(ctx context.Context, in *pb.GetPathRequest, opts ...grpc.CallOption) (*pb.GetPathResponse, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &pb.GetPathResponse{Value: m.value, Success: true}, nil
+}
+
+func (m *mockStore) Patch(ctx context.Context, in *pb.PatchRequest, opts ...grpc.CallOption) (*pb.PatchResponse, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &pb.PatchResponse{Value: m.value, Success: true}, nil
+}
+
+// Test the HandleGet function with a document path
+func TestHandleGetPath(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/store/test-key?path=$.user.email", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetPathValue("key", "test-key")
+
+	s := &GrpcServer{Store: &mockStore{value: `"a@b.c"`}}
+	s.HandleGet(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("HandleGet() wrote code %d, want %d", w.Code, http.StatusOK)
+	}
+	if resp := w.Body.String(); resp != "{\"value\":\"a@b.c\"}\n" {
+		t.Errorf("HandleGet() response = %s", resp)
+	}
+}
+
+// Test the HandlePatch function
+func TestHandlePatch(t *testing.T) {
+	tests := []struct {
+		name           string
+		contentType    string
+		wantCode       int
+		wantResp       string
+		grpcStoreError error
+	}{
+		{
+			name:        "json patch",
+			contentType: "application/json-patch+json",
+			wantCode:    http.StatusOK,
+			wantResp:    "{\"value\":{\"a\":1}}\n",
+		},
+		{
+			name:        "merge patch",
+			contentType: "application/merge-patch+json; charset=utf-8",
+			wantCode:    http.StatusOK,
+			wantResp:    "{\"value\":{\"a\":1}}\n",
+		},
+		{
+			name:        "unsupported content type",
+			contentType: "application/json",
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "key not found",
+			contentType:    "application/merge-patch+json",
+			grpcStoreError: status.Errorf(codes.NotFound, "key not found"),
+			wantCode:       http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("PATCH", "/store/test-key", bytes.NewBufferString(`{"a":1}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.SetPathValue("key", "test-key")
+			req.Header.Set("Content-Type", tt.contentType)
+
+			s := &GrpcServer{Store: &mockStore{value: `{"a":1}`, err: tt.grpcStoreError}}
+			s.HandlePatch(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("HandlePatch() wrote code %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantResp != "" && w.Body.String() != tt.wantResp {
+				t.Errorf("HandlePatch() response = %s, want %s", w.Body.String(), tt.wantResp)
+			}
+		})
+	}
+}
+
+// blockingStore answers Get calls once released
+type blockingStore struct {
+	pb.KvStoreServiceClient
+	release chan struct{}
+	gets    atomic.Int32
+}
+
+func (b *blockingStore) Get(ctx context.Context, in *pb.GetRequest, opts ...grpc.CallOption) (*pb.GetResponse, error) {
+	b.gets.Add(1)
+	select {
+	case <-b.release:
+		return &pb.GetResponse{Value: "test-value", Success: true}, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func TestHandleGetCoalescing(t *testing.T) {
+	store := &blockingStore{release: make(chan struct{})}
+	server := &GrpcServer{Store: store}
+
+	const callers = 5
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			server.HandleGet(w, httptest.NewRequest("GET", "/store?key=test-key", nil))
+			if w.Code != http.StatusOK || w.Body.String() != "{\"value\":\"test-value\"}\n" {
+				t.Errorf("HandleGet() = %d %s", w.Code, w.Body)
+			}
+		}()
+	}
+	for server.CoalescingStats().Calls < callers {
+		time.Sleep(time.Millisecond)
+	}
+	close(store.release)
+	wg.Wait()
+
+	if gets := store.gets.Load(); gets != 1 {
+		t.Errorf("Get calls = %d, want 1", gets)
+	}
+	if stats := server.CoalescingStats(); stats.Coalesced != callers-1 {
+		t.Errorf("CoalescingStats() = %+v, want %d coalesced", stats, callers-1)
+	}
+}
+
+func TestGrpcServer_Forget(t *testing.T) {
+	store := &blockingStore{release: make(chan struct{})}
+	server := &GrpcServer{Store: store}
+
+	var wg sync.WaitGroup
+	read := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := server.get(context.Background(), "", "test-key"); err != nil || value != "test-value" {
+				t.Errorf("get() = %q, %v", value, err)
+			}
+		}()
+	}
+	read()
+	for store.gets.Load() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A write through another replica makes the read in flight stale, so
+	// the next read does not share it
+	server.Forget("default", "test-key")
+	read()
+	for store.gets.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(store.release)
+	wg.Wait()
+}
@@ -0,0 +1,170 @@
+package transport
+
+import (
+	"censys/pkg/openapi"
+	"censys/pkg/util"
+	"net/http"
+	"strconv"
+)
+
+// OpenAPI returns the compiled OpenAPI document of the /v1 API. Routes are
+// served within the default namespace and within named namespaces.
+func OpenAPI() (*openapi.Document, error) {
+	doc := &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       "Key Value Store API",
+			Description: "Stores and retrieves key-value pairs",
+			Version:     "1.0.0",
+		},
+		Paths: make(map[string]openapi.PathItem),
+		Components: openapi.Components{
+			Schemas: map[string]*openapi.Schema{
+				"KeyValue": {
+					Type: "object",
+					Properties: map[string]*openapi.Schema{
+						"key":   {Type: "string"},
+						"value": {Type: "string"},
+					},
+					Required: []string{"key", "value"},
+				},
+				"Value": {
+					Type: "object",
+					Properties: map[string]*openapi.Schema{
+						"value": {Type: "string"},
+					},
+					Required:             []string{"value"},
+					AdditionalProperties: openapi.Bool(false),
+				},
+				"KeyList": {
+					Type: "object",
+					Properties: map[string]*openapi.Schema{
+						"keys": {Type: "array", Items: &openapi.Schema{Type: "string"}},
+					},
+					Required: []string{"keys"},
+				},
+				"Problem": {
+					Type:        "object",
+					Description: "RFC 7807 problem details",
+					Properties: map[string]*openapi.Schema{
+						"type":       {Type: "string"},
+						"title":      {Type: "string"},
+						"status":     {Type: "integer"},
+						"detail":     {Type: "string"},
+						"code":       {Type: "string", Description: "Stable machine-readable error code"},
+						"request_id": {Type: "string"},
+						"errors": {
+							Type: "array",
+							Items: &openapi.Schema{
+								Type: "object",
+								Properties: map[string]*openapi.Schema{
+									"field":       {Type: "string"},
+									"description": {Type: "string"},
+								},
+							},
+						},
+					},
+					Required: []string{"type", "status", "code"},
+				},
+			},
+		},
+	}
+
+	for _, namespaced := range []bool{false, true} {
+		prefix, suffix := "/v1", ""
+		var params []openapi.Parameter
+		if namespaced {
+			prefix, suffix = "/v1/namespaces/{namespace}", "InNamespace"
+			params = []openapi.Parameter{{
+				Name:     "namespace",
+				In:       "path",
+				Required: true,
+				Schema:   &openapi.Schema{Type: "string", MinLength: 1},
+			}}
+		}
+		// with returns the namespace parameter, if any, followed by more
+		with := func(more ...openapi.Parameter) []openapi.Parameter {
+			return append(append([]openapi.Parameter{}, params...), more...)
+		}
+		key := with(openapi.Parameter{
+			Name:     "key",
+			In:       "path",
+			Required: true,
+			Schema:   &openapi.Schema{Type: "string", MinLength: 1},
+		})
+
+		doc.Paths[prefix+"/keys"] = openapi.PathItem{
+			"get": {
+				OperationID: "listKeys" + suffix,
+				Summary:     "List keys in ascending order",
+				Parameters: with(
+					openapi.Parameter{
+						Name:        "prefix",
+						In:          "query",
+						Description: "Only list keys starting with prefix",
+						Schema:      &openapi.Schema{Type: "string"},
+					},
+					openapi.Parameter{
+						Name:        "limit",
+						In:          "query",
+						Description: "Maximum number of keys listed",
+						Schema:      &openapi.Schema{Type: "integer", Minimum: openapi.Number(1)},
+					},
+				),
+				Responses: responses(http.StatusOK, "KeyList"),
+			},
+		}
+		doc.Paths[prefix+"/keys/{key}"] = openapi.PathItem{
+			"get": {
+				OperationID: "getKey" + suffix,
+				Summary:     "Get the value of a key",
+				Parameters:  key,
+				Responses:   responses(http.StatusOK, "KeyValue"),
+			},
+			"put": {
+				OperationID: "putKey" + suffix,
+				Summary:     "Set the value of a key",
+				Parameters:  key,
+				RequestBody: &openapi.RequestBody{
+					Required: true,
+					Content: map[string]openapi.MediaType{
+						"application/json": {Schema: openapi.Ref("Value")},
+					},
+				},
+				Responses: responses(http.StatusOK, "KeyValue"),
+			},
+			"delete": {
+				OperationID: "deleteKey" + suffix,
+				Summary:     "Delete a key",
+				Parameters:  key,
+				Responses:   responses(http.StatusNoContent, ""),
+			},
+		}
+	}
+	if err := doc.Compile(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// responses describes the successful response of an operation with the
+// given schema, or without a body for an empty schema, and its errors
+func responses(status int, schema string) map[string]openapi.Response {
+	success := openapi.Response{
+		Description: http.StatusText(status),
+	}
+	if schema != "" {
+		success.Content = map[string]openapi.MediaType{
+			"application/json": {Schema: openapi.Ref(schema)},
+		}
+	}
+	return map[string]openapi.Response{
+		strconv.Itoa(status): success,
+		"default": {
+			Description: "Error",
+			Content: map[string]openapi.MediaType{
+				util.ProblemContentType: {Schema: openapi.Ref("Problem")},
+			},
+		},
+	}
+}
@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"censys/pkg/util"
+	pb "censys/proto/gen/proto"
+	"net/http"
+)
+
+// PublishMessage is the request body used to publish a message
+type PublishMessage struct {
+	Message string `json:"message"`
+}
+
+// ChannelMessage is a message delivered to a subscriber
+type ChannelMessage struct {
+	Channel string `json:"channel"`
+	Pattern string `json:"pattern,omitempty"`
+	Message string `json:"message"`
+}
+
+// HandlePublish handles POST requests to publish a message to a channel
+func (s *GrpcServer) HandlePublish(w http.ResponseWriter, r *http.Request) {
+	var req PublishMessage
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	resp, err := s.Store.Publish(r.Context(), &pb.PublishRequest{
+		Channel:   r.PathValue("channel"),
+		Namespace: r.PathValue("namespace"),
+		Message:   req.Message,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]int64{
+		"receivers": resp.Receivers,
+	})
+}
+
+// HandleSubscribe handles GET requests subscribing to the channels and
+// patterns given as channel and pattern query parameters. Messages are
+// streamed as server-sent events until the client goes away or falls too
+// far behind, in which case a final error event is sent.
+func (s *GrpcServer) HandleSubscribe(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	stream, err := s.Store.Subscribe(r.Context(), &pb.SubscribeRequest{
+		Channels:  query["channel"],
+		Patterns:  query["pattern"],
+		Namespace: r.PathValue("namespace"),
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+
+	streamEvents(w, r, stream, "message", func(msg *pb.Message) any {
+		return ChannelMessage{
+			Channel: msg.Channel,
+			Pattern: msg.Pattern,
+			Message: msg.Message,
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package transport
+
+import (
+	pb "censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type mockSubscribeClient struct {
+	grpc.ClientStream
+	header   metadata.MD
+	messages []*pb.Message
+	err      error
+}
+
+func (m *mockSubscribeClient) Header() (metadata.MD, error) {
+	return m.header, nil
+}
+
+func (m *mockSubscribeClient) Recv() (*pb.Message, error) {
+	if len(m.messages) == 0 {
+		return nil, m.err
+	}
+	msg := m.messages[0]
+	m.messages = m.messages[1:]
+	return msg, nil
+}
+
+func (m *mockStore) Subscribe(ctx context.Context, in *pb.SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.Message], error) {
+	return m.stream, nil
+}
+
+// Test the HandleSubscribe function
+func TestHandleSubscribe(t *testing.T) {
+	tests := []struct {
+		name     string
+		stream   *mockSubscribeClient
+		wantCode int
+		wantResp string
+	}{
+		{
+			name: "messages until the stream ends",
+			stream: &mockSubscribeClient{
+				header: metadata.MD{},
+				messages: []*pb.Message{
+					{Channel: "news", Message: "hello"},
+					{Channel: "news.sport", Pattern: "news.*", Message: "goal"},
+				},
+				err: io.EOF,
+			},
+			wantCode: http.StatusOK,
+			wantResp: "event: message\ndata: {\"channel\":\"news\",\"message\":\"hello\"}\n\n" +
+				"event: message\ndata: {\"channel\":\"news.sport\",\"pattern\":\"news.*\",\"message\":\"goal\"}\n\n",
+		},
+		{
+			name: "slow consumer",
+			stream: &mockSubscribeClient{
+				header: metadata.MD{},
+				err:    status.Errorf(codes.ResourceExhausted, "subscriber disconnected for falling behind"),
+			},
+			wantCode: http.StatusOK,
+			wantResp: "event: error\ndata: subscriber disconnected for falling behind\n\n",
+		},
+		{
+			name: "rejected subscription",
+			stream: &mockSubscribeClient{
+				err: status.Errorf(codes.InvalidArgument, "invalid channel pattern"),
+			},
+			wantCode: http.StatusBadRequest,
+			wantResp: "{\"type\":\"about:blank\",\"title\":\"Bad Request\",\"status\":400,\"detail\":\"invalid channel pattern\",\"code\":\"invalid_argument\"}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/channels?pattern=news.*", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := &GrpcServer{Store: &mockStore{stream: tt.stream}}
+			s.HandleSubscribe(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("HandleSubscribe() wrote code %d, want %d", w.Code, tt.wantCode)
+			}
+			if w.Body.String() != tt.wantResp {
+				t.Errorf("HandleSubscribe() response = %q, want %q", w.Body.String(), tt.wantResp)
+			}
+		})
+	}
+}
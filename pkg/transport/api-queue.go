@@ -0,0 +1,155 @@
+package transport
+
+import (
+	"censys/pkg/util"
+	pb "censys/proto/gen/proto"
+	"net/http"
+)
+
+// EnqueueMessage is the request body used to enqueue a message
+type EnqueueMessage struct {
+	Body    string `json:"body"`
+	DelayMs int64  `json:"delay_ms"`
+}
+
+// DequeueOptions is the request body used to receive messages
+type DequeueOptions struct {
+	MaxMessages         int64 `json:"max_messages"`
+	VisibilityTimeoutMs int64 `json:"visibility_timeout_ms"`
+}
+
+// QueueConfig is the request body used to configure a queue
+type QueueConfig struct {
+	MaxAttempts int64 `json:"max_attempts"`
+}
+
+// QueueMessage is a message received from a queue
+type QueueMessage struct {
+	ID       string `json:"id"`
+	Body     string `json:"body"`
+	Attempts int64  `json:"attempts"`
+	Receipt  string `json:"receipt"`
+}
+
+// QueueReceipt is the request body used to ack or nack a received message
+type QueueReceipt struct {
+	Receipt string `json:"receipt"`
+	DelayMs int64  `json:"delay_ms"`
+}
+
+// HandleEnqueue handles POST requests to add a message to a queue
+func (s *GrpcServer) HandleEnqueue(w http.ResponseWriter, r *http.Request) {
+	var req EnqueueMessage
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	resp, err := s.Store.Enqueue(r.Context(), &pb.EnqueueRequest{
+		Name:      r.PathValue("name"),
+		Namespace: r.PathValue("namespace"),
+		Body:      req.Body,
+		DelayMs:   req.DelayMs,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]string{
+		"id": resp.Id,
+	})
+}
+
+// HandleDequeue handles POST requests to receive messages from a queue. The
+// request body is optional.
+func (s *GrpcServer) HandleDequeue(w http.ResponseWriter, r *http.Request) {
+	var req DequeueOptions
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+
+	resp, err := s.Store.Dequeue(r.Context(), &pb.DequeueRequest{
+		Name:                r.PathValue("name"),
+		Namespace:           r.PathValue("namespace"),
+		MaxMessages:         req.MaxMessages,
+		VisibilityTimeoutMs: req.VisibilityTimeoutMs,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+
+	messages := make([]QueueMessage, 0, len(resp.Messages))
+	for _, m := range resp.Messages {
+		messages = append(messages, QueueMessage{
+			ID:       m.Id,
+			Body:     m.Body,
+			Attempts: m.Attempts,
+			Receipt:  m.Receipt,
+		})
+	}
+	writeJSON(w, map[string]any{
+		"messages":      messages,
+		"dead_lettered": resp.DeadLettered,
+	})
+}
+
+// HandleConfigureQueue handles PUT requests setting the config of a queue
+func (s *GrpcServer) HandleConfigureQueue(w http.ResponseWriter, r *http.Request) {
+	var req QueueConfig
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	resp, err := s.Store.ConfigureQueue(r.Context(), &pb.ConfigureQueueRequest{
+		Name:        r.PathValue("name"),
+		Namespace:   r.PathValue("namespace"),
+		MaxAttempts: req.MaxAttempts,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]bool{
+		"success": resp.Success,
+	})
+}
+
+// HandleAck handles POST requests acknowledging a processed message
+func (s *GrpcServer) HandleAck(w http.ResponseWriter, r *http.Request) {
+	var req QueueReceipt
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	resp, err := s.Store.Ack(r.Context(), &pb.AckRequest{
+		Name:      r.PathValue("name"),
+		Namespace: r.PathValue("namespace"),
+		Id:        r.PathValue("id"),
+		Receipt:   req.Receipt,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]bool{
+		"success": resp.Success,
+	})
+}
+
+// HandleNack handles POST requests returning a message to its queue
+func (s *GrpcServer) HandleNack(w http.ResponseWriter, r *http.Request) {
+	var req QueueReceipt
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	resp, err := s.Store.Nack(r.Context(), &pb.NackRequest{
+		Name:      r.PathValue("name"),
+		Namespace: r.PathValue("namespace"),
+		Id:        r.PathValue("id"),
+		Receipt:   req.Receipt,
+		DelayMs:   req.DelayMs,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]bool{
+		"success": resp.Success,
+	})
+}
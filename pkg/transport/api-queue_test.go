@@ -0,0 +1,79 @@
+package transport
+
+import (
+	"bytes"
+	pb "censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func (m *mockStore) Dequeue(ctx context.Context, in *pb.DequeueRequest, opts ...grpc.CallOption) (*pb.DequeueResponse, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	messages := []*pb.QueueMessage{}
+	for i := int64(0); i < in.MaxMessages; i++ {
+		messages = append(messages, &pb.QueueMessage{Id: "id", Body: "job", Attempts: 1, Receipt: "receipt"})
+	}
+	return &pb.DequeueResponse{Messages: messages}, nil
+}
+
+// Test the HandleDequeue function
+func TestHandleDequeue(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		wantCode       int
+		wantResp       string
+		grpcStoreError error
+	}{
+		{
+			name:     "empty body",
+			wantCode: http.StatusOK,
+			wantResp: "{\"dead_lettered\":0,\"messages\":[]}\n",
+		},
+		{
+			name:     "max messages",
+			body:     `{"max_messages": 1}`,
+			wantCode: http.StatusOK,
+			wantResp: "{\"dead_lettered\":0,\"messages\":[{\"id\":\"id\",\"body\":\"job\",\"attempts\":1,\"receipt\":\"receipt\"}]}\n",
+		},
+		{
+			name:     "invalid body",
+			body:     `{"max_messages": "all"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid options",
+			body:           `{"max_messages": -1}`,
+			wantCode:       http.StatusBadRequest,
+			grpcStoreError: status.Errorf(codes.InvalidArgument, "max messages cannot be negative"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/queues/jobs/dequeue", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.SetPathValue("name", "jobs")
+
+			s := &GrpcServer{Store: &mockStore{err: tt.grpcStoreError}}
+			s.HandleDequeue(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("HandleDequeue() wrote code %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantResp != "" && w.Body.String() != tt.wantResp {
+				t.Errorf("HandleDequeue() response = %s, want %s", w.Body.String(), tt.wantResp)
+			}
+		})
+	}
+}
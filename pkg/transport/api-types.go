@@ -0,0 +1,488 @@
+package transport
+
+import (
+	"censys/pkg/util"
+	pb "censys/proto/gen/proto"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// HashFields is the request body used to set hash fields
+type HashFields struct {
+	Fields map[string]string `json:"fields"`
+}
+
+// ListValues is the request body used to push onto or pop from a list
+type ListValues struct {
+	Values []string `json:"values"`
+	End    string   `json:"end"`
+	Count  int64    `json:"count"`
+}
+
+// SetMembers is the request body used to add members to a set
+type SetMembers struct {
+	Members []string `json:"members"`
+}
+
+// ScoredMember is a sorted set member with its score
+type ScoredMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// SortedSetMembers is the request body used to add members to a sorted set
+type SortedSetMembers struct {
+	Members []ScoredMember `json:"members"`
+}
+
+// HandleHashSet handles POST requests to set fields of a hash
+func (s *GrpcServer) HandleHashSet(w http.ResponseWriter, r *http.Request) {
+	var req HashFields
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Fields) == 0 {
+		util.Error(w, "Missing fields", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.Store.HashSet(r.Context(), &pb.HashSetRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+		Fields:    req.Fields,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]int64{
+		"added": resp.Added,
+	})
+}
+
+// HandleHashGet handles GET requests for a single hash field
+func (s *GrpcServer) HandleHashGet(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.Store.HashGet(r.Context(), &pb.HashGetRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+		Field:     r.PathValue("field"),
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]string{
+		"value": resp.Value,
+	})
+}
+
+// HandleHashGetAll handles GET requests for every field of a hash
+func (s *GrpcServer) HandleHashGetAll(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.Store.HashGetAll(r.Context(), &pb.HashGetAllRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+
+	fields := resp.Fields
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	writeJSON(w, map[string]map[string]string{
+		"fields": fields,
+	})
+}
+
+// HandleHashDelete handles DELETE requests for a hash field
+func (s *GrpcServer) HandleHashDelete(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.Store.HashDelete(r.Context(), &pb.HashDeleteRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+		Fields:    []string{r.PathValue("field")},
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]int64{
+		"removed": resp.Removed,
+	})
+}
+
+// HandleListPush handles POST requests to push values onto a list
+func (s *GrpcServer) HandleListPush(w http.ResponseWriter, r *http.Request) {
+	var req ListValues
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	end, ok := parseListEnd(req.End)
+	if !ok || len(req.Values) == 0 {
+		util.Error(w, "Missing values or invalid end", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.Store.ListPush(r.Context(), &pb.ListPushRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+		Values:    req.Values,
+		End:       end,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]int64{
+		"length": resp.Length,
+	})
+}
+
+// HandleListPop handles POST requests to pop values from a list
+func (s *GrpcServer) HandleListPop(w http.ResponseWriter, r *http.Request) {
+	var req ListValues
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	end, ok := parseListEnd(req.End)
+	if !ok || req.Count < 0 {
+		util.Error(w, "Invalid end or count", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.Store.ListPop(r.Context(), &pb.ListPopRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+		End:       end,
+		Count:     req.Count,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string][]string{
+		"values": nonNil(resp.Values),
+	})
+}
+
+// HandleListRange handles GET requests for a range of list elements. The
+// start and stop query parameters default to the whole list.
+func (s *GrpcServer) HandleListRange(w http.ResponseWriter, r *http.Request) {
+	start, startErr := queryInt(r, "start", 0)
+	stop, stopErr := queryInt(r, "stop", -1)
+	if startErr != nil || stopErr != nil {
+		util.Error(w, "Invalid start or stop", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.Store.ListRange(r.Context(), &pb.ListRangeRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+		Start:     start,
+		Stop:      stop,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string][]string{
+		"values": nonNil(resp.Values),
+	})
+}
+
+// HandleSetAdd handles POST requests to add members to a set
+func (s *GrpcServer) HandleSetAdd(w http.ResponseWriter, r *http.Request) {
+	var req SetMembers
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Members) == 0 {
+		util.Error(w, "Missing members", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.Store.SetAdd(r.Context(), &pb.SetAddRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+		Members:   req.Members,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]int64{
+		"added": resp.Added,
+	})
+}
+
+// HandleSetRemove handles DELETE requests for a set member
+func (s *GrpcServer) HandleSetRemove(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.Store.SetRemove(r.Context(), &pb.SetRemoveRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+		Members:   []string{r.PathValue("member")},
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]int64{
+		"removed": resp.Removed,
+	})
+}
+
+// HandleSetMembers handles GET requests for the members of a set
+func (s *GrpcServer) HandleSetMembers(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.Store.SetMembers(r.Context(), &pb.SetMembersRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string][]string{
+		"members": nonNil(resp.Members),
+	})
+}
+
+// HandleSetIntersect handles GET requests for the intersection of the sets
+// named by the repeated intersect query parameter
+func (s *GrpcServer) HandleSetIntersect(w http.ResponseWriter, r *http.Request) {
+	keys := r.URL.Query()["intersect"]
+	if len(keys) == 0 {
+		util.Error(w, "Missing intersect keys", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.Store.SetIntersect(r.Context(), &pb.SetIntersectRequest{
+		Keys:      keys,
+		Namespace: r.PathValue("namespace"),
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string][]string{
+		"members": nonNil(resp.Members),
+	})
+}
+
+// HandleSortedSetAdd handles POST requests to add members to a sorted set
+func (s *GrpcServer) HandleSortedSetAdd(w http.ResponseWriter, r *http.Request) {
+	var req SortedSetMembers
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.Members) == 0 {
+		util.Error(w, "Missing members", http.StatusBadRequest)
+		return
+	}
+
+	members := make([]*pb.ScoredMember, 0, len(req.Members))
+	for _, m := range req.Members {
+		members = append(members, &pb.ScoredMember{
+			Member: m.Member,
+			Score:  m.Score,
+		})
+	}
+
+	resp, err := s.Store.SortedSetAdd(r.Context(), &pb.SortedSetAddRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+		Members:   members,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]int64{
+		"added": resp.Added,
+	})
+}
+
+// HandleSortedSetRemove handles DELETE requests for a sorted set member
+func (s *GrpcServer) HandleSortedSetRemove(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.Store.SortedSetRemove(r.Context(), &pb.SortedSetRemoveRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+		Members:   []string{r.PathValue("member")},
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]int64{
+		"removed": resp.Removed,
+	})
+}
+
+// HandleSortedSetIncrement handles POST requests to increment the score of a
+// sorted set member
+func (s *GrpcServer) HandleSortedSetIncrement(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Delta float64 `json:"delta"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	resp, err := s.Store.SortedSetIncrement(r.Context(), &pb.SortedSetIncrementRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+		Member:    r.PathValue("member"),
+		Delta:     req.Delta,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]float64{
+		"score": resp.Score,
+	})
+}
+
+// HandleSortedSetRank handles GET requests for the rank of a sorted set member
+func (s *GrpcServer) HandleSortedSetRank(w http.ResponseWriter, r *http.Request) {
+	reverse, err := queryBool(r, "reverse")
+	if err != nil {
+		util.Error(w, "Invalid reverse", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.Store.SortedSetRank(r.Context(), &pb.SortedSetRankRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+		Member:    r.PathValue("member"),
+		Reverse:   reverse,
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, map[string]any{
+		"rank":  resp.Rank,
+		"score": resp.Score,
+	})
+}
+
+// HandleSortedSetRange handles GET requests for a range of sorted set
+// members. With by=score the range is selected by the min and max scores
+// and paginated by offset and limit, otherwise it is selected by the start
+// and stop ranks.
+func (s *GrpcServer) HandleSortedSetRange(w http.ResponseWriter, r *http.Request) {
+	reverse, err := queryBool(r, "reverse")
+	if err != nil {
+		util.Error(w, "Invalid reverse", http.StatusBadRequest)
+		return
+	}
+
+	var resp *pb.SortedSetRangeResponse
+	switch r.URL.Query().Get("by") {
+	case "score":
+		minScore, minErr := queryFloat(r, "min", math.Inf(-1))
+		maxScore, maxErr := queryFloat(r, "max", math.Inf(1))
+		offset, offsetErr := queryInt(r, "offset", 0)
+		limit, limitErr := queryInt(r, "limit", 0)
+		if minErr != nil || maxErr != nil || offsetErr != nil || limitErr != nil {
+			util.Error(w, "Invalid min, max, offset or limit", http.StatusBadRequest)
+			return
+		}
+		resp, err = s.Store.SortedSetRangeByScore(r.Context(), &pb.SortedSetRangeByScoreRequest{
+			Key:       r.PathValue("key"),
+			Namespace: r.PathValue("namespace"),
+			Min:       minScore,
+			Max:       maxScore,
+			Offset:    offset,
+			Limit:     limit,
+			Reverse:   reverse,
+		})
+	case "", "rank":
+		start, startErr := queryInt(r, "start", 0)
+		stop, stopErr := queryInt(r, "stop", -1)
+		if startErr != nil || stopErr != nil {
+			util.Error(w, "Invalid start or stop", http.StatusBadRequest)
+			return
+		}
+		resp, err = s.Store.SortedSetRangeByRank(r.Context(), &pb.SortedSetRangeByRankRequest{
+			Key:       r.PathValue("key"),
+			Namespace: r.PathValue("namespace"),
+			Start:     start,
+			Stop:      stop,
+			Reverse:   reverse,
+		})
+	default:
+		util.Error(w, "Invalid by, must be score or rank", http.StatusBadRequest)
+		return
+	}
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+
+	members := make([]ScoredMember, 0, len(resp.Members))
+	for _, m := range resp.Members {
+		members = append(members, ScoredMember{
+			Member: m.Member,
+			Score:  m.Score,
+		})
+	}
+	writeJSON(w, map[string][]ScoredMember{
+		"members": members,
+	})
+}
+
+// decodeJSON decodes the request body, writing a 400 response on failure
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		util.WriteProblem(w, util.Problem{
+			Status: http.StatusBadRequest,
+			Code:   util.CodeMalformedRequest,
+			Detail: "Failed to decode request: " + err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// writeJSON writes a successful JSON response
+func writeJSON(w http.ResponseWriter, v any) {
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		util.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// parseListEnd parses the end of a list, defaulting to the right end
+func parseListEnd(end string) (pb.ListEnd, bool) {
+	switch end {
+	case "", "right":
+		return pb.ListEnd_RIGHT, true
+	case "left":
+		return pb.ListEnd_LEFT, true
+	default:
+		return pb.ListEnd_RIGHT, false
+	}
+}
+
+// queryInt parses an integer query parameter
+func queryInt(r *http.Request, name string, fallback int64) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// queryFloat parses a float query parameter, accepting -inf and +inf
+func queryFloat(r *http.Request, name string, fallback float64) (float64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// queryBool parses a boolean query parameter that defaults to false
+func queryBool(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// nonNil makes empty results encode as [] rather than null
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,190 @@
+package transport
+
+import (
+	"bytes"
+	pb "censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func (m *mockStore) ListPush(ctx context.Context, in *pb.ListPushRequest, opts ...grpc.CallOption) (*pb.ListPushResponse, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &pb.ListPushResponse{Length: int64(len(in.Values))}, nil
+}
+
+func (m *mockStore) SetIntersect(ctx context.Context, in *pb.SetIntersectRequest, opts ...grpc.CallOption) (*pb.SetIntersectResponse, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &pb.SetIntersectResponse{}, nil
+}
+
+// Test the HandleListPush function
+func TestHandleListPush(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		wantCode       int
+		wantResp       string
+		grpcStoreError error
+	}{
+		{
+			name:     "push right",
+			body:     `{"values": ["a", "b"]}`,
+			wantCode: http.StatusOK,
+			wantResp: "{\"length\":2}\n",
+		},
+		{
+			name:     "push left",
+			body:     `{"values": ["a"], "end": "left"}`,
+			wantCode: http.StatusOK,
+			wantResp: "{\"length\":1}\n",
+		},
+		{
+			name:     "invalid end",
+			body:     `{"values": ["a"], "end": "middle"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "malformed body",
+			body:     `{"values": `,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid key",
+			body:           `{"values": ["a"]}`,
+			grpcStoreError: status.Errorf(codes.InvalidArgument, "key cannot be empty"),
+			wantCode:       http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/lists/test-key", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.SetPathValue("key", "test-key")
+
+			s := &GrpcServer{Store: &mockStore{err: tt.grpcStoreError}}
+			s.HandleListPush(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("HandleListPush() wrote code %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantResp != "" && w.Body.String() != tt.wantResp {
+				t.Errorf("HandleListPush() response = %s, want %s", w.Body.String(), tt.wantResp)
+			}
+		})
+	}
+}
+
+// Test the HandleSetIntersect function
+func TestHandleSetIntersect(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantResp string
+	}{
+		{
+			name:     "two sets",
+			query:    "?intersect=a&intersect=b",
+			wantCode: http.StatusOK,
+			wantResp: "{\"members\":[]}\n",
+		},
+		{
+			name:     "missing keys",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/sets"+tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := &GrpcServer{Store: &mockStore{}}
+			s.HandleSetIntersect(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("HandleSetIntersect() wrote code %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantResp != "" && w.Body.String() != tt.wantResp {
+				t.Errorf("HandleSetIntersect() response = %s, want %s", w.Body.String(), tt.wantResp)
+			}
+		})
+	}
+}
+
+func (m *mockStore) SortedSetRangeByScore(ctx context.Context, in *pb.SortedSetRangeByScoreRequest, opts ...grpc.CallOption) (*pb.SortedSetRangeResponse, error) {
+	return &pb.SortedSetRangeResponse{Members: []*pb.ScoredMember{{Member: "score", Score: in.Min}}}, nil
+}
+
+func (m *mockStore) SortedSetRangeByRank(ctx context.Context, in *pb.SortedSetRangeByRankRequest, opts ...grpc.CallOption) (*pb.SortedSetRangeResponse, error) {
+	return &pb.SortedSetRangeResponse{Members: []*pb.ScoredMember{{Member: "rank", Score: float64(in.Stop)}}}, nil
+}
+
+// Test the HandleSortedSetRange function
+func TestHandleSortedSetRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantResp string
+	}{
+		{
+			name:     "by rank default",
+			wantCode: http.StatusOK,
+			wantResp: "{\"members\":[{\"member\":\"rank\",\"score\":-1}]}\n",
+		},
+		{
+			name:     "by score",
+			query:    "?by=score&min=5&max=inf&limit=10",
+			wantCode: http.StatusOK,
+			wantResp: "{\"members\":[{\"member\":\"score\",\"score\":5}]}\n",
+		},
+		{
+			name:     "invalid min",
+			query:    "?by=score&min=low",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "invalid by",
+			query:    "?by=member",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/zsets/board"+tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.SetPathValue("key", "board")
+
+			s := &GrpcServer{Store: &mockStore{}}
+			s.HandleSortedSetRange(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("HandleSortedSetRange() wrote code %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantResp != "" && w.Body.String() != tt.wantResp {
+				t.Errorf("HandleSortedSetRange() response = %s, want %s", w.Body.String(), tt.wantResp)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"censys/pkg/util"
+	pb "censys/proto/gen/proto"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// LegacyDeprecation is the RFC 9745 Deprecation header value sent by the
+// unversioned /store routes, which were deprecated by the /v1 API on
+// 2026-10-19
+const LegacyDeprecation = "@1792368000"
+
+// KeyValue is a key and its value
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Deprecated marks the responses of a legacy route as deprecated in favor of
+// the /v1 keys API
+func Deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", LegacyDeprecation)
+		w.Header().Set("Link", `</v1/keys>; rel="successor-version"`)
+		next(w, r)
+	}
+}
+
+// HandleKeyGet handles GET requests for the value of a key
+func (s *GrpcServer) HandleKeyGet(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	value, err := s.get(r.Context(), r.PathValue("namespace"), key)
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, KeyValue{
+		Key:   key,
+		Value: value,
+	})
+}
+
+// HandleKeyPut handles PUT requests setting the value of a key
+func (s *GrpcServer) HandleKeyPut(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Value string `json:"value"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	key := r.PathValue("key")
+	namespace := r.PathValue("namespace")
+	if !s.validatePair(w, namespace, key, req.Value) {
+		return
+	}
+
+	_, err := s.Store.Set(r.Context(), &pb.SetRequest{
+		Key:       key,
+		Value:     req.Value,
+		Namespace: namespace,
+	})
+	s.invalidate(namespace, key)
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	writeJSON(w, KeyValue{
+		Key:   key,
+		Value: req.Value,
+	})
+}
+
+// HandleKeyDelete handles DELETE requests for a key
+func (s *GrpcServer) HandleKeyDelete(w http.ResponseWriter, r *http.Request) {
+	_, err := s.Store.Delete(r.Context(), &pb.DeleteRequest{
+		Key:       r.PathValue("key"),
+		Namespace: r.PathValue("namespace"),
+	})
+	s.invalidate(r.PathValue("namespace"), r.PathValue("key"))
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleKeyList handles GET requests listing the keys starting with the
+// prefix query parameter, up to limit keys
+func (s *GrpcServer) HandleKeyList(w http.ResponseWriter, r *http.Request) {
+	var limit int
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			util.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp, err := s.Store.ListKeys(r.Context(), &pb.ListKeysRequest{
+		Namespace: r.PathValue("namespace"),
+		Prefix:    r.URL.Query().Get("prefix"),
+		Limit:     int32(limit),
+	})
+	if util.HandleGrpcError(w, err) {
+		return
+	}
+
+	keys := resp.Keys
+	if keys == nil {
+		keys = []string{}
+	}
+	writeJSON(w, map[string][]string{
+		"keys": keys,
+	})
+}
+
+// validatePair validates a key-value pair against the policy of the server,
+// writing a 400 response listing the violations on failure. Namespaces may
+// override the validation policy, so namespaced pairs are validated by the
+// kvstore instead.
+func (s *GrpcServer) validatePair(w http.ResponseWriter, namespace string, key string, value string) bool {
+	if namespace != "" {
+		if key == "" {
+			util.Error(w, "Missing key", http.StatusBadRequest)
+			return false
+		}
+		return true
+	}
+
+	err := s.policy().Validate(key, value)
+	if err == nil {
+		return true
+	}
+	problem := util.Problem{
+		Status: http.StatusBadRequest,
+		Detail: "Invalid key/value pair: " + err.Error(),
+	}
+	var validationErr *util.ValidationError
+	if errors.As(err, &validationErr) {
+		problem.Errors = validationErr.Violations
+	}
+	util.WriteProblem(w, problem)
+	return false
+}
@@ -0,0 +1,268 @@
+package transport
+
+import (
+	"censys/pkg/nearcache"
+	pb "censys/proto/gen/proto"
+	"context"
+	"encoding/json"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (m *mockStore) ListKeys(ctx context.Context, in *pb.ListKeysRequest, opts ...grpc.CallOption) (*pb.ListKeysResponse, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	var keys []string
+	for _, key := range strings.Fields(m.value) {
+		if strings.HasPrefix(key, in.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return &pb.ListKeysResponse{Keys: keys, Success: true}, nil
+}
+
+// mockInvalidations is an invalidation stream that stays connected
+type mockInvalidations struct {
+	grpc.ClientStream
+	ctx   context.Context
+	reset bool
+}
+
+func (m *mockInvalidations) Recv() (*pb.Invalidation, error) {
+	if !m.reset {
+		m.reset = true
+		return &pb.Invalidation{Reset_: true}, nil
+	}
+	<-m.ctx.Done()
+	return nil, m.ctx.Err()
+}
+
+func (m *mockStore) WatchInvalidations(ctx context.Context, in *pb.WatchInvalidationsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.Invalidation], error) {
+	return &mockInvalidations{ctx: ctx}, nil
+}
+
+// newV1Router serves the /v1 keys API validated against its OpenAPI document
+func newV1Router(t *testing.T, s *GrpcServer) *http.ServeMux {
+	t.Helper()
+	spec, err := OpenAPI()
+	if err != nil {
+		t.Fatalf("OpenAPI() error = %v", err)
+	}
+	router := http.NewServeMux()
+	router.Handle("GET /v1/openapi.json", spec)
+	for pattern, handler := range map[string]http.HandlerFunc{
+		"GET /v1/keys":          s.HandleKeyList,
+		"GET /v1/keys/{key}":    s.HandleKeyGet,
+		"PUT /v1/keys/{key}":    s.HandleKeyPut,
+		"DELETE /v1/keys/{key}": s.HandleKeyDelete,
+	} {
+		method, path, _ := strings.Cut(pattern, " ")
+		router.HandleFunc(pattern, spec.Validate(method, path, handler))
+	}
+	return router
+}
+
+func TestV1Keys(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		value          string
+		grpcStoreError error
+		wantCode       int
+		wantResp       string
+	}{
+		{
+			name:     "get key",
+			method:   "GET",
+			path:     "/v1/keys/color",
+			value:    "blue",
+			wantCode: http.StatusOK,
+			wantResp: "{\"key\":\"color\",\"value\":\"blue\"}\n",
+		},
+		{
+			name:           "get missing key",
+			method:         "GET",
+			path:           "/v1/keys/color",
+			grpcStoreError: status.Errorf(codes.NotFound, "key not found"),
+			wantCode:       http.StatusNotFound,
+		},
+		{
+			name:     "put key",
+			method:   "PUT",
+			path:     "/v1/keys/color",
+			body:     `{"value":"red"}`,
+			wantCode: http.StatusOK,
+			wantResp: "{\"key\":\"color\",\"value\":\"red\"}\n",
+		},
+		{
+			name:     "put without value",
+			method:   "PUT",
+			path:     "/v1/keys/color",
+			body:     `{}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "put with unknown field",
+			method:   "PUT",
+			path:     "/v1/keys/color",
+			body:     `{"value":"red","ttl":5}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "put invalid key",
+			method:   "PUT",
+			path:     "/v1/keys/bad%20key",
+			body:     `{"value":"red"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "delete key",
+			method:   "DELETE",
+			path:     "/v1/keys/color",
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "list keys by prefix",
+			method:   "GET",
+			path:     "/v1/keys?prefix=user-",
+			value:    "color user-1 user-2",
+			wantCode: http.StatusOK,
+			wantResp: "{\"keys\":[\"user-1\",\"user-2\"]}\n",
+		},
+		{
+			name:     "list no keys",
+			method:   "GET",
+			path:     "/v1/keys",
+			wantCode: http.StatusOK,
+			wantResp: "{\"keys\":[]}\n",
+		},
+		{
+			name:     "list with invalid limit",
+			method:   "GET",
+			path:     "/v1/keys?limit=0",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &mockStore{value: tt.value, err: tt.grpcStoreError, success: true}
+			router := newV1Router(t, &GrpcServer{Store: store})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("%s %s wrote code %d, want %d: %s", tt.method, tt.path, w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantResp != "" && w.Body.String() != tt.wantResp {
+				t.Errorf("%s %s response = %s, want %s", tt.method, tt.path, w.Body.String(), tt.wantResp)
+			}
+		})
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	w := httptest.NewRecorder()
+	newV1Router(t, &GrpcServer{Store: &mockStore{}}).ServeHTTP(w, httptest.NewRequest("GET", "/v1/openapi.json", nil))
+
+	var doc struct {
+		OpenAPI string                               `json:"openapi"`
+		Paths   map[string]map[string]map[string]any `json:"paths"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI == "" {
+		t.Error("document has no openapi version")
+	}
+
+	// Operation ids must be unique across the document
+	seen := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for method, op := range item {
+			id, _ := op["operationId"].(string)
+			if id == "" || seen[id] {
+				t.Errorf("%s %s has missing or duplicate operationId %q", method, path, id)
+			}
+			seen[id] = true
+		}
+	}
+	if len(seen) != 8 {
+		t.Errorf("document describes %d operations, want 8", len(seen))
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	w := httptest.NewRecorder()
+	Deprecated(func(w http.ResponseWriter, r *http.Request) {})(w, httptest.NewRequest("GET", "/store/color", nil))
+
+	if w.Header().Get("Deprecation") != LegacyDeprecation {
+		t.Errorf("Deprecation header = %q, want %q", w.Header().Get("Deprecation"), LegacyDeprecation)
+	}
+	if !strings.Contains(w.Header().Get("Link"), `rel="successor-version"`) {
+		t.Errorf("Link header = %q, want a successor-version link", w.Header().Get("Link"))
+	}
+}
+
+func TestV1KeysCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := &mockStore{value: "blue", success: true}
+	cache := nearcache.New(nearcache.Config{MaxEntries: 10})
+	go cache.Watch(ctx, store)
+	for cache.Stats().Resets == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	server := &GrpcServer{Store: store, Cache: cache}
+	router := newV1Router(t, server)
+	router.HandleFunc("GET /v1/namespaces/{namespace}/keys/{key}", server.HandleKeyGet)
+	router.HandleFunc("GET /store", server.HandleGet)
+
+	steps := []struct {
+		method   string
+		path     string
+		body     string
+		wantGets int
+	}{
+		{"GET", "/v1/keys/color", "", 1},
+		{"GET", "/v1/keys/color", "", 1},
+		{"GET", "/v1/namespaces/default/keys/color", "", 1},
+		{"GET", "/v1/namespaces/tenant/keys/color", "", 2},
+		{"PUT", "/v1/keys/color", `{"value":"blue"}`, 2},
+		{"GET", "/v1/keys/color", "", 3},
+		{"DELETE", "/v1/keys/color", "", 3},
+		{"GET", "/v1/keys/color", "", 4},
+		{"GET", "/store?key=color", "", 4},
+		{"GET", "/store?key=_locks/build", "", 5},
+		{"GET", "/store?key=_locks/build", "", 6},
+	}
+	for _, step := range steps {
+		r := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		if step.body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code >= 300 {
+			t.Fatalf("%s %s = %d: %s", step.method, step.path, w.Code, w.Body)
+		}
+		if store.gets != step.wantGets {
+			t.Errorf("%s %s: Get calls = %d, want %d", step.method, step.path, store.gets, step.wantGets)
+		}
+	}
+
+	if stats := cache.Stats(); stats.Hits != 3 {
+		t.Errorf("Stats() = %+v, want 3 hits", stats)
+	}
+}
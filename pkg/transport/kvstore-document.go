@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"censys/internal/kvstore"
+	"censys/pkg/document"
+	"censys/proto/gen/proto"
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetPath returns the part of a JSON document value selected by a path
+func (s *KvStoreServer) GetPath(ctx context.Context, request *proto.GetPathRequest) (*proto.GetPathResponse, error) {
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return &proto.GetPathResponse{
+			Success: false,
+		}, err
+	}
+
+	doc, ok := store.Get(ctx, request.GetKey())
+	if !ok {
+		return &proto.GetPathResponse{
+			Success: false,
+		}, storeError(kvstore.ErrNotFound, request.GetNamespace(), request.GetKey())
+	}
+
+	value, err := document.Get(doc, request.GetPath())
+	if err != nil {
+		return &proto.GetPathResponse{
+			Success: false,
+		}, documentError(err)
+	}
+
+	return &proto.GetPathResponse{
+		Value:   value,
+		Success: true,
+	}, nil
+}
+
+// SetPath atomically sets a single nested field of a JSON document value,
+// creating the document if the key does not exist
+func (s *KvStoreServer) SetPath(ctx context.Context, request *proto.SetPathRequest) (*proto.SetPathResponse, error) {
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return &proto.SetPathResponse{
+			Success: false,
+		}, err
+	}
+
+	unlock := s.locks.lock(request.GetNamespace(), request.GetKey())
+	defer unlock()
+
+	doc, ok := store.Get(ctx, request.GetKey())
+	if !ok {
+		doc = "null"
+	}
+
+	updated, err := document.Set(doc, request.GetPath(), request.GetValue())
+	if err != nil {
+		return &proto.SetPathResponse{
+			Success: false,
+		}, documentError(err)
+	}
+
+	err = s.setPlain(ctx, request.GetNamespace(), store, request.GetKey(), updated)
+	if err != nil {
+		return &proto.SetPathResponse{
+			Success: false,
+		}, err
+	}
+
+	return &proto.SetPathResponse{
+		Success: true,
+		Value:   updated,
+	}, nil
+}
+
+// Patch atomically applies a JSON Patch or JSON Merge Patch to a JSON
+// document value
+func (s *KvStoreServer) Patch(ctx context.Context, request *proto.PatchRequest) (*proto.PatchResponse, error) {
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return &proto.PatchResponse{
+			Success: false,
+		}, err
+	}
+
+	unlock := s.locks.lock(request.GetNamespace(), request.GetKey())
+	defer unlock()
+
+	doc, ok := store.Get(ctx, request.GetKey())
+	if !ok {
+		return &proto.PatchResponse{
+			Success: false,
+		}, storeError(kvstore.ErrNotFound, request.GetNamespace(), request.GetKey())
+	}
+
+	var patched string
+	switch request.GetType() {
+	case proto.PatchType_JSON_PATCH:
+		patched, err = document.ApplyPatch(doc, request.GetPatch())
+	case proto.PatchType_MERGE_PATCH:
+		patched, err = document.MergePatch(doc, request.GetPatch())
+	default:
+		err = status.Errorf(codes.InvalidArgument, "unknown patch type %v", request.GetType())
+	}
+	if err != nil {
+		return &proto.PatchResponse{
+			Success: false,
+		}, documentError(err)
+	}
+
+	err = s.setPlain(ctx, request.GetNamespace(), store, request.GetKey(), patched)
+	if err != nil {
+		return &proto.PatchResponse{
+			Success: false,
+		}, err
+	}
+
+	return &proto.PatchResponse{
+		Success: true,
+		Value:   patched,
+	}, nil
+}
+
+// documentError converts a document package error into a gRPC status
+func documentError(err error) error {
+	switch {
+	case errors.Is(err, document.ErrNotJSON), errors.Is(err, document.ErrTestFailed):
+		return status.Errorf(codes.FailedPrecondition, "%s", err)
+	case errors.Is(err, document.ErrPathNotFound):
+		return status.Errorf(codes.NotFound, "%s", err)
+	case errors.Is(err, document.ErrInvalidPath), errors.Is(err, document.ErrInvalidPatch), errors.Is(err, document.ErrInvalidValue):
+		return status.Errorf(codes.InvalidArgument, "%s", err)
+	default:
+		return status.Convert(err).Err()
+	}
+}
@@ -0,0 +1,121 @@
+package transport
+
+import (
+	inmemorystore "censys/internal/kvstore/inmemory"
+	"censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"testing"
+)
+
+func TestKvStoreServer_GetPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		path     string
+		wantResp string
+		wantCode codes.Code
+	}{
+		{name: "nested value", key: "doc", path: "$.user.email", wantResp: `"a@b.c"`},
+		{name: "missing key", key: "missing", path: "$", wantCode: codes.NotFound},
+		{name: "missing path", key: "doc", path: "$.user.phone", wantCode: codes.NotFound},
+		{name: "invalid path", key: "doc", path: "user", wantCode: codes.InvalidArgument},
+		{name: "not a document", key: "text", path: "$", wantCode: codes.FailedPrecondition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &inmemorystore.InMemoryStore{}
+			store.Set(context.Background(), "doc", `{"user":{"email":"a@b.c"}}`)
+			store.Set(context.Background(), "text", `plain text`)
+			server := &KvStoreServer{Store: store}
+
+			resp, err := server.GetPath(context.Background(), &proto.GetPathRequest{Key: tt.key, Path: tt.path})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("GetPath() error = %v, want %v", err, tt.wantCode)
+			}
+			if resp.GetValue() != tt.wantResp {
+				t.Errorf("GetPath() response = %v, want %v", resp.GetValue(), tt.wantResp)
+			}
+		})
+	}
+}
+
+func TestKvStoreServer_Patch(t *testing.T) {
+	tests := []struct {
+		name      string
+		patchType proto.PatchType
+		patch     string
+		wantResp  string
+		wantCode  codes.Code
+	}{
+		{
+			name:      "json patch",
+			patchType: proto.PatchType_JSON_PATCH,
+			patch:     `[{"op":"replace","path":"/user/email","value":"x@y.z"}]`,
+			wantResp:  `{"user":{"email":"x@y.z"}}`,
+		},
+		{
+			name:      "merge patch",
+			patchType: proto.PatchType_MERGE_PATCH,
+			patch:     `{"user":{"name":"Ada"}}`,
+			wantResp:  `{"user":{"email":"a@b.c","name":"Ada"}}`,
+		},
+		{
+			name:      "failed test",
+			patchType: proto.PatchType_JSON_PATCH,
+			patch:     `[{"op":"test","path":"/user/email","value":"x@y.z"}]`,
+			wantCode:  codes.FailedPrecondition,
+		},
+		{
+			name:      "invalid patch",
+			patchType: proto.PatchType_JSON_PATCH,
+			patch:     `{}`,
+			wantCode:  codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &inmemorystore.InMemoryStore{}
+			store.Set(context.Background(), "doc", `{"user":{"email":"a@b.c"}}`)
+			server := &KvStoreServer{Store: store}
+
+			resp, err := server.Patch(context.Background(), &proto.PatchRequest{Key: "doc", Type: tt.patchType, Patch: tt.patch})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Patch() error = %v, want %v", err, tt.wantCode)
+			}
+			if resp.GetValue() != tt.wantResp {
+				t.Errorf("Patch() response = %v, want %v", resp.GetValue(), tt.wantResp)
+			}
+		})
+	}
+}
+
+func TestKvStoreServer_SetPathConcurrent(t *testing.T) {
+	store := &inmemorystore.InMemoryStore{}
+	server := &KvStoreServer{Store: store}
+	ctx := context.Background()
+
+	// Concurrent updates of different fields must not overwrite each other
+	var wg sync.WaitGroup
+	for _, field := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		wg.Add(1)
+		go func(field string) {
+			defer wg.Done()
+			_, err := server.SetPath(ctx, &proto.SetPathRequest{Key: "doc", Path: "$." + field, Value: "1"})
+			if err != nil {
+				t.Errorf("SetPath() error = %v", err)
+			}
+		}(field)
+	}
+	wg.Wait()
+
+	value, _ := store.Get(ctx, "doc")
+	want := `{"a":1,"b":1,"c":1,"d":1,"e":1,"f":1,"g":1,"h":1}`
+	if value != want {
+		t.Errorf("SetPath() document = %v, want %v", value, want)
+	}
+}
@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ElectionPrefix prefixes the reserved keys holding election leaders
+const ElectionPrefix = "_elections/"
+
+// Campaign waits until the candidate is elected leader. Leadership is held
+// by a lease, so a leader that stops keeping its lease alive is replaced.
+func (s *KvStoreServer) Campaign(ctx context.Context, request *proto.CampaignRequest) (*proto.CampaignResponse, error) {
+	if request.GetElection() == "" || request.GetCandidate() == "" || request.GetLease() == 0 {
+		return &proto.CampaignResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "election, candidate and lease are required")
+	}
+
+	holder, err := s.acquire(ctx, request.GetNamespace(), ElectionPrefix+request.GetElection(), request.GetLease(), request.GetCandidate())
+	if err != nil {
+		return &proto.CampaignResponse{
+			Success: false,
+		}, err
+	}
+
+	return &proto.CampaignResponse{
+		Leader:  leaderToProto(holder),
+		Success: true,
+	}, nil
+}
+
+// Leader returns the current leader of an election
+func (s *KvStoreServer) Leader(ctx context.Context, request *proto.LeaderRequest) (*proto.LeaderResponse, error) {
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return &proto.LeaderResponse{
+			Success: false,
+		}, err
+	}
+
+	holder, held, err := readHolder(ctx, store, ElectionPrefix+request.GetElection())
+	if err != nil {
+		return &proto.LeaderResponse{
+			Success: false,
+		}, err
+	}
+	if !held {
+		return &proto.LeaderResponse{
+			Success: false,
+		}, status.Errorf(codes.NotFound, "election has no leader")
+	}
+
+	return &proto.LeaderResponse{
+		Leader:  leaderToProto(holder),
+		Success: true,
+	}, nil
+}
+
+// Observe streams the current leader of an election and every change of
+// leader, sending an empty leader while the election has none
+func (s *KvStoreServer) Observe(request *proto.ObserveRequest, stream proto.KvStoreService_ObserveServer) error {
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return err
+	}
+
+	key := ElectionPrefix + request.GetElection()
+	var last *lockHolder
+	for {
+		changed := s.lockChanges.wait()
+
+		holder, _, err := readHolder(stream.Context(), store, key)
+		if err != nil {
+			return err
+		}
+		if last == nil || holder != *last {
+			if err := stream.Send(leaderToProto(holder)); err != nil {
+				return err
+			}
+			last = &holder
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-changed:
+		}
+	}
+}
+
+// Resign gives up leadership held since the given revision
+func (s *KvStoreServer) Resign(ctx context.Context, request *proto.ResignRequest) (*proto.ResignResponse, error) {
+	err := s.release(ctx, request.GetNamespace(), ElectionPrefix+request.GetElection(), request.GetRevision())
+	if err != nil {
+		return &proto.ResignResponse{
+			Success: false,
+		}, err
+	}
+
+	return &proto.ResignResponse{
+		Success: true,
+	}, nil
+}
+
+func leaderToProto(holder lockHolder) *proto.ElectionLeader {
+	return &proto.ElectionLeader{
+		Candidate: holder.Candidate,
+		Lease:     holder.Lease,
+		Revision:  holder.Token,
+	}
+}
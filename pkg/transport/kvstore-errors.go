@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"censys/internal/kvstore"
+	"censys/pkg/schema"
+	"censys/pkg/util"
+	"context"
+	"errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"time"
+)
+
+// ConflictRetryDelay is the retry delay suggested to clients whose write
+// conflicted with a concurrent write
+const ConflictRetryDelay = 10 * time.Millisecond
+
+// storeError converts an error of a store or namespace into a gRPC status
+// carrying details about the key or namespace it concerns. Errors that are
+// already a gRPC status are returned unchanged, and unexpected errors are
+// reported as Internal without leaking their message.
+func storeError(err error, namespace string, key string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	namespace = canonicalNamespace(namespace)
+	switch {
+	case errors.Is(err, kvstore.ErrInvalidKey):
+		return withDetails(status.New(codes.InvalidArgument, err.Error()), &errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+				Field:       "key",
+				Description: err.Error(),
+			}},
+		})
+	case errors.Is(err, kvstore.ErrNotFound):
+		return withDetails(status.New(codes.NotFound, kvstore.ErrNotFound.Error()), keyResource(namespace, key))
+	case errors.Is(err, kvstore.ErrConflict):
+		return withDetails(status.New(codes.Aborted, err.Error()), keyResource(namespace, key), &errdetails.RetryInfo{
+			RetryDelay: durationpb.New(ConflictRetryDelay),
+		})
+	case errors.Is(err, kvstore.ErrQuotaExceeded):
+		return withDetails(status.New(codes.ResourceExhausted, kvstore.ErrQuotaExceeded.Error()), namespaceResource(namespace))
+	case errors.Is(err, kvstore.ErrNamespaceNotFound):
+		return withDetails(status.New(codes.NotFound, kvstore.ErrNamespaceNotFound.Error()), namespaceResource(namespace))
+	case errors.Is(err, kvstore.ErrNamespaceExists):
+		return withDetails(status.New(codes.AlreadyExists, kvstore.ErrNamespaceExists.Error()), namespaceResource(namespace))
+	case errors.Is(err, errors.ErrUnsupported):
+		return status.Errorf(codes.Unimplemented, "%s", err)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Errorf(codes.Internal, "internal store error")
+	}
+}
+
+// keyResource describes a key for a ResourceInfo error detail
+func keyResource(namespace string, key string) *errdetails.ResourceInfo {
+	return &errdetails.ResourceInfo{
+		ResourceType: "key",
+		ResourceName: key,
+		Owner:        "namespace:" + namespace,
+	}
+}
+
+// namespaceResource describes a namespace for a ResourceInfo error detail
+func namespaceResource(namespace string) *errdetails.ResourceInfo {
+	return &errdetails.ResourceInfo{
+		ResourceType: "namespace",
+		ResourceName: namespace,
+	}
+}
+
+// withDetails attaches error details to a status, returning the bare status
+// if they cannot be encoded
+func withDetails(st *status.Status, details ...protoadapt.MessageV1) error {
+	detailed, err := st.WithDetails(details...)
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// invalidArgument converts a validation error into an InvalidArgument status
+// carrying a BadRequest detail with one field violation per failed check
+func invalidArgument(err error) error {
+	st := status.New(codes.InvalidArgument, err.Error())
+
+	badRequest := &errdetails.BadRequest{}
+	var validationErr *util.ValidationError
+	var schemaErr *schema.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		for _, v := range validationErr.Violations {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+	case errors.As(err, &schemaErr):
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       "value",
+			Description: schemaErr.Error(),
+		})
+	default:
+		return st.Err()
+	}
+	return withDetails(st, badRequest)
+}
@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"censys/internal/kvstore"
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"testing"
+)
+
+func TestStoreError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantCode    codes.Code
+		wantMessage string
+		wantDetails []proto.Message
+	}{
+		{
+			name:        "invalid key",
+			err:         fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey),
+			wantCode:    codes.InvalidArgument,
+			wantMessage: "invalid key: key cannot be empty",
+			wantDetails: []proto.Message{&errdetails.BadRequest{
+				FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+					Field:       "key",
+					Description: "invalid key: key cannot be empty",
+				}},
+			}},
+		},
+		{
+			name:        "not found",
+			err:         kvstore.ErrNotFound,
+			wantCode:    codes.NotFound,
+			wantMessage: "key not found",
+			wantDetails: []proto.Message{&errdetails.ResourceInfo{
+				ResourceType: "key",
+				ResourceName: "test-key",
+				Owner:        "namespace:default",
+			}},
+		},
+		{
+			name:        "conflict",
+			err:         fmt.Errorf("%w: version changed", kvstore.ErrConflict),
+			wantCode:    codes.Aborted,
+			wantMessage: "conflicting write: version changed",
+			wantDetails: []proto.Message{
+				&errdetails.ResourceInfo{
+					ResourceType: "key",
+					ResourceName: "test-key",
+					Owner:        "namespace:default",
+				},
+				&errdetails.RetryInfo{RetryDelay: durationpb.New(ConflictRetryDelay)},
+			},
+		},
+		{
+			name:        "quota exceeded",
+			err:         kvstore.ErrQuotaExceeded,
+			wantCode:    codes.ResourceExhausted,
+			wantMessage: "namespace quota exceeded",
+			wantDetails: []proto.Message{&errdetails.ResourceInfo{
+				ResourceType: "namespace",
+				ResourceName: "default",
+			}},
+		},
+		{
+			name:        "deadline exceeded",
+			err:         context.DeadlineExceeded,
+			wantCode:    codes.DeadlineExceeded,
+			wantMessage: "context deadline exceeded",
+		},
+		{
+			name:        "status unchanged",
+			err:         status.Errorf(codes.FailedPrecondition, "lock is not held"),
+			wantCode:    codes.FailedPrecondition,
+			wantMessage: "lock is not held",
+		},
+		{
+			name:        "unexpected error",
+			err:         errors.New("disk on fire at /var/lib/kvstore"),
+			wantCode:    codes.Internal,
+			wantMessage: "internal store error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(storeError(tt.err, "", "test-key"))
+			if st.Code() != tt.wantCode || st.Message() != tt.wantMessage {
+				t.Errorf("storeError() = %v %q, want %v %q", st.Code(), st.Message(), tt.wantCode, tt.wantMessage)
+			}
+
+			details := st.Details()
+			if len(details) != len(tt.wantDetails) {
+				t.Fatalf("storeError() details = %v, want %v", details, tt.wantDetails)
+			}
+			for i, detail := range details {
+				if !proto.Equal(detail.(proto.Message), tt.wantDetails[i]) {
+					t.Errorf("storeError() detail %d = %v, want %v", i, detail, tt.wantDetails[i])
+				}
+			}
+		})
+	}
+}
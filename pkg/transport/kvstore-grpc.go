@@ -2,26 +2,123 @@ package transport
 
 import (
 	"censys/internal/kvstore"
+	"censys/internal/kvstore/types"
+	"censys/internal/lease"
+	"censys/internal/pubsub"
+	"censys/pkg/schema"
+	"censys/pkg/singleflight"
+	"censys/pkg/util"
 	"censys/proto/gen/proto"
 	"context"
+	"errors"
+	"fmt"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // KvStoreServer is a struct that implements the KvStoreServiceServer interface
 type KvStoreServer struct {
 	proto.UnimplementedKvStoreServiceServer
-	Store kvstore.KeyValueStore
+	Store      kvstore.KeyValueStore
+	Namespaces *kvstore.Namespaces
+	// Policies validates keys and values on Set. Validation is disabled when nil.
+	Policies *util.Policies
+	// Schemas validates JSON values by key prefix. Validation is disabled when nil.
+	Schemas *schema.Registry
+	// Broker delivers published messages. Publish/subscribe is unavailable when nil.
+	Broker *pubsub.Broker
+	// Leases grants leases keys and locks can be attached to. Leases are
+	// unavailable when nil. Create it with lease.NewLessor(server.Release).
+	Leases *lease.Lessor
+
+	locks keyLocks
+	// revision is incremented by every write and provides fencing tokens
+	revision    atomic.Int64
+	lockChanges notifier
+	// leaseState is the last persisted value of LeaseStateKey
+	leaseMu    sync.Mutex
+	leaseState leaseState
+	// invalidations notifies caches of written keys
+	invalidations invalidations
+	// reads coalesces concurrent Get calls of the same key
+	reads singleflight.Group[storedValue]
+}
+
+// storedValue is the result of reading a key from a store
+type storedValue struct {
+	value string
+	ok    bool
+}
+
+// keyspace returns the store backing the given namespace. The default
+// namespace is served by Store.
+func (s *KvStoreServer) keyspace(namespace string) (kvstore.KeyValueStore, error) {
+	if namespace == "" || namespace == kvstore.DefaultNamespace {
+		return s.Store, nil
+	}
+	if s.Namespaces == nil {
+		return nil, storeError(kvstore.ErrNamespaceNotFound, namespace, "")
+	}
+	ns, err := s.Namespaces.Get(namespace)
+	if err != nil {
+		return nil, storeError(err, namespace, "")
+	}
+	return ns, nil
+}
+
+// readKey identifies a key across namespaces for read coalescing
+func readKey(namespace string, key string) string {
+	return canonicalNamespace(namespace) + "\x00" + key
+}
+
+// CoalescingStats returns the statistics of Get calls coalescing
+func (s *KvStoreServer) CoalescingStats() singleflight.Stats {
+	return s.reads.Stats()
+}
+
+// canonicalNamespace returns the name of a namespace, mapping the empty name
+// to the default namespace
+func canonicalNamespace(namespace string) string {
+	if namespace == "" {
+		return kvstore.DefaultNamespace
+	}
+	return namespace
 }
 
 // Get returns the value for the given key
 func (s *KvStoreServer) Get(ctx context.Context, request *proto.GetRequest) (*proto.GetResponse, error) {
-	value, ok := s.Store.Get(ctx, request.GetKey())
-	if !ok {
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return &proto.GetResponse{
+			Success: false,
+		}, err
+	}
+
+	// Concurrent reads of a key share a single store lookup
+	stored, err := s.reads.Do(ctx, readKey(request.GetNamespace(), request.GetKey()), func(ctx context.Context) (storedValue, error) {
+		value, ok := store.Get(ctx, request.GetKey())
+		return storedValue{value, ok}, nil
+	})
+	if err != nil {
+		return &proto.GetResponse{
+			Success: false,
+		}, storeError(err, request.GetNamespace(), request.GetKey())
+	}
+	value := stored.value
+	if !stored.ok {
 		return &proto.GetResponse{
 			Value:   value,
 			Success: false,
-		}, status.Errorf(codes.NotFound, "key not found")
+		}, storeError(kvstore.ErrNotFound, request.GetNamespace(), request.GetKey())
+	}
+	if types.IsTyped(value) {
+		return &proto.GetResponse{
+			Success: false,
+		}, status.Errorf(codes.FailedPrecondition, "key holds a %s, not a string", types.TypeOf(value))
 	}
 
 	return &proto.GetResponse{
@@ -30,15 +127,35 @@ func (s *KvStoreServer) Get(ctx context.Context, request *proto.GetRequest) (*pr
 	}, nil
 }
 
-// Set sets the value for the given key
+// Set sets the value for the given key, which expires after the TTL of the
+// request if it has one
 func (s *KvStoreServer) Set(ctx context.Context, request *proto.SetRequest) (*proto.SetResponse, error) {
-	err := s.Store.Set(ctx, request.GetKey(), request.GetValue())
+	store, err := s.keyspace(request.GetNamespace())
 	if err != nil {
-		if err.Error() == "key cannot be empty" {
+		return &proto.SetResponse{
+			Success: false,
+		}, err
+	}
+	if request.GetTtlMs() != 0 {
+		store, err = withTTL(store, time.Duration(request.GetTtlMs())*time.Millisecond)
+		if err != nil {
 			return &proto.SetResponse{
 				Success: false,
-			}, status.Errorf(codes.InvalidArgument, "key cannot be empty")
+			}, storeError(err, request.GetNamespace(), request.GetKey())
 		}
+	}
+
+	unlock := s.locks.lock(request.GetNamespace(), request.GetKey())
+	defer unlock()
+
+	if err := s.attach(request.GetLease(), request.GetNamespace(), request.GetKey()); err != nil {
+		return &proto.SetResponse{
+			Success: false,
+		}, err
+	}
+	err = s.setPlain(ctx, request.GetNamespace(), store, request.GetKey(), request.GetValue())
+	if err != nil {
+		s.detach(request.GetNamespace(), request.GetKey())
 		return &proto.SetResponse{
 			Success: false,
 		}, err
@@ -49,24 +166,405 @@ func (s *KvStoreServer) Set(ctx context.Context, request *proto.SetRequest) (*pr
 	}, nil
 }
 
+// expiringStore sets the keys written through it with a TTL
+type expiringStore struct {
+	kvstore.KeyValueStore
+	expirer kvstore.Expirer
+	ttl     time.Duration
+}
+
+// withTTL returns a store setting the keys written through it with ttl,
+// failing with errors.ErrUnsupported if store cannot expire keys
+func withTTL(store kvstore.KeyValueStore, ttl time.Duration) (kvstore.KeyValueStore, error) {
+	if ttl < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "TTL cannot be negative")
+	}
+	expirer, ok := store.(kvstore.Expirer)
+	if !ok {
+		return nil, fmt.Errorf("%w: store cannot expire keys", errors.ErrUnsupported)
+	}
+	return expiringStore{KeyValueStore: store, expirer: expirer, ttl: ttl}, nil
+}
+
+// Set sets a value for a key that expires after the TTL of the store
+func (e expiringStore) Set(ctx context.Context, key string, value string) error {
+	return e.expirer.SetWithTTL(ctx, key, value, e.ttl)
+}
+
+// setPlain writes a value set by a client as a plain string. Reserved keys
+// and values that would decode as a typed value are rejected, so that
+// clients cannot forge either. Callers must hold the lock of the key.
+func (s *KvStoreServer) setPlain(ctx context.Context, namespace string, store kvstore.KeyValueStore, key string, value string) error {
+	if err := writable(key); err != nil {
+		return err
+	}
+	if types.IsTyped(value) {
+		return invalidArgument(&util.ValidationError{Violations: []util.Violation{{
+			Field:       "value",
+			Description: "value cannot start with the marker of typed values",
+		}}})
+	}
+	return s.set(ctx, namespace, store, key, value)
+}
+
+// set validates a key-value pair and writes it to the store. Callers must
+// hold the lock of the key.
+func (s *KvStoreServer) set(ctx context.Context, namespace string, store kvstore.KeyValueStore, key string, value string) error {
+	if s.Policies != nil {
+		policy := s.Policies.For(namespace)
+		if err := policy.Validate(key, value); err != nil {
+			return invalidArgument(err)
+		}
+	}
+
+	// Schemas describe JSON documents, which typed values never are
+	if s.Schemas != nil && !types.IsTyped(value) {
+		if err := s.Schemas.Validate(ctx, namespace, store, key, value); err != nil {
+			return invalidArgument(err)
+		}
+	}
+
+	if err := store.Set(ctx, key, value); err != nil {
+		return storeError(err, namespace, key)
+	}
+	s.revision.Add(1)
+	s.changed(namespace, key)
+	return nil
+}
+
 // Delete deletes the value for the given key
 func (s *KvStoreServer) Delete(ctx context.Context, request *proto.DeleteRequest) (*proto.DeleteResponse, error) {
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return &proto.DeleteResponse{
+			Success: false,
+		}, err
+	}
+
 	keyToDelete := request.GetKey()
-	_, ok := s.Store.Get(ctx, keyToDelete)
+	if err := writable(keyToDelete); err != nil {
+		return &proto.DeleteResponse{
+			Success: false,
+		}, err
+	}
+	unlock := s.locks.lock(request.GetNamespace(), keyToDelete)
+	defer unlock()
+
+	_, ok := store.Get(ctx, keyToDelete)
 	if !ok {
 		return &proto.DeleteResponse{
 			Success: false,
-		}, status.Errorf(codes.NotFound, "key not found")
+		}, storeError(kvstore.ErrNotFound, request.GetNamespace(), keyToDelete)
 	}
 
-	err := s.Store.Delete(ctx, keyToDelete)
+	err = store.Delete(ctx, keyToDelete)
 	if err != nil {
 		return &proto.DeleteResponse{
 			Success: false,
-		}, status.Errorf(codes.Internal, "failed to delete key not found %#v", err)
+		}, storeError(err, request.GetNamespace(), keyToDelete)
 	}
+	s.revision.Add(1)
+	s.detach(request.GetNamespace(), keyToDelete)
+	s.changed(request.GetNamespace(), keyToDelete)
 
 	return &proto.DeleteResponse{
 		Success: true,
 	}, nil
 }
+
+// ListKeys lists the keys of a namespace in ascending order. Reserved keys
+// holding server state are not listed.
+func (s *KvStoreServer) ListKeys(ctx context.Context, request *proto.ListKeysRequest) (*proto.ListKeysResponse, error) {
+	if request.GetLimit() < 0 {
+		return &proto.ListKeysResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "limit cannot be negative")
+	}
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return &proto.ListKeysResponse{
+			Success: false,
+		}, err
+	}
+
+	scanner, ok := store.(kvstore.Scanner)
+	if !ok {
+		return &proto.ListKeysResponse{
+			Success: false,
+		}, status.Errorf(codes.Unimplemented, "store cannot list keys")
+	}
+	keys, err := scanner.Keys(ctx, request.GetPrefix())
+	if err != nil {
+		return &proto.ListKeysResponse{
+			Success: false,
+		}, storeError(err, request.GetNamespace(), "")
+	}
+
+	listed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if request.GetLimit() > 0 && len(listed) == int(request.GetLimit()) {
+			break
+		}
+		if !isReserved(key) {
+			listed = append(listed, key)
+		}
+	}
+
+	return &proto.ListKeysResponse{
+		Keys:    listed,
+		Success: true,
+	}, nil
+}
+
+// namespaceNames returns the names of every namespace, the default one
+// first
+func (s *KvStoreServer) namespaceNames() []string {
+	names := []string{kvstore.DefaultNamespace}
+	if s.Namespaces != nil {
+		for _, ns := range s.Namespaces.List() {
+			names = append(names, ns.Name)
+		}
+	}
+	return names
+}
+
+// isReserved reports whether a key holds server state rather than user data
+func isReserved(key string) bool {
+	return key == schema.RegistryKey ||
+		key == LeaseStateKey ||
+		strings.HasPrefix(key, LockPrefix) ||
+		strings.HasPrefix(key, ElectionPrefix) ||
+		strings.HasPrefix(key, RateLimitPrefix) ||
+		strings.HasPrefix(key, QueueConfigPrefix) ||
+		key == PolicyKey
+}
+
+// writable rejects writes of reserved keys by clients, which go through the
+// calls managing the server state instead
+func writable(key string) error {
+	if !isReserved(key) {
+		return nil
+	}
+	return invalidArgument(&util.ValidationError{Violations: []util.Violation{{
+		Field:       "key",
+		Description: "key is reserved for server state",
+	}}})
+}
+
+// CreateNamespace creates a new namespace with the given quota
+func (s *KvStoreServer) CreateNamespace(ctx context.Context, request *proto.CreateNamespaceRequest) (*proto.CreateNamespaceResponse, error) {
+	if s.Namespaces == nil {
+		return &proto.CreateNamespaceResponse{
+			Success: false,
+		}, status.Errorf(codes.Unimplemented, "namespaces are not enabled")
+	}
+
+	if err := util.ValidateNamespace(request.GetName()); err != nil {
+		return &proto.CreateNamespaceResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	var policy *util.Policy
+	if request.GetPolicy() != nil && s.Policies != nil {
+		p, err := policyFromProto(s.Policies.Default, request.GetPolicy())
+		if err != nil {
+			return &proto.CreateNamespaceResponse{
+				Success: false,
+			}, status.Errorf(codes.InvalidArgument, "invalid key pattern: %s", err)
+		}
+		policy = &p
+	}
+
+	_, err := s.Namespaces.Create(request.GetName(), kvstore.Quota{
+		MaxKeys:  request.GetQuota().GetMaxKeys(),
+		MaxBytes: request.GetQuota().GetMaxBytes(),
+	})
+	if err != nil {
+		return &proto.CreateNamespaceResponse{
+			Success: false,
+		}, storeError(err, request.GetName(), "")
+	}
+	if policy != nil {
+		// A namespace is not created without the policy it was asked for
+		if err := s.savePolicy(ctx, request.GetName(), request.GetPolicy()); err != nil {
+			s.Namespaces.Drop(request.GetName())
+			return &proto.CreateNamespaceResponse{
+				Success: false,
+			}, storeError(err, request.GetName(), PolicyKey)
+		}
+		s.Policies.Set(request.GetName(), *policy)
+	}
+
+	return &proto.CreateNamespaceResponse{
+		Success: true,
+	}, nil
+}
+
+// ListNamespaces lists all namespaces along with their quota and usage
+func (s *KvStoreServer) ListNamespaces(ctx context.Context, request *proto.ListNamespacesRequest) (*proto.ListNamespacesResponse, error) {
+	resp := &proto.ListNamespacesResponse{}
+	if s.Namespaces == nil {
+		return resp, nil
+	}
+
+	for _, ns := range s.Namespaces.List() {
+		usage := ns.Usage()
+		resp.Namespaces = append(resp.Namespaces, &proto.Namespace{
+			Name: ns.Name,
+			Quota: &proto.Quota{
+				MaxKeys:  ns.Quota.MaxKeys,
+				MaxBytes: ns.Quota.MaxBytes,
+			},
+			Keys:  usage.Keys,
+			Bytes: usage.Bytes,
+		})
+	}
+	return resp, nil
+}
+
+// DropNamespace removes a namespace and all of its keys
+func (s *KvStoreServer) DropNamespace(ctx context.Context, request *proto.DropNamespaceRequest) (*proto.DropNamespaceResponse, error) {
+	if s.Namespaces == nil {
+		return &proto.DropNamespaceResponse{
+			Success: false,
+		}, storeError(kvstore.ErrNamespaceNotFound, request.GetName(), "")
+	}
+
+	if err := s.Namespaces.Drop(request.GetName()); err != nil {
+		return &proto.DropNamespaceResponse{
+			Success: false,
+		}, storeError(err, request.GetName(), "")
+	}
+	if s.Policies != nil {
+		s.Policies.Remove(request.GetName())
+	}
+	if s.Schemas != nil {
+		s.Schemas.Invalidate(request.GetName())
+	}
+	s.invalidations.publish(&proto.Invalidation{
+		Namespace: request.GetName(),
+	})
+
+	return &proto.DropNamespaceResponse{
+		Success: true,
+	}, nil
+}
+
+// RegisterSchema registers a JSON Schema for values of keys starting with a prefix
+func (s *KvStoreServer) RegisterSchema(ctx context.Context, request *proto.RegisterSchemaRequest) (*proto.RegisterSchemaResponse, error) {
+	if s.Schemas == nil {
+		return &proto.RegisterSchemaResponse{
+			Success: false,
+		}, status.Errorf(codes.Unimplemented, "schema validation is not enabled")
+	}
+
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return &proto.RegisterSchemaResponse{
+			Success: false,
+		}, err
+	}
+
+	err = s.Schemas.Register(ctx, request.GetNamespace(), store, request.GetPrefix(), request.GetSchema())
+	if err != nil {
+		return &proto.RegisterSchemaResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "invalid schema: %s", err)
+	}
+
+	return &proto.RegisterSchemaResponse{
+		Success: true,
+	}, nil
+}
+
+// UnregisterSchema removes the JSON Schema registered for a prefix
+func (s *KvStoreServer) UnregisterSchema(ctx context.Context, request *proto.UnregisterSchemaRequest) (*proto.UnregisterSchemaResponse, error) {
+	if s.Schemas == nil {
+		return &proto.UnregisterSchemaResponse{
+			Success: false,
+		}, status.Errorf(codes.Unimplemented, "schema validation is not enabled")
+	}
+
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return &proto.UnregisterSchemaResponse{
+			Success: false,
+		}, err
+	}
+
+	err = s.Schemas.Unregister(ctx, request.GetNamespace(), store, request.GetPrefix())
+	if errors.Is(err, schema.ErrSchemaNotFound) {
+		return &proto.UnregisterSchemaResponse{
+			Success: false,
+		}, status.Errorf(codes.NotFound, "schema not found")
+	}
+	if err != nil {
+		return &proto.UnregisterSchemaResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "failed to unregister schema: %s", err)
+	}
+
+	return &proto.UnregisterSchemaResponse{
+		Success: true,
+	}, nil
+}
+
+// ListSchemas lists the JSON Schemas registered in a namespace keyed by prefix
+func (s *KvStoreServer) ListSchemas(ctx context.Context, request *proto.ListSchemasRequest) (*proto.ListSchemasResponse, error) {
+	if s.Schemas == nil {
+		return &proto.ListSchemasResponse{}, nil
+	}
+
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return &proto.ListSchemasResponse{}, err
+	}
+
+	schemas, err := s.Schemas.List(ctx, store)
+	if err != nil {
+		return &proto.ListSchemasResponse{}, status.Errorf(codes.Internal, "failed to list schemas: %s", err)
+	}
+
+	return &proto.ListSchemasResponse{
+		Schemas: schemas,
+	}, nil
+}
+
+// changed is called after every write or delete of a key. Later reads of the
+// key do not share a lookup started before the write, cache watchers are
+// notified, and it reloads the schemas of a namespace when its schema registry
+// key changed.
+func (s *KvStoreServer) changed(namespace string, key string) {
+	s.reads.Forget(readKey(namespace, key))
+	if s.Schemas != nil && key == schema.RegistryKey {
+		s.Schemas.Invalidate(namespace)
+	}
+	s.invalidations.publish(&proto.Invalidation{
+		Namespace: canonicalNamespace(namespace),
+		Key:       key,
+	})
+}
+
+// policyFromProto overrides the fields of a base policy set by a validation
+// policy received over gRPC
+func policyFromProto(base util.Policy, p *proto.ValidationPolicy) (util.Policy, error) {
+	policy := base
+	if p.GetMaxKeyLength() != 0 {
+		policy.MaxKeyLength = int(p.GetMaxKeyLength())
+	}
+	if p.GetMaxValueSize() != 0 {
+		policy.MaxValueSize = int(p.GetMaxValueSize())
+	}
+	if p.AllowEmptyValue != nil {
+		policy.AllowEmptyValue = p.GetAllowEmptyValue()
+	}
+	if p.GetKeyPattern() != "" {
+		pattern, err := util.CompileKeyPattern(p.GetKeyPattern())
+		if err != nil {
+			return policy, err
+		}
+		policy.KeyPattern = pattern
+	}
+	return policy, nil
+}
@@ -1,13 +1,22 @@
 package transport
 
 import (
+	"censys/internal/kvstore"
+	inmemorystore "censys/internal/kvstore/inmemory"
+	"censys/pkg/schema"
+	"censys/pkg/util"
 	"censys/proto/gen/proto"
 	"context"
 	"errors"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type mockKvStore struct {
@@ -23,7 +32,7 @@ func (m *mockKvStore) Get(ctx context.Context, key string) (string, bool) {
 
 func (m *mockKvStore) Set(ctx context.Context, key string, value string) error {
 	if key == "" {
-		return status.Errorf(codes.InvalidArgument, "key cannot be empty")
+		return fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey)
 	}
 	m.value = value
 	return nil
@@ -31,12 +40,18 @@ func (m *mockKvStore) Set(ctx context.Context, key string, value string) error {
 
 func (m *mockKvStore) Delete(ctx context.Context, key string) error {
 	if key == "" {
-		return status.Errorf(codes.InvalidArgument, "key cannot be empty")
+		return fmt.Errorf("%w: key cannot be empty", kvstore.ErrInvalidKey)
 	}
 	m.value = ""
 	return nil
 }
 
+// sameStatus reports whether two errors have the same gRPC code and message,
+// ignoring their details
+func sameStatus(err error, want error) bool {
+	return status.Code(err) == status.Code(want) && status.Convert(err).Message() == status.Convert(want).Message()
+}
+
 func TestKvStoreServer_Get(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -77,7 +92,7 @@ func TestKvStoreServer_Get(t *testing.T) {
 			// Call the Get method
 			resp, err := server.Get(context.Background(), &proto.GetRequest{Key: tt.key})
 
-			if (err != nil) && !errors.Is(err, tt.wantErr) {
+			if (err != nil) && !sameStatus(err, tt.wantErr) {
 				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
@@ -89,6 +104,60 @@ func TestKvStoreServer_Get(t *testing.T) {
 	}
 }
 
+// blockingKvStore is a store whose reads wait until released
+type blockingKvStore struct {
+	inmemorystore.InMemoryStore
+	release chan struct{}
+	reads   atomic.Int32
+}
+
+func (b *blockingKvStore) Get(ctx context.Context, key string) (string, bool) {
+	b.reads.Add(1)
+	<-b.release
+	return b.InMemoryStore.Get(ctx, key)
+}
+
+func TestKvStoreServer_GetCoalescing(t *testing.T) {
+	store := &blockingKvStore{release: make(chan struct{})}
+	store.InMemoryStore.Set(context.Background(), "alpha", "1")
+	server := &KvStoreServer{Store: store}
+
+	const callers = 5
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := server.Get(context.Background(), &proto.GetRequest{Key: "alpha"})
+			if err != nil || resp.Value != "1" {
+				t.Errorf("Get() = %v, %v", resp, err)
+			}
+		}()
+	}
+	for server.CoalescingStats().Calls < callers {
+		time.Sleep(time.Millisecond)
+	}
+	close(store.release)
+	wg.Wait()
+
+	if reads := store.reads.Load(); reads != 1 {
+		t.Errorf("store reads = %d, want 1", reads)
+	}
+	if stats := server.CoalescingStats(); stats.Coalesced != callers-1 {
+		t.Errorf("CoalescingStats() = %+v, want %d coalesced", stats, callers-1)
+	}
+
+	// A timed out caller gets DeadlineExceeded
+	store.release = make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := server.Get(ctx, &proto.GetRequest{Key: "alpha"})
+	close(store.release)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Get() error = %v, want DeadlineExceeded", err)
+	}
+}
+
 func TestKvStoreServer_Set(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -106,7 +175,7 @@ func TestKvStoreServer_Set(t *testing.T) {
 		{
 			name:    "missing key",
 			key:     "",
-			wantErr: status.Errorf(codes.InvalidArgument, "key cannot be empty"),
+			wantErr: status.Errorf(codes.InvalidArgument, "invalid key: key cannot be empty"),
 		},
 		{
 			name:     "missing value",
@@ -127,7 +196,7 @@ func TestKvStoreServer_Set(t *testing.T) {
 			// Call the Set method
 			resp, err := server.Set(context.Background(), &proto.SetRequest{Key: tt.key, Value: tt.value})
 
-			if (err != nil) && !errors.Is(err, tt.wantErr) {
+			if (err != nil) && !sameStatus(err, tt.wantErr) {
 				t.Errorf("Set() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
@@ -139,6 +208,77 @@ func TestKvStoreServer_Set(t *testing.T) {
 	}
 }
 
+// expiringKvStore is a store recording the TTL keys are set with
+type expiringKvStore struct {
+	inmemorystore.InMemoryStore
+	ttls sync.Map
+}
+
+func (e *expiringKvStore) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	e.ttls.Store(key, ttl)
+	return e.Set(ctx, key, value)
+}
+
+func TestKvStoreServer_SetTTL(t *testing.T) {
+	tests := []struct {
+		name     string
+		store    kvstore.KeyValueStore
+		request  *proto.SetRequest
+		wantCode codes.Code
+		wantTTL  time.Duration
+	}{
+		{
+			name:    "expiring store",
+			store:   &expiringKvStore{},
+			request: &proto.SetRequest{Key: "session", Value: "value", TtlMs: 1500},
+			wantTTL: 1500 * time.Millisecond,
+		},
+		{
+			name:     "negative ttl",
+			store:    &expiringKvStore{},
+			request:  &proto.SetRequest{Key: "session", Value: "value", TtlMs: -1},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "store without ttl",
+			store:    &inmemorystore.InMemoryStore{},
+			request:  &proto.SetRequest{Key: "session", Value: "value", TtlMs: 1500},
+			wantCode: codes.Unimplemented,
+		},
+		{
+			name:     "namespace store without ttl",
+			store:    &inmemorystore.InMemoryStore{},
+			request:  &proto.SetRequest{Key: "session", Value: "value", TtlMs: 1500, Namespace: "team-a"},
+			wantCode: codes.Unimplemented,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &KvStoreServer{
+				Store: tt.store,
+				Namespaces: kvstore.NewNamespaces(func() (kvstore.KeyValueStore, error) {
+					return tt.store, nil
+				}),
+			}
+			ctx := context.Background()
+			server.Namespaces.Create("team-a", kvstore.Quota{})
+
+			_, err := server.Set(ctx, tt.request)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Set() error = %v, want %v", err, tt.wantCode)
+			}
+			if tt.wantTTL == 0 {
+				return
+			}
+			ttl, _ := tt.store.(*expiringKvStore).ttls.Load(tt.request.GetKey())
+			if ttl != tt.wantTTL {
+				t.Errorf("Set() TTL = %v, want %v", ttl, tt.wantTTL)
+			}
+		})
+	}
+}
+
 func TestKvStoreServer_Delete(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -177,7 +317,7 @@ func TestKvStoreServer_Delete(t *testing.T) {
 			// Call the Delete method
 			resp, err := server.Delete(context.Background(), &proto.DeleteRequest{Key: tt.key})
 
-			if (err != nil) && !errors.Is(err, tt.wantErr) {
+			if (err != nil) && !sameStatus(err, tt.wantErr) {
 				t.Errorf("Delete() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
@@ -188,3 +328,256 @@ func TestKvStoreServer_Delete(t *testing.T) {
 		})
 	}
 }
+
+func TestKvStoreServer_ListKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		store    kvstore.KeyValueStore
+		prefix   string
+		limit    int32
+		want     []string
+		wantCode codes.Code
+	}{
+		{
+			name:  "all keys without reserved keys",
+			store: &inmemorystore.InMemoryStore{},
+			want:  []string{"user-1", "user-2", "zone"},
+		},
+		{
+			name:   "prefix",
+			store:  &inmemorystore.InMemoryStore{},
+			prefix: "user-",
+			want:   []string{"user-1", "user-2"},
+		},
+		{
+			name:  "limit",
+			store: &inmemorystore.InMemoryStore{},
+			limit: 1,
+			want:  []string{"user-1"},
+		},
+		{
+			name:     "store cannot list keys",
+			store:    &mockKvStore{},
+			wantCode: codes.Unimplemented,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, key := range []string{"zone", "user-2", "user-1", LockPrefix + "jobs", schema.RegistryKey} {
+				tt.store.Set(ctx, key, "test-value")
+			}
+			server := &KvStoreServer{Store: tt.store}
+
+			resp, err := server.ListKeys(ctx, &proto.ListKeysRequest{Prefix: tt.prefix, Limit: tt.limit})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("ListKeys() error = %v, want %v", err, tt.wantCode)
+			}
+			if err == nil && !reflect.DeepEqual(resp.Keys, tt.want) {
+				t.Errorf("ListKeys() keys = %v, want %v", resp.Keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestKvStoreServer_Namespaces(t *testing.T) {
+	server := &KvStoreServer{
+		Store: &mockKvStore{},
+		Namespaces: kvstore.NewNamespaces(func() (kvstore.KeyValueStore, error) {
+			return &inmemorystore.InMemoryStore{}, nil
+		}),
+	}
+	ctx := context.Background()
+
+	_, err := server.Set(ctx, &proto.SetRequest{Key: "test-key", Value: "test-value", Namespace: "team-a"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Set() in missing namespace error = %v, want %v", err, codes.NotFound)
+	}
+
+	_, err = server.CreateNamespace(ctx, &proto.CreateNamespaceRequest{Name: "team-a", Quota: &proto.Quota{MaxKeys: 1}})
+	if err != nil {
+		t.Fatalf("CreateNamespace() error = %v", err)
+	}
+
+	_, err = server.CreateNamespace(ctx, &proto.CreateNamespaceRequest{Name: "team-a"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateNamespace() error = %v, want %v", err, codes.AlreadyExists)
+	}
+
+	_, err = server.CreateNamespace(ctx, &proto.CreateNamespaceRequest{Name: "team/a"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateNamespace() error = %v, want %v", err, codes.InvalidArgument)
+	}
+
+	_, err = server.Set(ctx, &proto.SetRequest{Key: "test-key", Value: "test-value", Namespace: "team-a"})
+	if err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	_, err = server.Set(ctx, &proto.SetRequest{Key: "other-key", Value: "test-value", Namespace: "team-a"})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Set() over quota error = %v, want %v", err, codes.ResourceExhausted)
+	}
+
+	list, err := server.ListNamespaces(ctx, &proto.ListNamespacesRequest{})
+	if err != nil {
+		t.Fatalf("ListNamespaces() error = %v", err)
+	}
+	if len(list.Namespaces) != 1 || list.Namespaces[0].Name != "team-a" || list.Namespaces[0].Keys != 1 {
+		t.Errorf("ListNamespaces() response = %v", list)
+	}
+
+	_, err = server.DropNamespace(ctx, &proto.DropNamespaceRequest{Name: "team-a"})
+	if err != nil {
+		t.Fatalf("DropNamespace() error = %v", err)
+	}
+
+	_, err = server.Get(ctx, &proto.GetRequest{Key: "test-key", Namespace: "team-a"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Get() in dropped namespace error = %v, want %v", err, codes.NotFound)
+	}
+}
+
+func TestKvStoreServer_SetPolicy(t *testing.T) {
+	server := &KvStoreServer{
+		Store: &mockKvStore{},
+		Namespaces: kvstore.NewNamespaces(func() (kvstore.KeyValueStore, error) {
+			return &inmemorystore.InMemoryStore{}, nil
+		}),
+		Policies: util.NewPolicies(util.Policy{KeyPattern: util.DefaultPolicy.KeyPattern, MaxValueSize: 8}),
+	}
+	ctx := context.Background()
+
+	_, err := server.Set(ctx, &proto.SetRequest{Key: "test-key!", Value: ""})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("Set() error = %v, want %v", err, codes.InvalidArgument)
+	}
+	if len(st.Details()) != 1 {
+		t.Fatalf("Set() details = %v, want one BadRequest", st.Details())
+	}
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	if !ok || len(badRequest.FieldViolations) != 2 {
+		t.Errorf("Set() details = %v, want two field violations", st.Details())
+	}
+
+	allowEmpty := true
+	_, err = server.CreateNamespace(ctx, &proto.CreateNamespaceRequest{
+		Name:   "team-a",
+		Policy: &proto.ValidationPolicy{KeyPattern: `^[a-z/]+$`, AllowEmptyValue: &allowEmpty},
+	})
+	if err != nil {
+		t.Fatalf("CreateNamespace() error = %v", err)
+	}
+
+	_, err = server.Set(ctx, &proto.SetRequest{Key: "config/service", Namespace: "team-a"})
+	if err != nil {
+		t.Errorf("Set() with namespace policy error = %v", err)
+	}
+
+	// Fields the override leaves unset keep the server policy
+	_, err = server.Set(ctx, &proto.SetRequest{Key: "config/size", Value: "too large", Namespace: "team-a"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Set() over the server max value size error = %v, want %v", err, codes.InvalidArgument)
+	}
+
+	// The override is restored by a server started over the same stores
+	restarted := &KvStoreServer{
+		Store:      server.Store,
+		Namespaces: server.Namespaces,
+		Policies:   util.NewPolicies(server.Policies.Default),
+	}
+	if err := restarted.LoadPolicies(ctx); err != nil {
+		t.Fatalf("LoadPolicies() error = %v", err)
+	}
+	_, err = restarted.Set(ctx, &proto.SetRequest{Key: "config/other", Namespace: "team-a"})
+	if err != nil {
+		t.Errorf("Set() with restored namespace policy error = %v", err)
+	}
+
+	_, err = server.CreateNamespace(ctx, &proto.CreateNamespaceRequest{
+		Name:   "team-b",
+		Policy: &proto.ValidationPolicy{KeyPattern: `[`},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateNamespace() error = %v, want %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestKvStoreServer_Schemas(t *testing.T) {
+	server := &KvStoreServer{
+		Store:   &inmemorystore.InMemoryStore{},
+		Schemas: schema.NewRegistry(),
+	}
+	ctx := context.Background()
+
+	_, err := server.RegisterSchema(ctx, &proto.RegisterSchemaRequest{
+		Prefix: "config-",
+		Schema: `{"type": "object", "properties": {"port": {"type": "integer"}}}`,
+	})
+	if err != nil {
+		t.Fatalf("RegisterSchema() error = %v", err)
+	}
+
+	_, err = server.Set(ctx, &proto.SetRequest{Key: "config-api", Value: `{"port": 8081}`})
+	if err != nil {
+		t.Errorf("Set() conforming value error = %v", err)
+	}
+
+	_, err = server.Set(ctx, &proto.SetRequest{Key: "config-api", Value: `{"port": "8081"}`})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument || len(st.Details()) != 1 {
+		t.Fatalf("Set() non-conforming value error = %v, want %v with details", err, codes.InvalidArgument)
+	}
+	violation := st.Details()[0].(*errdetails.BadRequest).FieldViolations[0]
+	if violation.Field != "value" || violation.Description != "$.port: expected integer, got string" {
+		t.Errorf("Set() field violation = %v", violation)
+	}
+
+	// The registry key cannot be overwritten directly
+	_, err = server.Set(ctx, &proto.SetRequest{Key: schema.RegistryKey, Value: `{}`})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Set() registry error = %v, want %v", err, codes.InvalidArgument)
+	}
+
+	if _, err := server.UnregisterSchema(ctx, &proto.UnregisterSchemaRequest{Prefix: "config-"}); err != nil {
+		t.Fatalf("UnregisterSchema() error = %v", err)
+	}
+	_, err = server.Set(ctx, &proto.SetRequest{Key: "config-api", Value: `{"port": "8081"}`})
+	if err != nil {
+		t.Errorf("Set() after unregistering error = %v", err)
+	}
+
+	list, err := server.ListSchemas(ctx, &proto.ListSchemasRequest{})
+	if err != nil || len(list.Schemas) != 0 {
+		t.Errorf("ListSchemas() = %v, %v", list, err)
+	}
+
+	_, err = server.UnregisterSchema(ctx, &proto.UnregisterSchemaRequest{Prefix: "config-"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("UnregisterSchema() error = %v, want %v", err, codes.NotFound)
+	}
+}
+
+func TestKvStoreServer_ReservedKeys(t *testing.T) {
+	store := &inmemorystore.InMemoryStore{}
+	server := &KvStoreServer{Store: store}
+	ctx := context.Background()
+	store.Set(ctx, LockPrefix+"jobs", `{"lease": 1, "token": 1}`)
+
+	for _, key := range []string{schema.RegistryKey, LockPrefix + "jobs", ElectionPrefix + "leader", RateLimitPrefix + "client", QueueConfigPrefix + "jobs"} {
+		if _, err := server.Set(ctx, &proto.SetRequest{Key: key, Value: "forged"}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Set(%q) error = %v, want %v", key, err, codes.InvalidArgument)
+		}
+		if _, err := server.Delete(ctx, &proto.DeleteRequest{Key: key}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Delete(%q) error = %v, want %v", key, err, codes.InvalidArgument)
+		}
+		if _, err := server.HashSet(ctx, &proto.HashSetRequest{Key: key, Fields: map[string]string{"a": "1"}}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("HashSet(%q) error = %v, want %v", key, err, codes.InvalidArgument)
+		}
+	}
+	if value, _ := store.Get(ctx, LockPrefix+"jobs"); value != `{"lease": 1, "token": 1}` {
+		t.Errorf("lock key = %q, want it unchanged", value)
+	}
+}
@@ -0,0 +1,90 @@
+package transport
+
+import (
+	"censys/proto/gen/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+)
+
+// invalidationBufferSize is the number of invalidations a watcher may fall
+// behind by before it is disconnected
+const invalidationBufferSize = 1024
+
+// invalidations fans invalidations out to watchers. A watcher that cannot
+// keep up is disconnected, so its cache can reset instead of serving stale
+// values. The zero value is ready to use.
+type invalidations struct {
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+}
+
+// watcher receives invalidations until done is closed
+type watcher struct {
+	invalidations chan *proto.Invalidation
+	done          chan struct{}
+}
+
+// watch registers a watcher of the invalidations published from now on
+func (i *invalidations) watch() *watcher {
+	w := &watcher{
+		invalidations: make(chan *proto.Invalidation, invalidationBufferSize),
+		done:          make(chan struct{}),
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.watchers == nil {
+		i.watchers = make(map[*watcher]struct{})
+	}
+	i.watchers[w] = struct{}{}
+	return w
+}
+
+// unwatch removes a watcher
+func (i *invalidations) unwatch(w *watcher) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.watchers[w]; ok {
+		delete(i.watchers, w)
+		close(w.done)
+	}
+}
+
+// publish delivers an invalidation to every watcher
+func (i *invalidations) publish(invalidation *proto.Invalidation) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for w := range i.watchers {
+		select {
+		case w.invalidations <- invalidation:
+		default:
+			delete(i.watchers, w)
+			close(w.done)
+		}
+	}
+}
+
+// WatchInvalidations streams an invalidation for every key written or
+// deleted from now on, starting with a reset once the watch is active. A
+// watcher that falls too far behind is disconnected with ResourceExhausted.
+func (s *KvStoreServer) WatchInvalidations(request *proto.WatchInvalidationsRequest, stream proto.KvStoreService_WatchInvalidationsServer) error {
+	w := s.invalidations.watch()
+	defer s.invalidations.unwatch(w)
+
+	if err := stream.Send(&proto.Invalidation{Reset_: true}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case invalidation := <-w.invalidations:
+			if err := stream.Send(invalidation); err != nil {
+				return err
+			}
+		case <-w.done:
+			return status.Errorf(codes.ResourceExhausted, "watcher disconnected for falling behind")
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package transport
+
+import (
+	inmemorystore "censys/internal/kvstore/inmemory"
+	"censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+type mockInvalidationStream struct {
+	grpc.ServerStream
+	ctx           context.Context
+	invalidations chan *proto.Invalidation
+}
+
+func (m *mockInvalidationStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockInvalidationStream) Send(invalidation *proto.Invalidation) error {
+	m.invalidations <- invalidation
+	return nil
+}
+
+func TestKvStoreServer_WatchInvalidations(t *testing.T) {
+	server := &KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &mockInvalidationStream{ctx: ctx, invalidations: make(chan *proto.Invalidation, 1)}
+
+	done := make(chan error)
+	go func() {
+		done <- server.WatchInvalidations(&proto.WatchInvalidationsRequest{}, stream)
+	}()
+	if first := <-stream.invalidations; !first.GetReset_() {
+		t.Fatalf("first invalidation = %v, want a reset", first)
+	}
+
+	writes := []struct {
+		name  string
+		write func() error
+		want  *proto.Invalidation
+	}{
+		{"set", func() error {
+			_, err := server.Set(ctx, &proto.SetRequest{Key: "alpha", Value: "1"})
+			return err
+		}, &proto.Invalidation{Namespace: "default", Key: "alpha"}},
+		{"delete", func() error {
+			_, err := server.Delete(ctx, &proto.DeleteRequest{Key: "alpha"})
+			return err
+		}, &proto.Invalidation{Namespace: "default", Key: "alpha"}},
+		{"list push", func() error {
+			_, err := server.ListPush(ctx, &proto.ListPushRequest{Key: "jobs", Values: []string{"1"}})
+			return err
+		}, &proto.Invalidation{Namespace: "default", Key: "jobs"}},
+	}
+	for _, tt := range writes {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.write(); err != nil {
+				t.Fatalf("write error = %v", err)
+			}
+			if got := <-stream.invalidations; got.GetNamespace() != tt.want.Namespace || got.GetKey() != tt.want.Key {
+				t.Errorf("invalidation = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("WatchInvalidations() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestKvStoreServer_WatchInvalidationsSlowWatcher(t *testing.T) {
+	server := &KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &mockInvalidationStream{ctx: ctx, invalidations: make(chan *proto.Invalidation)}
+
+	done := make(chan error)
+	go func() {
+		done <- server.WatchInvalidations(&proto.WatchInvalidationsRequest{}, stream)
+	}()
+	<-stream.invalidations
+
+	// The watcher blocks on its first send while its buffer fills up
+	for i := 0; i < invalidationBufferSize+2; i++ {
+		server.changed("", "alpha")
+	}
+	go func() {
+		for {
+			select {
+			case <-stream.invalidations:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if err := <-done; status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("WatchInvalidations() error = %v, want ResourceExhausted", err)
+	}
+}
@@ -0,0 +1,436 @@
+package transport
+
+import (
+	"censys/internal/kvstore"
+	"censys/internal/lease"
+	"censys/proto/gen/proto"
+	"context"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"sync"
+	"time"
+)
+
+// LockPrefix prefixes the reserved keys holding locks
+const LockPrefix = "_locks/"
+
+// LeaseStateKey is the reserved key of the default keyspace bounding the
+// fencing tokens and lease IDs handed out, so that both keep increasing
+// across restarts
+const LeaseStateKey = "_leases"
+
+// leaseStateStep is how far the bounds are moved past the fencing token or
+// lease ID that exceeded them, so that they are written once in a while
+// rather than on every lock and lease
+const leaseStateStep = 1000
+
+// leaseState is the value of LeaseStateKey
+type leaseState struct {
+	Revision int64 `json:"revision"`
+	Lease    int64 `json:"lease"`
+}
+
+// lockHolder is the value of a lock key
+type lockHolder struct {
+	Lease int64 `json:"lease"`
+	Token int64 `json:"token"`
+	// Candidate identifies the leader holding an election
+	Candidate string `json:"candidate,omitempty"`
+}
+
+// notifier wakes up the callers waiting for locks to change hands. The zero
+// value is ready to use.
+type notifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// wait returns a channel closed on the next notification
+func (n *notifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+func (n *notifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+// Release deletes keys whose lease expired or was revoked. It is the release
+// function of the server's lessor. Keys written again since the lease ended
+// are no longer attached to it, and are kept.
+func (s *KvStoreServer) Release(id int64, keys []lease.Key) {
+	ctx := context.Background()
+	for _, k := range keys {
+		store, err := s.keyspace(k.Namespace)
+		if err != nil {
+			// The namespace was dropped along with its keys
+			continue
+		}
+
+		unlock := s.locks.lock(k.Namespace, k.Key)
+		if !s.Leases.Attached(id, k) {
+			unlock()
+			continue
+		}
+		if _, ok := store.Get(ctx, k.Key); ok && store.Delete(ctx, k.Key) == nil {
+			s.revision.Add(1)
+			s.changed(k.Namespace, k.Key)
+		}
+		unlock()
+	}
+	s.lockChanges.notify()
+}
+
+// RecoverLeases prepares the locks and leases of a server started over the
+// stores of an earlier run, and must be called before serving. Leases do not
+// survive restarts, so the locks and election leaders held by the leases of
+// the earlier run are released, and fencing tokens and lease IDs resume
+// above the ones handed out before.
+func (s *KvStoreServer) RecoverLeases(ctx context.Context) error {
+	var state leaseState
+	if value, ok := s.Store.Get(ctx, LeaseStateKey); ok {
+		if err := json.Unmarshal([]byte(value), &state); err != nil {
+			return fmt.Errorf("corrupt lease state: %w", err)
+		}
+	}
+
+	for _, namespace := range s.namespaceNames() {
+		store, err := s.keyspace(namespace)
+		if err != nil {
+			return err
+		}
+		scanner, ok := store.(kvstore.Scanner)
+		if !ok {
+			return fmt.Errorf("store of namespace %q cannot list its locks", namespace)
+		}
+		for _, prefix := range []string{LockPrefix, ElectionPrefix} {
+			keys, err := scanner.Keys(ctx, prefix)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				// Corrupt holders are released as well
+				holder, _, _ := readHolder(ctx, store, key)
+				state.Revision = max(state.Revision, holder.Token)
+				state.Lease = max(state.Lease, holder.Lease)
+				if err := store.Delete(ctx, key); err != nil {
+					return fmt.Errorf("releasing %s of namespace %q: %w", key, namespace, err)
+				}
+			}
+		}
+	}
+
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+	s.leaseState = state
+	s.revision.Store(max(s.revision.Load(), state.Revision))
+	if s.Leases != nil {
+		s.Leases.Seed(state.Lease)
+	}
+	return nil
+}
+
+// reserve moves the persisted bounds past a fencing token or lease ID about
+// to be handed out, if it exceeds them. A zero token or ID is ignored.
+func (s *KvStoreServer) reserve(ctx context.Context, token int64, leaseID int64) error {
+	s.leaseMu.Lock()
+	defer s.leaseMu.Unlock()
+
+	state := s.leaseState
+	if token > state.Revision {
+		state.Revision = token + leaseStateStep
+	}
+	if leaseID > state.Lease {
+		state.Lease = leaseID + leaseStateStep
+	}
+	if state == s.leaseState {
+		return nil
+	}
+	data, _ := json.Marshal(state)
+	if err := s.Store.Set(ctx, LeaseStateKey, string(data)); err != nil {
+		return storeError(err, "", LeaseStateKey)
+	}
+	s.leaseState = state
+	return nil
+}
+
+// attach attaches a key about to be written to a lease. A zero lease
+// detaches the key from its previous lease instead.
+func (s *KvStoreServer) attach(id int64, namespace string, key string) error {
+	if id == 0 {
+		s.detach(namespace, key)
+		return nil
+	}
+	if s.Leases == nil {
+		return status.Errorf(codes.Unimplemented, "leases are not enabled")
+	}
+	if err := s.Leases.Attach(id, lease.Key{Namespace: canonicalNamespace(namespace), Key: key}); err != nil {
+		return status.Errorf(codes.NotFound, "%s", err)
+	}
+	return nil
+}
+
+// detach detaches a key from its lease, if any
+func (s *KvStoreServer) detach(namespace string, key string) {
+	if s.Leases != nil {
+		s.Leases.Detach(lease.Key{Namespace: canonicalNamespace(namespace), Key: key})
+	}
+}
+
+// LeaseGrant grants a lease that expires unless it is kept alive
+func (s *KvStoreServer) LeaseGrant(ctx context.Context, request *proto.LeaseGrantRequest) (*proto.LeaseGrantResponse, error) {
+	if s.Leases == nil {
+		return &proto.LeaseGrantResponse{
+			Success: false,
+		}, status.Errorf(codes.Unimplemented, "leases are not enabled")
+	}
+
+	id, err := s.Leases.Grant(time.Duration(request.GetTtlMs()) * time.Millisecond)
+	if err != nil {
+		return &proto.LeaseGrantResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	if err := s.reserve(ctx, 0, id); err != nil {
+		s.Leases.Revoke(id)
+		return &proto.LeaseGrantResponse{
+			Success: false,
+		}, err
+	}
+
+	return &proto.LeaseGrantResponse{
+		Id:      id,
+		TtlMs:   request.GetTtlMs(),
+		Success: true,
+	}, nil
+}
+
+// LeaseRevoke ends a lease, deleting its keys and releasing its locks
+func (s *KvStoreServer) LeaseRevoke(ctx context.Context, request *proto.LeaseRevokeRequest) (*proto.LeaseRevokeResponse, error) {
+	if s.Leases == nil {
+		return &proto.LeaseRevokeResponse{
+			Success: false,
+		}, status.Errorf(codes.Unimplemented, "leases are not enabled")
+	}
+
+	if err := s.Leases.Revoke(request.GetId()); err != nil {
+		return &proto.LeaseRevokeResponse{
+			Success: false,
+		}, status.Errorf(codes.NotFound, "%s", err)
+	}
+
+	return &proto.LeaseRevokeResponse{
+		Success: true,
+	}, nil
+}
+
+// LeaseKeepAlive restarts the TTL of every lease id received on the stream
+// and answers with the new TTL, or a TTL of 0 when the lease has expired
+func (s *KvStoreServer) LeaseKeepAlive(stream proto.KvStoreService_LeaseKeepAliveServer) error {
+	if s.Leases == nil {
+		return status.Errorf(codes.Unimplemented, "leases are not enabled")
+	}
+
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp := &proto.LeaseKeepAliveResponse{
+			Id: request.GetId(),
+		}
+		if ttl, err := s.Leases.KeepAlive(request.GetId()); err == nil {
+			resp.TtlMs = ttl.Milliseconds()
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// LeaseTimeToLive returns the remaining TTL of a lease and its keys
+func (s *KvStoreServer) LeaseTimeToLive(ctx context.Context, request *proto.LeaseTimeToLiveRequest) (*proto.LeaseTimeToLiveResponse, error) {
+	if s.Leases == nil {
+		return &proto.LeaseTimeToLiveResponse{
+			Success: false,
+		}, status.Errorf(codes.Unimplemented, "leases are not enabled")
+	}
+
+	ttl, keys, err := s.Leases.TimeToLive(request.GetId())
+	if err != nil {
+		return &proto.LeaseTimeToLiveResponse{
+			Success: false,
+		}, status.Errorf(codes.NotFound, "%s", err)
+	}
+
+	resp := &proto.LeaseTimeToLiveResponse{
+		TtlMs:   ttl.Milliseconds(),
+		Success: true,
+	}
+	for _, k := range keys {
+		resp.Keys = append(resp.Keys, &proto.AttachedKey{
+			Namespace: k.Namespace,
+			Key:       k.Key,
+		})
+	}
+	return resp, nil
+}
+
+// Lock acquires a named lock for a lease, waiting until the lock is free.
+// The returned fencing token is the revision of the write that acquired the
+// lock, so it is greater than the token of every earlier holder and stale
+// holders can be detected by the resources they access.
+func (s *KvStoreServer) Lock(ctx context.Context, request *proto.LockRequest) (*proto.LockResponse, error) {
+	if request.GetName() == "" || request.GetLease() == 0 {
+		return &proto.LockResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "lock name and lease are required")
+	}
+
+	holder, err := s.acquire(ctx, request.GetNamespace(), LockPrefix+request.GetName(), request.GetLease(), "")
+	if err != nil {
+		return &proto.LockResponse{
+			Success: false,
+		}, err
+	}
+
+	return &proto.LockResponse{
+		Token:   holder.Token,
+		Success: true,
+	}, nil
+}
+
+// Unlock releases a lock held with the given fencing token
+func (s *KvStoreServer) Unlock(ctx context.Context, request *proto.UnlockRequest) (*proto.UnlockResponse, error) {
+	err := s.release(ctx, request.GetNamespace(), LockPrefix+request.GetName(), request.GetToken())
+	if err != nil {
+		return &proto.UnlockResponse{
+			Success: false,
+		}, err
+	}
+
+	return &proto.UnlockResponse{
+		Success: true,
+	}, nil
+}
+
+// acquire writes a lock key attached to a lease, waiting until the key is
+// free. A lease acquiring a key it already holds gets the current holder.
+func (s *KvStoreServer) acquire(ctx context.Context, namespace string, key string, leaseID int64, candidate string) (lockHolder, error) {
+	if s.Leases == nil {
+		return lockHolder{}, status.Errorf(codes.Unimplemented, "leases are not enabled")
+	}
+	store, err := s.keyspace(namespace)
+	if err != nil {
+		return lockHolder{}, err
+	}
+
+	for {
+		// Wait for changes from before the attempt so none is missed
+		changed := s.lockChanges.wait()
+
+		holder, acquired, err := s.tryAcquire(ctx, store, namespace, key, leaseID, candidate)
+		if err != nil {
+			return lockHolder{}, err
+		}
+		if acquired {
+			return holder, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return lockHolder{}, status.FromContextError(ctx.Err()).Err()
+		case <-changed:
+		}
+	}
+}
+
+// tryAcquire writes a lock key if it is free
+func (s *KvStoreServer) tryAcquire(ctx context.Context, store kvstore.KeyValueStore, namespace string, key string, leaseID int64, candidate string) (lockHolder, bool, error) {
+	unlock := s.locks.lock(namespace, key)
+	defer unlock()
+
+	holder, held, err := readHolder(ctx, store, key)
+	if err != nil || held {
+		return holder, err == nil && holder.Lease == leaseID, err
+	}
+
+	if err := s.Leases.Attach(leaseID, lease.Key{Namespace: canonicalNamespace(namespace), Key: key}); err != nil {
+		return lockHolder{}, false, status.Errorf(codes.NotFound, "%s", err)
+	}
+	holder = lockHolder{
+		Lease:     leaseID,
+		Token:     s.revision.Add(1),
+		Candidate: candidate,
+	}
+	if err := s.reserve(ctx, holder.Token, 0); err != nil {
+		s.detach(namespace, key)
+		return lockHolder{}, false, err
+	}
+	data, _ := json.Marshal(holder)
+	if err := store.Set(ctx, key, string(data)); err != nil {
+		s.detach(namespace, key)
+		return lockHolder{}, false, storeError(err, namespace, key)
+	}
+	s.lockChanges.notify()
+	return holder, true, nil
+}
+
+// release deletes a lock key held with the given fencing token
+func (s *KvStoreServer) release(ctx context.Context, namespace string, key string, token int64) error {
+	store, err := s.keyspace(namespace)
+	if err != nil {
+		return err
+	}
+
+	unlock := s.locks.lock(namespace, key)
+	defer unlock()
+
+	holder, held, err := readHolder(ctx, store, key)
+	if err != nil {
+		return err
+	}
+	if !held {
+		return status.Errorf(codes.NotFound, "lock is not held")
+	}
+	if holder.Token != token {
+		return status.Errorf(codes.FailedPrecondition, "stale fencing token %d, lock is held with token %d", token, holder.Token)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		return storeError(err, namespace, key)
+	}
+	s.revision.Add(1)
+	s.detach(namespace, key)
+	s.lockChanges.notify()
+	return nil
+}
+
+// readHolder reads the holder of a lock key
+func readHolder(ctx context.Context, store kvstore.KeyValueStore, key string) (lockHolder, bool, error) {
+	value, ok := store.Get(ctx, key)
+	if !ok {
+		return lockHolder{}, false, nil
+	}
+	var holder lockHolder
+	if err := json.Unmarshal([]byte(value), &holder); err != nil {
+		return lockHolder{}, false, status.Errorf(codes.Internal, "corrupt lock: %s", err)
+	}
+	return holder, true, nil
+}
@@ -0,0 +1,213 @@
+package transport
+
+import (
+	"censys/internal/kvstore"
+	inmemorystore "censys/internal/kvstore/inmemory"
+	"censys/internal/lease"
+	"censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func newLeaseServer() *KvStoreServer {
+	server := &KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	server.Leases = lease.NewLessor(server.Release)
+	return server
+}
+
+func TestKvStoreServer_LeaseKeys(t *testing.T) {
+	server := newLeaseServer()
+	ctx := context.Background()
+
+	grant, err := server.LeaseGrant(ctx, &proto.LeaseGrantRequest{TtlMs: 60000})
+	if err != nil {
+		t.Fatalf("LeaseGrant() error = %v", err)
+	}
+	if _, err := server.Set(ctx, &proto.SetRequest{Key: "worker-1", Value: "alive", Lease: grant.Id}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	_, err = server.Set(ctx, &proto.SetRequest{Key: "worker-2", Value: "alive", Lease: grant.Id + 1})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Set() with unknown lease error = %v, want %v", err, codes.NotFound)
+	}
+
+	ttl, err := server.LeaseTimeToLive(ctx, &proto.LeaseTimeToLiveRequest{Id: grant.Id})
+	if err != nil || len(ttl.Keys) != 1 || ttl.Keys[0].Key != "worker-1" || ttl.Keys[0].Namespace != "default" {
+		t.Errorf("LeaseTimeToLive() = %v, %v", ttl, err)
+	}
+
+	if _, err := server.LeaseRevoke(ctx, &proto.LeaseRevokeRequest{Id: grant.Id}); err != nil {
+		t.Fatalf("LeaseRevoke() error = %v", err)
+	}
+	if _, err := server.Get(ctx, &proto.GetRequest{Key: "worker-1"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Get() after revoke error = %v, want %v", err, codes.NotFound)
+	}
+}
+
+func TestKvStoreServer_LeaseTypedValue(t *testing.T) {
+	server := newLeaseServer()
+	server.Namespaces = kvstore.NewNamespaces(func() (kvstore.KeyValueStore, error) {
+		return &inmemorystore.InMemoryStore{}, nil
+	})
+	ctx := context.Background()
+
+	grant, err := server.LeaseGrant(ctx, &proto.LeaseGrantRequest{TtlMs: 60000})
+	if err != nil {
+		t.Fatalf("LeaseGrant() error = %v", err)
+	}
+	if _, err := server.CreateNamespace(ctx, &proto.CreateNamespaceRequest{Name: "team-a"}); err != nil {
+		t.Fatalf("CreateNamespace() error = %v", err)
+	}
+	if _, err := server.Set(ctx, &proto.SetRequest{Key: "worker-1", Value: "alive", Lease: grant.Id, Namespace: "team-a"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	// Dropping the namespace removes the value but leaves the key attached
+	if _, err := server.DropNamespace(ctx, &proto.DropNamespaceRequest{Name: "team-a"}); err != nil {
+		t.Fatalf("DropNamespace() error = %v", err)
+	}
+	if _, err := server.CreateNamespace(ctx, &proto.CreateNamespaceRequest{Name: "team-a"}); err != nil {
+		t.Fatalf("CreateNamespace() error = %v", err)
+	}
+
+	_, err = server.HashSet(ctx, &proto.HashSetRequest{Key: "worker-1", Fields: map[string]string{"status": "idle"}, Namespace: "team-a"})
+	if err != nil {
+		t.Fatalf("HashSet() error = %v", err)
+	}
+	if _, err := server.LeaseRevoke(ctx, &proto.LeaseRevokeRequest{Id: grant.Id}); err != nil {
+		t.Fatalf("LeaseRevoke() error = %v", err)
+	}
+	got, err := server.HashGetAll(ctx, &proto.HashGetAllRequest{Key: "worker-1", Namespace: "team-a"})
+	if err != nil || got.Fields["status"] != "idle" {
+		t.Errorf("HashGetAll() after revoke = %v, %v, want the hash written without a lease", got, err)
+	}
+}
+
+func TestKvStoreServer_ReleaseRewrittenKey(t *testing.T) {
+	server := &KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	ctx := context.Background()
+	// The key is written again without a lease after its lease ended, but
+	// before its keys are released
+	server.Leases = lease.NewLessor(func(id int64, keys []lease.Key) {
+		if _, err := server.Set(ctx, &proto.SetRequest{Key: "worker-1", Value: "kept"}); err != nil {
+			t.Errorf("Set() error = %v", err)
+		}
+		server.Release(id, keys)
+	})
+
+	grant, _ := server.LeaseGrant(ctx, &proto.LeaseGrantRequest{TtlMs: 60000})
+	if _, err := server.Set(ctx, &proto.SetRequest{Key: "worker-1", Value: "alive", Lease: grant.Id}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := server.LeaseRevoke(ctx, &proto.LeaseRevokeRequest{Id: grant.Id}); err != nil {
+		t.Fatalf("LeaseRevoke() error = %v", err)
+	}
+	if resp, err := server.Get(ctx, &proto.GetRequest{Key: "worker-1"}); err != nil || resp.Value != "kept" {
+		t.Errorf("Get() after release = %v, %v, want the value written again", resp, err)
+	}
+}
+
+func TestKvStoreServer_RecoverLeases(t *testing.T) {
+	store := &inmemorystore.InMemoryStore{}
+	ctx := context.Background()
+
+	before := &KvStoreServer{Store: store}
+	before.Leases = lease.NewLessor(before.Release)
+	if err := before.RecoverLeases(ctx); err != nil {
+		t.Fatalf("RecoverLeases() error = %v", err)
+	}
+	grant, _ := before.LeaseGrant(ctx, &proto.LeaseGrantRequest{TtlMs: 60000})
+	held, err := before.Lock(ctx, &proto.LockRequest{Name: "billing", Lease: grant.Id})
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	// A server restarted over the same store releases the lock of the lease
+	// that did not survive, and hands out greater tokens and new lease IDs
+	after := &KvStoreServer{Store: store}
+	after.Leases = lease.NewLessor(after.Release)
+	if err := after.RecoverLeases(ctx); err != nil {
+		t.Fatalf("RecoverLeases() after restart error = %v", err)
+	}
+	regrant, _ := after.LeaseGrant(ctx, &proto.LeaseGrantRequest{TtlMs: 60000})
+	if regrant.Id <= grant.Id {
+		t.Errorf("LeaseGrant() after restart id = %d, want greater than %d", regrant.Id, grant.Id)
+	}
+	lockCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	relocked, err := after.Lock(lockCtx, &proto.LockRequest{Name: "billing", Lease: regrant.Id})
+	if err != nil {
+		t.Fatalf("Lock() after restart error = %v", err)
+	}
+	if relocked.Token <= held.Token {
+		t.Errorf("Lock() after restart token = %d, want greater than %d", relocked.Token, held.Token)
+	}
+}
+
+func TestKvStoreServer_Lock(t *testing.T) {
+	server := newLeaseServer()
+	ctx := context.Background()
+
+	first, _ := server.LeaseGrant(ctx, &proto.LeaseGrantRequest{TtlMs: 60000})
+	second, _ := server.LeaseGrant(ctx, &proto.LeaseGrantRequest{TtlMs: 60000})
+
+	held, err := server.Lock(ctx, &proto.LockRequest{Name: "billing", Lease: first.Id})
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	// The lock is held, so the second lease waits until its deadline
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = server.Lock(timeout, &proto.LockRequest{Name: "billing", Lease: second.Id})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Lock() held lock error = %v, want %v", err, codes.DeadlineExceeded)
+	}
+
+	acquired := make(chan *proto.LockResponse)
+	go func() {
+		resp, _ := server.Lock(ctx, &proto.LockRequest{Name: "billing", Lease: second.Id})
+		acquired <- resp
+	}()
+
+	_, err = server.Unlock(ctx, &proto.UnlockRequest{Name: "billing", Token: held.Token - 1})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Unlock() stale token error = %v, want %v", err, codes.FailedPrecondition)
+	}
+	if _, err := server.Unlock(ctx, &proto.UnlockRequest{Name: "billing", Token: held.Token}); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+
+	select {
+	case next := <-acquired:
+		if next == nil || next.Token <= held.Token {
+			t.Errorf("Lock() after unlock = %v, want a token greater than %d", next, held.Token)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Lock() was not granted after unlock")
+	}
+}
+
+func TestKvStoreServer_LockLeaseExpiry(t *testing.T) {
+	server := newLeaseServer()
+	ctx := context.Background()
+
+	expiring, _ := server.LeaseGrant(ctx, &proto.LeaseGrantRequest{TtlMs: 20})
+	waiting, _ := server.LeaseGrant(ctx, &proto.LeaseGrantRequest{TtlMs: 60000})
+	held, err := server.Lock(ctx, &proto.LockRequest{Name: "billing", Lease: expiring.Id})
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	next, err := server.Lock(timeout, &proto.LockRequest{Name: "billing", Lease: waiting.Id})
+	if err != nil {
+		t.Fatalf("Lock() after lease expiry error = %v", err)
+	}
+	if next.Token <= held.Token {
+		t.Errorf("Lock() token = %d, want greater than %d", next.Token, held.Token)
+	}
+}
@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"censys/proto/gen/proto"
+	"context"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// PolicyKey is the reserved key holding the validation policy override a
+// namespace was created with, dropped along with the namespace
+const PolicyKey = "_policy"
+
+// savePolicy stores the validation policy override of a namespace
+func (s *KvStoreServer) savePolicy(ctx context.Context, namespace string, policy *proto.ValidationPolicy) error {
+	store, err := s.keyspace(namespace)
+	if err != nil {
+		return err
+	}
+	// The override rather than the resulting policy is stored, so that the
+	// fields it does not set follow the server policy across restarts
+	data, err := protojson.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return store.Set(ctx, PolicyKey, string(data))
+}
+
+// LoadPolicies restores the validation policies of the namespaces of a
+// server started over the stores of an earlier run, and must be called
+// before serving
+func (s *KvStoreServer) LoadPolicies(ctx context.Context) error {
+	if s.Policies == nil || s.Namespaces == nil {
+		return nil
+	}
+	for _, ns := range s.Namespaces.List() {
+		value, ok := ns.Get(ctx, PolicyKey)
+		if !ok {
+			continue
+		}
+		var override proto.ValidationPolicy
+		if err := protojson.Unmarshal([]byte(value), &override); err != nil {
+			return fmt.Errorf("corrupt policy of namespace %q: %w", ns.Name, err)
+		}
+		policy, err := policyFromProto(s.Policies.Default, &override)
+		if err != nil {
+			return fmt.Errorf("policy of namespace %q: %w", ns.Name, err)
+		}
+		s.Policies.Set(ns.Name, policy)
+	}
+	return nil
+}
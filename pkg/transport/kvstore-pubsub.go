@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"censys/internal/pubsub"
+	"censys/proto/gen/proto"
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Publish delivers a message to the current subscribers of a channel
+func (s *KvStoreServer) Publish(ctx context.Context, request *proto.PublishRequest) (*proto.PublishResponse, error) {
+	namespace, err := s.channelNamespace(request.GetNamespace())
+	if err != nil {
+		return &proto.PublishResponse{
+			Success: false,
+		}, err
+	}
+	if request.GetChannel() == "" {
+		return &proto.PublishResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "channel cannot be empty")
+	}
+
+	receivers := s.Broker.Publish(namespace, request.GetChannel(), request.GetMessage())
+	return &proto.PublishResponse{
+		Receivers: int64(receivers),
+		Success:   true,
+	}, nil
+}
+
+// Subscribe streams the messages published to the requested channels and to
+// the channels matching the requested patterns. A subscriber that falls too
+// far behind is disconnected with ResourceExhausted.
+func (s *KvStoreServer) Subscribe(request *proto.SubscribeRequest, stream proto.KvStoreService_SubscribeServer) error {
+	namespace, err := s.channelNamespace(request.GetNamespace())
+	if err != nil {
+		return err
+	}
+	if len(request.GetChannels()) == 0 && len(request.GetPatterns()) == 0 {
+		return status.Errorf(codes.InvalidArgument, "at least one channel or pattern is required")
+	}
+
+	sub, err := s.Broker.Subscribe(namespace, request.GetChannels(), request.GetPatterns())
+	if errors.Is(err, pubsub.ErrInvalidPattern) {
+		return status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "%s", err)
+	}
+	defer sub.Close()
+
+	// Send the headers now so clients know the subscription is active
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-sub.Done():
+			return status.Errorf(codes.ResourceExhausted, "%s", sub.Err())
+		case msg := <-sub.Messages():
+			err := stream.Send(&proto.Message{
+				Channel: msg.Channel,
+				Pattern: msg.Pattern,
+				Message: msg.Payload,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// channelNamespace checks that publish/subscribe is enabled and the
+// namespace exists, and returns its canonical name
+func (s *KvStoreServer) channelNamespace(namespace string) (string, error) {
+	if s.Broker == nil {
+		return "", status.Errorf(codes.Unimplemented, "publish/subscribe is not enabled")
+	}
+	if _, err := s.keyspace(namespace); err != nil {
+		return "", err
+	}
+	return canonicalNamespace(namespace), nil
+}
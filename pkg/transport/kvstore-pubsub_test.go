@@ -0,0 +1,117 @@
+package transport
+
+import (
+	"censys/internal/pubsub"
+	"censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+type mockSubscribeStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	messages chan *proto.Message
+}
+
+func (m *mockSubscribeStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockSubscribeStream) SendHeader(md metadata.MD) error {
+	return nil
+}
+
+func (m *mockSubscribeStream) Send(msg *proto.Message) error {
+	m.messages <- msg
+	return nil
+}
+
+func TestKvStoreServer_Subscribe(t *testing.T) {
+	server := &KvStoreServer{Store: &mockKvStore{}, Broker: pubsub.NewBroker(8)}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &mockSubscribeStream{ctx: ctx, messages: make(chan *proto.Message, 1)}
+
+	done := make(chan error)
+	go func() {
+		done <- server.Subscribe(&proto.SubscribeRequest{Patterns: []string{"orders.*"}}, stream)
+	}()
+
+	// Publish until the subscription is registered
+	deadline := time.Now().Add(time.Second)
+	for {
+		resp, err := server.Publish(context.Background(), &proto.PublishRequest{
+			Channel:   "orders.created",
+			Namespace: "default",
+			Message:   "42",
+		})
+		if err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+		if resp.Receivers == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscription was never registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	msg := <-stream.messages
+	if msg.Channel != "orders.created" || msg.Pattern != "orders.*" || msg.Message != "42" {
+		t.Errorf("Subscribe() sent %v", msg)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Subscribe() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestKvStoreServer_SubscribeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		broker   *pubsub.Broker
+		request  *proto.SubscribeRequest
+		wantCode codes.Code
+	}{
+		{
+			name:     "disabled",
+			request:  &proto.SubscribeRequest{Channels: []string{"news"}},
+			wantCode: codes.Unimplemented,
+		},
+		{
+			name:     "nothing to subscribe to",
+			broker:   pubsub.NewBroker(1),
+			request:  &proto.SubscribeRequest{},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "invalid pattern",
+			broker:   pubsub.NewBroker(1),
+			request:  &proto.SubscribeRequest{Patterns: []string{"news.["}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "unknown namespace",
+			broker:   pubsub.NewBroker(1),
+			request:  &proto.SubscribeRequest{Channels: []string{"news"}, Namespace: "missing"},
+			wantCode: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &KvStoreServer{Store: &mockKvStore{}, Broker: tt.broker}
+			stream := &mockSubscribeStream{ctx: context.Background()}
+			err := server.Subscribe(tt.request, stream)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Subscribe() error = %v, want %v", err, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package transport
+
+import (
+	"censys/internal/kvstore"
+	"censys/internal/kvstore/types"
+	"censys/proto/gen/proto"
+	"context"
+	"encoding/json"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// DefaultVisibilityTimeout is how long dequeued messages stay hidden when
+// the request does not set a visibility timeout
+const DefaultVisibilityTimeout = 30 * time.Second
+
+// QueueConfigPrefix prefixes the reserved keys holding the config of queues
+const QueueConfigPrefix = "_queues/"
+
+// queueConfig is the value of a queue config key
+type queueConfig struct {
+	// MaxAttempts is how many times a message is delivered before it is
+	// moved to the dead-letter queue, 0 never dead-letters messages
+	MaxAttempts int `json:"max_attempts"`
+}
+
+// DeadLetterQueue returns the name of the queue receiving the messages of
+// a queue that exceeded their delivery attempts
+func DeadLetterQueue(name string) string {
+	return name + "-dlq"
+}
+
+// Enqueue appends a message to a queue, creating the queue if needed
+func (s *KvStoreServer) Enqueue(ctx context.Context, request *proto.EnqueueRequest) (*proto.EnqueueResponse, error) {
+	if request.GetDelayMs() < 0 {
+		return &proto.EnqueueResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "delay cannot be negative")
+	}
+
+	var msg types.QueueMessage
+	err := s.updateValue(ctx, request.GetNamespace(), request.GetName(), types.Queue, func(v *types.Value) error {
+		msg = v.Enqueue(request.GetBody(), time.Duration(request.GetDelayMs())*time.Millisecond, time.Now())
+		return nil
+	})
+	if err != nil {
+		return &proto.EnqueueResponse{
+			Success: false,
+		}, err
+	}
+
+	return &proto.EnqueueResponse{
+		Id:      msg.ID,
+		Success: true,
+	}, nil
+}
+
+// ConfigureQueue sets the config of a queue, which applies to every
+// consumer of the queue
+func (s *KvStoreServer) ConfigureQueue(ctx context.Context, request *proto.ConfigureQueueRequest) (*proto.ConfigureQueueResponse, error) {
+	if request.GetName() == "" || request.GetMaxAttempts() < 0 {
+		return &proto.ConfigureQueueResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "name is required and max attempts cannot be negative")
+	}
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return &proto.ConfigureQueueResponse{
+			Success: false,
+		}, err
+	}
+
+	key := QueueConfigPrefix + request.GetName()
+	unlock := s.locks.lock(request.GetNamespace(), key)
+	defer unlock()
+
+	// The default config is not stored
+	if request.GetMaxAttempts() == 0 {
+		err = store.Delete(ctx, key)
+	} else {
+		data, _ := json.Marshal(queueConfig{MaxAttempts: int(request.GetMaxAttempts())})
+		err = store.Set(ctx, key, string(data))
+	}
+	if err != nil {
+		return &proto.ConfigureQueueResponse{
+			Success: false,
+		}, storeError(err, request.GetNamespace(), key)
+	}
+
+	return &proto.ConfigureQueueResponse{
+		Success: true,
+	}, nil
+}
+
+// loadQueueConfig reads the config of a queue, returning the default config
+// if the queue was never configured
+func loadQueueConfig(ctx context.Context, store kvstore.KeyValueStore, name string) (queueConfig, error) {
+	var config queueConfig
+	value, ok := store.Get(ctx, QueueConfigPrefix+name)
+	if !ok {
+		return config, nil
+	}
+	if err := json.Unmarshal([]byte(value), &config); err != nil {
+		return config, status.Errorf(codes.Internal, "corrupt queue config: %s", err)
+	}
+	return config, nil
+}
+
+// Dequeue leases visible messages of a queue to the caller, which must ack
+// them before the visibility timeout or they are delivered again. Messages
+// delivered the max attempts of the queue config are moved to its
+// dead-letter queue instead.
+func (s *KvStoreServer) Dequeue(ctx context.Context, request *proto.DequeueRequest) (*proto.DequeueResponse, error) {
+	if request.GetMaxMessages() < 0 || request.GetVisibilityTimeoutMs() < 0 {
+		return &proto.DequeueResponse{}, status.Errorf(codes.InvalidArgument, "max messages and visibility timeout cannot be negative")
+	}
+	limit := int(request.GetMaxMessages())
+	if limit == 0 {
+		limit = 1
+	}
+	visibility := time.Duration(request.GetVisibilityTimeoutMs()) * time.Millisecond
+	if visibility == 0 {
+		visibility = DefaultVisibilityTimeout
+	}
+	if err := writable(request.GetName()); err != nil {
+		return &proto.DequeueResponse{}, err
+	}
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return &proto.DequeueResponse{}, err
+	}
+	config, err := loadQueueConfig(ctx, store, request.GetName())
+	if err != nil {
+		return &proto.DequeueResponse{}, err
+	}
+
+	// The queue and its dead-letter queue are locked together, so that dead
+	// letters are moved between them at once
+	deadLetters := DeadLetterQueue(request.GetName())
+	unlock := s.locks.lock(request.GetNamespace(), request.GetName(), deadLetters)
+	defer unlock()
+
+	queue, err := loadValue(ctx, store, request.GetName(), types.Queue)
+	if err != nil {
+		return &proto.DequeueResponse{}, err
+	}
+	delivered, dead := queue.Dequeue(limit, visibility, config.MaxAttempts, time.Now())
+	if len(dead) > 0 {
+		dlq, err := loadValue(ctx, store, deadLetters, types.Queue)
+		if err != nil {
+			return &proto.DequeueResponse{}, err
+		}
+		now := time.Now()
+		for _, msg := range dead {
+			msg.VisibleAt = now
+			dlq.Queue = append(dlq.Queue, msg)
+		}
+		// The dead letters are written first, so that a failed write leaves
+		// them in the queue, or in both queues, but never in neither
+		if err := s.storeValue(ctx, request.GetNamespace(), store, deadLetters, types.Queue, dlq); err != nil {
+			return &proto.DequeueResponse{}, err
+		}
+	}
+	if err := s.storeValue(ctx, request.GetNamespace(), store, request.GetName(), types.Queue, queue); err != nil {
+		return &proto.DequeueResponse{}, err
+	}
+
+	messages := make([]*proto.QueueMessage, 0, len(delivered))
+	for _, msg := range delivered {
+		messages = append(messages, &proto.QueueMessage{
+			Id:       msg.ID,
+			Body:     msg.Body,
+			Attempts: int64(msg.Attempts),
+			Receipt:  msg.Receipt,
+		})
+	}
+	return &proto.DequeueResponse{
+		Messages:     messages,
+		DeadLettered: int64(len(dead)),
+	}, nil
+}
+
+// Ack removes a processed message from its queue
+func (s *KvStoreServer) Ack(ctx context.Context, request *proto.AckRequest) (*proto.AckResponse, error) {
+	err := s.updateValue(ctx, request.GetNamespace(), request.GetName(), types.Queue, func(v *types.Value) error {
+		return queueError(v.Ack(request.GetId(), request.GetReceipt()))
+	})
+	if err != nil {
+		return &proto.AckResponse{
+			Success: false,
+		}, err
+	}
+
+	return &proto.AckResponse{
+		Success: true,
+	}, nil
+}
+
+// Nack returns a message to its queue to be delivered again after a delay
+func (s *KvStoreServer) Nack(ctx context.Context, request *proto.NackRequest) (*proto.NackResponse, error) {
+	if request.GetDelayMs() < 0 {
+		return &proto.NackResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "delay cannot be negative")
+	}
+
+	err := s.updateValue(ctx, request.GetNamespace(), request.GetName(), types.Queue, func(v *types.Value) error {
+		delay := time.Duration(request.GetDelayMs()) * time.Millisecond
+		return queueError(v.Nack(request.GetId(), request.GetReceipt(), delay, time.Now()))
+	})
+	if err != nil {
+		return &proto.NackResponse{
+			Success: false,
+		}, err
+	}
+
+	return &proto.NackResponse{
+		Success: true,
+	}, nil
+}
+
+// queueError maps queue errors to gRPC status errors
+func queueError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, types.ErrMessageNotFound):
+		return status.Errorf(codes.NotFound, "%s", err)
+	case errors.Is(err, types.ErrInvalidReceipt):
+		return status.Errorf(codes.FailedPrecondition, "%s", err)
+	default:
+		return status.Errorf(codes.Internal, "%s", err)
+	}
+}
@@ -0,0 +1,118 @@
+package transport
+
+import (
+	inmemorystore "censys/internal/kvstore/inmemory"
+	"censys/internal/kvstore/types"
+	"censys/proto/gen/proto"
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+)
+
+func TestKvStoreServer_Queue(t *testing.T) {
+	server := &KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	ctx := context.Background()
+
+	if _, err := server.Enqueue(ctx, &proto.EnqueueRequest{Name: "jobs", Body: "resize"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if _, err := server.Enqueue(ctx, &proto.EnqueueRequest{Name: "jobs", Body: "later", DelayMs: 60000}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	resp, err := server.Dequeue(ctx, &proto.DequeueRequest{Name: "jobs", MaxMessages: 10})
+	if err != nil || len(resp.Messages) != 1 || resp.Messages[0].Body != "resize" {
+		t.Fatalf("Dequeue() = %v, %v", resp, err)
+	}
+	msg := resp.Messages[0]
+
+	_, err = server.Ack(ctx, &proto.AckRequest{Name: "jobs", Id: msg.Id, Receipt: "stale"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Ack() stale receipt error = %v, want %v", err, codes.FailedPrecondition)
+	}
+	if _, err := server.Ack(ctx, &proto.AckRequest{Name: "jobs", Id: msg.Id, Receipt: msg.Receipt}); err != nil {
+		t.Errorf("Ack() error = %v", err)
+	}
+	_, err = server.Ack(ctx, &proto.AckRequest{Name: "jobs", Id: msg.Id, Receipt: msg.Receipt})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Ack() twice error = %v, want %v", err, codes.NotFound)
+	}
+}
+
+func TestKvStoreServer_QueueDeadLetter(t *testing.T) {
+	server := &KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	ctx := context.Background()
+
+	if _, err := server.ConfigureQueue(ctx, &proto.ConfigureQueueRequest{Name: "jobs", MaxAttempts: 1}); err != nil {
+		t.Fatalf("ConfigureQueue() error = %v", err)
+	}
+	if _, err := server.Enqueue(ctx, &proto.EnqueueRequest{Name: "jobs", Body: "poison"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	resp, err := server.Dequeue(ctx, &proto.DequeueRequest{Name: "jobs"})
+	if err != nil || len(resp.Messages) != 1 {
+		t.Fatalf("Dequeue() = %v, %v", resp, err)
+	}
+	msg := resp.Messages[0]
+	if _, err := server.Nack(ctx, &proto.NackRequest{Name: "jobs", Id: msg.Id, Receipt: msg.Receipt}); err != nil {
+		t.Fatalf("Nack() error = %v", err)
+	}
+
+	resp, err = server.Dequeue(ctx, &proto.DequeueRequest{Name: "jobs"})
+	if err != nil || len(resp.Messages) != 0 || resp.DeadLettered != 1 {
+		t.Fatalf("Dequeue() = %v, %v, want one dead letter", resp, err)
+	}
+
+	resp, err = server.Dequeue(ctx, &proto.DequeueRequest{Name: DeadLetterQueue("jobs")})
+	if err != nil || len(resp.Messages) != 1 || resp.Messages[0].Id != msg.Id || resp.Messages[0].Attempts != 2 {
+		t.Errorf("Dequeue() dead-letter queue = %v, %v", resp, err)
+	}
+}
+
+// deadLetterFailingStore is a store failing every write of a dead-letter
+// queue
+type deadLetterFailingStore struct {
+	inmemorystore.InMemoryStore
+}
+
+func (d *deadLetterFailingStore) Set(ctx context.Context, key string, value string) error {
+	if strings.HasSuffix(key, "-dlq") {
+		return errors.New("disk full")
+	}
+	return d.InMemoryStore.Set(ctx, key, value)
+}
+
+func TestKvStoreServer_QueueDeadLetterFailure(t *testing.T) {
+	store := &deadLetterFailingStore{}
+	server := &KvStoreServer{Store: store}
+	ctx := context.Background()
+
+	if _, err := server.ConfigureQueue(ctx, &proto.ConfigureQueueRequest{Name: "jobs", MaxAttempts: 1}); err != nil {
+		t.Fatalf("ConfigureQueue() error = %v", err)
+	}
+	if _, err := server.Enqueue(ctx, &proto.EnqueueRequest{Name: "jobs", Body: "poison"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	resp, err := server.Dequeue(ctx, &proto.DequeueRequest{Name: "jobs"})
+	if err != nil || len(resp.Messages) != 1 {
+		t.Fatalf("Dequeue() = %v, %v", resp, err)
+	}
+	msg := resp.Messages[0]
+	if _, err := server.Nack(ctx, &proto.NackRequest{Name: "jobs", Id: msg.Id, Receipt: msg.Receipt}); err != nil {
+		t.Fatalf("Nack() error = %v", err)
+	}
+
+	if _, err := server.Dequeue(ctx, &proto.DequeueRequest{Name: "jobs"}); err == nil {
+		t.Fatalf("Dequeue() with a failing dead-letter queue succeeded")
+	}
+
+	// The dead letter is still in its queue
+	raw, _ := store.Get(ctx, "jobs")
+	v, err := types.Decode(raw, types.Queue)
+	if err != nil || len(v.Queue) != 1 || v.Queue[0].ID != msg.Id {
+		t.Errorf("queue after failed dead-lettering = %+v, %v, want the dead letter", v, err)
+	}
+}
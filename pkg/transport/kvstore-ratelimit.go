@@ -0,0 +1,139 @@
+package transport
+
+import (
+	"censys/internal/kvstore"
+	"censys/pkg/ratelimit"
+	"censys/proto/gen/proto"
+	"context"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"time"
+)
+
+// RateLimitPrefix prefixes the reserved keys holding token buckets
+const RateLimitPrefix = "_ratelimit/"
+
+// DefaultRateLimitSweepInterval is how often full token buckets are deleted
+const DefaultRateLimitSweepInterval = time.Minute
+
+// storedBucket is the value of a token bucket key
+type storedBucket struct {
+	ratelimit.Bucket
+	// Full is when the bucket is refilled. A full bucket is the same as a
+	// missing one, so it is deleted by the next sweep.
+	Full time.Time `json:"full"`
+}
+
+// TakeToken takes a token from a token bucket stored in the keyspace, so
+// rate limiters running on several replicas share their limits
+func (s *KvStoreServer) TakeToken(ctx context.Context, request *proto.TakeTokenRequest) (*proto.TakeTokenResponse, error) {
+	if request.GetKey() == "" || request.GetRate() <= 0 || request.GetBurst() < 1 {
+		return &proto.TakeTokenResponse{}, status.Errorf(codes.InvalidArgument, "key, a positive rate and a burst of at least 1 are required")
+	}
+	store, err := s.keyspace(request.GetNamespace())
+	if err != nil {
+		return &proto.TakeTokenResponse{}, err
+	}
+
+	key := RateLimitPrefix + request.GetKey()
+	unlock := s.locks.lock(request.GetNamespace(), key)
+	defer unlock()
+
+	var bucket storedBucket
+	if value, ok := store.Get(ctx, key); ok {
+		if err := json.Unmarshal([]byte(value), &bucket); err != nil {
+			return &proto.TakeTokenResponse{}, status.Errorf(codes.Internal, "corrupt token bucket: %s", err)
+		}
+	}
+	now := time.Now()
+	result := bucket.Take(ratelimit.Limit{
+		Rate:  request.GetRate(),
+		Burst: int(request.GetBurst()),
+	}, now)
+	bucket.Full = now.Add(result.Reset)
+
+	data, _ := json.Marshal(bucket)
+	if err := store.Set(ctx, key, string(data)); err != nil {
+		return &proto.TakeTokenResponse{}, storeError(err, request.GetNamespace(), key)
+	}
+
+	return &proto.TakeTokenResponse{
+		Allowed:      result.Allowed,
+		Limit:        int64(result.Limit),
+		Remaining:    int64(result.Remaining),
+		RetryAfterMs: result.RetryAfter.Milliseconds(),
+		ResetMs:      result.Reset.Milliseconds(),
+	}, nil
+}
+
+// SweepRateLimits deletes the full token buckets of every namespace each
+// interval until ctx is done, so that the buckets of clients that went idle
+// do not pile up
+func (s *KvStoreServer) SweepRateLimits(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.sweepRateLimits(ctx, time.Now()); err != nil {
+				log.Printf("Sweeping token buckets failed: %s", err)
+			}
+		}
+	}
+}
+
+// sweepRateLimits deletes the token buckets full at now, returning how many
+// were deleted
+func (s *KvStoreServer) sweepRateLimits(ctx context.Context, now time.Time) (int, error) {
+	deleted := 0
+	for _, namespace := range s.namespaceNames() {
+		store, err := s.keyspace(namespace)
+		if err != nil {
+			// The namespace was dropped along with its buckets
+			continue
+		}
+		scanner, ok := store.(kvstore.Scanner)
+		if !ok {
+			return deleted, fmt.Errorf("store of namespace %q cannot list its token buckets", namespace)
+		}
+		keys, err := scanner.Keys(ctx, RateLimitPrefix)
+		if err != nil {
+			return deleted, err
+		}
+		for _, key := range keys {
+			ok, err := s.sweepBucket(ctx, store, namespace, key, now)
+			if err != nil {
+				return deleted, err
+			}
+			if ok {
+				deleted++
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// sweepBucket deletes a token bucket if it is full at now
+func (s *KvStoreServer) sweepBucket(ctx context.Context, store kvstore.KeyValueStore, namespace string, key string, now time.Time) (bool, error) {
+	unlock := s.locks.lock(namespace, key)
+	defer unlock()
+
+	value, ok := store.Get(ctx, key)
+	if !ok {
+		return false, nil
+	}
+	// Corrupt buckets are deleted as well, as TakeToken cannot use them
+	var bucket storedBucket
+	if err := json.Unmarshal([]byte(value), &bucket); err == nil && bucket.Full.After(now) {
+		return false, nil
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,55 @@
+package transport
+
+import (
+	inmemorystore "censys/internal/kvstore/inmemory"
+	"censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestKvStoreServer_TakeToken(t *testing.T) {
+	server := &KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	ctx := context.Background()
+	request := &proto.TakeTokenRequest{Key: "GET /store|10.0.0.1", Rate: 0.001, Burst: 2}
+
+	for i, wantAllowed := range []bool{true, true, false} {
+		resp, err := server.TakeToken(ctx, request)
+		if err != nil {
+			t.Fatalf("TakeToken() error = %v", err)
+		}
+		if resp.Allowed != wantAllowed || resp.Limit != 2 {
+			t.Errorf("take %d: TakeToken() = %v, want allowed %v", i, resp, wantAllowed)
+		}
+		if !resp.Allowed && resp.RetryAfterMs <= 0 {
+			t.Errorf("take %d: TakeToken() retry after = %v, want positive", i, resp.RetryAfterMs)
+		}
+	}
+
+	_, err := server.TakeToken(ctx, &proto.TakeTokenRequest{Key: "bucket", Rate: 1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("TakeToken() without burst error = %v, want %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestKvStoreServer_SweepRateLimits(t *testing.T) {
+	store := &inmemorystore.InMemoryStore{}
+	server := &KvStoreServer{Store: store}
+	ctx := context.Background()
+
+	// The bucket refills a token per second
+	if _, err := server.TakeToken(ctx, &proto.TakeTokenRequest{Key: "client", Rate: 1, Burst: 2}); err != nil {
+		t.Fatalf("TakeToken() error = %v", err)
+	}
+	if deleted, err := server.sweepRateLimits(ctx, time.Now()); err != nil || deleted != 0 {
+		t.Errorf("sweepRateLimits() of a bucket refilling = %d, %v, want none deleted", deleted, err)
+	}
+	if deleted, err := server.sweepRateLimits(ctx, time.Now().Add(time.Minute)); err != nil || deleted != 1 {
+		t.Errorf("sweepRateLimits() of a full bucket = %d, %v, want 1 deleted", deleted, err)
+	}
+	if _, ok := store.Get(ctx, RateLimitPrefix+"client"); ok {
+		t.Error("full bucket was not deleted")
+	}
+}
@@ -0,0 +1,450 @@
+package transport
+
+import (
+	"censys/internal/kvstore"
+	"censys/internal/kvstore/types"
+	"censys/proto/gen/proto"
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+)
+
+// HashSet sets fields of a hash, creating the hash if needed
+func (s *KvStoreServer) HashSet(ctx context.Context, request *proto.HashSetRequest) (*proto.HashSetResponse, error) {
+	var added int64
+	err := s.updateValue(ctx, request.GetNamespace(), request.GetKey(), types.Hash, func(v *types.Value) error {
+		for field, value := range request.GetFields() {
+			if _, ok := v.Hash[field]; !ok {
+				added++
+			}
+			v.Hash[field] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return &proto.HashSetResponse{}, err
+	}
+
+	return &proto.HashSetResponse{
+		Added: added,
+	}, nil
+}
+
+// HashGet returns the value of a hash field
+func (s *KvStoreServer) HashGet(ctx context.Context, request *proto.HashGetRequest) (*proto.HashGetResponse, error) {
+	v, err := s.readValue(ctx, request.GetNamespace(), request.GetKey(), types.Hash)
+	if err != nil {
+		return &proto.HashGetResponse{
+			Success: false,
+		}, err
+	}
+
+	value, ok := v.Hash[request.GetField()]
+	if !ok {
+		return &proto.HashGetResponse{
+			Success: false,
+		}, status.Errorf(codes.NotFound, "field not found")
+	}
+
+	return &proto.HashGetResponse{
+		Value:   value,
+		Success: true,
+	}, nil
+}
+
+// HashDelete removes fields from a hash
+func (s *KvStoreServer) HashDelete(ctx context.Context, request *proto.HashDeleteRequest) (*proto.HashDeleteResponse, error) {
+	var removed int64
+	err := s.updateValue(ctx, request.GetNamespace(), request.GetKey(), types.Hash, func(v *types.Value) error {
+		for _, field := range request.GetFields() {
+			if _, ok := v.Hash[field]; ok {
+				removed++
+				delete(v.Hash, field)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return &proto.HashDeleteResponse{}, err
+	}
+
+	return &proto.HashDeleteResponse{
+		Removed: removed,
+	}, nil
+}
+
+// HashGetAll returns every field of a hash
+func (s *KvStoreServer) HashGetAll(ctx context.Context, request *proto.HashGetAllRequest) (*proto.HashGetAllResponse, error) {
+	v, err := s.readValue(ctx, request.GetNamespace(), request.GetKey(), types.Hash)
+	if err != nil {
+		return &proto.HashGetAllResponse{}, err
+	}
+
+	return &proto.HashGetAllResponse{
+		Fields: v.Hash,
+	}, nil
+}
+
+// ListPush pushes values onto either end of a list, creating the list if
+// needed. Values pushed on the left end up in reverse order.
+func (s *KvStoreServer) ListPush(ctx context.Context, request *proto.ListPushRequest) (*proto.ListPushResponse, error) {
+	var length int64
+	err := s.updateValue(ctx, request.GetNamespace(), request.GetKey(), types.List, func(v *types.Value) error {
+		for _, value := range request.GetValues() {
+			if request.GetEnd() == proto.ListEnd_LEFT {
+				v.List = append([]string{value}, v.List...)
+			} else {
+				v.List = append(v.List, value)
+			}
+		}
+		length = int64(len(v.List))
+		return nil
+	})
+	if err != nil {
+		return &proto.ListPushResponse{}, err
+	}
+
+	return &proto.ListPushResponse{
+		Length: length,
+	}, nil
+}
+
+// ListPop removes and returns values from either end of a list
+func (s *KvStoreServer) ListPop(ctx context.Context, request *proto.ListPopRequest) (*proto.ListPopResponse, error) {
+	count := int(request.GetCount())
+	if count < 0 {
+		return &proto.ListPopResponse{}, status.Errorf(codes.InvalidArgument, "count cannot be negative")
+	}
+	if count == 0 {
+		count = 1
+	}
+
+	values := []string{}
+	err := s.updateValue(ctx, request.GetNamespace(), request.GetKey(), types.List, func(v *types.Value) error {
+		count = min(count, len(v.List))
+		if request.GetEnd() == proto.ListEnd_LEFT {
+			values = append(values, v.List[:count]...)
+			v.List = v.List[count:]
+			return nil
+		}
+		for i := 0; i < count; i++ {
+			values = append(values, v.List[len(v.List)-1-i])
+		}
+		v.List = v.List[:len(v.List)-count]
+		return nil
+	})
+	if err != nil {
+		return &proto.ListPopResponse{}, err
+	}
+
+	return &proto.ListPopResponse{
+		Values: values,
+	}, nil
+}
+
+// ListRange returns the elements of a list between two inclusive indexes
+func (s *KvStoreServer) ListRange(ctx context.Context, request *proto.ListRangeRequest) (*proto.ListRangeResponse, error) {
+	v, err := s.readValue(ctx, request.GetNamespace(), request.GetKey(), types.List)
+	if err != nil {
+		return &proto.ListRangeResponse{}, err
+	}
+
+	return &proto.ListRangeResponse{
+		Values: v.Range(int(request.GetStart()), int(request.GetStop())),
+	}, nil
+}
+
+// SetAdd adds members to a set, creating the set if needed
+func (s *KvStoreServer) SetAdd(ctx context.Context, request *proto.SetAddRequest) (*proto.SetAddResponse, error) {
+	var added int64
+	err := s.updateValue(ctx, request.GetNamespace(), request.GetKey(), types.Set, func(v *types.Value) error {
+		for _, member := range request.GetMembers() {
+			if _, ok := v.Set[member]; !ok {
+				added++
+				v.Set[member] = struct{}{}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return &proto.SetAddResponse{}, err
+	}
+
+	return &proto.SetAddResponse{
+		Added: added,
+	}, nil
+}
+
+// SetRemove removes members from a set
+func (s *KvStoreServer) SetRemove(ctx context.Context, request *proto.SetRemoveRequest) (*proto.SetRemoveResponse, error) {
+	var removed int64
+	err := s.updateValue(ctx, request.GetNamespace(), request.GetKey(), types.Set, func(v *types.Value) error {
+		for _, member := range request.GetMembers() {
+			if _, ok := v.Set[member]; ok {
+				removed++
+				delete(v.Set, member)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return &proto.SetRemoveResponse{}, err
+	}
+
+	return &proto.SetRemoveResponse{
+		Removed: removed,
+	}, nil
+}
+
+// SetMembers returns the members of a set in sorted order
+func (s *KvStoreServer) SetMembers(ctx context.Context, request *proto.SetMembersRequest) (*proto.SetMembersResponse, error) {
+	v, err := s.readValue(ctx, request.GetNamespace(), request.GetKey(), types.Set)
+	if err != nil {
+		return &proto.SetMembersResponse{}, err
+	}
+
+	return &proto.SetMembersResponse{
+		Members: v.Members(),
+	}, nil
+}
+
+// SetIntersect returns the members present in every given set
+func (s *KvStoreServer) SetIntersect(ctx context.Context, request *proto.SetIntersectRequest) (*proto.SetIntersectResponse, error) {
+	if len(request.GetKeys()) == 0 {
+		return &proto.SetIntersectResponse{}, status.Errorf(codes.InvalidArgument, "at least one key is required")
+	}
+
+	sets := make([]*types.Value, 0, len(request.GetKeys()))
+	for _, key := range request.GetKeys() {
+		v, err := s.readValue(ctx, request.GetNamespace(), key, types.Set)
+		if err != nil {
+			return &proto.SetIntersectResponse{}, err
+		}
+		sets = append(sets, v)
+	}
+
+	return &proto.SetIntersectResponse{
+		Members: types.Intersect(sets...),
+	}, nil
+}
+
+// SortedSetAdd adds members to a sorted set or updates their scores
+func (s *KvStoreServer) SortedSetAdd(ctx context.Context, request *proto.SortedSetAddRequest) (*proto.SortedSetAddResponse, error) {
+	for _, m := range request.GetMembers() {
+		if !isFinite(m.GetScore()) {
+			return &proto.SortedSetAddResponse{}, status.Errorf(codes.InvalidArgument, "score of %q must be a finite number", m.GetMember())
+		}
+	}
+
+	var added int64
+	err := s.updateValue(ctx, request.GetNamespace(), request.GetKey(), types.SortedSet, func(v *types.Value) error {
+		for _, m := range request.GetMembers() {
+			if _, ok := v.Scores[m.GetMember()]; !ok {
+				added++
+			}
+			v.Scores[m.GetMember()] = m.GetScore()
+		}
+		return nil
+	})
+	if err != nil {
+		return &proto.SortedSetAddResponse{}, err
+	}
+
+	return &proto.SortedSetAddResponse{
+		Added: added,
+	}, nil
+}
+
+// SortedSetRemove removes members from a sorted set
+func (s *KvStoreServer) SortedSetRemove(ctx context.Context, request *proto.SortedSetRemoveRequest) (*proto.SortedSetRemoveResponse, error) {
+	var removed int64
+	err := s.updateValue(ctx, request.GetNamespace(), request.GetKey(), types.SortedSet, func(v *types.Value) error {
+		for _, member := range request.GetMembers() {
+			if _, ok := v.Scores[member]; ok {
+				removed++
+				delete(v.Scores, member)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return &proto.SortedSetRemoveResponse{}, err
+	}
+
+	return &proto.SortedSetRemoveResponse{
+		Removed: removed,
+	}, nil
+}
+
+// SortedSetIncrement adds delta to the score of a member, adding the member
+// with a score of delta if it does not exist
+func (s *KvStoreServer) SortedSetIncrement(ctx context.Context, request *proto.SortedSetIncrementRequest) (*proto.SortedSetIncrementResponse, error) {
+	var score float64
+	err := s.updateValue(ctx, request.GetNamespace(), request.GetKey(), types.SortedSet, func(v *types.Value) error {
+		score = v.Scores[request.GetMember()] + request.GetDelta()
+		if !isFinite(score) {
+			return status.Errorf(codes.InvalidArgument, "score must be a finite number")
+		}
+		v.Scores[request.GetMember()] = score
+		return nil
+	})
+	if err != nil {
+		return &proto.SortedSetIncrementResponse{}, err
+	}
+
+	return &proto.SortedSetIncrementResponse{
+		Score: score,
+	}, nil
+}
+
+// SortedSetRank returns the rank and score of a member
+func (s *KvStoreServer) SortedSetRank(ctx context.Context, request *proto.SortedSetRankRequest) (*proto.SortedSetRankResponse, error) {
+	v, err := s.readValue(ctx, request.GetNamespace(), request.GetKey(), types.SortedSet)
+	if err != nil {
+		return &proto.SortedSetRankResponse{
+			Success: false,
+		}, err
+	}
+
+	rank, ok := v.Rank(request.GetMember(), request.GetReverse())
+	if !ok {
+		return &proto.SortedSetRankResponse{
+			Success: false,
+		}, status.Errorf(codes.NotFound, "member not found")
+	}
+
+	return &proto.SortedSetRankResponse{
+		Rank:    int64(rank),
+		Score:   v.Scores[request.GetMember()],
+		Success: true,
+	}, nil
+}
+
+// SortedSetRangeByScore returns a page of the members whose score lies
+// between two inclusive bounds
+func (s *KvStoreServer) SortedSetRangeByScore(ctx context.Context, request *proto.SortedSetRangeByScoreRequest) (*proto.SortedSetRangeResponse, error) {
+	if request.GetOffset() < 0 || request.GetLimit() < 0 {
+		return &proto.SortedSetRangeResponse{}, status.Errorf(codes.InvalidArgument, "offset and limit cannot be negative")
+	}
+
+	v, err := s.readValue(ctx, request.GetNamespace(), request.GetKey(), types.SortedSet)
+	if err != nil {
+		return &proto.SortedSetRangeResponse{}, err
+	}
+
+	members := v.RangeByScore(request.GetMin(), request.GetMax(), int(request.GetOffset()), int(request.GetLimit()), request.GetReverse())
+	return &proto.SortedSetRangeResponse{
+		Members: scoredMembersToProto(members),
+	}, nil
+}
+
+// SortedSetRangeByRank returns the members between two inclusive ranks
+func (s *KvStoreServer) SortedSetRangeByRank(ctx context.Context, request *proto.SortedSetRangeByRankRequest) (*proto.SortedSetRangeResponse, error) {
+	v, err := s.readValue(ctx, request.GetNamespace(), request.GetKey(), types.SortedSet)
+	if err != nil {
+		return &proto.SortedSetRangeResponse{}, err
+	}
+
+	members := v.RangeByRank(int(request.GetStart()), int(request.GetStop()), request.GetReverse())
+	return &proto.SortedSetRangeResponse{
+		Members: scoredMembersToProto(members),
+	}, nil
+}
+
+func scoredMembersToProto(members []types.ScoredMember) []*proto.ScoredMember {
+	converted := make([]*proto.ScoredMember, 0, len(members))
+	for _, m := range members {
+		converted = append(converted, &proto.ScoredMember{
+			Member: m.Member,
+			Score:  m.Score,
+		})
+	}
+	return converted
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// readValue returns the typed value held by a key, or an empty value if the
+// key does not exist
+func (s *KvStoreServer) readValue(ctx context.Context, namespace string, key string, t types.Type) (*types.Value, error) {
+	store, err := s.keyspace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return loadValue(ctx, store, key, t)
+}
+
+// updateValue applies fn to the typed value held by a key while holding the
+// key lock. A missing key starts out as an empty value and a key whose value
+// becomes empty is deleted. Reserved keys are rejected. Typed values are
+// never attached to leases, so a written key is detached from the lease of
+// a value it held before, which would otherwise delete the new value.
+func (s *KvStoreServer) updateValue(ctx context.Context, namespace string, key string, t types.Type, fn func(*types.Value) error) error {
+	if err := writable(key); err != nil {
+		return err
+	}
+	store, err := s.keyspace(namespace)
+	if err != nil {
+		return err
+	}
+
+	unlock := s.locks.lock(namespace, key)
+	defer unlock()
+
+	v, err := loadValue(ctx, store, key, t)
+	if err != nil {
+		return err
+	}
+	if err := fn(v); err != nil {
+		return err
+	}
+	return s.storeValue(ctx, namespace, store, key, t, v)
+}
+
+// storeValue writes a typed value to a key, deleting the key if the value is
+// empty. Callers must hold the lock of the key.
+func (s *KvStoreServer) storeValue(ctx context.Context, namespace string, store kvstore.KeyValueStore, key string, t types.Type, v *types.Value) error {
+	if v.Len() == 0 {
+		if _, ok := store.Get(ctx, key); !ok {
+			return nil
+		}
+		if err := store.Delete(ctx, key); err != nil {
+			return storeError(err, namespace, key)
+		}
+		s.detach(namespace, key)
+		s.revision.Add(1)
+		s.changed(namespace, key)
+		return nil
+	}
+
+	encoded, err := v.Encode()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to encode %s: %s", t, err)
+	}
+	if err := s.set(ctx, namespace, store, key, encoded); err != nil {
+		return err
+	}
+	s.detach(namespace, key)
+	return nil
+}
+
+// loadValue reads and decodes the typed value held by a key
+func loadValue(ctx context.Context, store kvstore.KeyValueStore, key string, t types.Type) (*types.Value, error) {
+	raw, ok := store.Get(ctx, key)
+	if !ok {
+		return types.New(t), nil
+	}
+
+	v, err := types.Decode(raw, t)
+	if errors.Is(err, types.ErrWrongType) {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s", err)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%s", err)
+	}
+	return v, nil
+}
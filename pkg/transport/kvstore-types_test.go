@@ -0,0 +1,213 @@
+package transport
+
+import (
+	inmemorystore "censys/internal/kvstore/inmemory"
+	"censys/proto/gen/proto"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestKvStoreServer_Hash(t *testing.T) {
+	server := &KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	ctx := context.Background()
+
+	set, err := server.HashSet(ctx, &proto.HashSetRequest{Key: "user", Fields: map[string]string{"name": "Ada", "email": "a@b.c"}})
+	if err != nil || set.Added != 2 {
+		t.Fatalf("HashSet() = %v, %v", set, err)
+	}
+	set, err = server.HashSet(ctx, &proto.HashSetRequest{Key: "user", Fields: map[string]string{"name": "Grace"}})
+	if err != nil || set.Added != 0 {
+		t.Fatalf("HashSet() overwrite = %v, %v", set, err)
+	}
+
+	get, err := server.HashGet(ctx, &proto.HashGetRequest{Key: "user", Field: "name"})
+	if err != nil || get.Value != "Grace" {
+		t.Errorf("HashGet() = %v, %v", get, err)
+	}
+	_, err = server.HashGet(ctx, &proto.HashGetRequest{Key: "user", Field: "phone"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("HashGet() missing field error = %v, want %v", err, codes.NotFound)
+	}
+
+	del, err := server.HashDelete(ctx, &proto.HashDeleteRequest{Key: "user", Fields: []string{"name", "email", "phone"}})
+	if err != nil || del.Removed != 2 {
+		t.Errorf("HashDelete() = %v, %v", del, err)
+	}
+
+	// Removing the last field removes the key
+	_, err = server.Get(ctx, &proto.GetRequest{Key: "user"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Get() of emptied hash error = %v, want %v", err, codes.NotFound)
+	}
+}
+
+func TestKvStoreServer_List(t *testing.T) {
+	server := &KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	ctx := context.Background()
+
+	server.ListPush(ctx, &proto.ListPushRequest{Key: "jobs", Values: []string{"b", "c"}})
+	push, err := server.ListPush(ctx, &proto.ListPushRequest{Key: "jobs", Values: []string{"a", "z"}, End: proto.ListEnd_LEFT})
+	if err != nil || push.Length != 4 {
+		t.Fatalf("ListPush() = %v, %v", push, err)
+	}
+
+	rng, err := server.ListRange(ctx, &proto.ListRangeRequest{Key: "jobs", Start: 0, Stop: -1})
+	if err != nil || !reflect.DeepEqual(rng.Values, []string{"z", "a", "b", "c"}) {
+		t.Errorf("ListRange() = %v, %v", rng, err)
+	}
+
+	pop, err := server.ListPop(ctx, &proto.ListPopRequest{Key: "jobs", Count: 2})
+	if err != nil || !reflect.DeepEqual(pop.Values, []string{"c", "b"}) {
+		t.Errorf("ListPop() right = %v, %v", pop, err)
+	}
+	pop, err = server.ListPop(ctx, &proto.ListPopRequest{Key: "jobs", End: proto.ListEnd_LEFT, Count: 5})
+	if err != nil || !reflect.DeepEqual(pop.Values, []string{"z", "a"}) {
+		t.Errorf("ListPop() left = %v, %v", pop, err)
+	}
+	pop, err = server.ListPop(ctx, &proto.ListPopRequest{Key: "jobs"})
+	if err != nil || len(pop.Values) != 0 {
+		t.Errorf("ListPop() empty = %v, %v", pop, err)
+	}
+}
+
+func TestKvStoreServer_SetType(t *testing.T) {
+	server := &KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	ctx := context.Background()
+
+	add, err := server.SetAdd(ctx, &proto.SetAddRequest{Key: "a", Members: []string{"x", "y", "z", "x"}})
+	if err != nil || add.Added != 3 {
+		t.Fatalf("SetAdd() = %v, %v", add, err)
+	}
+	server.SetAdd(ctx, &proto.SetAddRequest{Key: "b", Members: []string{"y", "z"}})
+
+	rem, err := server.SetRemove(ctx, &proto.SetRemoveRequest{Key: "b", Members: []string{"y", "w"}})
+	if err != nil || rem.Removed != 1 {
+		t.Errorf("SetRemove() = %v, %v", rem, err)
+	}
+
+	members, err := server.SetMembers(ctx, &proto.SetMembersRequest{Key: "a"})
+	if err != nil || !reflect.DeepEqual(members.Members, []string{"x", "y", "z"}) {
+		t.Errorf("SetMembers() = %v, %v", members, err)
+	}
+
+	inter, err := server.SetIntersect(ctx, &proto.SetIntersectRequest{Keys: []string{"a", "b"}})
+	if err != nil || !reflect.DeepEqual(inter.Members, []string{"z"}) {
+		t.Errorf("SetIntersect() = %v, %v", inter, err)
+	}
+}
+
+func TestKvStoreServer_WrongType(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(server *KvStoreServer) error
+	}{
+		{
+			name: "get of a set",
+			call: func(server *KvStoreServer) error {
+				_, err := server.Get(context.Background(), &proto.GetRequest{Key: "set"})
+				return err
+			},
+		},
+		{
+			name: "list push onto a set",
+			call: func(server *KvStoreServer) error {
+				_, err := server.ListPush(context.Background(), &proto.ListPushRequest{Key: "set", Values: []string{"a"}})
+				return err
+			},
+		},
+		{
+			name: "hash get of a string",
+			call: func(server *KvStoreServer) error {
+				_, err := server.HashGet(context.Background(), &proto.HashGetRequest{Key: "string", Field: "a"})
+				return err
+			},
+		},
+		{
+			name: "intersect with a string",
+			call: func(server *KvStoreServer) error {
+				_, err := server.SetIntersect(context.Background(), &proto.SetIntersectRequest{Keys: []string{"set", "string"}})
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+			server.Set(context.Background(), &proto.SetRequest{Key: "string", Value: "value"})
+			server.SetAdd(context.Background(), &proto.SetAddRequest{Key: "set", Members: []string{"a"}})
+
+			if err := tt.call(server); status.Code(err) != codes.FailedPrecondition {
+				t.Errorf("error = %v, want %v", err, codes.FailedPrecondition)
+			}
+		})
+	}
+}
+
+func TestKvStoreServer_ForgedType(t *testing.T) {
+	server := &KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	ctx := context.Background()
+
+	// A plain string carrying the marker of typed values would decode as one
+	forged := "\x00kvtype:set:[\"a\"]"
+	if _, err := server.Set(ctx, &proto.SetRequest{Key: "forged", Value: forged}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Set() error = %v, want %v", err, codes.InvalidArgument)
+	}
+	if members, err := server.SetMembers(ctx, &proto.SetMembersRequest{Key: "forged"}); len(members.GetMembers()) != 0 {
+		t.Errorf("SetMembers() = %v, %v, want no members", members, err)
+	}
+}
+
+func TestKvStoreServer_SortedSet(t *testing.T) {
+	server := &KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	ctx := context.Background()
+
+	add, err := server.SortedSetAdd(ctx, &proto.SortedSetAddRequest{Key: "board", Members: []*proto.ScoredMember{
+		{Member: "ada", Score: 30},
+		{Member: "bob", Score: 10},
+		{Member: "cy", Score: 20},
+	}})
+	if err != nil || add.Added != 3 {
+		t.Fatalf("SortedSetAdd() = %v, %v", add, err)
+	}
+
+	_, err = server.SortedSetAdd(ctx, &proto.SortedSetAddRequest{Key: "board", Members: []*proto.ScoredMember{
+		{Member: "eve", Score: math.NaN()},
+	}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SortedSetAdd() NaN error = %v, want %v", err, codes.InvalidArgument)
+	}
+
+	inc, err := server.SortedSetIncrement(ctx, &proto.SortedSetIncrementRequest{Key: "board", Member: "bob", Delta: 25})
+	if err != nil || inc.Score != 35 {
+		t.Errorf("SortedSetIncrement() = %v, %v", inc, err)
+	}
+
+	rank, err := server.SortedSetRank(ctx, &proto.SortedSetRankRequest{Key: "board", Member: "bob", Reverse: true})
+	if err != nil || rank.Rank != 0 || rank.Score != 35 {
+		t.Errorf("SortedSetRank() = %v, %v", rank, err)
+	}
+	_, err = server.SortedSetRank(ctx, &proto.SortedSetRankRequest{Key: "board", Member: "eve"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("SortedSetRank() missing member error = %v, want %v", err, codes.NotFound)
+	}
+
+	byScore, err := server.SortedSetRangeByScore(ctx, &proto.SortedSetRangeByScoreRequest{Key: "board", Min: 20, Max: 30})
+	if err != nil || len(byScore.Members) != 2 || byScore.Members[0].Member != "cy" {
+		t.Errorf("SortedSetRangeByScore() = %v, %v", byScore, err)
+	}
+
+	byRank, err := server.SortedSetRangeByRank(ctx, &proto.SortedSetRangeByRankRequest{Key: "board", Start: 0, Stop: 0, Reverse: true})
+	if err != nil || len(byRank.Members) != 1 || byRank.Members[0].Member != "bob" {
+		t.Errorf("SortedSetRangeByRank() = %v, %v", byRank, err)
+	}
+
+	rem, err := server.SortedSetRemove(ctx, &proto.SortedSetRemoveRequest{Key: "board", Members: []string{"ada", "bob", "cy"}})
+	if err != nil || rem.Removed != 3 {
+		t.Errorf("SortedSetRemove() = %v, %v", rem, err)
+	}
+}
@@ -0,0 +1,37 @@
+package transport
+
+import (
+	"hash/fnv"
+	"slices"
+	"sync"
+)
+
+// keyLocks serialises read-modify-write operations on the same key using a
+// fixed number of striped mutexes. The zero value is ready to use.
+type keyLocks [64]sync.Mutex
+
+// lock locks the stripes of keys of a namespace and returns the function
+// that unlocks them. Stripes are locked in a fixed order, so that locking
+// several keys at once cannot deadlock.
+func (l *keyLocks) lock(namespace string, keys ...string) func() {
+	var stripes []int
+	for _, key := range keys {
+		h := fnv.New32a()
+		h.Write([]byte(canonicalNamespace(namespace)))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		stripes = append(stripes, int(h.Sum32()%uint32(len(l))))
+	}
+	// Keys sharing a stripe lock it once, as the mutexes are not reentrant
+	slices.Sort(stripes)
+	stripes = slices.Compact(stripes)
+
+	for _, i := range stripes {
+		l[i].Lock()
+	}
+	return func() {
+		for _, i := range stripes {
+			l[i].Unlock()
+		}
+	}
+}
@@ -7,6 +7,39 @@ type Server interface {
 	HandleGet(w http.ResponseWriter, r *http.Request)
 	HandleSet(w http.ResponseWriter, r *http.Request)
 	HandleDelete(w http.ResponseWriter, r *http.Request)
+	HandlePatch(w http.ResponseWriter, r *http.Request)
+	HandleSetPath(w http.ResponseWriter, r *http.Request)
+
+	HandleKeyGet(w http.ResponseWriter, r *http.Request)
+	HandleKeyPut(w http.ResponseWriter, r *http.Request)
+	HandleKeyDelete(w http.ResponseWriter, r *http.Request)
+	HandleKeyList(w http.ResponseWriter, r *http.Request)
+
+	HandleHashSet(w http.ResponseWriter, r *http.Request)
+	HandleHashGet(w http.ResponseWriter, r *http.Request)
+	HandleHashGetAll(w http.ResponseWriter, r *http.Request)
+	HandleHashDelete(w http.ResponseWriter, r *http.Request)
+	HandleListPush(w http.ResponseWriter, r *http.Request)
+	HandleListPop(w http.ResponseWriter, r *http.Request)
+	HandleListRange(w http.ResponseWriter, r *http.Request)
+	HandleSetAdd(w http.ResponseWriter, r *http.Request)
+	HandleSetRemove(w http.ResponseWriter, r *http.Request)
+	HandleSetMembers(w http.ResponseWriter, r *http.Request)
+	HandleSetIntersect(w http.ResponseWriter, r *http.Request)
+	HandleSortedSetAdd(w http.ResponseWriter, r *http.Request)
+	HandleSortedSetRemove(w http.ResponseWriter, r *http.Request)
+	HandleSortedSetIncrement(w http.ResponseWriter, r *http.Request)
+	HandleSortedSetRank(w http.ResponseWriter, r *http.Request)
+	HandleSortedSetRange(w http.ResponseWriter, r *http.Request)
+	HandlePublish(w http.ResponseWriter, r *http.Request)
+	HandleSubscribe(w http.ResponseWriter, r *http.Request)
+	HandleEnqueue(w http.ResponseWriter, r *http.Request)
+	HandleDequeue(w http.ResponseWriter, r *http.Request)
+	HandleConfigureQueue(w http.ResponseWriter, r *http.Request)
+	HandleAck(w http.ResponseWriter, r *http.Request)
+	HandleNack(w http.ResponseWriter, r *http.Request)
+	HandleLeader(w http.ResponseWriter, r *http.Request)
+	HandleObserve(w http.ResponseWriter, r *http.Request)
 }
 
 // KvPair represents a key-value pair
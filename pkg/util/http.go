@@ -1,33 +1,127 @@
 package util
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"math"
 	"net/http"
+	"regexp"
+	"strconv"
 )
 
-// HandleGrpcError converts gRPC errors to HTTP errors
+// RequestIDHeader carries the id of a request, which is echoed in its
+// response and in error responses
+const RequestIDHeader = "X-Request-ID"
+
+// StatusClientClosedRequest is the non-standard status of requests cancelled
+// by the client
+const StatusClientClosedRequest = 499
+
+// grpcError is the HTTP status and error code a gRPC status code maps to
+type grpcError struct {
+	status int
+	code   string
+}
+
+// grpcErrors maps every gRPC status code to an HTTP error
+var grpcErrors = map[codes.Code]grpcError{
+	codes.Canceled:           {StatusClientClosedRequest, CodeCanceled},
+	codes.Unknown:            {http.StatusInternalServerError, CodeInternal},
+	codes.InvalidArgument:    {http.StatusBadRequest, CodeInvalidArgument},
+	codes.DeadlineExceeded:   {http.StatusGatewayTimeout, CodeDeadlineExceeded},
+	codes.NotFound:           {http.StatusNotFound, CodeNotFound},
+	codes.AlreadyExists:      {http.StatusConflict, CodeAlreadyExists},
+	codes.PermissionDenied:   {http.StatusForbidden, CodePermissionDenied},
+	codes.ResourceExhausted:  {http.StatusTooManyRequests, CodeResourceExhausted},
+	codes.FailedPrecondition: {http.StatusPreconditionFailed, CodeFailedPrecondition},
+	codes.Aborted:            {http.StatusConflict, CodeConflict},
+	codes.OutOfRange:         {http.StatusBadRequest, CodeInvalidArgument},
+	codes.Unimplemented:      {http.StatusNotImplemented, CodeUnimplemented},
+	codes.Internal:           {http.StatusInternalServerError, CodeInternal},
+	codes.Unavailable:        {http.StatusServiceUnavailable, CodeUnavailable},
+	codes.DataLoss:           {http.StatusInternalServerError, CodeInternal},
+	codes.Unauthenticated:    {http.StatusUnauthorized, CodeUnauthenticated},
+}
+
+// HandleGrpcError converts gRPC errors to problem+json HTTP errors. Field
+// violations are listed in the problem and a suggested retry delay is sent
+// as a Retry-After header.
 func HandleGrpcError(w http.ResponseWriter, err error) bool {
 	if err == nil {
 		return false
 	}
 
+	for _, detail := range status.Convert(err).Details() {
+		if detail, ok := detail.(*errdetails.RetryInfo); ok {
+			seconds := math.Ceil(detail.GetRetryDelay().AsDuration().Seconds())
+			w.Header().Set("Retry-After", strconv.Itoa(int(seconds)))
+		}
+	}
+
+	WriteProblem(w, GrpcProblem(err))
+	return true
+}
+
+// GrpcProblem converts a gRPC error to the problem describing it, listing
+// its field violations
+func GrpcProblem(err error) Problem {
 	// Extract gRPC status for detailed error handling
 	st, ok := status.FromError(err)
-	if !ok {
+	mapped, known := grpcErrors[st.Code()]
+	if !ok || !known {
 		// Generic error handling if not a gRPC status error
-		http.Error(w, "Unknown error", http.StatusInternalServerError)
-		return true
+		return Problem{
+			Status: http.StatusInternalServerError,
+			Code:   CodeInternal,
+			Detail: "Internal error",
+		}
 	}
 
-	// Map gRPC status codes to appropriate HTTP status codes
-	switch st.Code() {
-	case codes.NotFound:
-		http.Error(w, st.Message(), http.StatusNotFound)
-	case codes.InvalidArgument:
-		http.Error(w, st.Message(), http.StatusBadRequest)
-	default:
-		http.Error(w, "Unknown error", http.StatusInternalServerError)
+	problem := Problem{
+		Status: mapped.status,
+		Code:   mapped.code,
+		Detail: st.Message(),
 	}
-	return true
+	// Messages of internal errors are not meant for clients
+	if mapped.code == CodeInternal {
+		problem.Detail = "Internal error"
+	}
+
+	for _, detail := range st.Details() {
+		if detail, ok := detail.(*errdetails.BadRequest); ok {
+			for _, v := range detail.GetFieldViolations() {
+				problem.Errors = append(problem.Errors, Violation{
+					Field:       v.GetField(),
+					Description: v.GetDescription(),
+				})
+			}
+		}
+	}
+	return problem
+}
+
+// requestIDPattern matches the request ids accepted from clients
+var requestIDPattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,128}$`)
+
+// RequestID gives every request an id, taken from its X-Request-ID header
+// when valid and generated otherwise, and sets it on the response
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
 }
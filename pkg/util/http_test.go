@@ -1,18 +1,28 @@
 package util
 
 import (
+	"encoding/json"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestHandleGrpcError(t *testing.T) {
 	tests := []struct {
-		name     string
-		err      error
-		wantCode int
+		name           string
+		err            error
+		wantCode       int
+		wantErrorCode  string
+		wantDetail     string
+		wantErrors     []Violation
+		wantRetryAfter string
 	}{
 		{
 			name:     "no error",
@@ -20,33 +30,173 @@ func TestHandleGrpcError(t *testing.T) {
 			wantCode: 0, // no error written
 		},
 		{
-			name:     "grpc error with invalid argument",
-			err:      status.Errorf(codes.InvalidArgument, "invalid argument"),
-			wantCode: http.StatusBadRequest,
+			name:          "grpc error with invalid argument",
+			err:           status.Errorf(codes.InvalidArgument, "invalid argument"),
+			wantCode:      http.StatusBadRequest,
+			wantErrorCode: CodeInvalidArgument,
+			wantDetail:    "invalid argument",
 		},
 		{
-			name:     "grpc error key not found",
-			err:      status.Errorf(codes.NotFound, "key not found"),
-			wantCode: http.StatusNotFound,
+			name: "grpc error with field violations",
+			err: withDetails(status.New(codes.InvalidArgument, "value cannot be empty"), &errdetails.BadRequest{
+				FieldViolations: []*errdetails.BadRequest_FieldViolation{
+					{Field: "value", Description: "value cannot be empty"},
+				},
+			}),
+			wantCode:      http.StatusBadRequest,
+			wantErrorCode: CodeInvalidArgument,
+			wantDetail:    "value cannot be empty",
+			wantErrors:    []Violation{{Field: "value", Description: "value cannot be empty"}},
 		},
 		{
-			name:     "unknown grpc error",
-			err:      status.Errorf(codes.Unknown, "unknown error"),
-			wantCode: http.StatusInternalServerError,
+			name:          "grpc error key not found",
+			err:           status.Errorf(codes.NotFound, "key not found"),
+			wantCode:      http.StatusNotFound,
+			wantErrorCode: CodeNotFound,
+			wantDetail:    "key not found",
+		},
+		{
+			name:          "already exists",
+			err:           status.Errorf(codes.AlreadyExists, "namespace already exists"),
+			wantCode:      http.StatusConflict,
+			wantErrorCode: CodeAlreadyExists,
+			wantDetail:    "namespace already exists",
+		},
+		{
+			name:          "failed precondition",
+			err:           status.Errorf(codes.FailedPrecondition, "lock is not held"),
+			wantCode:      http.StatusPreconditionFailed,
+			wantErrorCode: CodeFailedPrecondition,
+			wantDetail:    "lock is not held",
+		},
+		{
+			name:          "unauthenticated",
+			err:           status.Errorf(codes.Unauthenticated, "missing credentials"),
+			wantCode:      http.StatusUnauthorized,
+			wantErrorCode: CodeUnauthenticated,
+			wantDetail:    "missing credentials",
+		},
+		{
+			name: "resource exhausted with retry delay",
+			err: withDetails(status.New(codes.ResourceExhausted, "server is overloaded"), &errdetails.RetryInfo{
+				RetryDelay: durationpb.New(1500 * time.Millisecond),
+			}),
+			wantCode:       http.StatusTooManyRequests,
+			wantErrorCode:  CodeResourceExhausted,
+			wantDetail:     "server is overloaded",
+			wantRetryAfter: "2",
+		},
+		{
+			name:          "unavailable",
+			err:           status.Errorf(codes.Unavailable, "connection refused"),
+			wantCode:      http.StatusServiceUnavailable,
+			wantErrorCode: CodeUnavailable,
+			wantDetail:    "connection refused",
+		},
+		{
+			name:          "deadline exceeded",
+			err:           status.Errorf(codes.DeadlineExceeded, "context deadline exceeded"),
+			wantCode:      http.StatusGatewayTimeout,
+			wantErrorCode: CodeDeadlineExceeded,
+			wantDetail:    "context deadline exceeded",
+		},
+		{
+			name:          "unknown grpc error",
+			err:           status.Errorf(codes.Unknown, "unknown error"),
+			wantCode:      http.StatusInternalServerError,
+			wantErrorCode: CodeInternal,
+			wantDetail:    "Internal error",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			w := httptest.NewRecorder()
-			if HandleGrpcError(w, tt.err) {
-				if w.Code != tt.wantCode {
-					t.Errorf("HandleGrpcError() wrote code %d, want %d", w.Code, tt.wantCode)
-				}
-			} else {
+			w.Header().Set(RequestIDHeader, "req-1")
+			if !HandleGrpcError(w, tt.err) {
 				if w.Code != 200 && w.Code != 201 {
 					t.Errorf("HandleGrpcError() wrote code %d, want 200 0r 201", w.Code)
 				}
+				return
+			}
+
+			if w.Code != tt.wantCode {
+				t.Errorf("HandleGrpcError() wrote code %d, want %d", w.Code, tt.wantCode)
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != ProblemContentType {
+				t.Errorf("HandleGrpcError() content type = %s, want %s", contentType, ProblemContentType)
+			}
+			if retryAfter := w.Header().Get("Retry-After"); retryAfter != tt.wantRetryAfter {
+				t.Errorf("HandleGrpcError() Retry-After = %q, want %q", retryAfter, tt.wantRetryAfter)
+			}
+
+			var problem Problem
+			if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+				t.Fatal(err)
+			}
+			want := Problem{
+				Type:      "about:blank",
+				Title:     http.StatusText(tt.wantCode),
+				Status:    tt.wantCode,
+				Detail:    tt.wantDetail,
+				Code:      tt.wantErrorCode,
+				RequestID: "req-1",
+				Errors:    tt.wantErrors,
+			}
+			if !reflect.DeepEqual(problem, want) {
+				t.Errorf("HandleGrpcError() problem = %+v, want %+v", problem, want)
+			}
+		})
+	}
+}
+
+func withDetails(st *status.Status, detail protoadapt.MessageV1) error {
+	detailed, err := st.WithDetails(detail)
+	if err != nil {
+		panic(err)
+	}
+	return detailed.Err()
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{
+			name:   "client id",
+			header: "abc-123",
+			want:   "abc-123",
+		},
+		{
+			name:   "generated id",
+			header: "",
+		},
+		{
+			name:   "invalid client id",
+			header: "bad id\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = r.Header.Get(RequestIDHeader)
+			}))
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/store/key", nil)
+			req.Header.Set(RequestIDHeader, tt.header)
+			handler.ServeHTTP(w, req)
+
+			id := w.Header().Get(RequestIDHeader)
+			if tt.want != "" && id != tt.want {
+				t.Errorf("RequestID() id = %q, want %q", id, tt.want)
+			}
+			if !requestIDPattern.MatchString(id) || id != seen {
+				t.Errorf("RequestID() id = %q, handler saw %q", id, seen)
 			}
 		})
 	}
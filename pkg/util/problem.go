@@ -0,0 +1,104 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ProblemContentType is the media type of error responses
+const ProblemContentType = "application/problem+json"
+
+// Error codes identify the kind of an error. Unlike messages they are stable,
+// so clients can rely on them.
+const (
+	CodeInvalidArgument      = "invalid_argument"
+	CodeMalformedRequest     = "malformed_request"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeNotFound             = "not_found"
+	CodeAlreadyExists        = "already_exists"
+	CodeConflict             = "conflict"
+	CodeFailedPrecondition   = "failed_precondition"
+	CodeUnauthenticated      = "unauthenticated"
+	CodePermissionDenied     = "permission_denied"
+	CodeResourceExhausted    = "resource_exhausted"
+	CodeRateLimited          = "rate_limited"
+	CodeCanceled             = "canceled"
+	CodeDeadlineExceeded     = "deadline_exceeded"
+	CodeUnimplemented        = "unimplemented"
+	CodeUnavailable          = "unavailable"
+	CodeInternal             = "internal"
+)
+
+// Problem is an RFC 7807 problem details object, extended with a stable error
+// code and the id of the failed request
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title,omitempty"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+	// RequestID is the X-Request-ID of the failed request
+	RequestID string `json:"request_id,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []Violation `json:"errors,omitempty"`
+}
+
+// WriteProblem writes a problem+json error response. The type defaults to
+// about:blank, the title to the text of the status and the code to the one
+// matching the status.
+func WriteProblem(w http.ResponseWriter, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Code == "" {
+		p.Code = statusCode(p.Status)
+	}
+	p.RequestID = w.Header().Get(RequestIDHeader)
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// Error replies to a request with a problem+json error. Like http.Error it
+// takes a message and an HTTP status code.
+func Error(w http.ResponseWriter, detail string, status int) {
+	WriteProblem(w, Problem{
+		Status: status,
+		Detail: detail,
+	})
+}
+
+// statusCode returns the error code matching an HTTP status
+func statusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidArgument
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodePermissionDenied
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusPreconditionFailed:
+		return CodeFailedPrecondition
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case http.StatusTooManyRequests:
+		return CodeResourceExhausted
+	case http.StatusNotImplemented:
+		return CodeUnimplemented
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeDeadlineExceeded
+	default:
+		return CodeInternal
+	}
+}
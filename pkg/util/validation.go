@@ -2,24 +2,166 @@ package util
 
 import (
 	"fmt"
+	"os"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 )
 
-// ValidateKvPair validates a key-value pair.
-func ValidateKvPair(key string, value string) error {
-	// Key validation
+// Policy describes which keys and values are accepted by the store. Zero
+// limits mean unlimited and a nil KeyPattern accepts any key.
+type Policy struct {
+	KeyPattern      *regexp.Regexp
+	MaxKeyLength    int
+	MaxValueSize    int
+	AllowEmptyValue bool
+}
+
+// DefaultPolicy is the policy applied when none is configured
+var DefaultPolicy = Policy{
+	KeyPattern: regexp.MustCompile(`^[a-zA-Z0-9_-]+$`),
+}
+
+// Violation describes why a single field failed validation
+type Violation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// ValidationError is returned when a key-value pair violates a policy
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	descriptions := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		descriptions = append(descriptions, v.Description)
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+// Validate validates a key-value pair against the policy, reporting every
+// violation found.
+func (p Policy) Validate(key string, value string) error {
+	var violations []Violation
+
+	// Key validation. An empty key has nothing else to report.
 	if key == "" {
-		return fmt.Errorf("key cannot be empty")
+		violations = append(violations, Violation{"key", "key cannot be empty"})
+	} else {
+		if p.MaxKeyLength > 0 && len(key) > p.MaxKeyLength {
+			violations = append(violations, Violation{"key", fmt.Sprintf("key exceeds maximum length of %d", p.MaxKeyLength)})
+		}
+		if p.KeyPattern != nil && !p.KeyPattern.MatchString(key) {
+			violations = append(violations, Violation{"key", "key contains invalid characters"})
+		}
 	}
 
 	// Value validation
-	if value == "" {
-		return fmt.Errorf("value cannot be empty")
+	switch {
+	case value == "" && !p.AllowEmptyValue:
+		violations = append(violations, Violation{"value", "value cannot be empty"})
+	case p.MaxValueSize > 0 && len(value) > p.MaxValueSize:
+		violations = append(violations, Violation{"value", fmt.Sprintf("value exceeds maximum size of %d bytes", p.MaxValueSize)})
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// CompileKeyPattern compiles a key pattern anchored at both ends, so that
+// keys must match it as a whole rather than contain a match
+func CompileKeyPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+// PolicyFromEnv builds a policy from the KEY_PATTERN, MAX_KEY_LENGTH,
+// MAX_VALUE_SIZE and ALLOW_EMPTY_VALUES environment variables, falling back
+// to DefaultPolicy for unset variables.
+func PolicyFromEnv() (Policy, error) {
+	policy := DefaultPolicy
+	var err error
+
+	if pattern := os.Getenv("KEY_PATTERN"); pattern != "" {
+		if policy.KeyPattern, err = CompileKeyPattern(pattern); err != nil {
+			return policy, fmt.Errorf("invalid KEY_PATTERN: %w", err)
+		}
+	}
+	if length := os.Getenv("MAX_KEY_LENGTH"); length != "" {
+		if policy.MaxKeyLength, err = strconv.Atoi(length); err != nil {
+			return policy, fmt.Errorf("invalid MAX_KEY_LENGTH: %w", err)
+		}
+	}
+	if size := os.Getenv("MAX_VALUE_SIZE"); size != "" {
+		if policy.MaxValueSize, err = strconv.Atoi(size); err != nil {
+			return policy, fmt.Errorf("invalid MAX_VALUE_SIZE: %w", err)
+		}
+	}
+	if allow := os.Getenv("ALLOW_EMPTY_VALUES"); allow != "" {
+		if policy.AllowEmptyValue, err = strconv.ParseBool(allow); err != nil {
+			return policy, fmt.Errorf("invalid ALLOW_EMPTY_VALUES: %w", err)
+		}
+	}
+	return policy, nil
+}
+
+// Policies holds the default policy and per-namespace overrides
+type Policies struct {
+	Default Policy
+
+	mu         sync.RWMutex
+	namespaces map[string]Policy
+}
+
+// NewPolicies creates a policy set with the given default policy
+func NewPolicies(defaultPolicy Policy) *Policies {
+	return &Policies{
+		Default:    defaultPolicy,
+		namespaces: make(map[string]Policy),
+	}
+}
+
+// Set overrides the policy of a namespace
+func (p *Policies) Set(namespace string, policy Policy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.namespaces[namespace] = policy
+}
+
+// Remove removes the policy override of a namespace
+func (p *Policies) Remove(namespace string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.namespaces, namespace)
+}
+
+// For returns the policy that applies to a namespace
+func (p *Policies) For(namespace string) Policy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if policy, ok := p.namespaces[namespace]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// ValidateKvPair validates a key-value pair against the default policy.
+func ValidateKvPair(key string, value string) error {
+	return DefaultPolicy.Validate(key, value)
+}
+
+// ValidateNamespace validates a namespace name.
+func ValidateNamespace(name string) error {
+	if name == "" {
+		return fmt.Errorf("namespace cannot be empty")
 	}
 
-	// Additional custom validations
-	if containsInvalidChars(key) {
-		return fmt.Errorf("key contains invalid characters")
+	if containsInvalidChars(name) {
+		return fmt.Errorf("namespace contains invalid characters")
 	}
 
 	return nil
@@ -1,6 +1,11 @@
 package util
 
-import "testing"
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+)
 
 func TestValidateKvPair(t *testing.T) {
 	tests := []struct {
@@ -52,3 +57,144 @@ func TestValidateKvPair(t *testing.T) {
 		})
 	}
 }
+
+func TestPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name           string
+		policy         Policy
+		key            string
+		value          string
+		wantViolations []Violation
+	}{
+		{
+			name:   "valid key-value pair",
+			policy: DefaultPolicy,
+			key:    "test-key",
+			value:  "test-value",
+		},
+		{
+			name:   "empty value allowed",
+			policy: Policy{AllowEmptyValue: true},
+			key:    "test-key",
+		},
+		{
+			name:   "custom key pattern",
+			policy: Policy{KeyPattern: regexp.MustCompile(`^[a-z]+(/[a-z]+)*$`)},
+			key:    "config/service",
+			value:  "test-value",
+		},
+		{
+			name:   "key too long",
+			policy: Policy{MaxKeyLength: 4},
+			key:    "test-key",
+			value:  "test-value",
+			wantViolations: []Violation{
+				{"key", "key exceeds maximum length of 4"},
+			},
+		},
+		{
+			name:   "value too large",
+			policy: Policy{MaxValueSize: 4},
+			key:    "test-key",
+			value:  "test-value",
+			wantViolations: []Violation{
+				{"value", "value exceeds maximum size of 4 bytes"},
+			},
+		},
+		{
+			name:   "every key violation",
+			policy: Policy{MaxKeyLength: 4, KeyPattern: DefaultPolicy.KeyPattern},
+			key:    "test-key!",
+			value:  "test-value",
+			wantViolations: []Violation{
+				{"key", "key exceeds maximum length of 4"},
+				{"key", "key contains invalid characters"},
+			},
+		},
+		{
+			name:   "empty key",
+			policy: Policy{MaxKeyLength: 4, KeyPattern: DefaultPolicy.KeyPattern},
+			value:  "test-value",
+			wantViolations: []Violation{
+				{"key", "key cannot be empty"},
+			},
+		},
+		{
+			name:   "key and value violations",
+			policy: DefaultPolicy,
+			key:    "test-key!",
+			wantViolations: []Violation{
+				{"key", "key contains invalid characters"},
+				{"value", "value cannot be empty"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate(tt.key, tt.value)
+			if tt.wantViolations == nil {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validate() error = %v, want *ValidationError", err)
+			}
+			if !reflect.DeepEqual(validationErr.Violations, tt.wantViolations) {
+				t.Errorf("Validate() violations = %v, want %v", validationErr.Violations, tt.wantViolations)
+			}
+		})
+	}
+}
+
+func TestPolicies_For(t *testing.T) {
+	policies := NewPolicies(DefaultPolicy)
+	override := Policy{AllowEmptyValue: true}
+	policies.Set("team-a", override)
+
+	if err := policies.For("team-a").Validate("test-key", ""); err != nil {
+		t.Errorf("For() override error = %v, want nil", err)
+	}
+	if err := policies.For("team-b").Validate("test-key", ""); err == nil {
+		t.Errorf("For() default error = nil, want error")
+	}
+
+	policies.Remove("team-a")
+	if err := policies.For("team-a").Validate("test-key", ""); err == nil {
+		t.Errorf("For() after Remove() error = nil, want error")
+	}
+}
+
+func TestPolicyFromEnv(t *testing.T) {
+	t.Setenv("MAX_KEY_LENGTH", "8")
+	t.Setenv("ALLOW_EMPTY_VALUES", "true")
+
+	policy, err := PolicyFromEnv()
+	if err != nil {
+		t.Fatalf("PolicyFromEnv() error = %v", err)
+	}
+	if policy.MaxKeyLength != 8 || !policy.AllowEmptyValue || policy.KeyPattern != DefaultPolicy.KeyPattern {
+		t.Errorf("PolicyFromEnv() = %+v", policy)
+	}
+
+	// Keys must match KEY_PATTERN as a whole
+	t.Setenv("KEY_PATTERN", "[a-z]+")
+	if policy, err = PolicyFromEnv(); err != nil {
+		t.Fatalf("PolicyFromEnv() error = %v", err)
+	}
+	if err := policy.Validate("key", "value"); err != nil {
+		t.Errorf("Validate() error = %v, want nil", err)
+	}
+	if err := policy.Validate("key!", "value"); err == nil {
+		t.Error("Validate() of a key containing an invalid character error = nil")
+	}
+
+	t.Setenv("MAX_VALUE_SIZE", "large")
+	if _, err := PolicyFromEnv(); err == nil {
+		t.Errorf("PolicyFromEnv() error = nil, want error")
+	}
+}
@@ -0,0 +1,210 @@
+package webrpc
+
+import (
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// protocol is the wire protocol of a call, selected by its content type
+type protocol struct {
+	// contentType is the media type of the request, echoed on the response
+	contentType string
+	codec       codec
+	// connect is true for the Connect protocol and false for gRPC-Web
+	connect bool
+	// streaming is true for the Connect streaming protocol, which frames
+	// messages and ends with an end-stream message
+	streaming bool
+	// enveloped is true when messages are framed with a 5-byte prefix,
+	// which is all but Connect unary calls
+	enveloped bool
+	// text is true for gRPC-Web bodies encoded in base64
+	text bool
+}
+
+// parseProtocol selects the protocol of a Content-Type header
+func parseProtocol(contentType string) (protocol, bool) {
+	mediaType := mediaType(contentType)
+	p := protocol{contentType: mediaType, codec: protoCodec{}}
+
+	subtype, ok := "", false
+	switch {
+	case mediaType == "application/proto", mediaType == "application/json":
+		p.connect = true
+		subtype, ok = strings.CutPrefix(mediaType, "application/")
+	case strings.HasPrefix(mediaType, "application/connect+"):
+		p.connect, p.streaming, p.enveloped = true, true, true
+		subtype, ok = strings.CutPrefix(mediaType, "application/connect+")
+	case strings.HasPrefix(mediaType, "application/grpc-web-text"):
+		p.enveloped, p.text = true, true
+		subtype, ok = grpcWebSubtype(strings.TrimPrefix(mediaType, "application/grpc-web-text"))
+	case strings.HasPrefix(mediaType, "application/grpc-web"):
+		p.enveloped = true
+		subtype, ok = grpcWebSubtype(strings.TrimPrefix(mediaType, "application/grpc-web"))
+	}
+	if !ok {
+		return p, false
+	}
+
+	switch subtype {
+	case "proto":
+	case "json":
+		p.codec = jsonCodec{}
+	default:
+		return p, false
+	}
+	return p, true
+}
+
+// grpcWebSubtype returns the codec of a gRPC-Web media type suffix, which
+// defaults to proto
+func grpcWebSubtype(suffix string) (string, bool) {
+	if suffix == "" {
+		return "proto", true
+	}
+	return strings.CutPrefix(suffix, "+")
+}
+
+// encoding returns the compression of the request messages
+func (p protocol) encoding(r *http.Request) string {
+	switch {
+	case p.connect && p.streaming:
+		return r.Header.Get("Connect-Content-Encoding")
+	case p.connect:
+		return r.Header.Get("Content-Encoding")
+	default:
+		return r.Header.Get("Grpc-Encoding")
+	}
+}
+
+// timeout returns the timeout requested by the client, 0 when unset
+func (p protocol) timeout(r *http.Request) (time.Duration, error) {
+	if p.connect {
+		value := r.Header.Get("Connect-Timeout-Ms")
+		if value == "" {
+			return 0, nil
+		}
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || ms <= 0 {
+			return 0, status.Errorf(codes.InvalidArgument, "invalid Connect-Timeout-Ms %q", value)
+		}
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+
+	value := r.Header.Get("Grpc-Timeout")
+	if value == "" {
+		return 0, nil
+	}
+	timeout, err := parseTimeout(value)
+	if err != nil {
+		return 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return timeout, nil
+}
+
+// codec marshals messages to and from their wire format
+type codec interface {
+	marshal(msg any) ([]byte, error)
+	unmarshal(data []byte, msg any) error
+}
+
+// protoCodec encodes messages in the protobuf binary format
+type protoCodec struct{}
+
+func (protoCodec) marshal(msg any) ([]byte, error) {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "%T is not a protobuf message", msg)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) unmarshal(data []byte, msg any) error {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "%T is not a protobuf message", msg)
+	}
+	if err := proto.Unmarshal(data, m); err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to decode request: %s", err)
+	}
+	return nil
+}
+
+// jsonCodec encodes messages with the protobuf JSON mapping
+type jsonCodec struct{}
+
+func (jsonCodec) marshal(msg any) ([]byte, error) {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "%T is not a protobuf message", msg)
+	}
+	return protojson.Marshal(m)
+}
+
+func (jsonCodec) unmarshal(data []byte, msg any) error {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "%T is not a protobuf message", msg)
+	}
+	if err := protojson.Unmarshal(data, m); err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to decode request: %s", err)
+	}
+	return nil
+}
+
+// connectCodes maps gRPC status codes to their Connect names and HTTP statuses
+var connectCodes = map[codes.Code]struct {
+	name   string
+	status int
+}{
+	codes.Canceled:           {"canceled", 499},
+	codes.Unknown:            {"unknown", http.StatusInternalServerError},
+	codes.InvalidArgument:    {"invalid_argument", http.StatusBadRequest},
+	codes.DeadlineExceeded:   {"deadline_exceeded", http.StatusGatewayTimeout},
+	codes.NotFound:           {"not_found", http.StatusNotFound},
+	codes.AlreadyExists:      {"already_exists", http.StatusConflict},
+	codes.PermissionDenied:   {"permission_denied", http.StatusForbidden},
+	codes.ResourceExhausted:  {"resource_exhausted", http.StatusTooManyRequests},
+	codes.FailedPrecondition: {"failed_precondition", http.StatusBadRequest},
+	codes.Aborted:            {"aborted", http.StatusConflict},
+	codes.OutOfRange:         {"out_of_range", http.StatusBadRequest},
+	codes.Unimplemented:      {"unimplemented", http.StatusNotImplemented},
+	codes.Internal:           {"internal", http.StatusInternalServerError},
+	codes.Unavailable:        {"unavailable", http.StatusServiceUnavailable},
+	codes.DataLoss:           {"data_loss", http.StatusInternalServerError},
+	codes.Unauthenticated:    {"unauthenticated", http.StatusUnauthorized},
+}
+
+// connectError is the JSON form of an error in the Connect protocol
+type connectError struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message,omitempty"`
+	Details []connectDetail `json:"details,omitempty"`
+}
+
+// connectDetail is an error detail, holding the unpadded base64 encoding of
+// a protobuf message
+type connectDetail struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// grpcMessage percent-encodes a status message for the grpc-message trailer
+func grpcMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,265 @@
+package webrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Flags of enveloped messages
+const (
+	flagCompressed = 0x01
+	// flagEndStream marks the last message of a Connect stream
+	flagEndStream = 0x02
+	// flagTrailer marks the trailers of a gRPC-Web response
+	flagTrailer = 0x80
+)
+
+// serverStream is the grpc.ServerStream of a call, reading requests from the
+// HTTP request and writing responses in the protocol of the call
+type serverStream struct {
+	ctx      context.Context
+	method   string
+	w        http.ResponseWriter
+	body     io.Reader
+	protocol protocol
+
+	header      metadata.MD
+	trailer     metadata.MD
+	wroteHeader bool
+	finished    bool
+}
+
+func newServerStream(w http.ResponseWriter, r *http.Request, p protocol) *serverStream {
+	s := &serverStream{
+		ctx:      r.Context(),
+		method:   r.URL.Path,
+		w:        w,
+		body:     r.Body,
+		protocol: p,
+		header:   metadata.MD{},
+		trailer:  metadata.MD{},
+	}
+	if p.text {
+		s.body = base64.NewDecoder(base64.StdEncoding, r.Body)
+	}
+	return s
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) SetHeader(md metadata.MD) error {
+	if s.wroteHeader {
+		return status.Error(codes.Internal, "headers already sent")
+	}
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *serverStream) SendHeader(md metadata.MD) error {
+	if err := s.SetHeader(md); err != nil {
+		return err
+	}
+	s.writeHeader()
+	http.NewResponseController(s.w).Flush()
+	return nil
+}
+
+func (s *serverStream) SetTrailer(md metadata.MD) {
+	s.trailer = metadata.Join(s.trailer, md)
+}
+
+// SendMsg writes a response message
+func (s *serverStream) SendMsg(m any) error {
+	data, err := s.protocol.codec.marshal(m)
+	if err != nil {
+		return err
+	}
+	s.writeHeader()
+	if !s.protocol.enveloped {
+		_, err = s.w.Write(data)
+		return err
+	}
+	if err := s.writeEnvelope(0, data); err != nil {
+		return err
+	}
+	return http.NewResponseController(s.w).Flush()
+}
+
+// RecvMsg reads the next request message, returning io.EOF once the
+// request body is fully read
+func (s *serverStream) RecvMsg(m any) error {
+	var prefix [5]byte
+	if _, err := io.ReadFull(s.body, prefix[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return status.Errorf(codes.InvalidArgument, "failed to read request: %s", err)
+	}
+	if prefix[0]&flagCompressed != 0 {
+		return status.Error(codes.Unimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxMessageSize {
+		return status.Errorf(codes.ResourceExhausted, "request larger than %d bytes", maxMessageSize)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(s.body, data); err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to read request: %s", err)
+	}
+	return s.protocol.codec.unmarshal(data, m)
+}
+
+// writeHeader writes the response status and headers, with the header
+// metadata of the call
+func (s *serverStream) writeHeader() {
+	if s.wroteHeader {
+		return
+	}
+	s.wroteHeader = true
+	writeMetadata(s.w.Header(), "", s.header)
+	if s.protocol.connect && !s.protocol.streaming {
+		// Connect unary responses send trailers as prefixed headers
+		writeMetadata(s.w.Header(), "Trailer-", s.trailer)
+	}
+	s.w.Header().Set("Content-Type", s.protocol.contentType)
+	s.w.WriteHeader(http.StatusOK)
+}
+
+// writeEnvelope writes a message prefixed with its flags and size
+func (s *serverStream) writeEnvelope(flags byte, data []byte) error {
+	message := make([]byte, 5+len(data))
+	message[0] = flags
+	binary.BigEndian.PutUint32(message[1:], uint32(len(data)))
+	copy(message[5:], data)
+
+	if s.protocol.text {
+		message = []byte(base64.StdEncoding.EncodeToString(message))
+	}
+	_, err := s.w.Write(message)
+	return err
+}
+
+// finish ends the call with its status
+func (s *serverStream) finish(err error) {
+	if s.finished {
+		return
+	}
+	s.finished = true
+	st := callStatus(err)
+
+	switch {
+	case s.protocol.connect && !s.protocol.streaming:
+		if st.Code() == codes.OK || s.wroteHeader {
+			return
+		}
+		writeMetadata(s.w.Header(), "", s.header)
+		writeMetadata(s.w.Header(), "Trailer-", s.trailer)
+		data, _ := json.Marshal(newConnectError(st))
+		s.w.Header().Set("Content-Type", "application/json")
+		s.w.WriteHeader(connectCodes[st.Code()].status)
+		s.w.Write(data)
+
+	case s.protocol.connect:
+		end := struct {
+			Error    *connectError       `json:"error,omitempty"`
+			Metadata map[string][]string `json:"metadata,omitempty"`
+		}{}
+		if st.Code() != codes.OK {
+			end.Error = newConnectError(st)
+		}
+		if len(s.trailer) > 0 {
+			end.Metadata = make(http.Header)
+			writeMetadata(end.Metadata, "", s.trailer)
+		}
+		data, _ := json.Marshal(end)
+		s.writeHeader()
+		s.writeEnvelope(flagEndStream, data)
+
+	default:
+		var trailer bytes.Buffer
+		fmt.Fprintf(&trailer, "grpc-status: %d\r\n", st.Code())
+		if st.Message() != "" {
+			fmt.Fprintf(&trailer, "grpc-message: %s\r\n", grpcMessage(st.Message()))
+		}
+		if len(st.Proto().GetDetails()) > 0 {
+			if details, err := proto.Marshal(st.Proto()); err == nil {
+				fmt.Fprintf(&trailer, "grpc-status-details-bin: %s\r\n", base64.RawStdEncoding.EncodeToString(details))
+			}
+		}
+		fields := make(http.Header)
+		writeMetadata(fields, "", s.trailer)
+		for key, values := range fields {
+			for _, value := range values {
+				fmt.Fprintf(&trailer, "%s: %s\r\n", strings.ToLower(key), value)
+			}
+		}
+		s.writeHeader()
+		s.writeEnvelope(flagTrailer, trailer.Bytes())
+	}
+	http.NewResponseController(s.w).Flush()
+}
+
+// callStatus returns the status of a call ended by err
+func callStatus(err error) *status.Status {
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+	return status.FromContextError(err)
+}
+
+func newConnectError(st *status.Status) *connectError {
+	e := &connectError{
+		Code:    connectCodes[st.Code()].name,
+		Message: st.Message(),
+	}
+	for _, detail := range st.Proto().GetDetails() {
+		e.Details = append(e.Details, connectDetail{
+			Type:  strings.TrimPrefix(detail.GetTypeUrl(), "type.googleapis.com/"),
+			Value: base64.RawStdEncoding.EncodeToString(detail.GetValue()),
+		})
+	}
+	return e
+}
+
+// writeMetadata adds metadata to HTTP headers, encoding the values of binary
+// keys in base64
+func writeMetadata(header http.Header, prefix string, md metadata.MD) {
+	for key, values := range md {
+		for _, value := range values {
+			if strings.HasSuffix(key, "-bin") {
+				value = base64.RawStdEncoding.EncodeToString([]byte(value))
+			}
+			header.Add(prefix+key, value)
+		}
+	}
+}
+
+// transportStream lets handlers set the metadata of a call with
+// grpc.SetHeader, grpc.SendHeader and grpc.SetTrailer
+type transportStream struct {
+	*serverStream
+}
+
+func (t *transportStream) Method() string {
+	return t.method
+}
+
+func (t *transportStream) SetTrailer(md metadata.MD) error {
+	t.serverStream.SetTrailer(md)
+	return nil
+}
@@ -0,0 +1,276 @@
+package webrpc
+
+import (
+	"censys/pkg/util"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxMessageSize is the maximum size of a request message, the gRPC default
+const maxMessageSize = 4 << 20
+
+// Handler serves registered gRPC services over the Connect and gRPC-Web
+// protocols, so browsers and plain HTTP/1.1 clients such as curl can call
+// them. Services are called in process, without a gRPC server.
+type Handler struct {
+	// UnaryInterceptor and StreamInterceptor wrap every call like the
+	// interceptors of a grpc.Server. Nil interceptors are skipped.
+	UnaryInterceptor  grpc.UnaryServerInterceptor
+	StreamInterceptor grpc.StreamServerInterceptor
+	// AllowedOrigins lists the origins browsers may call from, or "*" for
+	// any origin. Cross-origin calls are refused when empty.
+	AllowedOrigins []string
+
+	methods map[string]*method
+}
+
+// method is a registered RPC
+type method struct {
+	impl   any
+	unary  *grpc.MethodDesc
+	stream *grpc.StreamDesc
+}
+
+// RegisterService registers a service implementation, like the
+// RegisterService of a grpc.Server
+func (h *Handler) RegisterService(desc *grpc.ServiceDesc, impl any) {
+	if h.methods == nil {
+		h.methods = make(map[string]*method)
+	}
+	for i := range desc.Methods {
+		h.methods["/"+desc.ServiceName+"/"+desc.Methods[i].MethodName] = &method{impl: impl, unary: &desc.Methods[i]}
+	}
+	for i := range desc.Streams {
+		h.methods["/"+desc.ServiceName+"/"+desc.Streams[i].StreamName] = &method{impl: impl, stream: &desc.Streams[i]}
+	}
+}
+
+// ServeHTTP calls the RPC named by the request path, such as
+// /KvStoreService/Get
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.cors(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		util.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m, ok := h.methods[r.URL.Path]
+	if !ok {
+		util.Error(w, "Unknown method", http.StatusNotFound)
+		return
+	}
+	p, ok := parseProtocol(r.Header.Get("Content-Type"))
+	if !ok || (p.connect && p.streaming != (m.unary == nil)) {
+		w.Header().Set("Accept-Post", accepted(m.unary == nil))
+		util.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	stream := newServerStream(w, r, p)
+	ctx, cancel, err := callContext(r, p)
+	if err != nil {
+		stream.finish(err)
+		return
+	}
+	defer cancel()
+	ctx = grpc.NewContextWithServerTransportStream(ctx, &transportStream{stream})
+	stream.ctx = ctx
+
+	if encoding := p.encoding(r); encoding != "" && encoding != "identity" {
+		stream.finish(status.Errorf(codes.Unimplemented, "compression %q is not supported", encoding))
+		return
+	}
+
+	if m.unary != nil {
+		h.serveUnary(ctx, r, m, stream)
+		return
+	}
+	h.serveStream(m, stream)
+}
+
+// serveUnary calls a unary RPC
+func (h *Handler) serveUnary(ctx context.Context, r *http.Request, m *method, stream *serverStream) {
+	dec := stream.RecvMsg
+	if !stream.protocol.enveloped {
+		// Connect unary requests are the message itself
+		dec = func(msg any) error {
+			data, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "failed to read request: %s", err)
+			}
+			if len(data) > maxMessageSize {
+				return status.Errorf(codes.ResourceExhausted, "request larger than %d bytes", maxMessageSize)
+			}
+			return stream.protocol.codec.unmarshal(data, msg)
+		}
+	}
+
+	resp, err := m.unary.Handler(m.impl, ctx, dec, h.UnaryInterceptor)
+	if err != nil {
+		stream.finish(err)
+		return
+	}
+	if err := stream.SendMsg(resp); err != nil {
+		stream.finish(err)
+		return
+	}
+	stream.finish(nil)
+}
+
+// serveStream calls a streaming RPC
+func (h *Handler) serveStream(m *method, stream *serverStream) {
+	if m.stream.ClientStreams {
+		// Responses are written while the body is still being read
+		http.NewResponseController(stream.w).EnableFullDuplex()
+	}
+
+	var err error
+	if h.StreamInterceptor != nil {
+		err = h.StreamInterceptor(m.impl, stream, &grpc.StreamServerInfo{
+			FullMethod:     stream.method,
+			IsClientStream: m.stream.ClientStreams,
+			IsServerStream: m.stream.ServerStreams,
+		}, m.stream.Handler)
+	} else {
+		err = m.stream.Handler(m.impl, stream)
+	}
+	stream.finish(err)
+}
+
+// cors sets the CORS headers of calls from allowed origins and answers
+// preflight requests. It returns false when the request was answered.
+func (h *Handler) cors(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	w.Header().Add("Vary", "Origin")
+	if !slices.Contains(h.AllowedOrigins, "*") && !slices.Contains(h.AllowedOrigins, origin) {
+		if r.Method == http.MethodOptions {
+			util.Error(w, "Origin not allowed", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Expose-Headers", "Grpc-Status, Grpc-Message, Grpc-Status-Details-Bin")
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		w.Header().Set("Access-Control-Allow-Methods", http.MethodPost)
+		w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+		w.Header().Set("Access-Control-Max-Age", "7200")
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	return true
+}
+
+// callContext derives the context of a call from its request, with the
+// call deadline, the request headers as incoming metadata and the client
+// address as peer
+func callContext(r *http.Request, p protocol) (context.Context, context.CancelFunc, error) {
+	ctx := r.Context()
+
+	md := metadata.MD{}
+	for name, values := range r.Header {
+		key := strings.ToLower(name)
+		if strings.HasPrefix(key, "connect-") || strings.HasPrefix(key, "grpc-") || key == "content-type" || key == "content-length" {
+			continue
+		}
+		for _, value := range values {
+			if strings.HasSuffix(key, "-bin") {
+				decoded, err := decodeBinaryHeader(value)
+				if err != nil {
+					return nil, nil, status.Errorf(codes.InvalidArgument, "invalid %s header", name)
+				}
+				value = string(decoded)
+			}
+			md.Append(key, value)
+		}
+	}
+	ctx = metadata.NewIncomingContext(ctx, md)
+
+	if addr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: net.TCPAddrFromAddrPort(addr)})
+	}
+
+	timeout, err := p.timeout(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, cancel, nil
+}
+
+// parseTimeout parses a grpc-timeout header, such as "100m"
+func parseTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 {
+		return 0, fmt.Errorf("invalid timeout %q", value)
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid timeout %q", value)
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid timeout %q", value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// decodeBinaryHeader decodes the base64 value of a -bin header, which may be
+// padded or not
+func decodeBinaryHeader(value string) ([]byte, error) {
+	if len(value)%4 == 0 {
+		return base64.StdEncoding.DecodeString(value)
+	}
+	return base64.RawStdEncoding.DecodeString(value)
+}
+
+// accepted lists the content types accepted by unary or streaming RPCs
+func accepted(streaming bool) string {
+	if streaming {
+		return "application/connect+proto, application/connect+json, application/grpc-web+proto, application/grpc-web+json, application/grpc-web-text"
+	}
+	return "application/proto, application/json, application/grpc-web+proto, application/grpc-web+json, application/grpc-web-text"
+}
+
+// mediaType returns the media type of a Content-Type header without its
+// parameters
+func mediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
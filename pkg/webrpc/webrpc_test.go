@@ -0,0 +1,302 @@
+package webrpc
+
+import (
+	"bytes"
+	inmemorystore "censys/internal/kvstore/inmemory"
+	"censys/internal/lease"
+	"censys/pkg/transport"
+	pb "censys/proto/gen/proto"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// newTestHandler serves a kvstore holding the key alpha
+func newTestHandler(t *testing.T) *Handler {
+	service := &transport.KvStoreServer{Store: &inmemorystore.InMemoryStore{}}
+	service.Leases = lease.NewLessor(service.Release)
+	if _, err := service.Set(context.Background(), &pb.SetRequest{Key: "alpha", Value: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Handler{AllowedOrigins: []string{"https://console.example.com"}}
+	pb.RegisterKvStoreServiceServer(h, service)
+	return h
+}
+
+// envelope frames messages with the 5-byte prefix of enveloped protocols
+func envelope(messages ...[]byte) string {
+	var b bytes.Buffer
+	for _, message := range messages {
+		prefix := make([]byte, 5)
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(message)))
+		b.Write(prefix)
+		b.Write(message)
+	}
+	return b.String()
+}
+
+// frame is an enveloped message
+type frame struct {
+	flags byte
+	data  string
+}
+
+func frames(t *testing.T, body []byte) []frame {
+	var frames []frame
+	for len(body) > 0 {
+		if len(body) < 5 {
+			t.Fatalf("truncated frame %q", body)
+		}
+		size := int(binary.BigEndian.Uint32(body[1:5]))
+		frames = append(frames, frame{flags: body[0], data: string(body[5 : 5+size])})
+		body = body[5+size:]
+	}
+	return frames
+}
+
+// compact removes the whitespace protojson randomly adds to JSON
+func compact(data string) string {
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(data)); err != nil {
+		return data
+	}
+	return b.String()
+}
+
+func marshal(t *testing.T, msg proto.Message) []byte {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestUnary(t *testing.T) {
+	h := newTestHandler(t)
+	get := marshal(t, &pb.GetRequest{Key: "alpha"})
+	missing := marshal(t, &pb.GetRequest{Key: "missing"})
+	value := string(marshal(t, &pb.GetResponse{Value: "1", Success: true}))
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		header      map[string]string
+		body        string
+		status      int
+		// want is contained in the body, or in the last frame of enveloped
+		// responses
+		want      string
+		wantFirst string
+	}{
+		{"connect json", "POST", "/KvStoreService/Get", "application/json", nil, `{"key":"alpha"}`, http.StatusOK, `"value":"1"`, ""},
+		{"connect proto", "POST", "/KvStoreService/Get", "application/proto", nil, string(get), http.StatusOK, value, ""},
+		{"connect error", "POST", "/KvStoreService/Get", "application/json", nil, `{"key":"missing"}`, http.StatusNotFound, `"code":"not_found","message":"key not found","details":[{"type":"google.rpc.ResourceInfo"`, ""},
+		{"connect malformed", "POST", "/KvStoreService/Get", "application/json", nil, `{"key":`, http.StatusBadRequest, `"code":"invalid_argument"`, ""},
+		{"connect compressed", "POST", "/KvStoreService/Get", "application/json", map[string]string{"Content-Encoding": "gzip"}, `{"key":"alpha"}`, http.StatusNotImplemented, `"code":"unimplemented"`, ""},
+		{"connect invalid timeout", "POST", "/KvStoreService/Get", "application/json", map[string]string{"Connect-Timeout-Ms": "soon"}, `{"key":"alpha"}`, http.StatusBadRequest, `Connect-Timeout-Ms`, ""},
+		{"grpc-web", "POST", "/KvStoreService/Get", "application/grpc-web+proto", nil, envelope(get), http.StatusOK, "grpc-status: 0\r\n", value},
+		{"grpc-web json", "POST", "/KvStoreService/Get", "application/grpc-web+json", nil, envelope([]byte(`{"key":"alpha"}`)), http.StatusOK, "grpc-status: 0\r\n", `{"value":"1","success":true}`},
+		{"grpc-web error", "POST", "/KvStoreService/Get", "application/grpc-web", nil, envelope(missing), http.StatusOK, "grpc-status: 5\r\ngrpc-message: key not found\r\ngrpc-status-details-bin: ", ""},
+		{"grpc-web timeout", "POST", "/KvStoreService/Get", "application/grpc-web", map[string]string{"Grpc-Timeout": "1S"}, envelope(get), http.StatusOK, "grpc-status: 0\r\n", value},
+		{"streaming content type", "POST", "/KvStoreService/Get", "application/connect+json", nil, "", http.StatusUnsupportedMediaType, "", ""},
+		{"unknown content type", "POST", "/KvStoreService/Get", "text/plain", nil, "", http.StatusUnsupportedMediaType, "", ""},
+		{"unknown method", "POST", "/KvStoreService/Bogus", "application/json", nil, "{}", http.StatusNotFound, "", ""},
+		{"get", "GET", "/KvStoreService/Get", "application/json", nil, "", http.StatusMethodNotAllowed, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			for name, value := range tt.header {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			body := w.Body.String()
+			if strings.HasPrefix(tt.contentType, "application/grpc-web") {
+				frames := frames(t, w.Body.Bytes())
+				last := frames[len(frames)-1]
+				if last.flags != flagTrailer {
+					t.Fatalf("last frame flags = %#x, want trailers", last.flags)
+				}
+				body = last.data
+				if tt.wantFirst != "" && (len(frames) != 2 || compact(frames[0].data) != tt.wantFirst) {
+					t.Errorf("frames = %q, want %q first", frames, tt.wantFirst)
+				}
+			}
+			if !strings.Contains(compact(body), tt.want) {
+				t.Errorf("body = %q, want it to contain %q", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrpcWebText(t *testing.T) {
+	h := newTestHandler(t)
+
+	body := base64.StdEncoding.EncodeToString([]byte(envelope(marshal(t, &pb.GetRequest{Key: "alpha"}))))
+	r := httptest.NewRequest("POST", "/KvStoreService/Get", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/grpc-web-text")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if got := w.Header().Get("Content-Type"); got != "application/grpc-web-text" {
+		t.Errorf("Content-Type = %q, want application/grpc-web-text", got)
+	}
+	// Each frame is encoded separately
+	var decoded []byte
+	for _, chunk := range regexp.MustCompile(`[^=]+=*`).FindAllString(w.Body.String(), -1) {
+		data, err := base64.StdEncoding.DecodeString(chunk)
+		if err != nil {
+			t.Fatalf("decoding %q error = %v", chunk, err)
+		}
+		decoded = append(decoded, data...)
+	}
+	frames := frames(t, decoded)
+	if len(frames) != 2 || frames[0].data != string(marshal(t, &pb.GetResponse{Value: "1", Success: true})) ||
+		!strings.Contains(frames[1].data, "grpc-status: 0") {
+		t.Errorf("frames = %q", frames)
+	}
+}
+
+func TestConnectStream(t *testing.T) {
+	h := newTestHandler(t)
+
+	r := httptest.NewRequest("POST", "/KvStoreService/LeaseGrant", strings.NewReader(`{"ttlMs":"60000"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id":"1"`) {
+		t.Fatalf("LeaseGrant = %d %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want []frame
+	}{
+		{"requests", envelope([]byte(`{"id":"1"}`), []byte(`{"id":"1"}`)), []frame{
+			{0, `{"id":"1","ttlMs":"60000"}`},
+			{0, `{"id":"1","ttlMs":"60000"}`},
+			{flagEndStream, `{}`},
+		}},
+		{"no requests", "", []frame{{flagEndStream, `{}`}}},
+		{"malformed request", envelope([]byte(`{"id":`)), []frame{
+			{flagEndStream, `{"error":{"code":"invalid_argument"`},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/KvStoreService/LeaseKeepAlive", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/connect+json")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			got := frames(t, w.Body.Bytes())
+			if len(got) != len(tt.want) {
+				t.Fatalf("frames = %q, want %q", got, tt.want)
+			}
+			for i, want := range tt.want {
+				if got[i].flags != want.flags || !strings.HasPrefix(compact(got[i].data), want.data) {
+					t.Errorf("frame %d = %q, want %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	h := newTestHandler(t)
+	h.UnaryInterceptor = func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if info.FullMethod != pb.KvStoreService_Get_FullMethodName || len(md.Get("authorization")) == 0 {
+			return nil, status.Error(codes.Unauthenticated, "missing credentials")
+		}
+		grpc.SetHeader(ctx, metadata.Pairs("x-served-by", "replica-1"))
+		return handler(ctx, req)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+		servedBy      string
+	}{
+		{"authorized", "Bearer token", http.StatusOK, "replica-1"},
+		{"unauthorized", "", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/KvStoreService/Get", strings.NewReader(`{"key":"alpha"}`))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if got := w.Header().Get("X-Served-By"); got != tt.servedBy {
+				t.Errorf("X-Served-By = %q, want %q", got, tt.servedBy)
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	h := newTestHandler(t)
+
+	tests := []struct {
+		name   string
+		origin string
+		status int
+		allow  string
+	}{
+		{"allowed origin", "https://console.example.com", http.StatusNoContent, "https://console.example.com"},
+		{"other origin", "https://evil.example.com", http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("OPTIONS", "/KvStoreService/Get", nil)
+			r.Header.Set("Origin", tt.origin)
+			r.Header.Set("Access-Control-Request-Method", "POST")
+			r.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}